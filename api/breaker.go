@@ -0,0 +1,209 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var ErrRateLimited = errors.New("rate limited")
+var ErrServerError = errors.New("server error")
+var ErrCircuitOpen = errors.New("circuit open")
+
+var apiCircuitState = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "mojira_api_circuit_state",
+	Help: "State of the circuit breaker of each API: 0 is closed, 1 is half-open and 2 is open",
+}, []string{"source"})
+
+var apiRate = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "mojira_api_rate",
+	Help: "Current request rate limit of each API in requests per second, 0 is unlimited",
+}, []string{"source"})
+
+// Classifies the status of a response, so rate limiting and server errors open the circuit
+func checkStatus(resp *http.Response) error {
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return ErrRateLimited
+	}
+	if resp.StatusCode >= 500 {
+		return fmt.Errorf("%w: status %d", ErrServerError, resp.StatusCode)
+	}
+	return nil
+}
+
+type CircuitState int
+
+const (
+	CircuitClosed CircuitState = iota
+	CircuitHalfOpen
+	CircuitOpen
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitHalfOpen:
+		return "half-open"
+	case CircuitOpen:
+		return "open"
+	default:
+		return "closed"
+	}
+}
+
+// Server or connection errors in a row after which the circuit opens. Being rate limited opens it right away
+const breakerThreshold = 3
+const minBreakerCooldown = 30 * time.Second
+const maxBreakerCooldown = 10 * time.Minute
+
+// Stops sending requests to a source that is rate limiting or failing. After a cooldown a single request is let through,
+// which closes the circuit again when it succeeds
+type Breaker struct {
+	source string
+
+	mu        sync.Mutex
+	state     CircuitState
+	failures  int
+	cooldown  time.Duration
+	openUntil time.Time
+	lastError string
+	probing   bool
+}
+
+func NewBreaker(source string) *Breaker {
+	b := &Breaker{source: source}
+	apiCircuitState.WithLabelValues(source).Set(float64(CircuitClosed))
+	return b
+}
+
+func (b *Breaker) setState(state CircuitState) {
+	b.state = state
+	apiCircuitState.WithLabelValues(b.source).Set(float64(state))
+}
+
+// Whether a request may be sent now. In the half-open state only one request at a time is allowed
+func (b *Breaker) Allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == CircuitOpen && time.Now().After(b.openUntil) {
+		b.setState(CircuitHalfOpen)
+	}
+	switch b.state {
+	case CircuitOpen:
+		return false
+	case CircuitHalfOpen:
+		if b.probing {
+			return false
+		}
+		b.probing = true
+		return true
+	default:
+		return true
+	}
+}
+
+// Records the outcome of an allowed request, err is nil when the source answered normally
+func (b *Breaker) Record(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return
+	}
+	rateLimited := errors.Is(err, ErrRateLimited)
+	if err == nil {
+		b.failures = 0
+		b.cooldown = 0
+		b.lastError = ""
+		if b.state != CircuitClosed {
+			b.setState(CircuitClosed)
+		}
+		return
+	}
+	b.failures += 1
+	b.lastError = err.Error()
+	if rateLimited || b.failures >= breakerThreshold || b.state == CircuitHalfOpen {
+		b.cooldown = min(maxBreakerCooldown, max(minBreakerCooldown, b.cooldown*2))
+		b.openUntil = time.Now().Add(b.cooldown)
+		b.setState(CircuitOpen)
+	}
+}
+
+// When a request may be let through again, zero if the circuit is closed
+func (b *Breaker) OpenUntil() time.Time {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == CircuitClosed {
+		return time.Time{}
+	}
+	return b.openUntil
+}
+
+type BreakerStatus struct {
+	Source    string
+	State     CircuitState
+	Failures  int
+	OpenUntil *time.Time
+	LastError string
+}
+
+func (b *Breaker) Status() BreakerStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	status := BreakerStatus{Source: b.source, State: b.state, Failures: b.failures, LastError: b.lastError}
+	if b.state == CircuitOpen {
+		openUntil := b.openUntil
+		status.OpenUntil = &openUntil
+	}
+	return status
+}
+
+// Rate limit and circuit breaker shared by all requests to one source
+type Upstream struct {
+	source  string
+	limiter *Limiter
+	breaker *Breaker
+}
+
+func NewUpstream(source string) *Upstream {
+	return &Upstream{source: source, limiter: NewLimiter(source, 0), breaker: NewBreaker(source)}
+}
+
+func (u *Upstream) Source() string {
+	return u.source
+}
+
+func (u *Upstream) Limiter() *Limiter {
+	return u.limiter
+}
+
+func (u *Upstream) Breaker() *Breaker {
+	return u.breaker
+}
+
+// Waits for a request to be allowed, every successful call must be followed by Done
+func (u *Upstream) Acquire(ctx context.Context) error {
+	if !u.breaker.Allow() {
+		return fmt.Errorf("API error %s: %w until %s", u.source, ErrCircuitOpen, u.breaker.OpenUntil().UTC().Format(time.RFC3339))
+	}
+	if err := u.limiter.Wait(ctx); err != nil {
+		u.breaker.Record(err)
+		return NewApiError(u.source, err)
+	}
+	return nil
+}
+
+// Records the outcome of a request, slowing down when the source is rate limiting
+func (u *Upstream) Done(err error) {
+	u.breaker.Record(err)
+	if errors.Is(err, ErrRateLimited) {
+		u.limiter.Throttle()
+	} else if err == nil {
+		u.limiter.Recover()
+	}
+}
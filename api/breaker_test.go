@@ -0,0 +1,165 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// Lets the cooldown run out without waiting for it
+func expireCooldown(b *Breaker) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.openUntil = time.Now().Add(-time.Second)
+}
+
+func expectState(t *testing.T, b *Breaker, state CircuitState) {
+	t.Helper()
+	if got := b.Status().State; got != state {
+		t.Fatalf("expected the circuit to be %s, got %s", state, got)
+	}
+}
+
+func TestBreakerOpens(t *testing.T) {
+	serverError := fmt.Errorf("%w: status 503", ErrServerError)
+
+	t.Run("failures in a row", func(t *testing.T) {
+		b := NewBreaker("test")
+		for i := 1; i < breakerThreshold; i++ {
+			b.Record(serverError)
+			expectState(t, b, CircuitClosed)
+			if !b.Allow() {
+				t.Fatalf("expected requests to be allowed after %d failures", i)
+			}
+		}
+		b.Record(serverError)
+		expectState(t, b, CircuitOpen)
+		if b.Allow() {
+			t.Error("expected no requests to be allowed while open")
+		}
+		status := b.Status()
+		if status.Failures != breakerThreshold || status.LastError != serverError.Error() || status.OpenUntil == nil {
+			t.Errorf("unexpected status %+v", status)
+		}
+		if wait := time.Until(b.OpenUntil()); wait <= minBreakerCooldown-time.Second || wait > minBreakerCooldown {
+			t.Errorf("expected to stay open for %v, got %v", minBreakerCooldown, wait)
+		}
+	})
+
+	t.Run("success resets the failures", func(t *testing.T) {
+		b := NewBreaker("test")
+		for range breakerThreshold - 1 {
+			b.Record(serverError)
+		}
+		b.Record(nil)
+		for range breakerThreshold - 1 {
+			b.Record(serverError)
+		}
+		expectState(t, b, CircuitClosed)
+	})
+
+	t.Run("rate limited", func(t *testing.T) {
+		b := NewBreaker("test")
+		b.Record(ErrRateLimited)
+		expectState(t, b, CircuitOpen)
+	})
+
+	t.Run("context errors", func(t *testing.T) {
+		b := NewBreaker("test")
+		for range breakerThreshold {
+			b.Record(context.Canceled)
+			b.Record(fmt.Errorf("request failed: %w", context.DeadlineExceeded))
+		}
+		expectState(t, b, CircuitClosed)
+		if status := b.Status(); status.Failures != 0 || status.LastError != "" {
+			t.Errorf("expected context errors not to count as failures, got %+v", status)
+		}
+	})
+}
+
+func TestBreakerHalfOpen(t *testing.T) {
+	t.Run("single probe", func(t *testing.T) {
+		b := NewBreaker("test")
+		b.Record(ErrRateLimited)
+		expireCooldown(b)
+		if !b.Allow() {
+			t.Fatal("expected a probe to be allowed after the cooldown")
+		}
+		expectState(t, b, CircuitHalfOpen)
+		if b.Allow() {
+			t.Fatal("expected only one probe at a time")
+		}
+		b.Record(nil)
+		expectState(t, b, CircuitClosed)
+		if !b.OpenUntil().IsZero() || !b.Allow() || !b.Allow() {
+			t.Error("expected requests to be allowed again after a successful probe")
+		}
+	})
+
+	t.Run("failed probe", func(t *testing.T) {
+		b := NewBreaker("test")
+		b.Record(ErrRateLimited)
+		expireCooldown(b)
+		b.Allow()
+		// A single server error is enough while half-open
+		b.Record(ErrServerError)
+		expectState(t, b, CircuitOpen)
+		if b.Allow() {
+			t.Error("expected the circuit to open again after a failed probe")
+		}
+	})
+
+	t.Run("canceled probe", func(t *testing.T) {
+		b := NewBreaker("test")
+		b.Record(ErrRateLimited)
+		expireCooldown(b)
+		b.Allow()
+		b.Record(context.Canceled)
+		expectState(t, b, CircuitHalfOpen)
+		if !b.Allow() {
+			t.Error("expected another probe after a canceled one")
+		}
+	})
+}
+
+func TestBreakerCooldown(t *testing.T) {
+	b := NewBreaker("test")
+	expected := []time.Duration{30 * time.Second, time.Minute, 2 * time.Minute, 4 * time.Minute, 8 * time.Minute, 10 * time.Minute, 10 * time.Minute}
+	for i, cooldown := range expected {
+		if i > 0 {
+			expireCooldown(b)
+			if !b.Allow() {
+				t.Fatalf("failure %d: expected a probe after the cooldown", i+1)
+			}
+		}
+		b.Record(ErrRateLimited)
+		if wait := time.Until(b.OpenUntil()); wait <= cooldown-time.Second || wait > cooldown {
+			t.Errorf("failure %d: expected a cooldown of %v, got %v", i+1, cooldown, wait)
+		}
+	}
+
+	// A successful probe starts over with the shortest cooldown
+	expireCooldown(b)
+	b.Allow()
+	b.Record(nil)
+	b.Record(ErrRateLimited)
+	if wait := time.Until(b.OpenUntil()); wait > minBreakerCooldown {
+		t.Errorf("expected the cooldown to be reset, got %v", wait)
+	}
+}
+
+func TestUpstreamAcquire(t *testing.T) {
+	u := NewUpstream("test")
+	if err := u.Acquire(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	u.Done(ErrRateLimited)
+	if rate := u.Limiter().CurrentRate(); rate != throttleBaseRate/2 {
+		t.Errorf("expected the rate to be throttled to %v, got %v", throttleBaseRate/2, rate)
+	}
+	if err := u.Acquire(context.Background()); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("expected the open circuit to reject the request, got %v", err)
+	}
+}
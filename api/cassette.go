@@ -0,0 +1,220 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Where -record saves cassettes, relative to the repository root. The replay tests in this package read them from cassettes
+const CassetteDir = "api/cassettes"
+
+// Responses from an API, recorded to be replayed later. JSON bodies are kept as JSON so the files stay readable
+type Cassette struct {
+	Interactions []Interaction `json:"interactions"`
+}
+
+type Interaction struct {
+	Request  RecordedRequest  `json:"request"`
+	Response RecordedResponse `json:"response"`
+}
+
+type RecordedRequest struct {
+	Method string          `json:"method"`
+	Path   string          `json:"path"`
+	JSON   json.RawMessage `json:"json,omitempty"`
+}
+
+type RecordedResponse struct {
+	Status int             `json:"status"`
+	Header http.Header     `json:"header,omitempty"`
+	JSON   json.RawMessage `json:"json,omitempty"`
+	Body   string          `json:"body,omitempty"`
+}
+
+// Login requests carry the credentials, so their body is never recorded
+const loginPath = "/jsd-login/v1/authentication/authenticate"
+
+// Headers that are kept in a recording, the others change on every request or identify the session
+var recordedHeaders = []string{"Content-Type", "Retry-After", "Set-Cookie"}
+
+func LoadCassette(path string) (*Cassette, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var cassette Cassette
+	if err := json.Unmarshal(raw, &cassette); err != nil {
+		return nil, errors.New("failed to parse cassette " + path + ": " + err.Error())
+	}
+	return &cassette, nil
+}
+
+func (c *Cassette) Save(path string) error {
+	raw, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(raw, '\n'), 0644)
+}
+
+func compactJSON(raw []byte) json.RawMessage {
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, raw); err != nil {
+		return nil
+	}
+	return buf.Bytes()
+}
+
+// Passes requests on to the API and records the responses
+type Recorder struct {
+	base http.RoundTripper
+
+	mu       sync.Mutex
+	cassette Cassette
+}
+
+func NewRecorder(base http.RoundTripper) *Recorder {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &Recorder{base: base}
+}
+
+func (r *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	recorded := RecordedRequest{Method: req.Method, Path: req.URL.RequestURI()}
+	if req.Body != nil {
+		raw, err := io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		req.Body = io.NopCloser(bytes.NewReader(raw))
+		if req.URL.Path != loginPath {
+			recorded.JSON = compactJSON(raw)
+		}
+	}
+
+	resp, err := r.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	raw, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(raw))
+
+	response := RecordedResponse{Status: resp.StatusCode, Header: make(http.Header)}
+	for _, name := range recordedHeaders {
+		for _, value := range resp.Header.Values(name) {
+			if name == "Set-Cookie" {
+				value = redactCookie(value)
+			}
+			response.Header.Add(name, value)
+		}
+	}
+	if content := compactJSON(raw); content != nil {
+		response.JSON = content
+	} else {
+		response.Body = string(raw)
+	}
+
+	r.mu.Lock()
+	r.cassette.Interactions = append(r.cassette.Interactions, Interaction{Request: recorded, Response: response})
+	r.mu.Unlock()
+	return resp, nil
+}
+
+// Keeps the name and attributes of a cookie, but not the session it identifies
+func redactCookie(value string) string {
+	cookie, err := http.ParseSetCookie(value)
+	if err != nil {
+		return ""
+	}
+	cookie.Value = "recorded"
+	return cookie.String()
+}
+
+func (r *Recorder) Cassette() *Cassette {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return &Cassette{Interactions: append([]Interaction(nil), r.cassette.Interactions...)}
+}
+
+// Answers requests from a cassette. Each interaction is used once, in the recorded order, so a request can get a different answer when it is repeated
+type Replayer struct {
+	mu           sync.Mutex
+	interactions []Interaction
+	used         []bool
+}
+
+func NewReplayer(cassette *Cassette) *Replayer {
+	return &Replayer{interactions: cassette.Interactions, used: make([]bool, len(cassette.Interactions))}
+}
+
+func (r *Replayer) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body json.RawMessage
+	if req.Body != nil {
+		raw, err := io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		body = compactJSON(raw)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, interaction := range r.interactions {
+		recorded := interaction.Request
+		if r.used[i] || recorded.Method != req.Method || recorded.Path != req.URL.RequestURI() {
+			continue
+		}
+		if recorded.JSON != nil && !bytes.Equal(compactJSON(recorded.JSON), body) {
+			continue
+		}
+		r.used[i] = true
+		response := interaction.Response
+		content := []byte(response.Body)
+		if response.JSON != nil {
+			content = response.JSON
+		}
+		header := response.Header.Clone()
+		if header == nil {
+			header = make(http.Header)
+		}
+		return &http.Response{
+			Status:        fmt.Sprintf("%d %s", response.Status, http.StatusText(response.Status)),
+			StatusCode:    response.Status,
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        header,
+			Body:          io.NopCloser(bytes.NewReader(content)),
+			ContentLength: int64(len(content)),
+			Request:       req,
+		}, nil
+	}
+	return nil, fmt.Errorf("no recorded response for %s %s %s", req.Method, req.URL.RequestURI(), strings.TrimSpace(string(body)))
+}
+
+// Number of interactions that weren't replayed
+func (r *Replayer) Unused() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	unused := 0
+	for _, used := range r.used {
+		if !used {
+			unused++
+		}
+	}
+	return unused
+}
@@ -3,10 +3,12 @@ package api
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"mojira/model"
 	"net/http"
+	"strings"
 	"time"
 )
 
@@ -22,36 +24,59 @@ type LegacyIssue struct {
 	Comments       []model.Comment
 }
 
+const DefaultLegacyURL = "https://bugs-legacy.mojang.com"
+
 type LegacyClient struct {
-	client *http.Client
+	client   *http.Client
+	baseURL  string
+	upstream *Upstream
 }
 
-func NewLegacyClient() *LegacyClient {
+func NewLegacyClient(baseURL string) *LegacyClient {
 	return &LegacyClient{
-		client: &http.Client{},
+		client:   &http.Client{},
+		baseURL:  strings.TrimSuffix(baseURL, "/"),
+		upstream: NewUpstream("legacy"),
 	}
 }
 
+func (l *LegacyClient) Upstream() *Upstream {
+	return l.upstream
+}
+
+// Replaces how requests are sent, for recording or replaying responses
+func (l *LegacyClient) SetTransport(transport http.RoundTripper) {
+	l.client.Transport = transport
+}
+
 func (l *LegacyClient) GetIssue(ctx context.Context, key string) (*LegacyIssue, error) {
 	NewApiCall("legacy")
 
-	url := fmt.Sprintf("https://bugs-legacy.mojang.com/rest/api/2/issue/%s", key)
+	url := fmt.Sprintf("%s/rest/api/2/issue/%s", l.baseURL, key)
 	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
 		return nil, NewApiError("legacy", err)
 	}
 	req.Header.Set("Content-Type", "application/json")
 
+	if err := l.upstream.Acquire(ctx); err != nil {
+		return nil, err
+	}
 	resp, err := l.client.Do(req)
 	if err != nil {
+		l.upstream.Done(err)
 		return nil, NewApiError("legacy", err)
 	}
 	defer resp.Body.Close()
 
 	raw, err := io.ReadAll(resp.Body)
+	l.upstream.Done(errors.Join(err, checkStatus(resp)))
 	if err != nil {
 		return nil, NewApiError("legacy", err)
 	}
+	if err := checkStatus(resp); err != nil {
+		return nil, NewApiError("legacy", err)
+	}
 
 	var parsed struct {
 		Key    string
@@ -94,6 +119,7 @@ func (l *LegacyClient) GetIssue(ctx context.Context, key string) (*LegacyIssue,
 	if parsed.Key == "" {
 		return nil, model.ErrIssueNotFound
 	}
+	checkSchema("legacy", raw, &parsed)
 
 	var resolvedDate *time.Time
 	if parsed.Fields.Resolutiondate != "" {
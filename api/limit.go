@@ -0,0 +1,123 @@
+package api
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// Rate used as a starting point when a source without a configured limit starts rate limiting
+const throttleBaseRate = 10
+
+// Lowest rate a source is throttled to
+const minThrottledRate = 0.2
+
+// Token bucket limiting the requests to one source. A rate of 0 doesn't limit anything.
+// When the source is rate limiting, the rate is halved and then slowly raised back to the configured rate
+type Limiter struct {
+	source string
+
+	mu         sync.Mutex
+	configured float64
+	rate       float64
+	tokens     float64
+	last       time.Time
+}
+
+func NewLimiter(source string, rate float64) *Limiter {
+	l := &Limiter{source: source}
+	l.SetRate(rate)
+	return l
+}
+
+// Allows bursts of up to one second worth of requests
+func (l *Limiter) burst() float64 {
+	return math.Max(1, l.rate)
+}
+
+func (l *Limiter) setRate(rate float64) {
+	l.rate = rate
+	l.tokens = math.Min(l.tokens, l.burst())
+	apiRate.WithLabelValues(l.source).Set(rate)
+}
+
+// The configured rate
+func (l *Limiter) Rate() float64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.configured
+}
+
+// The rate currently in use, lower than the configured one while recovering from rate limiting
+func (l *Limiter) CurrentRate() float64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.rate
+}
+
+func (l *Limiter) SetRate(rate float64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.configured = math.Max(0, rate)
+	l.setRate(l.configured)
+	l.tokens = l.burst()
+	l.last = time.Now()
+}
+
+// Halves the rate after the source rate limited a request
+func (l *Limiter) Throttle() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	rate := l.rate
+	if rate == 0 {
+		rate = throttleBaseRate
+	}
+	l.setRate(math.Max(minThrottledRate, rate/2))
+}
+
+// Raises a throttled rate a little after a successful request
+func (l *Limiter) Recover() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.rate == l.configured {
+		return
+	}
+	rate := l.rate + 0.05
+	if l.configured == 0 && rate >= throttleBaseRate {
+		rate = 0
+	} else if l.configured > 0 {
+		rate = math.Min(l.configured, rate)
+	}
+	l.setRate(rate)
+}
+
+// Takes a token, waiting until one is available or the context is done
+func (l *Limiter) Wait(ctx context.Context) error {
+	l.mu.Lock()
+	if l.rate == 0 {
+		l.mu.Unlock()
+		return nil
+	}
+	now := time.Now()
+	l.tokens = math.Min(l.burst(), l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	l.last = now
+	l.tokens -= 1
+	delay := time.Duration(-l.tokens / l.rate * float64(time.Second))
+	l.mu.Unlock()
+	if delay <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		l.mu.Lock()
+		l.tokens += 1
+		l.mu.Unlock()
+		return ctx.Err()
+	}
+}
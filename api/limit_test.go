@@ -0,0 +1,130 @@
+package api
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+)
+
+func expectRate(t *testing.T, l *Limiter, rate float64) {
+	t.Helper()
+	if got := l.CurrentRate(); math.Abs(got-rate) > 1e-9 {
+		t.Fatalf("expected a rate of %v, got %v", rate, got)
+	}
+}
+
+func TestLimiterThrottle(t *testing.T) {
+	t.Run("configured", func(t *testing.T) {
+		l := NewLimiter("test", 4)
+		for _, rate := range []float64{2, 1, 0.5, 0.25, minThrottledRate, minThrottledRate} {
+			l.Throttle()
+			expectRate(t, l, rate)
+		}
+		for range 4 {
+			l.Recover()
+		}
+		expectRate(t, l, minThrottledRate+0.2)
+		// Recovering never goes past the configured rate
+		for range 100 {
+			l.Recover()
+		}
+		expectRate(t, l, 4)
+		if l.Rate() != 4 {
+			t.Errorf("expected the configured rate to stay 4, got %v", l.Rate())
+		}
+	})
+
+	t.Run("unlimited", func(t *testing.T) {
+		l := NewLimiter("test", 0)
+		l.Recover()
+		expectRate(t, l, 0)
+		l.Throttle()
+		expectRate(t, l, throttleBaseRate/2)
+		// Back to unlimited once the base rate is reached
+		for range 99 {
+			l.Recover()
+		}
+		expectRate(t, l, throttleBaseRate-0.05)
+		l.Recover()
+		expectRate(t, l, 0)
+	})
+
+	t.Run("negative", func(t *testing.T) {
+		l := NewLimiter("test", -1)
+		expectRate(t, l, 0)
+	})
+
+	t.Run("burst", func(t *testing.T) {
+		l := NewLimiter("test", 10)
+		l.Throttle()
+		if l.tokens != 5 {
+			t.Errorf("expected the tokens to be limited to the new burst, got %v", l.tokens)
+		}
+	})
+}
+
+func TestLimiterWait(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("unlimited", func(t *testing.T) {
+		l := NewLimiter("test", 0)
+		for range 1000 {
+			if err := l.Wait(ctx); err != nil {
+				t.Fatal(err)
+			}
+		}
+	})
+
+	t.Run("burst", func(t *testing.T) {
+		l := NewLimiter("test", 5)
+		start := time.Now()
+		for range 5 {
+			l.Wait(ctx)
+		}
+		if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+			t.Errorf("expected a burst of 5 requests not to wait, took %v", elapsed)
+		}
+	})
+
+	t.Run("refill", func(t *testing.T) {
+		l := NewLimiter("test", 2)
+		l.tokens, l.last = 0, time.Now().Add(-time.Second)
+		l.Wait(ctx)
+		if math.Abs(l.tokens-1) > 0.01 {
+			t.Errorf("expected 2 tokens to be refilled and 1 taken, got %v", l.tokens)
+		}
+		// Idle time doesn't add more than the burst
+		l.last = time.Now().Add(-time.Hour)
+		l.Wait(ctx)
+		if math.Abs(l.tokens-1) > 0.01 {
+			t.Errorf("expected the burst of 2 to limit the tokens, got %v", l.tokens)
+		}
+	})
+
+	t.Run("delay", func(t *testing.T) {
+		l := NewLimiter("test", 50)
+		l.tokens, l.last = 0, time.Now()
+		start := time.Now()
+		if err := l.Wait(ctx); err != nil {
+			t.Fatal(err)
+		}
+		if elapsed := time.Since(start); elapsed < 15*time.Millisecond || elapsed > time.Second {
+			t.Errorf("expected to wait 20ms for the next token, took %v", elapsed)
+		}
+	})
+
+	t.Run("canceled", func(t *testing.T) {
+		l := NewLimiter("test", 1)
+		l.Wait(ctx)
+		canceled, cancel := context.WithCancel(ctx)
+		cancel()
+		if err := l.Wait(canceled); err != context.Canceled {
+			t.Fatalf("expected the wait to be canceled, got %v", err)
+		}
+		// The token taken by the canceled request is given back
+		if l.tokens < -0.01 || l.tokens > 0.01 {
+			t.Errorf("expected no token to be used by the canceled request, got %v", l.tokens)
+		}
+	})
+}
@@ -37,16 +37,31 @@ type PublicIssue struct {
 	Attachments        []model.Attachment
 }
 
+const DefaultPublicURL = "https://bugs.mojang.com"
+
 type PublicClient struct {
-	client *http.Client
+	client   *http.Client
+	baseURL  string
+	upstream *Upstream
 }
 
-func NewPublicClient() *PublicClient {
+func NewPublicClient(baseURL string) *PublicClient {
 	return &PublicClient{
-		client: &http.Client{Timeout: 4 * time.Second},
+		client:   &http.Client{Timeout: 4 * time.Second},
+		baseURL:  strings.TrimSuffix(baseURL, "/"),
+		upstream: NewUpstream("public"),
 	}
 }
 
+func (c *PublicClient) Upstream() *Upstream {
+	return c.upstream
+}
+
+// Replaces how requests are sent, for recording or replaying responses
+func (c *PublicClient) SetTransport(transport http.RoundTripper) {
+	c.client.Transport = transport
+}
+
 type publicJQLRequest struct {
 	Advanced   bool   `json:"advanced"`
 	Project    string `json:"project"`
@@ -63,22 +78,30 @@ func (c *PublicClient) GetIssue(ctx context.Context, key string) (*PublicIssue,
 		Search:     "key = " + key,
 		MaxResults: 1,
 	})
-	url := "https://bugs.mojang.com/api/jql-search-post"
+	url := c.baseURL + "/api/jql-search-post"
 	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(body))
 	if err != nil {
 		return nil, NewApiError("public", err)
 	}
 	req.Header.Set("Content-Type", "application/json")
+	if err := c.upstream.Acquire(ctx); err != nil {
+		return nil, err
+	}
 	resp, err := c.client.Do(req)
 	if err != nil {
+		c.upstream.Done(err)
 		return nil, NewApiError("public", err)
 	}
 	defer resp.Body.Close()
 
 	raw, err := io.ReadAll(resp.Body)
+	c.upstream.Done(errors.Join(err, checkStatus(resp)))
 	if err != nil {
 		return nil, NewApiError("public", err)
 	}
+	if err := checkStatus(resp); err != nil {
+		return nil, NewApiError("public", err)
+	}
 	var parsed struct {
 		Issues []struct {
 			Key    string
@@ -148,7 +171,7 @@ func (c *PublicClient) GetIssue(ctx context.Context, key string) (*PublicIssue,
 								Name string
 							}
 						}
-					}
+					} `schema:"optional"`
 					OutwardIssue struct {
 						Key    string
 						Fields struct {
@@ -157,7 +180,7 @@ func (c *PublicClient) GetIssue(ctx context.Context, key string) (*PublicIssue,
 								Name string
 							}
 						}
-					}
+					} `schema:"optional"`
 				}
 			}
 		}
@@ -168,6 +191,7 @@ func (c *PublicClient) GetIssue(ctx context.Context, key string) (*PublicIssue,
 	if len(parsed.Issues) == 0 {
 		return nil, NewApiError("public", errors.New("issue not found on public API"))
 	}
+	checkSchema("public", raw, &parsed)
 
 	f := parsed.Issues[0].Fields
 	var versions []string
@@ -0,0 +1,257 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"mojira/model"
+	"net/http"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Base URL of the clients while replaying, requests never leave the process
+const replayURL = "http://replay.invalid"
+
+type cassetteCase struct {
+	cassette string
+	run      func(ctx context.Context, transport http.RoundTripper) (any, error)
+	// The parsed result, with every field filled in when complete is set
+	want     any
+	complete bool
+	wantErr  error
+	errText  string
+}
+
+func replayLegacy(key string) func(context.Context, http.RoundTripper) (any, error) {
+	return func(ctx context.Context, transport http.RoundTripper) (any, error) {
+		client := NewLegacyClient(replayURL)
+		client.SetTransport(transport)
+		return client.GetIssue(ctx, key)
+	}
+}
+
+func replayPublic(key string) func(context.Context, http.RoundTripper) (any, error) {
+	return func(ctx context.Context, transport http.RoundTripper) (any, error) {
+		client := NewPublicClient(replayURL)
+		client.SetTransport(transport)
+		return client.GetIssue(ctx, key)
+	}
+}
+
+func replayServiceDesk(key string) func(context.Context, http.RoundTripper) (any, error) {
+	return func(ctx context.Context, transport http.RoundTripper) (any, error) {
+		client := NewServiceDeskClient(replayURL)
+		client.SetTransport(transport)
+		return client.GetIssue(ctx, key)
+	}
+}
+
+func replayUpdatedIssues(ctx context.Context, transport http.RoundTripper) (any, error) {
+	client := NewServiceDeskClient(replayURL)
+	client.SetTransport(transport)
+	return client.GetUpdatedIssues(ctx)
+}
+
+func cassetteCases(t *testing.T) []cassetteCase {
+	replayDate := func(value string) *time.Time {
+		date, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			t.Fatalf("invalid date %q: %v", value, err)
+		}
+		return &date
+	}
+	return []cassetteCase{
+		{
+			cassette: "legacy-MC-4.json",
+			run:      replayLegacy("MC-4"),
+			complete: true,
+			want: &LegacyIssue{
+				CreatorKey:     "kumasasa",
+				CreatorName:    "Kumasasa",
+				CreatorAvatar:  "https://bugs-legacy.mojang.com/secure/useravatar?avatarId=10001",
+				ReporterKey:    "jeb",
+				ReporterName:   "Jens Bergensten",
+				ReporterAvatar: "https://bugs-legacy.mojang.com/secure/useravatar?avatarId=10002",
+				ResolvedDate:   replayDate("2013-02-15T12:30:00Z"),
+				Votes:          120,
+				Comments: []model.Comment{
+					{LegacyId: "20001", Date: replayDate("2012-07-29T09:15:00Z"), AuthorName: "Kumasasa", AuthorAvatar: "https://bugs-legacy.mojang.com/secure/useravatar?avatarId=10001"},
+					{LegacyId: "20002", Date: replayDate("2012-08-02T16:40:00Z"), AuthorName: "Marc Watson", AuthorAvatar: "https://bugs-legacy.mojang.com/secure/useravatar?avatarId=10003"},
+				},
+			},
+		},
+		{
+			cassette: "legacy-not-found.json",
+			run:      replayLegacy("MC-1"),
+			wantErr:  model.ErrIssueNotFound,
+		},
+		{
+			cassette: "legacy-server-error.json",
+			run:      replayLegacy("MC-4"),
+			wantErr:  ErrServerError,
+		},
+		{
+			cassette: "public-MC-4.json",
+			run:      replayPublic("MC-4"),
+			complete: true,
+			want: &PublicIssue{
+				Key:                "MC-4",
+				Summary:            "Item drops appear at the wrong position",
+				Description:        `{"content":[],"type":"doc","version":1}`,
+				Labels:             []string{"item-entity"},
+				CreatedDate:        replayDate("2012-07-28T12:00:00Z"),
+				UpdatedDate:        replayDate("2025-03-01T10:00:00Z"),
+				ResolvedDate:       replayDate("2025-02-11T08:00:00Z"),
+				Status:             "Resolved",
+				ConfirmationStatus: "Confirmed",
+				Resolution:         "Fixed",
+				AffectedVersions:   []string{"1.3.1", "1.4.2"},
+				FixVersions:        []string{"1.4.4"},
+				Category:           []string{"Items"},
+				MojangPriority:     "Normal",
+				Area:               "Platform",
+				Platform:           "Windows",
+				OSVersion:          "Windows 11",
+				RealmsPlatform:     "Java",
+				ADO:                "1234",
+				Votes:              3,
+				Links: []model.IssueLink{
+					{Type: "is duplicated by", OtherKey: "MC-29", OtherSummary: "Dropped items float", OtherStatus: "Resolved"},
+					{Type: "relates to", OtherKey: "MC-8", OtherSummary: "Items clip into blocks", OtherStatus: "Open"},
+				},
+				Attachments: []model.Attachment{
+					{Id: "30001", Filename: "drops.png", AuthorName: "Kumasasa", AuthorAvatar: "https://bugs.mojang.com/avatar/kumasasa.png", CreatedDate: replayDate("2012-07-28T12:05:00Z"), Size: 20480, MimeType: "image/png"},
+				},
+			},
+		},
+		{
+			cassette: "public-not-found.json",
+			run:      replayPublic("MC-1"),
+			errText:  "issue not found on public API",
+		},
+		{
+			cassette: "public-rate-limited.json",
+			run:      replayPublic("MC-4"),
+			wantErr:  ErrRateLimited,
+		},
+		{
+			cassette: "public-server-error.json",
+			run:      replayPublic("MC-4"),
+			wantErr:  ErrServerError,
+		},
+		{
+			cassette: "servicedesk-MC-4.json",
+			run:      replayServiceDesk("MC-4"),
+			complete: true,
+			want: &ServiceDeskIssue{
+				Key:              "MC-4",
+				Summary:          "Item drops appear at the wrong position",
+				ReporterName:     "Kumasasa",
+				ReporterAvatar:   "https://report.bugs.mojang.com/avatar/kumasasa.png",
+				AssigneeName:     "Moderator",
+				AssigneeAvatar:   "https://report.bugs.mojang.com/avatar/moderator.png",
+				Description:      `{"type":"doc","version":1,"content":[]}`,
+				Environment:      `{"type":"doc","version":1,"content":[{"type":"paragraph","content":[{"type":"text","text":"Windows 11"}]}]}`,
+				CreatedDate:      replayDate("2012-07-28T12:00:00Z"),
+				Status:           "Resolved",
+				AffectedVersions: []string{"1.3.1", "1.4.2"},
+				Components:       []string{"Items", "Entities"},
+				RealmsPlatform:   "Java",
+				Comments: []model.Comment{
+					{Id: "40001", Date: replayDate("2012-07-29T09:15:00Z"), AuthorName: "Kumasasa", AuthorAvatar: "https://report.bugs.mojang.com/avatar/kumasasa.png", AdfComment: `{"type":"doc","version":1,"content":[]}`},
+					{Id: "40002", Date: replayDate("2025-03-01T10:00:00Z"), AuthorName: "Moderator", AuthorAvatar: "https://report.bugs.mojang.com/avatar/moderator.png", AdfComment: `{"type":"doc","version":1,"content":[]}`},
+				},
+			},
+		},
+		{
+			cassette: "servicedesk-not-found.json",
+			run:      replayServiceDesk("MC-1"),
+			wantErr:  model.ErrIssueNotFound,
+		},
+		{
+			cassette: "servicedesk-html.json",
+			run:      replayServiceDesk("MC-4"),
+			wantErr:  ErrRateLimited,
+		},
+		{
+			cassette: "servicedesk-relogin.json",
+			run:      replayServiceDesk("MC-280000"),
+			want: &ServiceDeskIssue{
+				Key:              "MC-280000",
+				Summary:          "Bundles lose their contents when dropped in lava",
+				ReporterName:     "Steve",
+				Status:           "Open",
+				CreatedDate:      replayDate("2025-03-04T18:20:00Z"),
+				AffectedVersions: []string{""},
+				Components:       []string{""},
+				Comments:         []model.Comment{},
+			},
+		},
+		{
+			cassette: "servicedesk-updated.json",
+			run:      replayUpdatedIssues,
+			want:     []string{"MC-280001", "MC-280000", "MC-4"},
+		},
+	}
+}
+
+// Names of the fields that were left empty
+func emptyFields(value any) []string {
+	v := reflect.Indirect(reflect.ValueOf(value))
+	if v.Kind() != reflect.Struct {
+		return nil
+	}
+	var empty []string
+	for i := 0; i < v.NumField(); i++ {
+		if v.Field(i).IsZero() {
+			empty = append(empty, v.Type().Field(i).Name)
+		}
+	}
+	return empty
+}
+
+// Replays the recorded API responses and checks the issues the clients parse from them
+func TestCassettes(t *testing.T) {
+	for _, c := range cassetteCases(t) {
+		t.Run(strings.TrimSuffix(c.cassette, ".json"), func(t *testing.T) {
+			cassette, err := LoadCassette(filepath.Join("cassettes", c.cassette))
+			if err != nil {
+				t.Fatal(err)
+			}
+			replayer := NewReplayer(cassette)
+			got, err := c.run(context.Background(), replayer)
+
+			if c.wantErr != nil || c.errText != "" {
+				if err == nil {
+					t.Fatal("expected an error, got none")
+				}
+				if c.wantErr != nil && !errors.Is(err, c.wantErr) {
+					t.Errorf("expected %q, got %q", c.wantErr, err)
+				}
+				if !strings.Contains(err.Error(), c.errText) {
+					t.Errorf("expected %q, got %q", c.errText, err)
+				}
+			} else {
+				if err != nil {
+					t.Fatal(err)
+				}
+				// Compared as JSON, so dates parsed with different locations are equal
+				want, _ := json.MarshalIndent(c.want, "", "  ")
+				result, _ := json.MarshalIndent(got, "", "  ")
+				if string(want) != string(result) {
+					t.Errorf("parsed response doesn't match, got:\n%s", result)
+				}
+				if empty := emptyFields(got); c.complete && len(empty) > 0 {
+					t.Errorf("the cassette doesn't cover %s", strings.Join(empty, ", "))
+				}
+			}
+			if unused := replayer.Unused(); unused > 0 {
+				t.Errorf("%d recorded responses weren't requested", unused)
+			}
+		})
+	}
+}
@@ -0,0 +1,189 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"maps"
+	"os"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var apiSchemaUnknownFields = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "mojira_api_schema_unknown_fields",
+	Help: "Number of distinct fields seen in API responses that the parser doesn't know",
+}, []string{"source"})
+
+var apiSchemaMissingFields = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "mojira_api_schema_missing_fields",
+	Help: "Number of fields the parser expects that were missing from API responses",
+}, []string{"source"})
+
+// How often an example of a drifted response is logged per source and response type
+const schemaSampleInterval = 10 * time.Minute
+
+// Longest example payload that is logged
+const schemaSampleSize = 4000
+
+// Comparing responses to the parsed structs is enabled with API_SCHEMA_CHECK
+var schemaCheckEnabled = sync.OnceValue(func() bool {
+	return os.Getenv("API_SCHEMA_CHECK") != ""
+})
+
+var rawMessageType = reflect.TypeFor[json.RawMessage]()
+
+type schemaKey struct {
+	source string
+	t      reflect.Type
+}
+
+type schemaState struct {
+	mu         sync.Mutex
+	unknown    map[string]bool
+	lastSample time.Time
+}
+
+var schemaStates sync.Map
+
+// Paths of the fields that differ between a response and the struct it is parsed into
+type schemaDrift struct {
+	unknown map[string]bool
+	missing map[string]bool
+}
+
+// Compares a response with the struct it was parsed into. The struct is the expected shape: fields it doesn't have are unknown,
+// and fields it has that the response lacks are missing, unless they are tagged with `schema:"optional"`. A null value counts as present.
+// Each unknown field is counted once, missing fields are counted for every response
+func checkSchema(source string, raw []byte, parsed any) {
+	if !schemaCheckEnabled() {
+		return
+	}
+	var value any
+	if err := json.Unmarshal(raw, &value); err != nil {
+		return
+	}
+	t := reflect.TypeOf(parsed)
+	drift := schemaDrift{unknown: make(map[string]bool), missing: make(map[string]bool)}
+	compareSchema(t, value, "", &drift)
+
+	stored, _ := schemaStates.LoadOrStore(schemaKey{source, t}, &schemaState{unknown: make(map[string]bool)})
+	state := stored.(*schemaState)
+	state.mu.Lock()
+	var newUnknown []string
+	for path := range drift.unknown {
+		if !state.unknown[path] {
+			state.unknown[path] = true
+			newUnknown = append(newUnknown, path)
+		}
+	}
+	sample := (len(newUnknown) > 0 || len(drift.missing) > 0) && time.Since(state.lastSample) > schemaSampleInterval
+	if sample {
+		state.lastSample = time.Now()
+	}
+	state.mu.Unlock()
+
+	apiSchemaUnknownFields.WithLabelValues(source).Add(float64(len(newUnknown)))
+	apiSchemaMissingFields.WithLabelValues(source).Add(float64(len(drift.missing)))
+	if sample {
+		slices.Sort(newUnknown)
+		missing := slices.Sorted(maps.Keys(drift.missing))
+		example := string(compactJSON(raw))
+		if len(example) > schemaSampleSize {
+			example = example[:schemaSampleSize] + "..."
+		}
+		log.Printf("[schema] %s response has %d new unknown fields %v and lacks %v, example: %s", source, len(newUnknown), newUnknown, missing, example)
+	}
+}
+
+func schemaPath(path string, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+type schemaField struct {
+	name     string
+	t        reflect.Type
+	optional bool
+}
+
+// The fields of a struct as encoding/json decodes them
+func schemaFields(t reflect.Type) []schemaField {
+	var fields []schemaField
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		fields = append(fields, schemaField{name: name, t: f.Type, optional: f.Tag.Get("schema") == "optional"})
+	}
+	return fields
+}
+
+func compareSchema(t reflect.Type, value any, path string, drift *schemaDrift) {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if value == nil || t.Kind() == reflect.Interface || t == rawMessageType {
+		return
+	}
+	switch v := value.(type) {
+	case map[string]any:
+		switch t.Kind() {
+		case reflect.Map:
+			for key, child := range v {
+				compareSchema(t.Elem(), child, schemaPath(path, key), drift)
+			}
+		case reflect.Struct:
+			fields := schemaFields(t)
+			matched := make([]bool, len(fields))
+			for key, child := range v {
+				// Exact names win over case-insensitive ones, like in encoding/json
+				index := slices.IndexFunc(fields, func(f schemaField) bool { return f.name == key })
+				if index < 0 {
+					index = slices.IndexFunc(fields, func(f schemaField) bool { return strings.EqualFold(f.name, key) })
+				}
+				if index < 0 {
+					drift.unknown[schemaPath(path, key)] = true
+					continue
+				}
+				matched[index] = true
+				compareSchema(fields[index].t, child, schemaPath(path, key), drift)
+			}
+			for i, f := range fields {
+				if !matched[i] && !f.optional {
+					drift.missing[schemaPath(path, f.name)] = true
+				}
+			}
+		default:
+			drift.missing[path] = true
+		}
+	case []any:
+		if t.Kind() != reflect.Slice {
+			drift.missing[path] = true
+			return
+		}
+		for _, child := range v {
+			compareSchema(t.Elem(), child, path+"[]", drift)
+		}
+	default:
+		// A scalar where an object or a list is expected
+		if t.Kind() == reflect.Struct || t.Kind() == reflect.Slice || t.Kind() == reflect.Map {
+			drift.missing[path] = true
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package api
+
+import (
+	"encoding/json"
+	"maps"
+	"reflect"
+	"slices"
+	"testing"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+type schemaTestItem struct {
+	Name string `json:"name"`
+	Size int    `json:"size"`
+}
+
+type schemaTestIssue struct {
+	Key     string                    `json:"key"`
+	Summary *string                   `json:"summary"`
+	Labels  []string                  `json:"labels"`
+	Items   []schemaTestItem          `json:"items"`
+	Extra   map[string]schemaTestItem `json:"extra"`
+	Raw     json.RawMessage           `json:"raw"`
+	Any     any                       `json:"any"`
+	Note    string                    `json:"note" schema:"optional"`
+	Ignored string                    `json:"-"`
+	Default string
+	hidden  string
+}
+
+// Same fields, but another response type
+type schemaTestOther schemaTestIssue
+
+// A response with every field of schemaTestIssue, with some fields replaced or removed
+func schemaTestResponse(t *testing.T, replace map[string]any, remove ...string) []byte {
+	t.Helper()
+	response := map[string]any{
+		"key":     "MC-1",
+		"summary": nil,
+		"labels":  []any{"crash"},
+		"items":   []any{map[string]any{"name": "a", "size": 1}, map[string]any{"name": "b", "size": 2}},
+		"extra":   map[string]any{"x": map[string]any{"name": "c", "size": 3}},
+		"raw":     map[string]any{"anything": []any{1, "two"}},
+		"any":     []any{map[string]any{"x": 1}},
+		"Default": "",
+	}
+	maps.Copy(response, replace)
+	for _, name := range remove {
+		delete(response, name)
+	}
+	data, err := json.Marshal(response)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestCompareSchema(t *testing.T) {
+	cases := []struct {
+		name    string
+		replace map[string]any
+		remove  []string
+		unknown []string
+		missing []string
+	}{
+		{name: "complete"},
+		{name: "optional present", replace: map[string]any{"note": "text"}},
+		{name: "unknown field", replace: map[string]any{"votes": 3, "Ignored": "x"}, unknown: []string{"Ignored", "votes"}},
+		{name: "missing field", remove: []string{"key", "Default"}, missing: []string{"Default", "key"}},
+		{name: "empty", remove: []string{"key", "summary", "labels", "items", "extra", "raw", "any", "Default"}, missing: []string{"Default", "any", "extra", "items", "key", "labels", "raw", "summary"}},
+		{name: "case insensitive", replace: map[string]any{"KEY": "MC-1", "default": ""}, remove: []string{"key", "Default"}},
+		{
+			name:    "unknown in array",
+			replace: map[string]any{"items": []any{map[string]any{"name": "a", "size": 1, "color": "red"}, map[string]any{"name": "b", "size": 2, "color": "blue"}}},
+			unknown: []string{"items[].color"},
+		},
+		{name: "missing in array", replace: map[string]any{"items": []any{map[string]any{"name": "a"}}}, missing: []string{"items[].size"}},
+		{name: "empty array", replace: map[string]any{"items": []any{}}},
+		{name: "unknown in map", replace: map[string]any{"extra": map[string]any{"x": map[string]any{"name": "c", "size": 3, "weight": 1}}}, unknown: []string{"extra.x.weight"}},
+		{name: "null", replace: map[string]any{"labels": nil, "items": nil, "extra": nil}},
+		{name: "raw message and interface", replace: map[string]any{"raw": "text", "any": map[string]any{"deeply": map[string]any{"nested": 1}}}},
+		{
+			name:    "wrong shape",
+			replace: map[string]any{"key": map[string]any{"id": 1}, "labels": "crash", "items": map[string]any{"name": "a"}, "extra": []any{}},
+			missing: []string{"extra", "items", "key", "labels"},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var value any
+			json.Unmarshal(schemaTestResponse(t, c.replace, c.remove...), &value)
+			drift := schemaDrift{unknown: make(map[string]bool), missing: make(map[string]bool)}
+			compareSchema(reflect.TypeFor[*schemaTestIssue](), value, "", &drift)
+			if unknown := slices.Sorted(maps.Keys(drift.unknown)); !slices.Equal(unknown, c.unknown) {
+				t.Errorf("expected unknown fields %v, got %v", c.unknown, unknown)
+			}
+			if missing := slices.Sorted(maps.Keys(drift.missing)); !slices.Equal(missing, c.missing) {
+				t.Errorf("expected missing fields %v, got %v", c.missing, missing)
+			}
+		})
+	}
+}
+
+func counterValue(t *testing.T, counter interface{ Write(*dto.Metric) error }) float64 {
+	t.Helper()
+	var metric dto.Metric
+	if err := counter.Write(&metric); err != nil {
+		t.Fatal(err)
+	}
+	return metric.GetCounter().GetValue()
+}
+
+func TestCheckSchema(t *testing.T) {
+	enabled := schemaCheckEnabled
+	t.Cleanup(func() { schemaCheckEnabled = enabled })
+	schemaCheckEnabled = func() bool { return false }
+	source := "schema-test"
+	unknown := apiSchemaUnknownFields.WithLabelValues(source)
+	missing := apiSchemaMissingFields.WithLabelValues(source)
+
+	checkSchema(source, schemaTestResponse(t, map[string]any{"votes": 3}), &schemaTestIssue{})
+	if counterValue(t, unknown) != 0 {
+		t.Fatal("expected nothing to be checked without API_SCHEMA_CHECK")
+	}
+
+	schemaCheckEnabled = func() bool { return true }
+	// Unknown fields are counted the first time they are seen, missing fields for every response
+	for range 3 {
+		checkSchema(source, schemaTestResponse(t, map[string]any{"votes": 3}, "key"), &schemaTestIssue{})
+	}
+	if got := counterValue(t, unknown); got != 1 {
+		t.Errorf("expected 1 unknown field, counted %v", got)
+	}
+	if got := counterValue(t, missing); got != 3 {
+		t.Errorf("expected 3 missing fields, counted %v", got)
+	}
+	checkSchema(source, schemaTestResponse(t, map[string]any{"votes": 3, "watchers": 1}), &schemaTestIssue{})
+	if got := counterValue(t, unknown); got != 2 {
+		t.Errorf("expected the new unknown field to be counted, counted %v", got)
+	}
+	// The same field is counted again for another response type
+	checkSchema(source, schemaTestResponse(t, map[string]any{"votes": 3}), &schemaTestOther{})
+	if got := counterValue(t, unknown); got != 3 {
+		t.Errorf("expected the unknown field of another type to be counted, counted %v", got)
+	}
+
+	checkSchema(source, []byte("<html>"), &schemaTestIssue{})
+	if got := counterValue(t, missing); got != 3 {
+		t.Errorf("expected a response that isn't JSON to be skipped, counted %v missing", got)
+	}
+}
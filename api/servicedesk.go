@@ -5,14 +5,19 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"net/http"
 	"os"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"mojira/model"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
 )
 
 type ServiceDeskIssue struct {
@@ -32,53 +37,262 @@ type ServiceDeskIssue struct {
 	Comments         []model.Comment
 }
 
+var serviceDeskSessionHealthy = promauto.NewGauge(prometheus.GaugeOpts{
+	Name: "mojira_servicedesk_session_healthy",
+	Help: "Whether there is a servicedesk session (1) or logging in is failing (0)",
+})
+
+var serviceDeskLogins = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "mojira_servicedesk_logins",
+	Help: "Number of servicedesk login attempts",
+}, []string{"result"})
+
+const minLoginBackoff = 30 * time.Second
+const maxLoginBackoff = 30 * time.Minute
+
+// Other servicedesk requests wait while logging in, so it can't take forever
+const loginTimeout = 15 * time.Second
+
+// Sessions are renewed this long before the cookie expires
+const sessionExpiryMargin = time.Minute
+
+const DefaultServiceDeskURL = "https://report.bugs.mojang.com"
+
 type ServiceDeskClient struct {
-	client *http.Client
-	cookie *http.Cookie
+	client   *http.Client
+	baseURL  string
+	upstream *Upstream
+
+	mu           sync.Mutex
+	cookie       *http.Cookie
+	loginDate    *time.Time
+	loginError   string
+	loginBackoff time.Duration
+	nextLogin    time.Time
+	failures     int
+}
+
+// State of the servicedesk session, shown on the queue page
+type ServiceDeskHealth struct {
+	Healthy   bool
+	LoginDate *time.Time
+	Expires   *time.Time
+	Failures  int
+	LastError string
+	NextLogin *time.Time
 }
 
-func NewServiceDeskClient() *ServiceDeskClient {
+func NewServiceDeskClient(baseURL string) *ServiceDeskClient {
 	return &ServiceDeskClient{
-		client: &http.Client{},
-		cookie: nil,
+		client:   &http.Client{},
+		baseURL:  strings.TrimSuffix(baseURL, "/"),
+		cookie:   nil,
+		upstream: NewUpstream("servicedesk"),
 	}
 }
 
+func (s *ServiceDeskClient) Upstream() *Upstream {
+	return s.upstream
+}
+
+// Replaces how requests are sent, for recording or replaying responses
+func (s *ServiceDeskClient) SetTransport(transport http.RoundTripper) {
+	s.client.Transport = transport
+}
+
 func (s *ServiceDeskClient) Authenticate() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.login()
+}
+
+// Logs in and keeps track of failures, the caller must hold the lock
+func (s *ServiceDeskClient) login() error {
+	cookie, err := s.requestSession()
+	now := time.Now()
+	if err != nil {
+		s.cookie = nil
+		s.failures += 1
+		s.loginError = err.Error()
+		s.loginBackoff = min(maxLoginBackoff, max(minLoginBackoff, s.loginBackoff*2))
+		s.nextLogin = now.Add(s.loginBackoff)
+		serviceDeskLogins.WithLabelValues("failed").Inc()
+		serviceDeskSessionHealthy.Set(0)
+		return err
+	}
+	s.cookie = cookie
+	s.loginDate = &now
+	s.failures = 0
+	s.loginError = ""
+	s.loginBackoff = 0
+	s.nextLogin = time.Time{}
+	serviceDeskLogins.WithLabelValues("success").Inc()
+	serviceDeskSessionHealthy.Set(1)
+	return nil
+}
+
+func (s *ServiceDeskClient) requestSession() (*http.Cookie, error) {
 	body, err := json.Marshal(map[string]string{
 		"email":    os.Getenv("JIRA_EMAIL"),
 		"password": os.Getenv("JIRA_PASSWORD"),
 	})
 	if err != nil {
-		return NewApiError("servicedesk", err)
+		return nil, NewApiError("servicedesk", err)
 	}
 
-	req, err := http.NewRequest("POST", "https://report.bugs.mojang.com/jsd-login/v1/authentication/authenticate", bytes.NewBuffer(body))
+	ctx, cancel := context.WithTimeout(context.Background(), loginTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "POST", s.baseURL+"/jsd-login/v1/authentication/authenticate", bytes.NewBuffer(body))
 	if err != nil {
-		return NewApiError("servicedesk", err)
+		return nil, NewApiError("servicedesk", err)
 	}
 	req.Header.Set("Content-Type", "application/json")
 
 	resp, err := s.client.Do(req)
 	if err != nil {
-		return NewApiError("servicedesk", err)
+		return nil, NewApiError("servicedesk", err)
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode >= 400 {
+		return nil, NewApiError("servicedesk", fmt.Errorf("login failed with status %d", resp.StatusCode))
+	}
 	cookies := resp.Cookies()
 	if len(cookies) == 0 {
-		return NewApiError("servicedesk", errors.New("no session cookie returned"))
+		return nil, NewApiError("servicedesk", errors.New("no session cookie returned"))
+	}
+	return cookies[0], nil
+}
+
+func cookieExpiry(cookie *http.Cookie, loginDate time.Time) *time.Time {
+	if cookie.MaxAge > 0 {
+		expires := loginDate.Add(time.Duration(cookie.MaxAge) * time.Second)
+		return &expires
+	}
+	if !cookie.Expires.IsZero() {
+		return &cookie.Expires
 	}
-	s.cookie = cookies[0]
 	return nil
 }
 
-func (s *ServiceDeskClient) GetIssue(ctx context.Context, key string) (*ServiceDeskIssue, error) {
-	NewApiCall("servicedesk")
+// Returns the current session cookie, logging in again when there is none or it is about to expire
+func (s *ServiceDeskClient) session() (*http.Cookie, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.cookie != nil {
+		expires := cookieExpiry(s.cookie, *s.loginDate)
+		if expires == nil || time.Until(*expires) > sessionExpiryMargin {
+			return s.cookie, nil
+		}
+	}
+	if time.Now().Before(s.nextLogin) {
+		return nil, NewApiError("servicedesk", fmt.Errorf("no connection to servicedesk, next login attempt in %s", time.Until(s.nextLogin).Round(time.Second)))
+	}
+	if err := s.login(); err != nil {
+		return nil, err
+	}
+	return s.cookie, nil
+}
 
-	if s.cookie == nil {
-		return nil, NewApiError("servicedesk", errors.New("no connection to servicedesk"))
+// Drops a session that the servicedesk rejected, unless another request already replaced it
+func (s *ServiceDeskClient) invalidate(cookie *http.Cookie) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.cookie == cookie {
+		s.cookie = nil
+		serviceDeskSessionHealthy.Set(0)
 	}
+}
+
+func (s *ServiceDeskClient) Health() ServiceDeskHealth {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	health := ServiceDeskHealth{
+		Healthy:   s.cookie != nil,
+		LoginDate: s.loginDate,
+		Failures:  s.failures,
+		LastError: s.loginError,
+	}
+	if s.cookie != nil {
+		health.Expires = cookieExpiry(s.cookie, *s.loginDate)
+	}
+	if !s.nextLogin.IsZero() {
+		nextLogin := s.nextLogin
+		health.NextLogin = &nextLogin
+	}
+	return health
+}
+
+// The servicedesk answers with a 401, or redirects to its login page, when the session isn't valid anymore.
+// The body isn't looked at, other HTML pages like the rate limit page link to the login as well
+func isLoginResponse(resp *http.Response) bool {
+	if resp.StatusCode == http.StatusUnauthorized {
+		return true
+	}
+	if location := resp.Header.Get("Location"); resp.StatusCode >= 300 && resp.StatusCode < 400 && strings.Contains(location, "/jsd-login") {
+		return true
+	}
+	// The client follows redirects, so the response may already be the login page
+	return resp.Request != nil && resp.Request.URL != nil && strings.HasPrefix(resp.Request.URL.Path, "/jsd-login")
+}
+
+// Posts to the customer models endpoint, logging in again once if the session was rejected
+func (s *ServiceDeskClient) postModels(ctx context.Context, body []byte) ([]byte, error) {
+	for attempt := 0; ; attempt++ {
+		cookie, err := s.session()
+		if err != nil {
+			return nil, err
+		}
+
+		req, err := http.NewRequestWithContext(ctx, "POST", s.baseURL+"/rest/servicedesk/1/customer/models", bytes.NewBuffer(body))
+		if err != nil {
+			return nil, NewApiError("servicedesk", err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.AddCookie(cookie)
+
+		if err := s.upstream.Acquire(ctx); err != nil {
+			return nil, err
+		}
+		resp, err := s.client.Do(req)
+		if err != nil {
+			s.upstream.Done(err)
+			return nil, NewApiError("servicedesk", err)
+		}
+		raw, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			s.upstream.Done(err)
+			return nil, NewApiError("servicedesk", err)
+		}
+
+		loginResponse := isLoginResponse(resp)
+		// The servicedesk rate limits with an HTML page in place of the JSON response
+		if !loginResponse && resp.StatusCode < 300 && bytes.HasPrefix(raw, []byte("<!DOCTYPE html>")) {
+			err := fmt.Errorf("%w: received HTML response from servicedesk", ErrRateLimited)
+			s.upstream.Done(err)
+			return nil, NewApiError("servicedesk", err)
+		}
+		if loginResponse {
+			// A lost session says nothing about the health of the servicedesk
+			s.upstream.Done(nil)
+			s.invalidate(cookie)
+			if attempt == 0 {
+				continue
+			}
+			return nil, NewApiError("servicedesk", errors.New("servicedesk session was rejected after logging in again"))
+		}
+		statusErr := checkStatus(resp)
+		s.upstream.Done(statusErr)
+		if statusErr != nil {
+			return nil, NewApiError("servicedesk", statusErr)
+		}
+		return raw, nil
+	}
+}
+
+func (s *ServiceDeskClient) GetIssue(ctx context.Context, key string) (*ServiceDeskIssue, error) {
+	NewApiCall("servicedesk")
 
 	portalId := model.PortalIds[strings.Split(key, "-")[0]]
 	body, err := json.Marshal(map[string]any{
@@ -95,25 +309,9 @@ func (s *ServiceDeskClient) GetIssue(ctx context.Context, key string) (*ServiceD
 		return nil, NewApiError("servicedesk", err)
 	}
 
-	req, err := http.NewRequestWithContext(ctx, "POST", "https://report.bugs.mojang.com/rest/servicedesk/1/customer/models", bytes.NewBuffer(body))
-	if err != nil {
-		return nil, NewApiError("servicedesk", err)
-	}
-	req.Header.Set("Content-Type", "application/json")
-	req.AddCookie(s.cookie)
-
-	resp, err := s.client.Do(req)
+	raw, err := s.postModels(ctx, body)
 	if err != nil {
-		return nil, NewApiError("servicedesk", err)
-	}
-	defer resp.Body.Close()
-
-	raw, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, NewApiError("servicedesk", err)
-	}
-	if strings.HasPrefix(string(raw), "<!DOCTYPE html>") {
-		return nil, NewApiError("servicedesk", errors.New("received HTML response from servicedesk, likely rate limited"))
+		return nil, err
 	}
 
 	var parsed struct {
@@ -127,7 +325,7 @@ func (s *ServiceDeskClient) GetIssue(ctx context.Context, key string) (*ServiceD
 				Assignee struct {
 					DisplayName string
 					AvatarUrl   string
-				}
+				} `schema:"optional"`
 				Summary string
 				Status  string
 				Date    string
@@ -135,13 +333,14 @@ func (s *ServiceDeskClient) GetIssue(ctx context.Context, key string) (*ServiceD
 					Id    string
 					Value json.RawMessage
 				}
+				// Only comments have all fields
 				ActivityStream []struct {
 					Type       string
-					CommentId  int
+					CommentId  int `schema:"optional"`
 					Date       string
-					Author     string
-					AvatarUrl  string
-					AdfComment string
+					Author     string `schema:"optional"`
+					AvatarUrl  string `schema:"optional"`
+					AdfComment string `schema:"optional"`
 				}
 			}
 		}
@@ -152,6 +351,7 @@ func (s *ServiceDeskClient) GetIssue(ctx context.Context, key string) (*ServiceD
 	if parsed.ReqDetails.Issue.Key == "" {
 		return nil, model.ErrIssueNotFound
 	}
+	checkSchema("servicedesk", raw, &parsed)
 
 	apiIssue := parsed.ReqDetails.Issue
 	comments := make([]model.Comment, 0, len(apiIssue.ActivityStream))
@@ -243,10 +443,6 @@ func (s *ServiceDeskClient) GetIssue(ctx context.Context, key string) (*ServiceD
 }
 
 func (s *ServiceDeskClient) GetUpdatedIssues(ctx context.Context) ([]string, error) {
-	if s.cookie == nil {
-		return nil, NewApiError("servicedesk", errors.New("no connection to servicedesk"))
-	}
-
 	body, err := json.Marshal(map[string]any{
 		"models": []string{"allReqFilter"},
 		"options": map[string]any{
@@ -260,25 +456,9 @@ func (s *ServiceDeskClient) GetUpdatedIssues(ctx context.Context) ([]string, err
 		return nil, NewApiError("servicedesk", err)
 	}
 
-	req, err := http.NewRequestWithContext(ctx, "POST", "https://report.bugs.mojang.com/rest/servicedesk/1/customer/models", bytes.NewBuffer(body))
+	raw, err := s.postModels(ctx, body)
 	if err != nil {
-		return nil, NewApiError("servicedesk", err)
-	}
-	req.Header.Set("Content-Type", "application/json")
-	req.AddCookie(s.cookie)
-
-	resp, err := s.client.Do(req)
-	if err != nil {
-		return nil, NewApiError("servicedesk", err)
-	}
-	defer resp.Body.Close()
-
-	raw, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, NewApiError("servicedesk", err)
-	}
-	if strings.HasPrefix(string(raw), "<!DOCTYPE html>") {
-		return nil, NewApiError("servicedesk", errors.New("received HTML response from servicedesk, likely rate limited"))
+		return nil, err
 	}
 
 	var response struct {
@@ -291,6 +471,7 @@ func (s *ServiceDeskClient) GetUpdatedIssues(ctx context.Context) ([]string, err
 	if err := json.Unmarshal(raw, &response); err != nil {
 		return nil, NewApiError("servicedesk", err)
 	}
+	checkSchema("servicedesk", raw, &response)
 
 	var keys []string
 	for _, i := range response.AllReqFilter.RequestList {
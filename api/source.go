@@ -0,0 +1,28 @@
+package api
+
+import "context"
+
+// The APIs an issue is merged from. Besides the clients in this package, they can be pointed at the fake tracker for local development
+
+type LegacySource interface {
+	GetIssue(ctx context.Context, key string) (*LegacyIssue, error)
+	Upstream() *Upstream
+}
+
+type PublicSource interface {
+	GetIssue(ctx context.Context, key string) (*PublicIssue, error)
+	Upstream() *Upstream
+}
+
+// The servicedesk is also polled for recently updated issues and needs a logged in session
+type ServiceDeskSource interface {
+	Authenticate() error
+	GetIssue(ctx context.Context, key string) (*ServiceDeskIssue, error)
+	GetUpdatedIssues(ctx context.Context) ([]string, error)
+	Health() ServiceDeskHealth
+	Upstream() *Upstream
+}
+
+var _ LegacySource = (*LegacyClient)(nil)
+var _ PublicSource = (*PublicClient)(nil)
+var _ ServiceDeskSource = (*ServiceDeskClient)(nil)
@@ -25,7 +25,7 @@ var apiErrors = promauto.NewCounterVec(prometheus.CounterOpts{
 
 func NewApiError(source string, err error) error {
 	apiErrors.WithLabelValues(source).Inc()
-	return fmt.Errorf("API error %s: %s", source, err)
+	return fmt.Errorf("API error %s: %w", source, err)
 }
 
 func ParseTime(s string) (*time.Time, error) {
@@ -3,11 +3,16 @@ package main
 import (
 	"context"
 	"database/sql"
+	"encoding/base64"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"log"
 	"math"
+	"mojira/jql"
 	"mojira/model"
 	"os"
+	"slices"
 	"strings"
 	"time"
 
@@ -110,60 +115,290 @@ func (c *DBClient) SearchIssues(text string, limit int) ([]model.Issue, error) {
 	return issues, nil
 }
 
-func (c *DBClient) FilterIssues(search string, project string, status string, confirmation string, resolution string, priority string, reporter string, assignee string, affected_version string, fix_version string, category string, label string, component string, platform string, area string, sort string, offset int, limit int) ([]model.Issue, int, error) {
+type IssueFilter struct {
+	Search          string `json:"search,omitempty"`
+	Project         string `json:"project,omitempty"`
+	Status          string `json:"status,omitempty"`
+	Confirmation    string `json:"confirmation,omitempty"`
+	Resolution      string `json:"resolution,omitempty"`
+	Priority        string `json:"priority,omitempty"`
+	Reporter        string `json:"reporter,omitempty"`
+	Assignee        string `json:"assignee,omitempty"`
+	AffectedVersion string `json:"affected_version,omitempty"`
+	FixVersion      string `json:"fix_version,omitempty"`
+	Category        string `json:"category,omitempty"`
+	Label           string `json:"label,omitempty"`
+	Component       string `json:"component,omitempty"`
+	Platform        string `json:"platform,omitempty"`
+	Area            string `json:"area,omitempty"`
+	Query           string `json:"query,omitempty"`
+	Sort            string `json:"sort,omitempty"`
+	// Descriptions are only loaded for feeds, listings don't show them
+	WithDescription bool `json:"-"`
+
+	// The parsed query, set before matching so that it isn't parsed for every issue
+	query *jql.Query
+}
+
+func (f *IssueFilter) descriptionColumn() string {
+	if f.WithDescription {
+		return `description`
+	}
+	return `''`
+}
+
+func (f *IssueFilter) args() []any {
+	return []any{f.Search, f.Project, f.Status, f.Confirmation, f.Resolution, f.Priority, f.Reporter, f.Assignee, f.AffectedVersion, f.FixVersion, f.Category, f.Label, f.Component, f.Platform, f.Area}
+}
+
+// Checks a single issue against the filter, the search is approximated by matching the summary
+func (f *IssueFilter) Matches(issue *model.Issue) bool {
+	eq := func(filter string, value string) bool {
+		return filter == "" || filter == value
+	}
+	eqFold := func(filter string, value string) bool {
+		return filter == "" || strings.EqualFold(filter, value)
+	}
+	contains := func(filter string, values []string) bool {
+		return filter == "" || slices.Contains(values, filter)
+	}
+	return eq(f.Project, issue.Project()) &&
+		eq(f.Status, issue.Status) &&
+		eq(f.Confirmation, issue.ConfirmationStatus) &&
+		(eq(f.Resolution, issue.Resolution) || (issue.Resolution == "" && f.Resolution == "Unresolved")) &&
+		eq(f.Priority, issue.MojangPriority) &&
+		eqFold(f.Reporter, issue.ReporterName) &&
+		eqFold(f.Assignee, issue.AssigneeName) &&
+		contains(f.AffectedVersion, issue.AffectedVersions) &&
+		contains(f.FixVersion, issue.FixVersions) &&
+		contains(f.Category, issue.Category) &&
+		contains(f.Label, issue.Labels) &&
+		contains(f.Component, issue.Components) &&
+		eq(f.Platform, issue.Platform) &&
+		eq(f.Area, issue.Area) &&
+		(f.Search == "" || strings.Contains(strings.ToLower(issue.Summary), strings.ToLower(f.Search))) &&
+		f.matchesQuery(issue)
+}
+
+// A query that wasn't parsed or is invalid matches no issue
+func (f *IssueFilter) matchesQuery(issue *model.Issue) bool {
+	if f.Query == "" {
+		return true
+	}
+	return f.query != nil && f.query.Matches(issue)
+}
+
+const issueFilterWhere = `state = 'present' AND ($2 = '' OR project = $2) AND ($3 = '' OR status = $3) AND ($4 = '' OR confirmation_status = $4) AND ($5 = '' OR resolution = $5 OR (resolution = '' AND $5 = 'Unresolved')) AND ($6 = '' OR mojang_priority = $6) AND ($7 = '' OR LOWER(reporter_name) = LOWER($7)) AND ($8 = '' OR LOWER(assignee_name) = LOWER($8)) AND ($9 = '' OR $9=ANY(affected_versions)) AND ($10 = '' OR $10=ANY(fix_versions)) AND ($11 = '' OR $11=ANY(category)) AND ($12 = '' OR $12=ANY(labels)) AND ($13 = '' OR $13=ANY(components)) AND ($14 = '' OR platform = $14) AND ($15 = '' OR area = $15) AND ($1 = '' OR to_tsvector('english', text) @@ websearch_to_tsquery('english', $1))`
+
+type sortColumn struct {
+	expr string
+	typ  string
+}
+
+type issueSort struct {
+	columns []sortColumn // ends with the key, so that every row has a unique position
+	filter  string
+}
+
+var createdSortColumn = sortColumn{`COALESCE(created_date, '-infinity')`, "timestamptz"}
+var keySortColumn = sortColumn{`key`, "text"}
+
+// Every sort mode is paginated using its columns as a keyset, see migration 018 for the matching indexes
+var issueSorts = map[string]issueSort{
+	"Created":    {columns: []sortColumn{createdSortColumn, keySortColumn}},
+	"Updated":    {columns: []sortColumn{{`updated_date`, "timestamptz"}, keySortColumn}, filter: ` AND (updated_date IS NOT NULL)`},
+	"Resolved":   {columns: []sortColumn{{`resolved_date`, "timestamptz"}, keySortColumn}, filter: ` AND (resolved_date IS NOT NULL)`},
+	"Priority":   {columns: []sortColumn{{`mojang_priority_rank`, "int"}, createdSortColumn, keySortColumn}},
+	"Votes":      {columns: []sortColumn{{`total_votes`, "int"}, createdSortColumn, keySortColumn}},
+	"Comments":   {columns: []sortColumn{{`comment_count`, "int"}, createdSortColumn, keySortColumn}},
+	"Duplicates": {columns: []sortColumn{{`duplicate_count`, "int"}, createdSortColumn, keySortColumn}},
+}
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Position in a list of filtered issues. Values holds the sort columns of the row next to the page,
+// queries with their own ORDER BY fall back to an offset
+type IssueCursor struct {
+	Sort   string   `json:"s,omitempty"`
+	Values []string `json:"v,omitempty"`
+	Before bool     `json:"b,omitempty"`
+	Offset int      `json:"o,omitempty"`
+}
+
+func (c *IssueCursor) String() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func ParseIssueCursor(value string) (*IssueCursor, error) {
+	if value == "" {
+		return nil, nil
+	}
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	var cursor IssueCursor
+	if err := json.Unmarshal(data, &cursor); err != nil || cursor.Offset < 0 {
+		return nil, ErrInvalidCursor
+	}
+	return &cursor, nil
+}
+
+// Builds the condition for the rows after the cursor (or before it), their order and the sort values selected for the next cursors.
+// The cursor values are appended to args, they are the text of the selected values and cast back to the column types
+func issueKeysetSQL(sort issueSort, cursor *IssueCursor, args []any) (string, string, string, []any) {
+	pageStr := ``
+	if len(cursor.Values) > 0 {
+		var exprs, params []string
+		for i, col := range sort.columns {
+			args = append(args, cursor.Values[i])
+			exprs = append(exprs, col.expr)
+			params = append(params, fmt.Sprintf("$%d::%s", len(args), col.typ))
+		}
+		op := "<"
+		if cursor.Before {
+			op = ">"
+		}
+		pageStr = fmt.Sprintf(` AND (%s) %s (%s)`, strings.Join(exprs, ", "), op, strings.Join(params, ", "))
+	}
+	direction := " DESC"
+	if cursor.Before {
+		direction = " ASC"
+	}
+	var orders []string
+	valuesStr := ``
+	for _, col := range sort.columns {
+		orders = append(orders, col.expr+direction)
+		valuesStr += `, (` + col.expr + `)::text`
+	}
+	return pageStr, strings.Join(orders, ", "), valuesStr, args
+}
+
+type IssuePage struct {
+	Issues []model.Issue
+	Count  int
+	Next   *IssueCursor
+	Prev   *IssueCursor
+}
+
+func (c *DBClient) FilterIssues(filter IssueFilter, cursor *IssueCursor, limit int) (*IssuePage, error) {
+	page := &IssuePage{Issues: []model.Issue{}}
 	// Disallow queries starting with "-" for performance reasons
-	if strings.HasPrefix(strings.TrimSpace(search), "-") {
-		return []model.Issue{}, 0, nil
-	}
-	sortStr := `created_date DESC`
-	filterStr := ``
-	switch sort {
-	case "Updated":
-		sortStr = `updated_date DESC`
-		filterStr += ` AND (updated_date IS NOT NULL)`
-	case "Resolved":
-		sortStr = `resolved_date DESC`
-		filterStr += ` AND (resolved_date IS NOT NULL)`
-	case "Priority":
-		sortStr = `mojang_priority_rank DESC`
-	case "Votes":
-		sortStr = `total_votes DESC, created_date DESC`
-	case "Comments":
-		sortStr = `comment_count DESC`
-	case "Duplicates":
-		sortStr = `duplicate_count DESC`
-	}
-	rows, err := c.db.Query(`SELECT key, summary, status, resolution, confirmation_status, reporter_avatar, reporter_name, assignee_avatar, assignee_name, created_date, total_votes FROM issue WHERE state = 'present' AND ($2 = '' OR project = $2) AND ($3 = '' OR status = $3) AND ($4 = '' OR confirmation_status = $4) AND ($5 = '' OR resolution = $5 OR (resolution = '' AND $5 = 'Unresolved')) AND ($6 = '' OR mojang_priority = $6) AND ($7 = '' OR LOWER(reporter_name) = LOWER($7)) AND ($8 = '' OR LOWER(assignee_name) = LOWER($8)) AND ($9 = '' OR $9=ANY(affected_versions)) AND ($10 = '' OR $10=ANY(fix_versions)) AND ($11 = '' OR $11=ANY(category)) AND ($12 = '' OR $12=ANY(labels)) AND ($13 = '' OR $13=ANY(components)) AND ($14 = '' OR platform = $14) AND ($15 = '' OR area = $15) AND ($1 = '' OR to_tsvector('english', text) @@ websearch_to_tsquery('english', $1))`+filterStr+` ORDER BY `+sortStr+` OFFSET $16 LIMIT $17`, search, project, status, confirmation, resolution, priority, reporter, assignee, affected_version, fix_version, category, label, component, platform, area, offset, limit)
+	if strings.HasPrefix(strings.TrimSpace(filter.Search), "-") {
+		return page, nil
+	}
+	sortName := filter.Sort
+	sort, ok := issueSorts[sortName]
+	if !ok {
+		sortName = "Created"
+		sort = issueSorts[sortName]
+	}
+	if cursor == nil {
+		cursor = &IssueCursor{}
+	}
+	filterStr := sort.filter
+	sortStr := ``
+	var queryArgs []any
+	if filter.Query != "" {
+		q, err := jql.Parse(filter.Query)
+		if err != nil {
+			return nil, err
+		}
+		var where string
+		where, queryArgs = q.SQL(16)
+		filterStr += ` AND ` + where
+		sortStr = q.OrderSQL()
+	}
+	keyset := sortStr == ``
+
+	args := append(filter.args(), queryArgs...)
+	pageStr := ``
+	valuesStr := ``
+	offset := cursor.Offset
+	if len(cursor.Values) > 0 {
+		if !keyset || cursor.Sort != sortName || len(cursor.Values) != len(sort.columns) {
+			return nil, ErrInvalidCursor
+		}
+		offset = 0
+	}
+	if keyset {
+		pageStr, sortStr, valuesStr, args = issueKeysetSQL(sort, cursor, args)
+	}
+	// Fetch one extra row to know whether there is another page
+	args = append(args, offset, limit+1)
+	pagination := fmt.Sprintf(` OFFSET $%d LIMIT $%d`, len(args)-1, len(args))
+	rows, err := c.db.Query(`SELECT key, summary, `+filter.descriptionColumn()+`, status, resolution, confirmation_status, reporter_avatar, reporter_name, assignee_avatar, assignee_name, created_date, updated_date, resolved_date, labels, affected_versions, fix_versions, category, components, mojang_priority, area, platform, votes, legacy_votes`+valuesStr+` FROM issue WHERE `+issueFilterWhere+filterStr+pageStr+` ORDER BY `+sortStr+pagination, args...)
 	if err != nil {
-		return nil, 0, err
+		return nil, err
 	}
 	defer rows.Close()
 
-	var issues []model.Issue
+	var sortValues [][]string
 	for rows.Next() {
 		var issue model.Issue
-		var ignoredTotalVotes int
-		if err := rows.Scan(&issue.Key, &issue.Summary, &issue.Status, &issue.Resolution, &issue.ConfirmationStatus, &issue.ReporterAvatar, &issue.ReporterName, &issue.AssigneeAvatar, &issue.AssigneeName, &issue.CreatedDate, &ignoredTotalVotes); err != nil {
-			return nil, 0, err
+		values := make([]string, len(sort.columns))
+		dest := []any{&issue.Key, &issue.Summary, &issue.Description, &issue.Status, &issue.Resolution, &issue.ConfirmationStatus, &issue.ReporterAvatar, &issue.ReporterName, &issue.AssigneeAvatar, &issue.AssigneeName, &issue.CreatedDate, &issue.UpdatedDate, &issue.ResolvedDate, pq.Array(&issue.Labels), pq.Array(&issue.AffectedVersions), pq.Array(&issue.FixVersions), pq.Array(&issue.Category), pq.Array(&issue.Components), &issue.MojangPriority, &issue.Area, &issue.Platform, &issue.Votes, &issue.LegacyVotes}
+		if keyset {
+			for i := range values {
+				dest = append(dest, &values[i])
+			}
 		}
-		issues = append(issues, issue)
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		page.Issues = append(page.Issues, issue)
+		sortValues = append(sortValues, values)
+	}
+	hasMore := len(page.Issues) > limit
+	if hasMore {
+		page.Issues = page.Issues[:limit]
+		sortValues = sortValues[:limit]
 	}
 
-	var count int
-	if search == "" && priority == "" && reporter == "" && assignee == "" && affected_version == "" && fix_version == "" && category == "" && label == "" && component == "" && platform == "" && area == "" {
-		countRow := c.db.QueryRow(`SELECT COALESCE(SUM(count), 0) FROM issue_count WHERE ($1 = '' OR project = $1) AND ($2 = '' OR status = $2) AND ($3 = '' OR confirmation_status = $3) AND ($4 = '' OR resolution = $4 OR (resolution = '' AND $4 = 'Unresolved'))`, project, status, confirmation, resolution)
-		err = countRow.Scan(&count)
+	if keyset && len(page.Issues) > 0 {
+		if cursor.Before {
+			slices.Reverse(page.Issues)
+			slices.Reverse(sortValues)
+		}
+		first := &IssueCursor{Sort: sortName, Values: sortValues[0], Before: true}
+		last := &IssueCursor{Sort: sortName, Values: sortValues[len(sortValues)-1]}
+		if cursor.Before {
+			page.Next = last
+			if hasMore {
+				page.Prev = first
+			}
+		} else {
+			if hasMore {
+				page.Next = last
+			}
+			if len(cursor.Values) > 0 || cursor.Offset > 0 {
+				page.Prev = first
+			}
+		}
+	} else if !keyset {
+		if hasMore {
+			page.Next = &IssueCursor{Offset: cursor.Offset + limit}
+		}
+		if cursor.Offset > 0 {
+			page.Prev = &IssueCursor{Offset: max(cursor.Offset-limit, 0)}
+		}
+	}
+
+	if filter.Search == "" && filter.Query == "" && filter.Priority == "" && filter.Reporter == "" && filter.Assignee == "" && filter.AffectedVersion == "" && filter.FixVersion == "" && filter.Category == "" && filter.Label == "" && filter.Component == "" && filter.Platform == "" && filter.Area == "" {
+		countRow := c.db.QueryRow(`SELECT COALESCE(SUM(count), 0) FROM issue_count WHERE ($1 = '' OR project = $1) AND ($2 = '' OR status = $2) AND ($3 = '' OR confirmation_status = $3) AND ($4 = '' OR resolution = $4 OR (resolution = '' AND $4 = 'Unresolved'))`, filter.Project, filter.Status, filter.Confirmation, filter.Resolution)
+		err = countRow.Scan(&page.Count)
 		if err != nil {
-			return nil, 0, err
+			return nil, err
 		}
 	} else {
-		countRow := c.db.QueryRow(`SELECT COUNT(*) FROM issue WHERE state = 'present' AND ($2 = '' OR project = $2) AND ($3 = '' OR status = $3) AND ($4 = '' OR confirmation_status = $4) AND ($5 = '' OR resolution = $5 OR (resolution = '' AND $5 = 'Unresolved')) AND ($6 = '' OR mojang_priority = $6) AND ($7 = '' OR LOWER(reporter_name) = LOWER($7)) AND ($8 = '' OR LOWER(assignee_name) = LOWER($8)) AND ($9 = '' OR $9=ANY(affected_versions)) AND ($10 = '' OR $10=ANY(fix_versions)) AND ($11 = '' OR $11=ANY(category)) AND ($12 = '' OR $12=ANY(labels)) AND ($13 = '' OR $13=ANY(components)) AND ($14 = '' OR platform = $14) AND ($15 = '' OR area = $15) AND ($1 = '' OR to_tsvector('english', text) @@ websearch_to_tsquery('english', $1))`+filterStr, search, project, status, confirmation, resolution, priority, reporter, assignee, affected_version, fix_version, category, label, component, platform, area)
-		err = countRow.Scan(&count)
+		countRow := c.db.QueryRow(`SELECT COUNT(*) FROM issue WHERE `+issueFilterWhere+filterStr, append(filter.args(), queryArgs...)...)
+		err = countRow.Scan(&page.Count)
 		if err != nil {
-			return nil, 0, err
+			return nil, err
 		}
 	}
-	return issues, count, nil
+	return page, nil
 }
 
 func (c *DBClient) GetIssueByReporter(reporter string, limit int) ([]model.Issue, error) {
@@ -211,7 +446,26 @@ func (c *DBClient) GetIssueForSync(key string) (*model.Issue, error) {
 	return &issue, nil
 }
 
+func (c *DBClient) GetIssueSyncedDate(key string) (*time.Time, error) {
+	row := c.db.QueryRow("SELECT synced_date FROM issue WHERE key = $1 AND state = 'present'", key)
+	var syncedDate *time.Time
+	err := row.Scan(&syncedDate)
+	if err != nil {
+		return nil, err
+	}
+	return syncedDate, nil
+}
+
 func (c *DBClient) GetIssueByKey(key string) (*model.Issue, error) {
+	issue, removed, err := c.GetStoredIssue(key)
+	if removed {
+		return nil, model.ErrIssueRemoved
+	}
+	return issue, err
+}
+
+// Loads an issue whether or not it was removed, for comparing it with the fetched issue
+func (c *DBClient) GetStoredIssue(key string) (*model.Issue, bool, error) {
 	row := c.db.QueryRow("SELECT summary, creator_name, creator_avatar, reporter_name, reporter_avatar, assignee_name, assignee_avatar, description, environment, labels, created_date, updated_date, resolved_date, status, confirmation_status, resolution, affected_versions, fix_versions, category, mojang_priority, area, components, ado, platform, os_version, realms_platform, votes, legacy_votes, synced_date, state FROM issue WHERE key = $1", key)
 	var state string
 	var issue model.Issue
@@ -219,12 +473,9 @@ func (c *DBClient) GetIssueByKey(key string) (*model.Issue, error) {
 	err := row.Scan(&issue.Summary, &issue.CreatorName, &issue.CreatorAvatar, &issue.ReporterName, &issue.ReporterAvatar, &issue.AssigneeName, &issue.AssigneeAvatar, &issue.Description, &issue.Environment, pq.Array(&issue.Labels), &issue.CreatedDate, &issue.UpdatedDate, &issue.ResolvedDate, &issue.Status, &issue.ConfirmationStatus, &issue.Resolution, pq.Array(&issue.AffectedVersions), pq.Array(&issue.FixVersions), pq.Array(&issue.Category), &issue.MojangPriority, &issue.Area, pq.Array(&issue.Components), &issue.ADO, &issue.Platform, &issue.OSVersion, &issue.RealmsPlatform, &issue.Votes, &issue.LegacyVotes, &issue.SyncedDate, &state)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			return nil, model.ErrIssueNotStored
+			return nil, false, model.ErrIssueNotStored
 		}
-		return nil, err
-	}
-	if state == "removed" {
-		return nil, model.ErrIssueRemoved
+		return nil, false, err
 	}
 	comments := []model.Comment{}
 	rows, err := c.db.Query(`SELECT comment_id, legacy_id, date, author_name, author_avatar, adf_comment FROM comment WHERE issue_key = $1 ORDER BY date ASC`, key)
@@ -234,7 +485,7 @@ func (c *DBClient) GetIssueByKey(key string) (*model.Issue, error) {
 			var cmt model.Comment
 			err := rows.Scan(&cmt.Id, &cmt.LegacyId, &cmt.Date, &cmt.AuthorName, &cmt.AuthorAvatar, &cmt.AdfComment)
 			if err != nil {
-				return nil, err
+				return nil, false, err
 			}
 			cmt.Issue = &issue
 			comments = append(comments, cmt)
@@ -249,7 +500,7 @@ func (c *DBClient) GetIssueByKey(key string) (*model.Issue, error) {
 			var l model.IssueLink
 			err := rows.Scan(&l.Type, &l.OtherKey, &l.OtherSummary, &l.OtherStatus)
 			if err != nil {
-				return nil, err
+				return nil, false, err
 			}
 			links = append(links, l)
 		}
@@ -263,13 +514,38 @@ func (c *DBClient) GetIssueByKey(key string) (*model.Issue, error) {
 			var a model.Attachment
 			err := rows.Scan(&a.Id, &a.Filename, &a.AuthorName, &a.AuthorAvatar, &a.CreatedDate, &a.Size, &a.MimeType)
 			if err != nil {
-				return nil, err
+				return nil, false, err
 			}
 			attachments = append(attachments, a)
 		}
 	}
 	issue.Attachments = attachments
-	return &issue, nil
+	return &issue, state == "removed", nil
+}
+
+// Loads the stored fields of several issues at once, without comments, links and attachments
+func (c *DBClient) GetIssuesByKeys(ctx context.Context, keys []string) ([]model.Issue, []string, error) {
+	rows, err := c.db.QueryContext(ctx, "SELECT key, summary, creator_name, creator_avatar, reporter_name, reporter_avatar, assignee_name, assignee_avatar, description, environment, labels, created_date, updated_date, resolved_date, status, confirmation_status, resolution, affected_versions, fix_versions, category, mojang_priority, area, components, ado, platform, os_version, realms_platform, votes, legacy_votes, synced_date, state FROM issue WHERE key = ANY($1) ORDER BY key_num", pq.Array(keys))
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+	issues := []model.Issue{}
+	removed := []string{}
+	for rows.Next() {
+		var state string
+		var issue model.Issue
+		err := rows.Scan(&issue.Key, &issue.Summary, &issue.CreatorName, &issue.CreatorAvatar, &issue.ReporterName, &issue.ReporterAvatar, &issue.AssigneeName, &issue.AssigneeAvatar, &issue.Description, &issue.Environment, pq.Array(&issue.Labels), &issue.CreatedDate, &issue.UpdatedDate, &issue.ResolvedDate, &issue.Status, &issue.ConfirmationStatus, &issue.Resolution, pq.Array(&issue.AffectedVersions), pq.Array(&issue.FixVersions), pq.Array(&issue.Category), &issue.MojangPriority, &issue.Area, pq.Array(&issue.Components), &issue.ADO, &issue.Platform, &issue.OSVersion, &issue.RealmsPlatform, &issue.Votes, &issue.LegacyVotes, &issue.SyncedDate, &state)
+		if err != nil {
+			return nil, nil, err
+		}
+		if state == "removed" {
+			removed = append(removed, issue.Key)
+			continue
+		}
+		issues = append(issues, issue)
+	}
+	return issues, removed, rows.Err()
 }
 
 func (c *DBClient) GetCommentsByUser(name string, offset int, limit int) ([]model.Comment, error) {
@@ -298,7 +574,7 @@ func (c *DBClient) GetCommentsByUser(name string, offset int, limit int) ([]mode
 	return comments, nil
 }
 
-func (c *DBClient) UpdateIssue(ctx context.Context, issue *model.Issue) error {
+func (c *DBClient) UpdateIssue(ctx context.Context, issue *model.Issue, changes []model.FieldChange) error {
 	if issue.Partial {
 		return errors.New("tried to insert a partial issue")
 	}
@@ -310,10 +586,18 @@ func (c *DBClient) UpdateIssue(ctx context.Context, issue *model.Issue) error {
 		tx.Rollback()
 		return err
 	}
+	for _, change := range changes {
+		_, err = tx.Exec(`INSERT INTO issue_history (issue_key, field, old_value, new_value) VALUES ($1, $2, $3, $4)`, issue.Key, change.Field, change.OldValue, change.NewValue)
+		if err != nil {
+			tx.Rollback()
+			return errors.New("failed to insert issue_history: " + err.Error())
+		}
+	}
 	return tx.Commit()
 }
 
-func (c *DBClient) updateIssueImpl(tx *sql.Tx, issue *model.Issue) error {
+// The text column that is searched, with the summary, description, environment and comments
+func searchText(issue *model.Issue) string {
 	var textParts []string
 	if issue.Summary != "" {
 		textParts = append(textParts, issue.Summary)
@@ -329,20 +613,28 @@ func (c *DBClient) updateIssueImpl(tx *sql.Tx, issue *model.Issue) error {
 			textParts = append(textParts, model.ExtractPlainTextFromADF(cmt.AdfComment))
 		}
 	}
-	text := strings.Join(textParts, "\n")
-	duplicateCount := 0
+	return strings.Join(textParts, "\n")
+}
+
+func duplicateCount(issue *model.Issue) int {
+	count := 0
 	for _, l := range issue.Links {
 		if l.Type == "is duplicated by" {
-			duplicateCount += 1
+			count += 1
 		}
 	}
+	return count
+}
+
+func (c *DBClient) updateIssueImpl(tx *sql.Tx, issue *model.Issue) error {
+	text := searchText(issue)
 
 	_, err := tx.Exec(`INSERT INTO issue (key, creator_name, creator_avatar, synced_date, state) VALUES ($1, '', '', NOW(), 'present') ON CONFLICT DO NOTHING`, issue.Key)
 	if err != nil {
 		return err
 	}
 	query := `UPDATE issue SET summary = $2, creator_name = $3, creator_avatar = $4, reporter_name = $5, reporter_avatar = $6, assignee_name = $7, assignee_avatar = $8, description = $9, environment = $10, labels = $11, created_date = $12, updated_date = $13, resolved_date = $14, status = $15, confirmation_status = $16, resolution = $17, affected_versions = $18, fix_versions = $19, category = $20, mojang_priority = $21, area = $22, components = $23, ado = $24, platform = $25, os_version = $26, realms_platform = $27, votes = $28, legacy_votes = $29, text = $30, comment_count = $31, duplicate_count = $32, synced_date = $33, state = 'present' WHERE key = $1`
-	_, err = tx.Exec(query, issue.Key, issue.Summary, issue.CreatorName, issue.CreatorAvatar, issue.ReporterName, issue.ReporterAvatar, issue.AssigneeName, issue.AssigneeAvatar, issue.Description, issue.Environment, pq.Array(issue.Labels), issue.CreatedDate, issue.UpdatedDate, issue.ResolvedDate, issue.Status, issue.ConfirmationStatus, issue.Resolution, pq.Array(issue.AffectedVersions), pq.Array(issue.FixVersions), pq.Array(issue.Category), issue.MojangPriority, issue.Area, pq.Array(issue.Components), issue.ADO, issue.Platform, issue.OSVersion, issue.RealmsPlatform, issue.Votes, issue.LegacyVotes, text, len(issue.Comments), duplicateCount, issue.SyncedDate)
+	_, err = tx.Exec(query, issue.Key, issue.Summary, issue.CreatorName, issue.CreatorAvatar, issue.ReporterName, issue.ReporterAvatar, issue.AssigneeName, issue.AssigneeAvatar, issue.Description, issue.Environment, pq.Array(issue.Labels), issue.CreatedDate, issue.UpdatedDate, issue.ResolvedDate, issue.Status, issue.ConfirmationStatus, issue.Resolution, pq.Array(issue.AffectedVersions), pq.Array(issue.FixVersions), pq.Array(issue.Category), issue.MojangPriority, issue.Area, pq.Array(issue.Components), issue.ADO, issue.Platform, issue.OSVersion, issue.RealmsPlatform, issue.Votes, issue.LegacyVotes, text, len(issue.Comments), duplicateCount(issue), issue.SyncedDate)
 	if err != nil {
 		return errors.New("failed to update issue: " + err.Error())
 	}
@@ -380,6 +672,55 @@ func (c *DBClient) updateIssueImpl(tx *sql.Tx, issue *model.Issue) error {
 	return nil
 }
 
+func (c *DBClient) GetIssueHistory(ctx context.Context, key string) ([]model.FieldChange, error) {
+	rows, err := c.db.QueryContext(ctx, `SELECT field, old_value, new_value, changed_date FROM issue_history WHERE issue_key = $1 ORDER BY changed_date DESC, id DESC`, key)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	history := []model.FieldChange{}
+	for rows.Next() {
+		var change model.FieldChange
+		if err := rows.Scan(&change.Field, &change.OldValue, &change.NewValue, &change.Date); err != nil {
+			return nil, err
+		}
+		history = append(history, change)
+	}
+	return history, nil
+}
+
+func (c *DBClient) InsertActivity(ctx context.Context, events []model.ActivityEvent) error {
+	tx, err := c.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, e := range events {
+		_, err = tx.ExecContext(ctx, `INSERT INTO activity (issue_key, type, summary, old_value, new_value, author_name, anchor, versions, date) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, COALESCE($9, NOW()))`, e.IssueKey, e.Type, e.Summary, e.OldValue, e.NewValue, e.AuthorName, e.Anchor, pq.Array(e.Versions), e.Date)
+		if err != nil {
+			return errors.New("failed to insert activity: " + err.Error())
+		}
+	}
+	return tx.Commit()
+}
+
+func (c *DBClient) GetActivity(ctx context.Context, project string, typ string, version string, before int, limit int) ([]model.ActivityEvent, error) {
+	rows, err := c.db.QueryContext(ctx, `SELECT id, issue_key, type, summary, old_value, new_value, author_name, anchor, versions, date FROM activity WHERE ($1 = '' OR project = $1) AND ($2 = '' OR type = $2) AND ($3 = '' OR $3=ANY(versions)) AND ($4 = 0 OR id < $4) ORDER BY id DESC LIMIT $5`, project, typ, version, before, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	events := []model.ActivityEvent{}
+	for rows.Next() {
+		var e model.ActivityEvent
+		if err := rows.Scan(&e.Id, &e.IssueKey, &e.Type, &e.Summary, &e.OldValue, &e.NewValue, &e.AuthorName, &e.Anchor, pq.Array(&e.Versions), &e.Date); err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+	return events, nil
+}
+
 func (c *DBClient) MarkIssueRemoved(key string) error {
 	query := `UPDATE issue SET state = 'removed' WHERE key = $1`
 	_, err := c.db.Exec(query, key)
@@ -421,13 +762,81 @@ func (c *DBClient) QueueIssueKeys(keys []string, priority int, reason string) ([
 	return result, nil
 }
 
-func (c *DBClient) PeekQueuedIssues(ctx context.Context, limit int) ([]string, error) {
-	query := `SELECT issue_key
-		FROM sync_queue
-		WHERE retry_after <= NOW()
-		ORDER BY priority DESC, failed_count ASC, queued_date ASC
-		LIMIT $1`
-	rows, err := c.db.QueryContext(ctx, query, limit)
+var ErrLeaseLost = errors.New("queue lease lost")
+
+type ClaimedIssue struct {
+	Key    string
+	Reason string
+	// The worker whose expired lease was taken over, if any
+	AbandonedBy string
+	// The scan job that queued the key, if any
+	ScanJobId *int
+}
+
+// Claims up to limit queued keys for a worker until the lease expires. Rows claimed by other workers are skipped, unless their lease expired
+func (c *DBClient) ClaimQueuedIssues(ctx context.Context, worker string, limit int, lease time.Duration) ([]ClaimedIssue, error) {
+	query := `UPDATE sync_queue q
+		SET claimed_by = $1, lease_until = NOW() + make_interval(secs => $3)
+		FROM (
+			SELECT issue_key, claimed_by
+			FROM sync_queue
+			WHERE retry_after <= NOW() AND (claimed_by IS NULL OR lease_until < NOW())
+			ORDER BY priority DESC, failed_count ASC, queued_date ASC
+			LIMIT $2
+			FOR UPDATE SKIP LOCKED
+		) c
+		WHERE q.issue_key = c.issue_key
+		RETURNING q.issue_key, q.reason, COALESCE(c.claimed_by, ''), q.scan_job_id`
+	rows, err := c.db.QueryContext(ctx, query, worker, limit, lease.Seconds())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var claimed []ClaimedIssue
+	for rows.Next() {
+		var issue ClaimedIssue
+		if err := rows.Scan(&issue.Key, &issue.Reason, &issue.AbandonedBy, &issue.ScanJobId); err != nil {
+			return nil, err
+		}
+		claimed = append(claimed, issue)
+	}
+	return claimed, rows.Err()
+}
+
+// Highest key number of each project, including removed issues
+func (c *DBClient) GetHighestKeyNums(ctx context.Context, projects []string) (map[string]int, error) {
+	rows, err := c.db.QueryContext(ctx, `SELECT p, (SELECT MAX(key_num) FROM issue WHERE project = p) FROM UNNEST($1::text[]) AS p`, pq.Array(projects))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	result := make(map[string]int)
+	for rows.Next() {
+		var project string
+		var keyNum *int
+		if err := rows.Scan(&project, &keyNum); err != nil {
+			return nil, err
+		}
+		if keyNum != nil {
+			result[project] = *keyNum
+		}
+	}
+	return result, rows.Err()
+}
+
+// Finds keys below the highest key of a project that aren't mirrored and weren't probed since the given time, newest first
+func (c *DBClient) FindKeyGaps(ctx context.Context, project string, probedSince time.Time, limit int) ([]string, error) {
+	query := `SELECT $1::text || '-' || n
+		FROM (
+			SELECT key_num, LEAD(key_num) OVER (ORDER BY key_num) AS next_num
+			FROM issue
+			WHERE project = $1::text
+		) t, generate_series(t.key_num + 1, t.next_num - 1) AS n
+		WHERE t.next_num > t.key_num + 1
+			AND NOT EXISTS (SELECT 1 FROM key_probe p WHERE p.issue_key = $1::text || '-' || n AND p.checked_date >= $2)
+		ORDER BY n DESC
+		LIMIT $3`
+	rows, err := c.db.QueryContext(ctx, query, project, probedSince, limit)
 	if err != nil {
 		return nil, err
 	}
@@ -440,7 +849,50 @@ func (c *DBClient) PeekQueuedIssues(ctx context.Context, limit int) ([]string, e
 		}
 		keys = append(keys, key)
 	}
-	return keys, nil
+	return keys, rows.Err()
+}
+
+// Remembers that a key was probed, so gap probes skip it until the probe is old enough
+func (c *DBClient) RecordKeyProbe(ctx context.Context, key string, reason string) error {
+	query := `INSERT INTO key_probe (issue_key, reason) VALUES ($1, $2)
+		ON CONFLICT (issue_key) DO UPDATE SET reason = EXCLUDED.reason, checked_date = NOW()`
+	_, err := c.db.ExecContext(ctx, query, key, reason)
+	return err
+}
+
+// Queues keys that aren't mirrored yet, skipping the ones that were probed since the given time
+func (c *DBClient) QueueProbeKeys(ctx context.Context, keys []string, priority int, reason string, probedSince time.Time) ([]string, error) {
+	if len(keys) == 0 {
+		return nil, nil
+	}
+	query := `
+		WITH probed AS (
+			INSERT INTO key_probe (issue_key, reason)
+			SELECT k, $3 FROM UNNEST($1::text[]) AS k
+			WHERE NOT EXISTS (SELECT 1 FROM issue i WHERE i.key = k)
+			ON CONFLICT (issue_key) DO UPDATE SET reason = EXCLUDED.reason, checked_date = NOW()
+			WHERE key_probe.checked_date < $4
+			RETURNING issue_key
+		)
+		INSERT INTO sync_queue (issue_key, priority, reason)
+		SELECT issue_key, $2, $3 FROM probed
+		ON CONFLICT DO NOTHING
+		RETURNING issue_key
+	`
+	rows, err := c.db.QueryContext(ctx, query, pq.Array(keys), priority, reason, probedSince)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var result []string
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return nil, err
+		}
+		result = append(result, key)
+	}
+	return result, rows.Err()
 }
 
 func (c *DBClient) PeekFutureVersionIssues(ctx context.Context, limit int) ([]string, error) {
@@ -467,7 +919,8 @@ func (c *DBClient) PeekFutureVersionIssues(ctx context.Context, limit int) ([]st
 	return keys, nil
 }
 
-func (c *DBClient) RetryQueuedIssue(ctx context.Context, key string) error {
+// Releases a claimed key and schedules it for a retry, or moves it to the dead letter table after too many failures
+func (c *DBClient) RetryQueuedIssue(ctx context.Context, worker string, key string, lastError string) error {
 	tx, err := c.db.BeginTx(ctx, nil)
 	if err != nil {
 		return err
@@ -476,14 +929,32 @@ func (c *DBClient) RetryQueuedIssue(ctx context.Context, key string) error {
 
 	var failedCount int
 	var priority int
-	query := `SELECT failed_count, priority FROM sync_queue WHERE issue_key = $1 FOR UPDATE`
-	err = tx.QueryRowContext(ctx, query, key).Scan(&failedCount, &priority)
+	query := `SELECT failed_count, priority FROM sync_queue WHERE issue_key = $1 AND claimed_by = $2 FOR UPDATE`
+	err = tx.QueryRowContext(ctx, query, key, worker).Scan(&failedCount, &priority)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrLeaseLost
+	}
 	if err != nil {
 		return err
 	}
 
 	failedCount += 1
 	if failedCount > 4 && priority < 10 {
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO sync_dead_letter (issue_key, priority, reason, last_error, failed_count, first_failed_date)
+			SELECT issue_key, priority, reason, $2, $3, COALESCE(first_failed_date, NOW())
+			FROM sync_queue
+			WHERE issue_key = $1
+			ON CONFLICT (issue_key) DO UPDATE SET
+				priority = EXCLUDED.priority,
+				reason = EXCLUDED.reason,
+				last_error = EXCLUDED.last_error,
+				failed_count = sync_dead_letter.failed_count + EXCLUDED.failed_count,
+				last_failed_date = NOW()
+		`, key, lastError, failedCount)
+		if err != nil {
+			return errors.New("failed to insert sync_dead_letter: " + err.Error())
+		}
 		_, err = tx.ExecContext(ctx, `DELETE FROM sync_queue WHERE issue_key = $1`, key)
 	} else {
 		// Delay will be: 2m, 4m, 8m, 16m, 16m, 16m, ...
@@ -494,9 +965,13 @@ func (c *DBClient) RetryQueuedIssue(ctx context.Context, key string) error {
 			SET 
 				failed_count = $2,
 				queued_date = NOW(),
-				retry_after = $3
+				retry_after = $3,
+				claimed_by = NULL,
+				lease_until = NULL,
+				last_error = $4,
+				first_failed_date = COALESCE(first_failed_date, NOW())
 			WHERE issue_key = $1
-		`, key, failedCount, retryAfter)
+		`, key, failedCount, retryAfter, lastError)
 	}
 	if err != nil {
 		return err
@@ -505,12 +980,47 @@ func (c *DBClient) RetryQueuedIssue(ctx context.Context, key string) error {
 	return tx.Commit()
 }
 
-func (c *DBClient) DeleteQueuedIssue(ctx context.Context, key string) error {
-	query := `DELETE FROM sync_queue WHERE issue_key = $1`
-	_, err := c.db.ExecContext(ctx, query, key)
+// Keeps the claim of a key that is still being processed
+func (c *DBClient) ExtendQueuedIssueLease(ctx context.Context, worker string, key string, lease time.Duration) error {
+	query := `UPDATE sync_queue SET lease_until = NOW() + make_interval(secs => $3) WHERE issue_key = $1 AND claimed_by = $2`
+	result, err := c.db.ExecContext(ctx, query, key, worker, lease.Seconds())
+	if err != nil {
+		return err
+	}
+	extended, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if extended == 0 {
+		return ErrLeaseLost
+	}
+	return nil
+}
+
+// Gives up a claim without counting it as a failure, so the key is processed again soon
+func (c *DBClient) ReleaseQueuedIssue(ctx context.Context, worker string, key string) error {
+	query := `UPDATE sync_queue SET claimed_by = NULL, lease_until = NULL WHERE issue_key = $1 AND claimed_by = $2`
+	_, err := c.db.ExecContext(ctx, query, key, worker)
 	return err
 }
 
+// Removes a processed key from the queue, as long as the worker still holds its lease
+func (c *DBClient) DeleteQueuedIssue(ctx context.Context, worker string, key string) error {
+	query := `DELETE FROM sync_queue WHERE issue_key = $1 AND claimed_by = $2`
+	result, err := c.db.ExecContext(ctx, query, key, worker)
+	if err != nil {
+		return err
+	}
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return ErrLeaseLost
+	}
+	return nil
+}
+
 func (c *DBClient) GetQueueSize(ctx context.Context) (int, error) {
 	row := c.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM sync_queue`)
 	var count int
@@ -555,10 +1065,13 @@ type QueueRow struct {
 	Reason      string
 	FailedCount int
 	RetryAfter  *time.Time
+	ClaimedBy   string
+	LeaseUntil  *time.Time
+	LastError   string
 }
 
 func (c *DBClient) GetQueue(ctx context.Context) ([]QueueRow, int, error) {
-	rows, err := c.db.QueryContext(ctx, `SELECT issue_key, queued_date, priority, reason, failed_count, retry_after FROM sync_queue ORDER BY priority DESC, queued_date ASC LIMIT 100`)
+	rows, err := c.db.QueryContext(ctx, `SELECT issue_key, queued_date, priority, reason, failed_count, retry_after, COALESCE(claimed_by, ''), lease_until, last_error FROM sync_queue ORDER BY priority DESC, queued_date ASC LIMIT 100`)
 	if err != nil {
 		return nil, 0, err
 	}
@@ -566,7 +1079,7 @@ func (c *DBClient) GetQueue(ctx context.Context) ([]QueueRow, int, error) {
 	var queue []QueueRow
 	for rows.Next() {
 		var q QueueRow
-		if err := rows.Scan(&q.Key, &q.QueuedDate, &q.Priority, &q.Reason, &q.FailedCount, &q.RetryAfter); err != nil {
+		if err := rows.Scan(&q.Key, &q.QueuedDate, &q.Priority, &q.Reason, &q.FailedCount, &q.RetryAfter, &q.ClaimedBy, &q.LeaseUntil, &q.LastError); err != nil {
 			return nil, 0, err
 		}
 		queue = append(queue, q)
@@ -578,7 +1091,418 @@ func (c *DBClient) GetQueue(ctx context.Context) ([]QueueRow, int, error) {
 	return queue, count, nil
 }
 
+type DeadLetterRow struct {
+	Key             string
+	Priority        int
+	Reason          string
+	LastError       string
+	FailedCount     int
+	FirstFailedDate *time.Time
+	LastFailedDate  *time.Time
+}
+
+func (c *DBClient) GetDeadLetters(ctx context.Context, limit int) ([]DeadLetterRow, int, error) {
+	rows, err := c.db.QueryContext(ctx, `SELECT issue_key, priority, reason, last_error, failed_count, first_failed_date, last_failed_date FROM sync_dead_letter ORDER BY last_failed_date DESC LIMIT $1`, limit)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+	var deadLetters []DeadLetterRow
+	for rows.Next() {
+		var d DeadLetterRow
+		if err := rows.Scan(&d.Key, &d.Priority, &d.Reason, &d.LastError, &d.FailedCount, &d.FirstFailedDate, &d.LastFailedDate); err != nil {
+			return nil, 0, err
+		}
+		deadLetters = append(deadLetters, d)
+	}
+	count, err := c.GetDeadLetterSize(ctx)
+	if err != nil {
+		return nil, 0, err
+	}
+	return deadLetters, count, nil
+}
+
+func (c *DBClient) GetDeadLetterSize(ctx context.Context) (int, error) {
+	row := c.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM sync_dead_letter`)
+	var count int
+	err := row.Scan(&count)
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// Moves dead letters back into the sync queue, all of them when keys is nil
+func (c *DBClient) RequeueDeadLetters(ctx context.Context, keys []string) ([]string, error) {
+	tx, err := c.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, `DELETE FROM sync_dead_letter
+		WHERE $1::text[] IS NULL OR issue_key = ANY($1)
+		RETURNING issue_key, priority, reason`, pq.Array(keys))
+	if err != nil {
+		return nil, err
+	}
+	var requeued []string
+	var priorities []int64
+	var reasons []string
+	for rows.Next() {
+		var key, reason string
+		var priority int64
+		if err := rows.Scan(&key, &priority, &reason); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		requeued = append(requeued, key)
+		priorities = append(priorities, priority)
+		reasons = append(reasons, reason)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	_, err = tx.ExecContext(ctx, `INSERT INTO sync_queue (issue_key, priority, reason)
+		SELECT * FROM UNNEST($1::text[], $2::int[], $3::text[])
+		ON CONFLICT DO NOTHING`, pq.Array(requeued), pq.Array(priorities), pq.Array(reasons))
+	if err != nil {
+		return nil, errors.New("failed to insert sync_queue: " + err.Error())
+	}
+	return requeued, tx.Commit()
+}
+
+type ScanJob struct {
+	Id           int
+	Project      string
+	RangeStart   int
+	RangeEnd     *int // Up to the highest mirrored key when nil
+	Predicate    string
+	Priority     int
+	Reason       string
+	ChunkSize    int
+	RepeatHours  int // Runs only once when 0
+	Enabled      bool
+	NextNum      int
+	ScanEnd      *int
+	QueuedCount  int
+	CreatedDate  *time.Time
+	StartedDate  *time.Time
+	FinishedDate *time.Time
+}
+
+// Percentage of the current pass that was queued
+func (j ScanJob) Progress() int {
+	if j.ScanEnd == nil {
+		return 0
+	}
+	total := *j.ScanEnd - j.RangeStart + 1
+	if total <= 0 || j.NextNum > *j.ScanEnd {
+		return 100
+	}
+	return (j.NextNum - j.RangeStart) * 100 / total
+}
+
+// SQL selecting the keys of one chunk, $1 is the project and $2 and $3 the range of key numbers
+var scanPredicates = map[string]string{
+	"all":      `SELECT $1::text || '-' || n FROM generate_series($2::int, $3::int) AS n`,
+	"missing":  `SELECT $1::text || '-' || n FROM generate_series($2::int, $3::int) AS n WHERE NOT EXISTS (SELECT 1 FROM issue WHERE key = $1::text || '-' || n)`,
+	"present":  `SELECT key FROM issue WHERE project = $1::text AND key_num BETWEEN $2::int AND $3::int AND state = 'present'`,
+	"resolved": `SELECT key FROM issue WHERE project = $1::text AND key_num BETWEEN $2::int AND $3::int AND state = 'present' AND status NOT IN ('Open', 'Reopened')`,
+	"removed":  `SELECT key FROM issue WHERE project = $1::text AND key_num BETWEEN $2::int AND $3::int AND state = 'removed'`,
+}
+
+const scanJobColumns = `id, project, range_start, range_end, predicate, priority, reason, chunk_size, repeat_hours, enabled, next_num, scan_end, queued_count, created_date, started_date, finished_date`
+
+func scanScanJob(row interface{ Scan(...any) error }) (ScanJob, error) {
+	var j ScanJob
+	err := row.Scan(&j.Id, &j.Project, &j.RangeStart, &j.RangeEnd, &j.Predicate, &j.Priority, &j.Reason, &j.ChunkSize, &j.RepeatHours, &j.Enabled, &j.NextNum, &j.ScanEnd, &j.QueuedCount, &j.CreatedDate, &j.StartedDate, &j.FinishedDate)
+	return j, err
+}
+
+func (c *DBClient) GetScanJobs(ctx context.Context) ([]ScanJob, error) {
+	rows, err := c.db.QueryContext(ctx, `SELECT `+scanJobColumns+` FROM scan_job ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var jobs []ScanJob
+	for rows.Next() {
+		j, err := scanScanJob(rows)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, j)
+	}
+	return jobs, rows.Err()
+}
+
+func (c *DBClient) CreateScanJob(ctx context.Context, job ScanJob) (int, error) {
+	if _, ok := scanPredicates[job.Predicate]; !ok {
+		return 0, fmt.Errorf("unknown scan predicate %q", job.Predicate)
+	}
+	var id int
+	err := c.db.QueryRowContext(ctx, `INSERT INTO scan_job (project, range_start, range_end, predicate, priority, reason, chunk_size, repeat_hours, next_num)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $2)
+		RETURNING id`, job.Project, job.RangeStart, job.RangeEnd, job.Predicate, job.Priority, job.Reason, job.ChunkSize, job.RepeatHours).Scan(&id)
+	return id, err
+}
+
+func (c *DBClient) SetScanJobEnabled(ctx context.Context, id int, enabled bool) error {
+	_, err := c.db.ExecContext(ctx, `UPDATE scan_job SET enabled = $2 WHERE id = $1`, id, enabled)
+	return err
+}
+
+func (c *DBClient) DeleteScanJob(ctx context.Context, id int) error {
+	_, err := c.db.ExecContext(ctx, `DELETE FROM scan_job WHERE id = $1`, id)
+	return err
+}
+
+// Queues the next chunk of a scan job, once the keys it queued before have mostly been processed.
+// Jobs that are being advanced by another instance are skipped. Returns the number of queued keys
+func (c *DBClient) AdvanceScanJob(ctx context.Context, id int) (int, error) {
+	tx, err := c.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	row := tx.QueryRowContext(ctx, `SELECT `+scanJobColumns+` FROM scan_job WHERE id = $1 AND enabled FOR UPDATE SKIP LOCKED`, id)
+	job, err := scanScanJob(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	predicate, ok := scanPredicates[job.Predicate]
+	if !ok {
+		return 0, fmt.Errorf("unknown scan predicate %q", job.Predicate)
+	}
+
+	if job.FinishedDate != nil {
+		if job.RepeatHours <= 0 || time.Since(*job.FinishedDate) < time.Duration(job.RepeatHours)*time.Hour {
+			return 0, nil
+		}
+		job.NextNum = job.RangeStart
+		job.FinishedDate = nil
+		job.StartedDate = nil
+	}
+
+	var backlog int
+	err = tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM sync_queue WHERE scan_job_id = $1`, job.Id).Scan(&backlog)
+	if err != nil {
+		return 0, err
+	}
+	if backlog >= job.ChunkSize {
+		return 0, nil
+	}
+
+	scanEnd := job.RangeEnd
+	if scanEnd == nil {
+		err = tx.QueryRowContext(ctx, `SELECT MAX(key_num) FROM issue WHERE project = $1`, job.Project).Scan(&scanEnd)
+		if err != nil {
+			return 0, err
+		}
+		if scanEnd == nil {
+			scanEnd = &job.RangeStart
+		}
+	}
+	to := min(job.NextNum+job.ChunkSize-1, *scanEnd)
+
+	var queued int64
+	if job.NextNum <= to {
+		result, err := tx.ExecContext(ctx, `INSERT INTO sync_queue (issue_key, priority, reason, scan_job_id)
+			SELECT k, $4, $5, $6 FROM (`+predicate+`) AS c(k)
+			ON CONFLICT DO NOTHING`, job.Project, job.NextNum, to, job.Priority, job.Reason, job.Id)
+		if err != nil {
+			return 0, errors.New("failed to insert sync_queue: " + err.Error())
+		}
+		queued, err = result.RowsAffected()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE scan_job SET
+			next_num = $2,
+			scan_end = $3,
+			queued_count = CASE WHEN $5 THEN $4 ELSE queued_count + $4 END,
+			started_date = CASE WHEN $5 THEN NOW() ELSE started_date END,
+			finished_date = CASE WHEN $2 > $3 THEN NOW() END
+		WHERE id = $1`, job.Id, max(job.NextNum, to+1), *scanEnd, queued, job.StartedDate == nil)
+	if err != nil {
+		return 0, err
+	}
+	return int(queued), tx.Commit()
+}
+
 func (c *DBClient) RefreshCountView() error {
 	_, err := c.db.Exec(`REFRESH MATERIALIZED VIEW CONCURRENTLY issue_count`)
 	return err
 }
+
+type Webhook struct {
+	Id          int
+	Url         string
+	Secret      string
+	Format      string // json, discord or slack
+	Filter      WebhookFilter
+	Events      []string
+	Enabled     bool
+	CreatedDate *time.Time
+	QueryError  error
+}
+
+type WebhookFilter struct {
+	IssueFilter
+	Key string `json:"key,omitempty"`
+}
+
+func (f WebhookFilter) String() string {
+	b, _ := json.Marshal(f)
+	return string(b)
+}
+
+func (c *DBClient) GetWebhooks(ctx context.Context) ([]Webhook, error) {
+	rows, err := c.db.QueryContext(ctx, `SELECT id, url, secret, format, filter, events, enabled, created_date FROM webhook ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var webhooks []Webhook
+	for rows.Next() {
+		var h Webhook
+		var filter []byte
+		if err := rows.Scan(&h.Id, &h.Url, &h.Secret, &h.Format, &filter, pq.Array(&h.Events), &h.Enabled, &h.CreatedDate); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(filter, &h.Filter); err != nil {
+			return nil, fmt.Errorf("invalid filter for webhook %d: %w", h.Id, err)
+		}
+		h.parseQuery()
+		webhooks = append(webhooks, h)
+	}
+	return webhooks, nil
+}
+
+type WebhookDelivery struct {
+	Id            int
+	WebhookId     int
+	WebhookUrl    string
+	Secret        string
+	Format        string
+	IssueKey      string
+	Payload       string
+	Status        string
+	FailedCount   int
+	ResponseCode  *int
+	LastError     string
+	QueuedDate    *time.Time
+	RetryAfter    *time.Time
+	ClaimedBy     string
+	LeaseUntil    *time.Time
+	DeliveredDate *time.Time
+}
+
+func (c *DBClient) QueueWebhookDeliveries(ctx context.Context, deliveries []WebhookDelivery) error {
+	tx, err := c.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, d := range deliveries {
+		_, err = tx.ExecContext(ctx, `INSERT INTO webhook_delivery (webhook_id, issue_key, payload, retry_after) VALUES ($1, $2, $3, COALESCE($4::timestamptz, NOW()))`, d.WebhookId, d.IssueKey, d.Payload, d.RetryAfter)
+		if err != nil {
+			return errors.New("failed to insert webhook_delivery: " + err.Error())
+		}
+	}
+	return tx.Commit()
+}
+
+// Claims pending deliveries for a worker, deliveries whose lease expired can be claimed by another worker
+func (c *DBClient) ClaimWebhookDeliveries(ctx context.Context, worker string, limit int, lease time.Duration) ([]WebhookDelivery, error) {
+	rows, err := c.db.QueryContext(ctx, `UPDATE webhook_delivery d
+		SET claimed_by = $1, lease_until = NOW() + make_interval(secs => $3)
+		FROM (
+			SELECT d.id
+			FROM webhook_delivery d
+			JOIN webhook h ON h.id = d.webhook_id
+			WHERE d.status = 'pending' AND d.retry_after <= NOW() AND h.enabled AND (d.claimed_by IS NULL OR d.lease_until < NOW())
+			ORDER BY d.retry_after ASC, d.id ASC
+			LIMIT $2
+			FOR UPDATE OF d SKIP LOCKED
+		) c, webhook h
+		WHERE d.id = c.id AND h.id = d.webhook_id
+		RETURNING d.id, d.webhook_id, h.url, h.secret, h.format, d.issue_key, d.payload, d.failed_count`, worker, limit, lease.Seconds())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var deliveries []WebhookDelivery
+	for rows.Next() {
+		var d WebhookDelivery
+		if err := rows.Scan(&d.Id, &d.WebhookId, &d.WebhookUrl, &d.Secret, &d.Format, &d.IssueKey, &d.Payload, &d.FailedCount); err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, d)
+	}
+	return deliveries, rows.Err()
+}
+
+func (c *DBClient) MarkWebhookDelivered(ctx context.Context, id int, responseCode int) error {
+	_, err := c.db.ExecContext(ctx, `UPDATE webhook_delivery SET status = 'delivered', response_code = $2, last_error = '', delivered_date = NOW(), claimed_by = NULL, lease_until = NULL WHERE id = $1`, id, responseCode)
+	return err
+}
+
+func (c *DBClient) RetryWebhookDelivery(ctx context.Context, id int, responseCode *int, lastError string) error {
+	tx, err := c.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var failedCount int
+	err = tx.QueryRowContext(ctx, `SELECT failed_count FROM webhook_delivery WHERE id = $1 FOR UPDATE`, id).Scan(&failedCount)
+	if err != nil {
+		return err
+	}
+
+	failedCount += 1
+	if failedCount >= 8 {
+		_, err = tx.ExecContext(ctx, `UPDATE webhook_delivery SET status = 'failed', failed_count = $2, response_code = $3, last_error = $4, claimed_by = NULL, lease_until = NULL WHERE id = $1`, id, failedCount, responseCode, lastError)
+	} else {
+		// Delay will be: 2m, 4m, 8m, 16m, 16m, 16m, ...
+		delay := time.Duration(math.Pow(2, float64(min(4, failedCount)))) * time.Minute
+		retryAfter := time.Now().Add(delay)
+		_, err = tx.ExecContext(ctx, `UPDATE webhook_delivery SET failed_count = $2, response_code = $3, last_error = $4, retry_after = $5, claimed_by = NULL, lease_until = NULL WHERE id = $1`, id, failedCount, responseCode, lastError, retryAfter)
+	}
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (c *DBClient) GetWebhookDeliveries(ctx context.Context, limit int) ([]WebhookDelivery, error) {
+	rows, err := c.db.QueryContext(ctx, `SELECT d.id, d.webhook_id, h.url, d.issue_key, d.status, d.failed_count, d.response_code, d.last_error, d.queued_date, d.retry_after, d.delivered_date
+		FROM webhook_delivery d
+		JOIN webhook h ON h.id = d.webhook_id
+		ORDER BY d.id DESC
+		LIMIT $1`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var deliveries []WebhookDelivery
+	for rows.Next() {
+		var d WebhookDelivery
+		if err := rows.Scan(&d.Id, &d.WebhookId, &d.WebhookUrl, &d.IssueKey, &d.Status, &d.FailedCount, &d.ResponseCode, &d.LastError, &d.QueuedDate, &d.RetryAfter, &d.DeliveredDate); err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, d)
+	}
+	return deliveries, nil
+}
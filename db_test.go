@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestIssueKeysetSQL(t *testing.T) {
+	created := `COALESCE(created_date, '-infinity')`
+	cases := map[string]struct {
+		columns []string
+		types   []string
+	}{
+		"Created":    {[]string{created, `key`}, []string{"timestamptz", "text"}},
+		"Updated":    {[]string{`updated_date`, `key`}, []string{"timestamptz", "text"}},
+		"Resolved":   {[]string{`resolved_date`, `key`}, []string{"timestamptz", "text"}},
+		"Priority":   {[]string{`mojang_priority_rank`, created, `key`}, []string{"int", "timestamptz", "text"}},
+		"Votes":      {[]string{`total_votes`, created, `key`}, []string{"int", "timestamptz", "text"}},
+		"Comments":   {[]string{`comment_count`, created, `key`}, []string{"int", "timestamptz", "text"}},
+		"Duplicates": {[]string{`duplicate_count`, created, `key`}, []string{"int", "timestamptz", "text"}},
+	}
+	if len(cases) != len(issueSorts) {
+		t.Fatalf("expected a case for each of the %d sorts", len(issueSorts))
+	}
+	// The filter arguments come before the cursor values
+	filterArgs := []any{"", "MC"}
+	for name, c := range cases {
+		sort, ok := issueSorts[name]
+		if !ok {
+			t.Errorf("unknown sort %s", name)
+			continue
+		}
+		wantArgs := append([]any{}, filterArgs...)
+		var values, params []string
+		for i, typ := range c.types {
+			values = append(values, fmt.Sprintf("value %d", i))
+			wantArgs = append(wantArgs, values[i])
+			params = append(params, fmt.Sprintf("$%d::%s", len(wantArgs), typ))
+		}
+
+		where, order, selected, args := issueKeysetSQL(sort, &IssueCursor{}, filterArgs)
+		if where != `` || len(args) != len(filterArgs) {
+			t.Errorf("%s: expected no condition on the first page, got %q with %v", name, where, args)
+		}
+		if want := strings.Join(c.columns, " DESC, ") + " DESC"; order != want {
+			t.Errorf("%s: order = %q, want %q", name, order, want)
+		}
+		// The selected values are what the next cursors hold
+		if want := `, (` + strings.Join(c.columns, `)::text, (`) + `)::text`; selected != want {
+			t.Errorf("%s: selected %q, want %q", name, selected, want)
+		}
+
+		for _, before := range []bool{false, true} {
+			cursor, err := ParseIssueCursor((&IssueCursor{Sort: name, Values: values, Before: before}).String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			where, order, _, args := issueKeysetSQL(sort, cursor, filterArgs)
+			op, direction := "<", "DESC"
+			if before {
+				op, direction = ">", "ASC"
+			}
+			wantWhere := fmt.Sprintf(` AND (%s) %s (%s)`, strings.Join(c.columns, ", "), op, strings.Join(params, ", "))
+			if where != wantWhere {
+				t.Errorf("%s (before %v): condition = %q, want %q", name, before, where, wantWhere)
+			}
+			if want := strings.Join(c.columns, " "+direction+", ") + " " + direction; order != want {
+				t.Errorf("%s (before %v): order = %q, want %q", name, before, order, want)
+			}
+			if fmt.Sprint(args) != fmt.Sprint(wantArgs) {
+				t.Errorf("%s (before %v): args = %v, want %v", name, before, args, wantArgs)
+			}
+		}
+	}
+}
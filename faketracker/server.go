@@ -0,0 +1,196 @@
+package faketracker
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"log"
+	"net/http"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Name of the servicedesk session cookie
+const sessionCookie = "JSESSIONID"
+
+var keyPattern = regexp.MustCompile(`^[A-Z]+-[0-9]+$`)
+
+// Serves issues from fixture files in the shape of the legacy, public and servicedesk APIs, so the mirror can run without the live trackers.
+// Each issue is a directory named after its key, with a legacy.json, public.json and servicedesk.json. A missing file means that API doesn't know the issue
+type Server struct {
+	fixtures fs.FS
+	mux      *http.ServeMux
+
+	mu       sync.Mutex
+	sessions map[string]bool
+}
+
+func NewServer(fixtures fs.FS) *Server {
+	s := &Server{fixtures: fixtures, mux: http.NewServeMux(), sessions: make(map[string]bool)}
+	s.mux.HandleFunc("GET /rest/api/2/issue/{key}", s.legacyIssue)
+	s.mux.HandleFunc("POST /api/jql-search-post", s.publicSearch)
+	s.mux.HandleFunc("POST /jsd-login/v1/authentication/authenticate", s.serviceDeskLogin)
+	s.mux.HandleFunc("POST /rest/servicedesk/1/customer/models", s.serviceDeskModels)
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// Drops all servicedesk sessions, like the servicedesk does when they expire
+func (s *Server) ExpireSessions() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	clear(s.sessions)
+}
+
+// Reads the fixture of an issue, returning nil when the API doesn't know the issue
+func (s *Server) fixture(key string, source string) (json.RawMessage, error) {
+	if !keyPattern.MatchString(key) {
+		return nil, nil
+	}
+	raw, err := fs.ReadFile(s.fixtures, path.Join(key, source+".json"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return raw, nil
+}
+
+// Keys of the issues the servicedesk knows
+func (s *Server) keys() ([]string, error) {
+	entries, err := fs.ReadDir(s.fixtures, ".")
+	if err != nil {
+		return nil, err
+	}
+	var keys []string
+	for _, entry := range entries {
+		if !entry.IsDir() || !keyPattern.MatchString(entry.Name()) {
+			continue
+		}
+		if _, err := fs.Stat(s.fixtures, path.Join(entry.Name(), "servicedesk.json")); err == nil {
+			keys = append(keys, entry.Name())
+		}
+	}
+	return keys, nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(value); err != nil {
+		log.Printf("[ERROR] [faketracker] Error writing response: %v", err)
+	}
+}
+
+func fixtureError(w http.ResponseWriter, err error) {
+	log.Printf("[ERROR] [faketracker] Error reading fixture: %v", err)
+	http.Error(w, "failed to read fixture", http.StatusInternalServerError)
+}
+
+func (s *Server) legacyIssue(w http.ResponseWriter, r *http.Request) {
+	issue, err := s.fixture(r.PathValue("key"), "legacy")
+	if err != nil {
+		fixtureError(w, err)
+		return
+	}
+	if issue == nil {
+		writeJSON(w, http.StatusNotFound, map[string]any{
+			"errorMessages": []string{"Issue Does Not Exist"},
+			"errors":        map[string]any{},
+		})
+		return
+	}
+	writeJSON(w, http.StatusOK, issue)
+}
+
+func (s *Server) publicSearch(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Search string
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	// Only the exact key lookups done by the mirror are supported
+	key, _ := strings.CutPrefix(strings.TrimSpace(body.Search), "key = ")
+	issues := []json.RawMessage{}
+	issue, err := s.fixture(key, "public")
+	if err != nil {
+		fixtureError(w, err)
+		return
+	}
+	if issue != nil {
+		issues = append(issues, issue)
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"issues": issues})
+}
+
+func (s *Server) serviceDeskLogin(w http.ResponseWriter, r *http.Request) {
+	token := make([]byte, 16)
+	rand.Read(token)
+	session := hex.EncodeToString(token)
+	s.mu.Lock()
+	s.sessions[session] = true
+	s.mu.Unlock()
+	http.SetCookie(w, &http.Cookie{Name: sessionCookie, Value: session, Path: "/", MaxAge: 3600})
+	writeJSON(w, http.StatusOK, map[string]any{})
+}
+
+func (s *Server) serviceDeskModels(w http.ResponseWriter, r *http.Request) {
+	cookie, err := r.Cookie(sessionCookie)
+	s.mu.Lock()
+	valid := err == nil && s.sessions[cookie.Value]
+	s.mu.Unlock()
+	if !valid {
+		http.Error(w, "not logged in", http.StatusUnauthorized)
+		return
+	}
+
+	var body struct {
+		Models  []string
+		Options struct {
+			ReqDetails struct {
+				Key string
+			}
+		}
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	response := make(map[string]any)
+	if slices.Contains(body.Models, "reqDetails") {
+		issue, err := s.fixture(body.Options.ReqDetails.Key, "servicedesk")
+		if err != nil {
+			fixtureError(w, err)
+			return
+		}
+		if issue != nil {
+			response["reqDetails"] = map[string]any{"issue": issue}
+		} else {
+			response["reqDetails"] = map[string]any{}
+		}
+	}
+	if slices.Contains(body.Models, "allReqFilter") {
+		keys, err := s.keys()
+		if err != nil {
+			fixtureError(w, err)
+			return
+		}
+		requests := make([]map[string]string, 0, len(keys))
+		for _, key := range keys {
+			requests = append(requests, map[string]string{"key": key})
+		}
+		response["allReqFilter"] = map[string]any{"requestList": requests}
+	}
+	writeJSON(w, http.StatusOK, response)
+}
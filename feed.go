@@ -0,0 +1,356 @@
+package main
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"mojira/jql"
+	"mojira/model"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+var siteUrl = "https://mojira.dev"
+var feedSize = 50
+
+type feed struct {
+	Title   string
+	Link    string
+	Self    string
+	Updated *time.Time
+	Entries []feedEntry
+}
+
+type feedEntry struct {
+	Id         string
+	Title      string
+	Link       string
+	Author     string
+	Published  *time.Time
+	Updated    *time.Time
+	Content    template.HTML
+	Categories []string
+}
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Id      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Links   []atomLink  `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+}
+
+type atomEntry struct {
+	Id         string         `xml:"id"`
+	Title      string         `xml:"title"`
+	Updated    string         `xml:"updated"`
+	Published  string         `xml:"published,omitempty"`
+	Author     *atomAuthor    `xml:"author"`
+	Link       atomLink       `xml:"link"`
+	Categories []atomCategory `xml:"category"`
+	Content    atomContent    `xml:"content"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type atomCategory struct {
+	Term string `xml:"term,attr"`
+}
+
+type atomContent struct {
+	Type string `xml:"type,attr"`
+	Base string `xml:"xml:base,attr"`
+	Body string `xml:",chardata"`
+}
+
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Atom    string     `xml:"xmlns:atom,attr"`
+	DC      string     `xml:"xmlns:dc,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title         string    `xml:"title"`
+	Link          string    `xml:"link"`
+	Description   string    `xml:"description"`
+	LastBuildDate string    `xml:"lastBuildDate,omitempty"`
+	Self          rssSelf   `xml:"atom:link"`
+	Items         []rssItem `xml:"item"`
+}
+
+type rssSelf struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr"`
+	Type string `xml:"type,attr"`
+}
+
+type rssItem struct {
+	Title       string   `xml:"title"`
+	Link        string   `xml:"link"`
+	Guid        string   `xml:"guid"`
+	Author      string   `xml:"dc:creator,omitempty"`
+	PubDate     string   `xml:"pubDate,omitempty"`
+	Categories  []string `xml:"category"`
+	Description string   `xml:"description"`
+}
+
+func writeFeed(w http.ResponseWriter, r *http.Request, f feed) {
+	updated := time.Now()
+	if f.Updated != nil {
+		updated = *f.Updated
+	}
+	var data any
+	if r.URL.Query().Get("format") == "rss" {
+		w.Header().Set("Content-Type", "application/rss+xml; charset=utf-8")
+		items := make([]rssItem, 0, len(f.Entries))
+		for _, e := range f.Entries {
+			items = append(items, rssItem{
+				Title:       e.Title,
+				Link:        e.Link,
+				Guid:        e.Id,
+				Author:      e.Author,
+				PubDate:     formatFeedTime(e.Published, time.RFC1123Z),
+				Categories:  e.Categories,
+				Description: string(e.Content),
+			})
+		}
+		data = rssFeed{
+			Version: "2.0",
+			Atom:    "http://www.w3.org/2005/Atom",
+			DC:      "http://purl.org/dc/elements/1.1/",
+			Channel: rssChannel{
+				Title:         f.Title,
+				Link:          f.Link,
+				Description:   f.Title,
+				LastBuildDate: updated.UTC().Format(time.RFC1123Z),
+				Self:          rssSelf{Href: f.Self, Rel: "self", Type: "application/rss+xml"},
+				Items:         items,
+			},
+		}
+	} else {
+		w.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+		entries := make([]atomEntry, 0, len(f.Entries))
+		for _, e := range f.Entries {
+			entryUpdated := e.Updated
+			if entryUpdated == nil {
+				entryUpdated = e.Published
+			}
+			entry := atomEntry{
+				Id:        e.Id,
+				Title:     e.Title,
+				Updated:   formatFeedTime(entryUpdated, time.RFC3339),
+				Published: formatFeedTime(e.Published, time.RFC3339),
+				Link:      atomLink{Href: e.Link, Rel: "alternate", Type: "text/html"},
+				Content:   atomContent{Type: "html", Base: siteUrl + "/", Body: string(e.Content)},
+			}
+			if entry.Updated == "" {
+				entry.Updated = updated.UTC().Format(time.RFC3339)
+			}
+			if e.Author != "" {
+				entry.Author = &atomAuthor{Name: e.Author}
+			}
+			for _, c := range e.Categories {
+				entry.Categories = append(entry.Categories, atomCategory{Term: c})
+			}
+			entries = append(entries, entry)
+		}
+		data = atomFeed{
+			Id:      f.Self,
+			Title:   f.Title,
+			Updated: updated.UTC().Format(time.RFC3339),
+			Links: []atomLink{
+				{Href: f.Link, Rel: "alternate", Type: "text/html"},
+				{Href: f.Self, Rel: "self", Type: "application/atom+xml"},
+			},
+			Entries: entries,
+		}
+	}
+	w.Write([]byte(xml.Header))
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(data); err != nil {
+		log.Printf("[ERROR] Encoding feed %s: %s", f.Self, err)
+	}
+}
+
+func formatFeedTime(t *time.Time, layout string) string {
+	if t == nil {
+		return ""
+	}
+	return t.UTC().Format(layout)
+}
+
+// Builds a url on this site with the non-empty query parameters, except for the excluded ones
+func siteQueryUrl(path string, query url.Values, exclude ...string) string {
+	filtered := url.Values{}
+	for k, v := range query {
+		if !slices.Contains(exclude, k) && len(v) > 0 && v[0] != "" {
+			filtered[k] = v
+		}
+	}
+	if len(filtered) == 0 {
+		return siteUrl + path
+	}
+	return siteUrl + path + "?" + filtered.Encode()
+}
+
+func issueStatus(issue *model.Issue) string {
+	if issue.Resolution != "" {
+		return issue.Resolution
+	}
+	return issue.ConfirmationStatus
+}
+
+func commentEntry(cmt *model.Comment) feedEntry {
+	return feedEntry{
+		Id:        fmt.Sprintf("%s/%s#%s", siteUrl, cmt.Issue.Key, cmt.Anchor()),
+		Title:     fmt.Sprintf("%s commented on %s", cmt.AuthorName, cmt.Issue.Key),
+		Link:      fmt.Sprintf("%s/%s#%s", siteUrl, cmt.Issue.Key, cmt.Anchor()),
+		Author:    cmt.AuthorName,
+		Published: cmt.Date,
+		Content:   cmt.Render(),
+	}
+}
+
+func latestDate(entries []feedEntry) *time.Time {
+	var latest *time.Time
+	for _, e := range entries {
+		t := e.Updated
+		if t == nil {
+			t = e.Published
+		}
+		if t != nil && (latest == nil || t.After(*latest)) {
+			latest = t
+		}
+	}
+	return latest
+}
+
+func searchFeedHandler(service *IssueService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		filter := parseIssueFilter(query)
+		filter.WithDescription = true
+		page, err := service.db.FilterIssues(filter, nil, feedSize)
+		var queryErr *jql.Error
+		if errors.As(err, &queryErr) {
+			http.Error(w, "Invalid query: "+queryErr.Error(), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			log.Printf("[ERROR] FilterIssues feed: %s", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		var conditions []string
+		for _, v := range []string{filter.Project, filter.Status, filter.Confirmation, filter.Resolution, filter.Priority, filter.AffectedVersion, filter.FixVersion, filter.Category, filter.Label, filter.Component, filter.Platform, filter.Area} {
+			if v != "" {
+				conditions = append(conditions, v)
+			}
+		}
+		if filter.Search != "" {
+			conditions = append(conditions, fmt.Sprintf("%q", filter.Search))
+		}
+		if filter.Query != "" {
+			conditions = append(conditions, filter.Query)
+		}
+		title := "Issues | mojira.dev"
+		if len(conditions) > 0 {
+			title = fmt.Sprintf("Issues: %s | mojira.dev", strings.Join(conditions, ", "))
+		}
+		entries := make([]feedEntry, 0, len(page.Issues))
+		for _, issue := range page.Issues {
+			entries = append(entries, feedEntry{
+				Id:         fmt.Sprintf("%s/%s", siteUrl, issue.Key),
+				Title:      fmt.Sprintf("[%s] %s", issue.Key, issue.Summary),
+				Link:       fmt.Sprintf("%s/%s", siteUrl, issue.Key),
+				Author:     issue.ReporterName,
+				Published:  issue.CreatedDate,
+				Updated:    issue.UpdatedDate,
+				Content:    issue.RenderDescription(),
+				Categories: slices.DeleteFunc([]string{issue.Status, issueStatus(&issue)}, func(s string) bool { return s == "" }),
+			})
+		}
+		writeFeed(w, r, feed{
+			Title:   title,
+			Link:    siteQueryUrl("/", query, "page", "cursor", "format"),
+			Self:    siteQueryUrl("/feed", query, "page", "cursor"),
+			Updated: latestDate(entries),
+			Entries: entries,
+		})
+	}
+}
+
+func issueFeedHandler(service *IssueService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.PathValue("key")
+		issue, err := service.GetIssue(r.Context(), key)
+		if err != nil {
+			if errors.Is(err, model.ErrIssueRemoved) || errors.Is(err, model.ErrIssueNotFound) {
+				http.Error(w, "Issue not found", http.StatusNotFound)
+				return
+			}
+			log.Printf("[ERROR] Feed /%s: %s", key, err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		comments := issue.Comments
+		if len(comments) > feedSize {
+			comments = comments[len(comments)-feedSize:]
+		}
+		entries := make([]feedEntry, 0, len(comments))
+		for i := len(comments) - 1; i >= 0; i-- {
+			entries = append(entries, commentEntry(&comments[i]))
+		}
+		updated := latestDate(entries)
+		if updated == nil {
+			updated = issue.UpdatedDate
+		}
+		writeFeed(w, r, feed{
+			Title:   fmt.Sprintf("[%s] %s | mojira.dev", issue.Key, issue.Summary),
+			Link:    fmt.Sprintf("%s/%s", siteUrl, issue.Key),
+			Self:    siteQueryUrl(fmt.Sprintf("/%s/feed", issue.Key), r.URL.Query()),
+			Updated: updated,
+			Entries: entries,
+		})
+	}
+}
+
+func userFeedHandler(service *IssueService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userName := r.PathValue("name")
+		comments, err := service.db.GetCommentsByUser(userName, 0, feedSize)
+		if err != nil {
+			log.Printf("[ERROR] GetCommentsByUser feed: %s", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		entries := make([]feedEntry, 0, len(comments))
+		for i := range comments {
+			entries = append(entries, commentEntry(&comments[i]))
+		}
+		writeFeed(w, r, feed{
+			Title:   fmt.Sprintf("Comments by %s | mojira.dev", userName),
+			Link:    fmt.Sprintf("%s/user/%s", siteUrl, url.PathEscape(userName)),
+			Self:    siteQueryUrl(fmt.Sprintf("/user/%s/feed", url.PathEscape(userName)), r.URL.Query()),
+			Updated: latestDate(entries),
+			Entries: entries,
+		})
+	}
+}
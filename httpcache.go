@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"mojira/model"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Responses can change with each deploy, so the start time is part of every validator
+var startTime = time.Now()
+
+// Outdated issues show a refresh indicator, so they are a different version than the same issue before
+var issueFreshness = 5 * time.Minute
+
+// Hashes everything an issue response is built from, so two versions of an issue synced at the same time still get different validators
+func issueContentHash(issue *model.Issue) uint64 {
+	content := *issue
+	content.SyncedDate = nil
+	content.Comments = make([]model.Comment, len(issue.Comments))
+	for i, c := range issue.Comments {
+		// The comments point back at the issue
+		c.Issue = nil
+		content.Comments[i] = c
+	}
+	h := fnv.New64a()
+	json.NewEncoder(h).Encode(content)
+	return h.Sum64()
+}
+
+func issueValidators(kind string, issue *model.Issue, contentHash uint64) (string, time.Time) {
+	syncedDate := *issue.SyncedDate
+	lastModified := syncedDate
+	state := "u"
+	if time.Since(syncedDate) > issueFreshness {
+		lastModified = syncedDate.Add(issueFreshness)
+		state = "s"
+	}
+	if startTime.After(lastModified) {
+		lastModified = startTime
+	}
+	etag := fmt.Sprintf(`W/"%s-%x-%x-%x-%s"`, kind, syncedDate.UnixNano(), contentHash, startTime.Unix(), state)
+	return etag, lastModified.UTC().Truncate(time.Second)
+}
+
+func etagMatches(header string, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// Sets the caching headers of an issue response and answers a conditional request for it, before the response is rendered
+func issueNotModified(w http.ResponseWriter, r *http.Request, cache *issueCache, kind string, issue *model.Issue) bool {
+	if issue.Partial || issue.SyncedDate == nil {
+		return false
+	}
+	etag, lastModified := issueValidators(kind, issue, cache.ContentHash(issue))
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Last-Modified", lastModified.Format(http.TimeFormat))
+	if kind == "html" {
+		w.Header().Set("Cache-Control", "public, no-cache")
+	} else {
+		w.Header().Set("Cache-Control", "public, max-age=60")
+	}
+
+	if match := r.Header.Get("If-None-Match"); match != "" {
+		if !etagMatches(match, etag) {
+			return false
+		}
+	} else if since, err := http.ParseTime(r.Header.Get("If-Modified-Since")); err != nil || lastModified.After(since) {
+		return false
+	}
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
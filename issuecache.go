@@ -0,0 +1,126 @@
+package main
+
+import (
+	"container/list"
+	"log"
+	"mojira/model"
+	"os"
+	"strconv"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var issueCacheRequests = promauto.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "mojira_issue_cache_requests_total",
+		Help: "Number of issue cache lookups by result",
+	},
+	[]string{"result"},
+)
+
+const defaultIssueCacheSize = 2000
+
+// LRU cache of issues as they were read from the database. Cached issues are shared between requests and must not be modified.
+// An entry can be older than the database, for example when it was read just before an update, so readers compare its synced date
+type issueCache struct {
+	mu      sync.Mutex
+	size    int
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+type issueCacheEntry struct {
+	key   string
+	issue *model.Issue
+	// Content hash of the issue for its validators, 0 until it is first needed
+	hash uint64
+}
+
+func newIssueCache(size int) *issueCache {
+	return &issueCache{size: size, order: list.New(), entries: make(map[string]*list.Element)}
+}
+
+// Reads the cache size from ISSUE_CACHE_SIZE, 0 disables the cache
+func issueCacheSize() int {
+	value := os.Getenv("ISSUE_CACHE_SIZE")
+	if value == "" {
+		return defaultIssueCacheSize
+	}
+	size, err := strconv.Atoi(value)
+	if err != nil || size < 0 {
+		log.Printf("Invalid ISSUE_CACHE_SIZE %q, using %d", value, defaultIssueCacheSize)
+		return defaultIssueCacheSize
+	}
+	return size
+}
+
+func (c *issueCache) Get(key string) *model.Issue {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	element, ok := c.entries[key]
+	if !ok {
+		issueCacheRequests.WithLabelValues("miss").Inc()
+		return nil
+	}
+	issueCacheRequests.WithLabelValues("hit").Inc()
+	c.order.MoveToFront(element)
+	return element.Value.(*issueCacheEntry).issue
+}
+
+func (c *issueCache) Add(issue *model.Issue) {
+	if c.size <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if element, ok := c.entries[issue.Key]; ok {
+		element.Value.(*issueCacheEntry).issue = issue
+		element.Value.(*issueCacheEntry).hash = 0
+		c.order.MoveToFront(element)
+		return
+	}
+	c.entries[issue.Key] = c.order.PushFront(&issueCacheEntry{key: issue.Key, issue: issue})
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*issueCacheEntry).key)
+	}
+}
+
+func (c *issueCache) Remove(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if element, ok := c.entries[key]; ok {
+		c.order.Remove(element)
+		delete(c.entries, key)
+	}
+}
+
+// Looks up the entry of this exact version of an issue, the caller holds the lock
+func (c *issueCache) entry(issue *model.Issue) *issueCacheEntry {
+	if element, ok := c.entries[issue.Key]; ok && element.Value.(*issueCacheEntry).issue == issue {
+		return element.Value.(*issueCacheEntry)
+	}
+	return nil
+}
+
+// Hashes the content of an issue, once for each cached version so that conditional requests don't encode the issue every time
+func (c *issueCache) ContentHash(issue *model.Issue) uint64 {
+	c.mu.Lock()
+	if entry := c.entry(issue); entry != nil && entry.hash != 0 {
+		c.mu.Unlock()
+		return entry.hash
+	}
+	c.mu.Unlock()
+
+	hash := issueContentHash(issue)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// The issue may have been replaced while it was hashed
+	if entry := c.entry(issue); entry != nil {
+		entry.hash = hash
+	}
+	return hash
+}
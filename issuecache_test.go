@@ -0,0 +1,74 @@
+package main
+
+import (
+	"mojira/model"
+	"testing"
+)
+
+func TestIssueCache(t *testing.T) {
+	cache := newIssueCache(2)
+	cache.Add(&model.Issue{Key: "MC-1"})
+	cache.Add(&model.Issue{Key: "MC-2"})
+	if cache.Get("MC-1") == nil {
+		t.Fatal("expected MC-1 to be cached")
+	}
+	// MC-1 was used last, so adding a third issue evicts MC-2
+	cache.Add(&model.Issue{Key: "MC-3"})
+	if cache.Get("MC-2") != nil {
+		t.Error("expected the least recently used issue to be evicted")
+	}
+	if cache.Get("MC-1") == nil || cache.Get("MC-3") == nil {
+		t.Error("expected MC-1 and MC-3 to be cached")
+	}
+
+	updated := &model.Issue{Key: "MC-3", Summary: "Updated"}
+	cache.Add(updated)
+	if cache.Get("MC-3") != updated {
+		t.Error("expected adding a cached issue to replace it")
+	}
+	if len(cache.entries) != 2 || cache.order.Len() != 2 {
+		t.Errorf("expected 2 entries, got %d", cache.order.Len())
+	}
+
+	cache.Remove("MC-3")
+	cache.Remove("MC-4")
+	if cache.Get("MC-3") != nil {
+		t.Error("expected MC-3 to be removed")
+	}
+	cache.Add(&model.Issue{Key: "MC-4"})
+	if cache.Get("MC-1") == nil || cache.Get("MC-4") == nil {
+		t.Error("expected a removal to free its slot")
+	}
+}
+
+func TestIssueCacheDisabled(t *testing.T) {
+	cache := newIssueCache(0)
+	cache.Add(&model.Issue{Key: "MC-1"})
+	if cache.Get("MC-1") != nil {
+		t.Error("expected a cache of size 0 to store nothing")
+	}
+}
+
+func TestIssueCacheContentHash(t *testing.T) {
+	cache := newIssueCache(2)
+	issue := &model.Issue{Key: "MC-1", Summary: "Cached"}
+	cache.Add(issue)
+	hash := cache.ContentHash(issue)
+	if hash != issueContentHash(issue) {
+		t.Fatal("expected the hash of the issue content")
+	}
+	// Cached issues aren't modified, so the hash is only computed once
+	issue.Summary = "Modified"
+	if cache.ContentHash(issue) != hash {
+		t.Error("expected the hash to be kept with the cached issue")
+	}
+
+	updated := &model.Issue{Key: "MC-1", Summary: "Updated"}
+	if cache.ContentHash(updated) == hash {
+		t.Error("expected another version of the issue to be hashed on its own")
+	}
+	cache.Add(updated)
+	if cache.ContentHash(updated) != issueContentHash(updated) {
+		t.Error("expected replacing the issue to replace its hash")
+	}
+}
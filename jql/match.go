@@ -0,0 +1,236 @@
+package jql
+
+import (
+	"cmp"
+	"mojira/model"
+	"slices"
+	"strings"
+	"time"
+)
+
+type matcher struct {
+	issue *model.Issue
+}
+
+// Evaluates the query against a single issue, the full text search is approximated by the summary and description
+func (q *Query) Matches(issue *model.Issue) bool {
+	if q.Where == nil {
+		return true
+	}
+	return q.Where.match(&matcher{issue: issue})
+}
+
+func (e *And) match(m *matcher) bool {
+	return e.Left.match(m) && e.Right.match(m)
+}
+
+func (e *Or) match(m *matcher) bool {
+	return e.Left.match(m) || e.Right.match(m)
+}
+
+func (e *Not) match(m *matcher) bool {
+	return !e.Expr.match(m)
+}
+
+func (m *matcher) text(f *Field) string {
+	i := m.issue
+	switch f.Name {
+	case "project":
+		return i.Project()
+	case "key":
+		return i.Key
+	case "summary":
+		return i.Summary
+	case "text":
+		return i.Summary + "\n" + i.Description
+	case "status":
+		return i.Status
+	case "resolution":
+		return i.Resolution
+	case "confirmation":
+		return i.ConfirmationStatus
+	case "priority":
+		return i.MojangPriority
+	case "platform":
+		return i.Platform
+	case "area":
+		return i.Area
+	case "reporter":
+		return i.ReporterName
+	case "assignee":
+		return i.AssigneeName
+	}
+	return ""
+}
+
+func (m *matcher) array(f *Field) []string {
+	i := m.issue
+	switch f.Name {
+	case "labels":
+		return i.Labels
+	case "category":
+		return i.Category
+	case "components":
+		return i.Components
+	case "affected_version":
+		return i.AffectedVersions
+	case "fix_version":
+		return i.FixVersions
+	}
+	return nil
+}
+
+func (m *matcher) number(f *Field) int {
+	i := m.issue
+	switch f.Name {
+	case "votes":
+		return i.TotalVotes()
+	case "comments":
+		return len(i.Comments)
+	case "duplicates":
+		count := 0
+		for _, l := range i.Links {
+			if l.Type == "is duplicated by" {
+				count += 1
+			}
+		}
+		return count
+	case "priority":
+		return priorityRanks[strings.ToLower(i.MojangPriority)]
+	}
+	return 0
+}
+
+func (m *matcher) date(f *Field) *time.Time {
+	i := m.issue
+	switch f.Name {
+	case "created":
+		return i.CreatedDate
+	case "updated":
+		return i.UpdatedDate
+	case "resolved":
+		return i.ResolvedDate
+	}
+	return nil
+}
+
+func compare(a int, op string, b int) bool {
+	switch op {
+	case "=":
+		return a == b
+	case "<":
+		return a < b
+	case "<=":
+		return a <= b
+	case ">":
+		return a > b
+	case ">=":
+		return a >= b
+	}
+	return false
+}
+
+func (e *Clause) match(m *matcher) bool {
+	f := e.Field
+	switch e.Op {
+	case "!=", "!~", "not in", "not empty":
+		positive := *e
+		positive.Op = strings.TrimPrefix(strings.TrimPrefix(e.Op, "not "), "!")
+		return !positive.match(m)
+	case "in":
+		return slices.ContainsFunc(e.Values, func(v Value) bool {
+			return (&Clause{Field: f, Op: "=", Values: []Value{v}}).match(m)
+		})
+	}
+
+	switch f.kind {
+	case searchField:
+		return e.Values[0].Pattern.MatchString(m.text(f))
+	case arrayField:
+		values := m.array(f)
+		switch e.Op {
+		case "empty":
+			return len(values) == 0
+		case "~":
+			return slices.ContainsFunc(values, e.Values[0].Pattern.MatchString)
+		default:
+			return slices.Contains(values, e.Values[0].Text)
+		}
+	case numberField:
+		return compare(m.number(f), e.Op, e.Values[0].Number)
+	case dateField:
+		date := m.date(f)
+		if e.Op == "empty" {
+			return date == nil
+		}
+		if date == nil {
+			return false
+		}
+		v := e.Values[0]
+		op, bound := e.Op, v.Time
+		if v.DayOnly {
+			switch op {
+			case "=":
+				return !date.Before(bound) && date.Before(bound.Add(24*time.Hour))
+			case ">":
+				op, bound = ">=", bound.Add(24*time.Hour)
+			case "<=":
+				op, bound = "<", bound.Add(24*time.Hour)
+			}
+		}
+		return compare(date.Compare(bound), op, 0)
+	default:
+		value := m.text(f)
+		switch e.Op {
+		case "empty":
+			return value == ""
+		case "~":
+			return e.Values[0].Pattern.MatchString(value)
+		case "<", "<=", ">", ">=":
+			return compare(m.number(f), e.Op, e.Values[0].Number)
+		}
+		if f.fold {
+			return strings.EqualFold(value, e.Values[0].Text)
+		}
+		return value == e.Values[0].Text
+	}
+}
+
+// Compares two issues by the ORDER BY of the query. Missing dates sort last in both directions, like NULLS LAST
+func (q *Query) Compare(a *model.Issue, b *model.Issue) int {
+	ma, mb := &matcher{issue: a}, &matcher{issue: b}
+	for _, o := range q.OrderBy {
+		if c := compareField(o.Field, ma, mb, o.Desc); c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+func compareField(f *Field, a *matcher, b *matcher, desc bool) int {
+	var c int
+	switch {
+	case f.kind == dateField:
+		da, db := a.date(f), b.date(f)
+		if da == nil || db == nil {
+			if da == nil && db != nil {
+				return 1
+			}
+			if da != nil && db == nil {
+				return -1
+			}
+			return 0
+		}
+		c = da.Compare(*db)
+	case f.kind == numberField || f.Name == "priority":
+		c = cmp.Compare(a.number(f), b.number(f))
+	case f.kind == arrayField:
+		c = slices.Compare(a.array(f), b.array(f))
+	default:
+		c = strings.Compare(a.text(f), b.text(f))
+	}
+	if desc {
+		return -c
+	}
+	return c
+}
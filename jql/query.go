@@ -0,0 +1,511 @@
+package jql
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Error describes why a query could not be parsed, Pos is the byte offset in the input
+type Error struct {
+	Pos     int
+	Message string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s (at position %d)", e.Message, e.Pos+1)
+}
+
+type Query struct {
+	Where   Expr // nil if the query only has an ORDER BY
+	OrderBy []Order
+}
+
+type Order struct {
+	Field *Field
+	Desc  bool
+}
+
+type Expr interface {
+	sql(c *compiler) string
+	match(m *matcher) bool
+}
+
+type And struct {
+	Left  Expr
+	Right Expr
+}
+
+type Or struct {
+	Left  Expr
+	Right Expr
+}
+
+type Not struct {
+	Expr Expr
+}
+
+type Clause struct {
+	Field  *Field
+	Op     string // one of = != < <= > >= ~ !~ in "not in" empty "not empty"
+	Values []Value
+}
+
+type Value struct {
+	Text    string
+	Number  int
+	Time    time.Time
+	DayOnly bool           // the date had no time, so it covers the whole day
+	Pattern *regexp.Regexp // for ~ and !~
+}
+
+type fieldKind int
+
+const (
+	textField fieldKind = iota
+	arrayField
+	numberField
+	dateField
+	searchField
+)
+
+type Field struct {
+	Name   string
+	Column string
+	kind   fieldKind
+	fold   bool // case insensitive comparisons
+}
+
+var fields = map[string]*Field{
+	"project":          {Name: "project", Column: "project", kind: textField},
+	"key":              {Name: "key", Column: "key", kind: textField},
+	"summary":          {Name: "summary", Column: "summary", kind: textField},
+	"text":             {Name: "text", Column: "text", kind: searchField},
+	"status":           {Name: "status", Column: "status", kind: textField},
+	"resolution":       {Name: "resolution", Column: "resolution", kind: textField},
+	"confirmation":     {Name: "confirmation", Column: "confirmation_status", kind: textField},
+	"priority":         {Name: "priority", Column: "mojang_priority", kind: textField},
+	"platform":         {Name: "platform", Column: "platform", kind: textField},
+	"area":             {Name: "area", Column: "area", kind: textField},
+	"reporter":         {Name: "reporter", Column: "reporter_name", kind: textField, fold: true},
+	"assignee":         {Name: "assignee", Column: "assignee_name", kind: textField, fold: true},
+	"labels":           {Name: "labels", Column: "labels", kind: arrayField},
+	"category":         {Name: "category", Column: "category", kind: arrayField},
+	"components":       {Name: "components", Column: "components", kind: arrayField},
+	"affected_version": {Name: "affected_version", Column: "affected_versions", kind: arrayField},
+	"fix_version":      {Name: "fix_version", Column: "fix_versions", kind: arrayField},
+	"votes":            {Name: "votes", Column: "total_votes", kind: numberField},
+	"comments":         {Name: "comments", Column: "comment_count", kind: numberField},
+	"duplicates":       {Name: "duplicates", Column: "duplicate_count", kind: numberField},
+	"created":          {Name: "created", Column: "created_date", kind: dateField},
+	"updated":          {Name: "updated", Column: "updated_date", kind: dateField},
+	"resolved":         {Name: "resolved", Column: "resolved_date", kind: dateField},
+}
+
+var fieldAliases = map[string]string{
+	"confirmation_status": "confirmation",
+	"mojang_priority":     "priority",
+	"label":               "labels",
+	"component":           "components",
+	"affected_versions":   "affected_version",
+	"affects":             "affected_version",
+	"fix_versions":        "fix_version",
+	"fixversion":          "fix_version",
+}
+
+var priorityRanks = map[string]int{
+	"low":            1,
+	"normal":         2,
+	"important":      3,
+	"very important": 4,
+}
+
+func lookupField(name string) *Field {
+	name = strings.ToLower(name)
+	if alias, ok := fieldAliases[name]; ok {
+		name = alias
+	}
+	return fields[name]
+}
+
+// Parses a query such as `project = MC AND status in (Open, Reopened) ORDER BY votes DESC`
+func Parse(input string) (*Query, error) {
+	tokens, err := lex(input)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	q := &Query{}
+	if !p.peekKeyword("order") && p.peek().kind != tokEOF {
+		q.Where, err = p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+	}
+	if p.peekKeyword("order") {
+		p.next()
+		if !p.peekKeyword("by") {
+			return nil, p.errorf(p.peek(), "expected BY after ORDER")
+		}
+		p.next()
+		for {
+			t := p.next()
+			if t.kind != tokWord {
+				return nil, p.errorf(t, "expected a field to order by")
+			}
+			field := lookupField(t.text)
+			if field == nil {
+				return nil, p.errorf(t, "unknown field %q", t.text)
+			}
+			if field.kind == arrayField || field.kind == searchField {
+				return nil, p.errorf(t, "cannot order by %s", field.Name)
+			}
+			order := Order{Field: field}
+			if p.peekKeyword("desc") {
+				p.next()
+				order.Desc = true
+			} else if p.peekKeyword("asc") {
+				p.next()
+			}
+			q.OrderBy = append(q.OrderBy, order)
+			if p.peek().kind != tokComma {
+				break
+			}
+			p.next()
+		}
+	}
+	if t := p.peek(); t.kind != tokEOF {
+		return nil, p.errorf(t, "unexpected %s", t.describe())
+	}
+	return q, nil
+}
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokWord
+	tokString
+	tokOp
+	tokLParen
+	tokRParen
+	tokComma
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+func (t token) describe() string {
+	if t.kind == tokEOF {
+		return "end of query"
+	}
+	return fmt.Sprintf("%q", t.text)
+}
+
+func isWordChar(r byte) bool {
+	return !strings.ContainsRune(" \t\r\n=!<>~(),\"'", rune(r))
+}
+
+func lex(input string) ([]token, error) {
+	var tokens []token
+	i := 0
+	for i < len(input) {
+		c := input[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n':
+			i++
+		case c == '(':
+			tokens = append(tokens, token{kind: tokLParen, text: "(", pos: i})
+			i++
+		case c == ')':
+			tokens = append(tokens, token{kind: tokRParen, text: ")", pos: i})
+			i++
+		case c == ',':
+			tokens = append(tokens, token{kind: tokComma, text: ",", pos: i})
+			i++
+		case c == '"' || c == '\'':
+			start := i
+			var sb strings.Builder
+			i++
+			for i < len(input) && input[i] != c {
+				if input[i] == '\\' && i+1 < len(input) {
+					i++
+				}
+				sb.WriteByte(input[i])
+				i++
+			}
+			if i >= len(input) {
+				return nil, &Error{Pos: start, Message: "unterminated string"}
+			}
+			i++
+			tokens = append(tokens, token{kind: tokString, text: sb.String(), pos: start})
+		case strings.ContainsRune("=!<>~", rune(c)):
+			start := i
+			op := string(c)
+			if i+1 < len(input) && (input[i+1] == '=' || (c == '!' && input[i+1] == '~')) {
+				op += string(input[i+1])
+			}
+			if op == "!" || op == "==" || op == "~=" {
+				return nil, &Error{Pos: start, Message: fmt.Sprintf("unknown operator %q", op)}
+			}
+			i += len(op)
+			tokens = append(tokens, token{kind: tokOp, text: op, pos: start})
+		default:
+			start := i
+			for i < len(input) && isWordChar(input[i]) {
+				i++
+			}
+			tokens = append(tokens, token{kind: tokWord, text: input[start:i], pos: start})
+		}
+	}
+	tokens = append(tokens, token{kind: tokEOF, pos: len(input)})
+	return tokens, nil
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) peekKeyword(keyword string) bool {
+	t := p.peek()
+	return t.kind == tokWord && strings.EqualFold(t.text, keyword)
+}
+
+func (p *parser) errorf(t token, format string, args ...any) error {
+	return &Error{Pos: t.pos, Message: fmt.Sprintf(format, args...)}
+}
+
+func (p *parser) parseOr() (Expr, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peekKeyword("or") {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &Or{Left: left, Right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd() (Expr, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.peekKeyword("and") {
+		p.next()
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = &And{Left: left, Right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseNot() (Expr, error) {
+	if p.peekKeyword("not") {
+		p.next()
+		expr, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return &Not{Expr: expr}, nil
+	}
+	if p.peek().kind == tokLParen {
+		open := p.next()
+		expr, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek().kind != tokRParen {
+			return nil, p.errorf(p.peek(), "expected ) to close the ( at position %d", open.pos+1)
+		}
+		p.next()
+		return expr, nil
+	}
+	return p.parseClause()
+}
+
+var allowedOps = map[fieldKind][]string{
+	textField:   {"=", "!=", "~", "!~", "in", "not in", "empty", "not empty"},
+	arrayField:  {"=", "!=", "~", "!~", "in", "not in", "empty", "not empty"},
+	numberField: {"=", "!=", "<", "<=", ">", ">=", "in", "not in"},
+	dateField:   {"=", "!=", "<", "<=", ">", ">=", "empty", "not empty"},
+	searchField: {"~", "!~"},
+}
+
+func (p *parser) parseClause() (Expr, error) {
+	t := p.next()
+	if t.kind != tokWord {
+		return nil, p.errorf(t, "expected a field, got %s", t.describe())
+	}
+	field := lookupField(t.text)
+	if field == nil {
+		return nil, p.errorf(t, "unknown field %q", t.text)
+	}
+	clause := &Clause{Field: field}
+	opToken := p.next()
+	switch {
+	case opToken.kind == tokOp:
+		clause.Op = opToken.text
+	case opToken.kind == tokWord && strings.EqualFold(opToken.text, "in"):
+		clause.Op = "in"
+	case opToken.kind == tokWord && strings.EqualFold(opToken.text, "not") && p.peekKeyword("in"):
+		p.next()
+		clause.Op = "not in"
+	case opToken.kind == tokWord && strings.EqualFold(opToken.text, "is"):
+		clause.Op = "empty"
+		if p.peekKeyword("not") {
+			p.next()
+			clause.Op = "not empty"
+		}
+		if !p.peekKeyword("empty") && !p.peekKeyword("null") {
+			return nil, p.errorf(p.peek(), "expected EMPTY after IS")
+		}
+		p.next()
+	default:
+		return nil, p.errorf(opToken, "expected an operator after %s, got %s", field.Name, opToken.describe())
+	}
+	if !slices.Contains(allowedOps[field.kind], clause.Op) && !(field.Name == "priority" && isComparison(clause.Op)) {
+		return nil, p.errorf(opToken, "operator %s is not supported for %s", strings.ToUpper(clause.Op), field.Name)
+	}
+
+	switch clause.Op {
+	case "empty", "not empty":
+		return clause, nil
+	case "in", "not in":
+		if p.peek().kind != tokLParen {
+			return nil, p.errorf(p.peek(), "expected ( after %s", strings.ToUpper(clause.Op))
+		}
+		p.next()
+		for {
+			value, err := p.parseValue(clause)
+			if err != nil {
+				return nil, err
+			}
+			clause.Values = append(clause.Values, value)
+			sep := p.next()
+			if sep.kind == tokRParen {
+				break
+			}
+			if sep.kind != tokComma {
+				return nil, p.errorf(sep, "expected , or ) in list, got %s", sep.describe())
+			}
+		}
+	default:
+		value, err := p.parseValue(clause)
+		if err != nil {
+			return nil, err
+		}
+		clause.Values = []Value{value}
+	}
+	return clause, nil
+}
+
+var relativeDate = regexp.MustCompile(`^-(\d+)([hdwmy])$`)
+
+func (p *parser) parseValue(clause *Clause) (Value, error) {
+	t := p.next()
+	if t.kind != tokWord && t.kind != tokString {
+		return Value{}, p.errorf(t, "expected a value for %s, got %s", clause.Field.Name, t.describe())
+	}
+	value := Value{Text: t.text}
+	switch clause.Field.kind {
+	case numberField:
+		n, err := strconv.Atoi(t.text)
+		if err != nil {
+			return Value{}, p.errorf(t, "%s expects a number, got %q", clause.Field.Name, t.text)
+		}
+		value.Number = n
+	case dateField:
+		date, dayOnly, ok := parseDate(t.text)
+		if !ok {
+			return Value{}, p.errorf(t, "%s expects a date like 2024-01-31 or -7d, got %q", clause.Field.Name, t.text)
+		}
+		value.Time = date
+		value.DayOnly = dayOnly
+	default:
+		if clause.Field.Name == "resolution" && strings.EqualFold(t.text, "Unresolved") {
+			value.Text = ""
+		}
+		if clause.Field.Name == "priority" && isComparison(clause.Op) {
+			rank, ok := priorityRanks[strings.ToLower(t.text)]
+			if !ok {
+				return Value{}, p.errorf(t, "unknown priority %q", t.text)
+			}
+			value.Number = rank
+		}
+		if clause.Op == "~" || clause.Op == "!~" {
+			value.Pattern = globPattern(t.text)
+		}
+	}
+	return value, nil
+}
+
+func parseDate(text string) (time.Time, bool, bool) {
+	if m := relativeDate.FindStringSubmatch(text); m != nil {
+		n, _ := strconv.Atoi(m[1])
+		now := time.Now().UTC()
+		switch m[2] {
+		case "h":
+			return now.Add(-time.Duration(n) * time.Hour), false, true
+		case "d":
+			return now.AddDate(0, 0, -n), false, true
+		case "w":
+			return now.AddDate(0, 0, -7*n), false, true
+		case "m":
+			return now.AddDate(0, -n, 0), false, true
+		case "y":
+			return now.AddDate(-n, 0, 0), false, true
+		}
+	}
+	if t, err := time.Parse("2006-01-02", text); err == nil {
+		return t, true, true
+	}
+	for _, layout := range []string{time.RFC3339, "2006-01-02T15:04", "2006-01-02 15:04"} {
+		if t, err := time.Parse(layout, text); err == nil {
+			return t, false, true
+		}
+	}
+	return time.Time{}, false, false
+}
+
+// Turns a value with * wildcards into a case insensitive pattern, without wildcards it matches anywhere
+func globPattern(text string) *regexp.Regexp {
+	if !strings.Contains(text, "*") {
+		text = "*" + text + "*"
+	}
+	parts := strings.Split(text, "*")
+	for i, part := range parts {
+		parts[i] = regexp.QuoteMeta(part)
+	}
+	return regexp.MustCompile(`(?is)^` + strings.Join(parts, ".*") + `$`)
+}
+
+func isComparison(op string) bool {
+	return op == "<" || op == "<=" || op == ">" || op == ">="
+}
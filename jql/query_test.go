@@ -0,0 +1,239 @@
+package jql
+
+import (
+	"mojira/model"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func date(value string) *time.Time {
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		panic(err)
+	}
+	return &t
+}
+
+func testIssues() []*model.Issue {
+	return []*model.Issue{
+		{
+			Key: "MC-1", Summary: "Game crashes in lava", Status: "Open", MojangPriority: "Important",
+			Labels: []string{"crash", "lava"}, CreatedDate: date("2024-01-15T10:00:00Z"), Votes: 3, LegacyVotes: 4,
+		},
+		{
+			Key: "MC-2", Summary: "Bucket disappears", Status: "Resolved", Resolution: "Fixed", MojangPriority: "Low",
+			ReporterName: "Alice", CreatedDate: date("2024-01-16T00:00:00Z"), ResolvedDate: date("2024-02-01T12:00:00Z"),
+			Votes: 1, Comments: []model.Comment{{Id: "1"}, {Id: "2"}},
+		},
+		{
+			Key: "MCPE-3", Summary: "Water renders black", Status: "Open", MojangPriority: "Very Important",
+			AssigneeName: "bob", Components: []string{"Rendering"}, CreatedDate: date("2024-01-14T23:59:59Z"),
+			Links: []model.IssueLink{{Type: "is duplicated by", OtherKey: "MCPE-4"}, {Type: "relates to", OtherKey: "MC-1"}},
+		},
+	}
+}
+
+func matchingKeys(q *Query, issues []*model.Issue) []string {
+	keys := []string{}
+	for _, issue := range issues {
+		if q.Matches(issue) {
+			keys = append(keys, issue.Key)
+		}
+	}
+	return keys
+}
+
+// Writes the expression tree with explicit parentheses
+func describe(e Expr) string {
+	switch e := e.(type) {
+	case *And:
+		return "(" + describe(e.Left) + " AND " + describe(e.Right) + ")"
+	case *Or:
+		return "(" + describe(e.Left) + " OR " + describe(e.Right) + ")"
+	case *Not:
+		return "NOT " + describe(e.Expr)
+	case *Clause:
+		var values []string
+		for _, v := range e.Values {
+			values = append(values, v.Text)
+		}
+		return strings.TrimSpace(e.Field.Name + " " + e.Op + " " + strings.Join(values, ","))
+	}
+	return "?"
+}
+
+func TestParse(t *testing.T) {
+	cases := []struct {
+		query string
+		want  string
+	}{
+		{`project = MC OR status = Open AND resolution = Fixed`, `(project = MC OR (status = Open AND resolution = Fixed))`},
+		{`(project = MC OR status = Open) AND resolution = Fixed`, `((project = MC OR status = Open) AND resolution = Fixed)`},
+		{`project = MC and not status = Open or votes > 3`, `((project = MC AND NOT status = Open) OR votes > 3)`},
+		{`NOT project = MC AND status = Open`, `(NOT project = MC AND status = Open)`},
+		{`NOT (project = MC OR status = Open)`, `NOT (project = MC OR status = Open)`},
+		{`NOT NOT project = MC`, `NOT NOT project = MC`},
+		{`status IN (Open, "In Progress")`, `status in Open,In Progress`},
+		{`status not in ('Open')`, `status not in Open`},
+		{`labels is not empty AND resolved IS NULL`, `(labels not empty AND resolved empty)`},
+		{`mojang_priority = Low AND Affects = 1.20`, `(priority = Low AND affected_version = 1.20)`},
+		{`resolution = Unresolved`, `resolution =`},
+		{`summary = "say \"hi\""`, `summary = say "hi"`},
+	}
+	for _, c := range cases {
+		q, err := Parse(c.query)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", c.query, err)
+			continue
+		}
+		if got := describe(q.Where); got != c.want {
+			t.Errorf("Parse(%q) = %s, want %s", c.query, got, c.want)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	cases := []struct {
+		query   string
+		pos     int
+		message string
+	}{
+		{`project = `, 10, `expected a value for project, got end of query`},
+		{`foo = 1`, 0, `unknown field "foo"`},
+		{`project MC`, 8, `expected an operator after project, got "MC"`},
+		{`status ~= x`, 7, `unknown operator "~="`},
+		{`summary = "open`, 10, `unterminated string`},
+		{`(project = MC`, 13, `expected ) to close the ( at position 1`},
+		{`votes ~ 5`, 6, `operator ~ is not supported for votes`},
+		{`votes = many`, 8, `votes expects a number, got "many"`},
+		{`created > tomorrow`, 10, `created expects a date like 2024-01-31 or -7d, got "tomorrow"`},
+		{`status in Open`, 10, `expected ( after IN`},
+		{`status in (Open Reopened)`, 16, `expected , or ) in list, got "Reopened"`},
+		{`status is open`, 10, `expected EMPTY after IS`},
+		{`priority > Urgent`, 11, `unknown priority "Urgent"`},
+		{`project = MC project = MCPE`, 13, `unexpected "project"`},
+		{`order votes`, 6, `expected BY after ORDER`},
+		{`ORDER BY labels`, 9, `cannot order by labels`},
+		{`ORDER BY votes,`, 15, `expected a field to order by`},
+	}
+	for _, c := range cases {
+		_, err := Parse(c.query)
+		e, ok := err.(*Error)
+		if !ok {
+			t.Errorf("Parse(%q): expected an error, got %v", c.query, err)
+			continue
+		}
+		if e.Pos != c.pos || e.Message != c.message {
+			t.Errorf("Parse(%q) = %q at %d, want %q at %d", c.query, e.Message, e.Pos, c.message, c.pos)
+		}
+	}
+
+	_, err := Parse(`foo = 1`)
+	if got := err.Error(); got != `unknown field "foo" (at position 1)` {
+		t.Errorf("expected a one-based position in the message, got %q", got)
+	}
+}
+
+func TestMatches(t *testing.T) {
+	cases := []struct {
+		query string
+		keys  []string
+	}{
+		{`project = MC`, []string{"MC-1", "MC-2"}},
+		{`project != MC`, []string{"MCPE-3"}},
+		{`project = MC AND status = Open OR priority = Low`, []string{"MC-1", "MC-2"}},
+		{`project = MC AND (status = Open OR priority = "Very Important")`, []string{"MC-1"}},
+		{`NOT project = MC OR resolution = Fixed`, []string{"MC-2", "MCPE-3"}},
+		{`status in (Open, Reopened)`, []string{"MC-1", "MCPE-3"}},
+		{`status not in (Open)`, []string{"MC-2"}},
+		{`resolution = Unresolved`, []string{"MC-1", "MCPE-3"}},
+		{`resolution in (Fixed, "Won't Fix")`, []string{"MC-2"}},
+		{`summary ~ CRASH`, []string{"MC-1"}},
+		{`summary ~ "bucket*"`, []string{"MC-2"}},
+		{`summary ~ "*lava"`, []string{"MC-1"}},
+		{`summary ~ "game*lava"`, []string{"MC-1"}},
+		{`summary !~ crash`, []string{"MC-2", "MCPE-3"}},
+		{`labels ~ "lav*"`, []string{"MC-1"}},
+		{`labels = crash`, []string{"MC-1"}},
+		{`labels is empty`, []string{"MC-2", "MCPE-3"}},
+		{`components in (Rendering, Sound)`, []string{"MCPE-3"}},
+		{`reporter = alice`, []string{"MC-2"}},
+		{`assignee = BOB`, []string{"MCPE-3"}},
+		{`assignee is not empty`, []string{"MCPE-3"}},
+		{`created = 2024-01-15`, []string{"MC-1"}},
+		{`created != 2024-01-15`, []string{"MC-2", "MCPE-3"}},
+		{`created > 2024-01-15`, []string{"MC-2"}},
+		{`created >= 2024-01-15`, []string{"MC-1", "MC-2"}},
+		{`created < 2024-01-15`, []string{"MCPE-3"}},
+		{`created <= 2024-01-15`, []string{"MC-1", "MCPE-3"}},
+		{`created > "2024-01-15T10:00:00Z"`, []string{"MC-2"}},
+		{`created >= "2024-01-15 10:00"`, []string{"MC-1", "MC-2"}},
+		{`created < "2024-01-15T11:00:00+02:00"`, []string{"MCPE-3"}},
+		{`created > -1d`, []string{}},
+		{`resolved is empty`, []string{"MC-1", "MCPE-3"}},
+		{`resolved < 2024-02-02`, []string{"MC-2"}},
+		{`votes = 7`, []string{"MC-1"}},
+		{`votes >= 1 AND votes < 7`, []string{"MC-2"}},
+		{`votes in (0, 1)`, []string{"MC-2", "MCPE-3"}},
+		{`comments >= 2`, []string{"MC-2"}},
+		{`duplicates = 1`, []string{"MCPE-3"}},
+		{`priority > Important`, []string{"MCPE-3"}},
+		{`priority >= important`, []string{"MC-1", "MCPE-3"}},
+		{`priority < Normal`, []string{"MC-2"}},
+		{`priority = Low`, []string{"MC-2"}},
+		{`ORDER BY votes`, []string{"MC-1", "MC-2", "MCPE-3"}},
+	}
+	issues := testIssues()
+	for _, c := range cases {
+		q, err := Parse(c.query)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", c.query, err)
+			continue
+		}
+		if got := matchingKeys(q, issues); !slices.Equal(got, c.keys) {
+			t.Errorf("%s matched %v, want %v", c.query, got, c.keys)
+		}
+	}
+}
+
+func TestOrderBy(t *testing.T) {
+	cases := []struct {
+		query string
+		sql   string
+		keys  []string
+	}{
+		{`ORDER BY votes DESC`, `total_votes DESC NULLS LAST`, []string{"MC-1", "MC-2", "MCPE-3"}},
+		{`ORDER BY votes ASC`, `total_votes ASC NULLS LAST`, []string{"MCPE-3", "MC-2", "MC-1"}},
+		{`ORDER BY priority DESC`, `mojang_priority_rank DESC NULLS LAST`, []string{"MCPE-3", "MC-1", "MC-2"}},
+		{`ORDER BY created desc`, `created_date DESC NULLS LAST`, []string{"MC-2", "MC-1", "MCPE-3"}},
+		// Issues without a resolved date sort last either way, the key breaks the tie
+		{`ORDER BY resolved DESC, key`, `resolved_date DESC NULLS LAST, key ASC NULLS LAST`, []string{"MC-2", "MC-1", "MCPE-3"}},
+		{`ORDER BY resolved ASC, key DESC`, `resolved_date ASC NULLS LAST, key DESC NULLS LAST`, []string{"MC-2", "MCPE-3", "MC-1"}},
+		{`ORDER BY status, duplicates DESC`, `status ASC NULLS LAST, duplicate_count DESC NULLS LAST`, []string{"MCPE-3", "MC-1", "MC-2"}},
+		{`status = Open`, ``, nil},
+	}
+	for _, c := range cases {
+		q, err := Parse(c.query)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", c.query, err)
+			continue
+		}
+		if got := q.OrderSQL(); got != c.sql {
+			t.Errorf("%s: OrderSQL() = %q, want %q", c.query, got, c.sql)
+		}
+		if c.keys == nil {
+			continue
+		}
+		issues := testIssues()
+		slices.SortStableFunc(issues, q.Compare)
+		var keys []string
+		for _, issue := range issues {
+			keys = append(keys, issue.Key)
+		}
+		if !slices.Equal(keys, c.keys) {
+			t.Errorf("%s sorted %v, want %v", c.query, keys, c.keys)
+		}
+	}
+}
@@ -0,0 +1,232 @@
+package jql
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Databases the conditions can be compiled for
+type Dialect int
+
+const (
+	Postgres Dialect = iota
+	// Arrays are JSON, the text is searched with the issue_fts table and parameters are written as ?N
+	SQLite
+)
+
+type compiler struct {
+	args    []any
+	first   int
+	dialect Dialect
+}
+
+func (c *compiler) param(value any) string {
+	if c.dialect == SQLite {
+		// Times are stored as UTC text in SQLite, so they only compare in order in the same zone
+		if t, ok := value.(time.Time); ok {
+			value = t.UTC()
+		}
+	}
+	c.args = append(c.args, value)
+	if c.dialect == SQLite {
+		return fmt.Sprintf("?%d", c.first+len(c.args)-1)
+	}
+	return fmt.Sprintf("$%d", c.first+len(c.args)-1)
+}
+
+// Compiles the condition to SQL for the issue table, numbering parameters from $first
+func (q *Query) SQL(first int) (string, []any) {
+	return q.DialectSQL(Postgres, first)
+}
+
+// Compiles the condition to SQL for the issue table of a dialect, numbering parameters from first
+func (q *Query) DialectSQL(dialect Dialect, first int) (string, []any) {
+	if q.Where == nil {
+		return "TRUE", nil
+	}
+	c := &compiler{first: first, dialect: dialect}
+	return q.Where.sql(c), c.args
+}
+
+// Returns the ORDER BY expression, or an empty string when the query has none
+func (q *Query) OrderSQL() string {
+	var parts []string
+	for _, o := range q.OrderBy {
+		column := o.Field.Column
+		if o.Field.Name == "priority" {
+			column = "mojang_priority_rank"
+		}
+		if o.Desc {
+			parts = append(parts, column+" DESC NULLS LAST")
+		} else {
+			parts = append(parts, column+" ASC NULLS LAST")
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+func (e *And) sql(c *compiler) string {
+	return "(" + e.Left.sql(c) + " AND " + e.Right.sql(c) + ")"
+}
+
+func (e *Or) sql(c *compiler) string {
+	return "(" + e.Left.sql(c) + " OR " + e.Right.sql(c) + ")"
+}
+
+func (e *Not) sql(c *compiler) string {
+	return "NOT " + e.Expr.sql(c)
+}
+
+func (e *Clause) sql(c *compiler) string {
+	f := e.Field
+	switch e.Op {
+	case "!=", "!~", "not in", "not empty":
+		positive := *e
+		positive.Op = strings.TrimPrefix(strings.TrimPrefix(e.Op, "not "), "!")
+		return "NOT " + positive.sql(c)
+	case "in":
+		var parts []string
+		for _, v := range e.Values {
+			parts = append(parts, (&Clause{Field: f, Op: "=", Values: []Value{v}}).sql(c))
+		}
+		return "(" + strings.Join(parts, " OR ") + ")"
+	}
+
+	switch f.kind {
+	case searchField:
+		if c.dialect == SQLite {
+			query := FTSQuery(e.Values[0].Text)
+			if query == "" {
+				return "FALSE"
+			}
+			return fmt.Sprintf("(id IN (SELECT rowid FROM issue_fts WHERE issue_fts MATCH %s))", c.param(query))
+		}
+		return fmt.Sprintf("(to_tsvector('english', text) @@ websearch_to_tsquery('english', %s))", c.param(e.Values[0].Text))
+	case arrayField:
+		if c.dialect == SQLite {
+			switch e.Op {
+			case "empty":
+				return fmt.Sprintf("(COALESCE(json_array_length(%s), 0) = 0)", f.Column)
+			case "~":
+				return fmt.Sprintf(`(EXISTS (SELECT 1 FROM json_each(%s) WHERE value LIKE %s ESCAPE '\'))`, f.Column, c.param(likePattern(e.Values[0].Text)))
+			default:
+				return fmt.Sprintf("(EXISTS (SELECT 1 FROM json_each(%s) WHERE value = %s))", f.Column, c.param(e.Values[0].Text))
+			}
+		}
+		switch e.Op {
+		case "empty":
+			return fmt.Sprintf("(COALESCE(cardinality(%s), 0) = 0)", f.Column)
+		case "~":
+			return fmt.Sprintf("(EXISTS (SELECT 1 FROM unnest(%s) AS v WHERE v ILIKE %s))", f.Column, c.param(likePattern(e.Values[0].Text)))
+		default:
+			return fmt.Sprintf("(%s = ANY(%s))", c.param(e.Values[0].Text), f.Column)
+		}
+	case numberField:
+		return fmt.Sprintf("(%s %s %s)", f.Column, e.Op, c.param(e.Values[0].Number))
+	case dateField:
+		if e.Op == "empty" {
+			return fmt.Sprintf("(%s IS NULL)", f.Column)
+		}
+		v := e.Values[0]
+		if !v.DayOnly {
+			return fmt.Sprintf("(%s %s %s)", f.Column, e.Op, c.param(v.Time))
+		}
+		nextDay := v.Time.Add(24 * time.Hour)
+		switch e.Op {
+		case "=":
+			return fmt.Sprintf("(%s >= %s AND %s < %s)", f.Column, c.param(v.Time), f.Column, c.param(nextDay))
+		case ">":
+			return fmt.Sprintf("(%s >= %s)", f.Column, c.param(nextDay))
+		case "<=":
+			return fmt.Sprintf("(%s < %s)", f.Column, c.param(nextDay))
+		default:
+			return fmt.Sprintf("(%s %s %s)", f.Column, e.Op, c.param(v.Time))
+		}
+	default:
+		switch e.Op {
+		case "empty":
+			return fmt.Sprintf("(COALESCE(%s, '') = '')", f.Column)
+		case "~":
+			if c.dialect == SQLite {
+				// LIKE ignores the case of ASCII letters in SQLite
+				return fmt.Sprintf(`(%s LIKE %s ESCAPE '\')`, f.Column, c.param(likePattern(e.Values[0].Text)))
+			}
+			return fmt.Sprintf("(%s ILIKE %s)", f.Column, c.param(likePattern(e.Values[0].Text)))
+		case "<", "<=", ">", ">=":
+			return fmt.Sprintf("(mojang_priority_rank %s %s)", e.Op, c.param(e.Values[0].Number))
+		}
+		if f.fold {
+			return fmt.Sprintf("(LOWER(%s) = LOWER(%s))", f.Column, c.param(e.Values[0].Text))
+		}
+		return fmt.Sprintf("(%s = %s)", f.Column, c.param(e.Values[0].Text))
+	}
+}
+
+// Converts * wildcards to a LIKE pattern, escaping the characters that LIKE treats specially
+func likePattern(text string) string {
+	if !strings.Contains(text, "*") {
+		text = "*" + text + "*"
+	}
+	text = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(text)
+	return strings.ReplaceAll(text, "*", "%")
+}
+
+// Converts a web search, with "quoted phrases", -excluded words and OR, to an SQLite FTS5 query like websearch_to_tsquery does for Postgres.
+// Returns an empty string when there is no term that isn't excluded
+func FTSQuery(text string) string {
+	var parts []string
+	var excluded []string
+	or := false
+	for {
+		text = strings.TrimLeftFunc(text, unicode.IsSpace)
+		if text == "" {
+			break
+		}
+		negate := strings.HasPrefix(text, "-")
+		if negate {
+			text = text[1:]
+		}
+		var term string
+		quoted := strings.HasPrefix(text, `"`)
+		if quoted {
+			end := strings.Index(text[1:], `"`)
+			if end < 0 {
+				term, text = text[1:], ""
+			} else {
+				term, text = text[1:end+1], text[end+2:]
+			}
+		} else {
+			end := strings.IndexFunc(text, unicode.IsSpace)
+			if end < 0 {
+				end = len(text)
+			}
+			term, text = text[:end], text[end:]
+		}
+		if !quoted && !negate && strings.EqualFold(term, "or") {
+			or = len(parts) > 0
+			continue
+		}
+		// The tokenizer drops punctuation, so a term without letters or digits matches nothing
+		if !strings.ContainsFunc(term, func(r rune) bool { return unicode.IsLetter(r) || unicode.IsNumber(r) }) {
+			continue
+		}
+		phrase := `"` + strings.ReplaceAll(term, `"`, `""`) + `"`
+		switch {
+		case negate && len(parts) == 0:
+			// NOT needs something to exclude from, so leading exclusions wait for the first term
+			excluded = append(excluded, "NOT "+phrase)
+		case negate:
+			parts = append(parts, "NOT "+phrase)
+		case or:
+			parts = append(parts, "OR "+phrase)
+		case len(parts) == 0:
+			parts = append(append(parts, phrase), excluded...)
+		default:
+			parts = append(parts, phrase)
+		}
+		or = false
+	}
+	return strings.Join(parts, " ")
+}
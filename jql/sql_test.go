@@ -0,0 +1,202 @@
+package jql
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestFTSQuery(t *testing.T) {
+	cases := []struct {
+		text string
+		want string
+	}{
+		{`crash lava`, `"crash" "lava"`},
+		{`"item drops" bundle`, `"item drops" "bundle"`},
+		{`crash or lava`, `"crash" OR "lava"`},
+		{`lava -crash`, `"lava" NOT "crash"`},
+		{`-crash lava`, `"lava" NOT "crash"`},
+		{`-crash -"item drops" lava bundle`, `"lava" NOT "crash" NOT "item drops" "bundle"`},
+		{`-crash lava or bucket`, `"lava" NOT "crash" OR "bucket"`},
+		{`-crash`, ``},
+		{`-crash -lava`, ``},
+		{`or crash`, `"crash"`},
+		{`-- ...`, ``},
+		{`say "hi"`, `"say" "hi"`},
+		{`"unclosed quote`, `"unclosed quote"`},
+		{`a"b`, `"a""b"`},
+	}
+	for _, c := range cases {
+		if got := FTSQuery(c.text); got != c.want {
+			t.Errorf("FTSQuery(%q) = %q, want %q", c.text, got, c.want)
+		}
+	}
+}
+
+func TestSQL(t *testing.T) {
+	day := time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC)
+	plus2 := time.FixedZone("", 2*60*60)
+	cases := []struct {
+		query      string
+		postgres   string
+		sqlite     string
+		args       []any
+		sqliteArgs []any // when they differ from args
+	}{
+		{
+			query:    `project = MC AND status in (Open, Reopened)`,
+			postgres: `((project = $2) AND ((status = $3) OR (status = $4)))`,
+			sqlite:   `((project = ?2) AND ((status = ?3) OR (status = ?4)))`,
+			args:     []any{"MC", "Open", "Reopened"},
+		},
+		{
+			query:    `project = MC OR NOT status = Open AND resolution = Unresolved`,
+			postgres: `((project = $2) OR (NOT (status = $3) AND (resolution = $4)))`,
+			sqlite:   `((project = ?2) OR (NOT (status = ?3) AND (resolution = ?4)))`,
+			args:     []any{"MC", "Open", ""},
+		},
+		{
+			query:    `status not in (Open, Reopened)`,
+			postgres: `NOT ((status = $2) OR (status = $3))`,
+			sqlite:   `NOT ((status = ?2) OR (status = ?3))`,
+			args:     []any{"Open", "Reopened"},
+		},
+		{
+			query:    `reporter != Alice`,
+			postgres: `NOT (LOWER(reporter_name) = LOWER($2))`,
+			sqlite:   `NOT (LOWER(reporter_name) = LOWER(?2))`,
+			args:     []any{"Alice"},
+		},
+		{
+			query:    `summary ~ "50%_off*"`,
+			postgres: `(summary ILIKE $2)`,
+			sqlite:   `(summary LIKE ?2 ESCAPE '\')`,
+			args:     []any{`50\%\_off%`},
+		},
+		{
+			query:    `summary !~ crash`,
+			postgres: `NOT (summary ILIKE $2)`,
+			sqlite:   `NOT (summary LIKE ?2 ESCAPE '\')`,
+			args:     []any{`%crash%`},
+		},
+		{
+			query:    `assignee is not empty`,
+			postgres: `NOT (COALESCE(assignee_name, '') = '')`,
+			sqlite:   `NOT (COALESCE(assignee_name, '') = '')`,
+		},
+		{
+			query:    `labels = crash OR fix_version is empty`,
+			postgres: `(($2 = ANY(labels)) OR (COALESCE(cardinality(fix_versions), 0) = 0))`,
+			sqlite:   `((EXISTS (SELECT 1 FROM json_each(labels) WHERE value = ?2)) OR (COALESCE(json_array_length(fix_versions), 0) = 0))`,
+			args:     []any{"crash"},
+		},
+		{
+			query:    `labels ~ lav`,
+			postgres: `(EXISTS (SELECT 1 FROM unnest(labels) AS v WHERE v ILIKE $2))`,
+			sqlite:   `(EXISTS (SELECT 1 FROM json_each(labels) WHERE value LIKE ?2 ESCAPE '\'))`,
+			args:     []any{`%lav%`},
+		},
+		{
+			query:    `votes >= 10 and comments < 3`,
+			postgres: `((total_votes >= $2) AND (comment_count < $3))`,
+			sqlite:   `((total_votes >= ?2) AND (comment_count < ?3))`,
+			args:     []any{10, 3},
+		},
+		{
+			query:    `duplicates in (0, 1)`,
+			postgres: `((duplicate_count = $2) OR (duplicate_count = $3))`,
+			sqlite:   `((duplicate_count = ?2) OR (duplicate_count = ?3))`,
+			args:     []any{0, 1},
+		},
+		{
+			query:    `priority > Normal`,
+			postgres: `(mojang_priority_rank > $2)`,
+			sqlite:   `(mojang_priority_rank > ?2)`,
+			args:     []any{2},
+		},
+		{
+			query:    `priority = Normal`,
+			postgres: `(mojang_priority = $2)`,
+			sqlite:   `(mojang_priority = ?2)`,
+			args:     []any{"Normal"},
+		},
+		{
+			query:    `created = 2024-01-15`,
+			postgres: `(created_date >= $2 AND created_date < $3)`,
+			sqlite:   `(created_date >= ?2 AND created_date < ?3)`,
+			args:     []any{day, day.AddDate(0, 0, 1)},
+		},
+		{
+			query:    `created != 2024-01-15`,
+			postgres: `NOT (created_date >= $2 AND created_date < $3)`,
+			sqlite:   `NOT (created_date >= ?2 AND created_date < ?3)`,
+			args:     []any{day, day.AddDate(0, 0, 1)},
+		},
+		{
+			query:    `created > 2024-01-15 AND created <= 2024-01-15`,
+			postgres: `((created_date >= $2) AND (created_date < $3))`,
+			sqlite:   `((created_date >= ?2) AND (created_date < ?3))`,
+			args:     []any{day.AddDate(0, 0, 1), day.AddDate(0, 0, 1)},
+		},
+		{
+			query:    `created >= 2024-01-15 AND created < 2024-01-15`,
+			postgres: `((created_date >= $2) AND (created_date < $3))`,
+			sqlite:   `((created_date >= ?2) AND (created_date < ?3))`,
+			args:     []any{day, day},
+		},
+		{
+			query:      `updated > "2024-01-15T12:00:00+02:00"`,
+			postgres:   `(updated_date > $2)`,
+			sqlite:     `(updated_date > ?2)`,
+			args:       []any{time.Date(2024, 1, 15, 12, 0, 0, 0, plus2)},
+			sqliteArgs: []any{time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)},
+		},
+		{
+			query:    `resolved is empty`,
+			postgres: `(resolved_date IS NULL)`,
+			sqlite:   `(resolved_date IS NULL)`,
+		},
+		{
+			query:      `text ~ "lava -crash"`,
+			postgres:   `(to_tsvector('english', text) @@ websearch_to_tsquery('english', $2))`,
+			sqlite:     `(id IN (SELECT rowid FROM issue_fts WHERE issue_fts MATCH ?2))`,
+			args:       []any{"lava -crash"},
+			sqliteArgs: []any{`"lava" NOT "crash"`},
+		},
+		{
+			query:      `text ~ "-crash"`,
+			postgres:   `(to_tsvector('english', text) @@ websearch_to_tsquery('english', $2))`,
+			sqlite:     `FALSE`,
+			args:       []any{"-crash"},
+			sqliteArgs: []any{},
+		},
+		{
+			query:    `ORDER BY votes`,
+			postgres: `TRUE`,
+			sqlite:   `TRUE`,
+		},
+	}
+	// Times are compared with their zone, which SQLite needs to be UTC
+	format := func(args []any) string {
+		return fmt.Sprintf("%v", args)
+	}
+	for _, c := range cases {
+		q, err := Parse(c.query)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", c.query, err)
+			continue
+		}
+		sql, args := q.SQL(2)
+		if sql != c.postgres || format(args) != format(c.args) {
+			t.Errorf("%s: postgres SQL = %s %v, want %s %v", c.query, sql, args, c.postgres, c.args)
+		}
+		sqliteArgs := c.sqliteArgs
+		if sqliteArgs == nil {
+			sqliteArgs = c.args
+		}
+		sql, args = q.DialectSQL(SQLite, 2)
+		if sql != c.sqlite || format(args) != format(sqliteArgs) {
+			t.Errorf("%s: sqlite SQL = %s %v, want %s %v", c.query, sql, args, c.sqlite, sqliteArgs)
+		}
+	}
+}
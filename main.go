@@ -4,6 +4,8 @@ import (
 	"flag"
 	"io"
 	"log"
+	"mojira/api"
+	"mojira/faketracker"
 	"net"
 	"net/http"
 	"os"
@@ -50,25 +52,43 @@ func (r *responseRecorder) WriteHeader(code int) {
 func main() {
 	migrationFile := flag.String("migrate", "", "Run a specific migration file")
 	noSync := flag.Bool("nosync", false, "Disable background syncing")
+	recordKey := flag.String("record", "", "Record the API responses for this issue key to "+api.CassetteDir)
+	fakeTracker := flag.String("fake-tracker", "", "Serve the fake tracker fixtures on this address instead of running the server")
 	flag.Parse()
 
+	if *fakeTracker != "" {
+		log.Printf("Serving fake tracker from %s on %s", fakeTrackerFixtures, *fakeTracker)
+		log.Fatal(http.ListenAndServe(*fakeTracker, faketracker.NewServer(os.DirFS(fakeTrackerFixtures))))
+	}
+
 	err := godotenv.Overload()
 	if err != nil {
 		log.Fatal("Error loading .env file")
 	}
 
+	if *recordKey != "" {
+		if err := recordCassettes(*recordKey); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	fileLogger := NewFileLogger("mojira.log")
 	lokiLogger := NewLokiLogger(8 * time.Second)
 	log.SetOutput(io.MultiWriter(os.Stdout, fileLogger, lokiLogger))
 
-	service := NewIssueService()
 	if *migrationFile != "" {
-		if err := service.db.RunMigration(*migrationFile); err != nil {
+		dbClient, err := NewDBClient()
+		if err != nil {
+			log.Fatalf("Failed to connect to database: %v", err)
+		}
+		if err := dbClient.RunMigration(*migrationFile); err != nil {
 			log.Fatal(err)
 		}
 		return
 	}
-	StartSync(service, *noSync)
+	service := NewIssueService()
+	pool := StartSync(service, *noSync)
 
 	r := chi.NewRouter()
 	r.Use(middleware.RedirectSlashes)
@@ -88,15 +108,38 @@ func main() {
 		r.Use(InstrumentMiddleware)
 
 		r.Get("/", indexHandler(service))
+		r.Get("/feed", searchFeedHandler(service))
 		r.Get("/queue", queueOverviewHandler(service))
+		r.Get("/activity", activityHandler(service))
 		r.Get("/{key}", issueHandler(service))
+		r.Get("/{key}/feed", issueFeedHandler(service))
 		r.Get("/user/{name}", userHandler(service))
+		r.Get("/user/{name}/feed", userFeedHandler(service))
 
 		r.Post("/api/search", apiSearchHandler(service))
 		r.Get("/api/issues/{key}/refresh", apiRefreshHandler(service))
+		r.Get("/api/issues/{key}/history", apiHistoryHandler(service))
 		r.Get("/api/user/{name}/comments", apiUserCommentsHandler(service))
 
+		r.Get("/api/v1/openapi.json", apiV1OpenAPI)
+		r.Get("/api/v1/search", apiV1Search(service))
+		r.Post("/api/v1/issues/batch", apiV1IssuesBatch(service))
 		r.Get("/api/v1/issues/{key}", apiV1Issue(service))
+		r.Get("/api/v1/issues/{key}/comments", apiV1IssueComments(service))
+		r.Get("/api/v1/issues/{key}/history", apiV1IssueHistory(service))
+		r.Get("/api/v1/activity", apiV1Activity(service))
+
+		r.Group(func(r chi.Router) {
+			r.Use(requireAdmin)
+			r.Get("/admin/webhooks", webhooksHandler(service))
+			r.Get("/admin/dead-letter", deadLetterHandler(service))
+			r.Post("/admin/dead-letter/requeue", deadLetterRequeueHandler(service))
+			r.Get("/admin/scan-jobs", scanJobsHandler(service))
+			r.Post("/admin/scan-jobs", scanJobCreateHandler(service))
+			r.Post("/admin/scan-jobs/{id}", scanJobUpdateHandler(service))
+			r.Get("/admin/sync", syncPoolHandler(pool))
+			r.Post("/admin/sync", syncPoolUpdateHandler(pool))
+		})
 	})
 
 	log.Println("Starting server...")
@@ -0,0 +1,970 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"mojira/jql"
+	"mojira/model"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Store that keeps everything in memory, for tests and local development. Searches are approximated by matching words in the summary and description
+type MemoryStore struct {
+	mu             sync.Mutex
+	issues         map[string]*memoryIssue
+	history        map[string][]model.FieldChange
+	activity       []model.ActivityEvent
+	queue          map[string]*memoryQueueEntry
+	deadLetters    map[string]*DeadLetterRow
+	probes         map[string]time.Time
+	scanJobs       []ScanJob
+	webhooks       []Webhook
+	deliveries     []WebhookDelivery
+	nextActivityId int
+	nextScanJobId  int
+	nextWebhookId  int
+	nextDeliveryId int
+}
+
+type memoryIssue struct {
+	issue   model.Issue
+	removed bool
+}
+
+type memoryQueueEntry struct {
+	QueueRow
+	FirstFailedDate *time.Time
+	ScanJobId       int
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		issues:      make(map[string]*memoryIssue),
+		history:     make(map[string][]model.FieldChange),
+		queue:       make(map[string]*memoryQueueEntry),
+		deadLetters: make(map[string]*DeadLetterRow),
+		probes:      make(map[string]time.Time),
+	}
+}
+
+func keyNum(key string) int {
+	_, num, _ := strings.Cut(key, "-")
+	n, _ := strconv.Atoi(num)
+	return n
+}
+
+// Copies an issue, so that callers can't change the stored one
+func cloneIssue(issue *model.Issue) model.Issue {
+	c := *issue
+	c.Labels = slices.Clone(issue.Labels)
+	c.AffectedVersions = slices.Clone(issue.AffectedVersions)
+	c.FixVersions = slices.Clone(issue.FixVersions)
+	c.Category = slices.Clone(issue.Category)
+	c.Components = slices.Clone(issue.Components)
+	c.Links = slices.Clone(issue.Links)
+	c.Attachments = slices.Clone(issue.Attachments)
+	c.Comments = slices.Clone(issue.Comments)
+	return c
+}
+
+// The fields of an issue that lists load, without comments, links and attachments
+func listedIssue(issue *model.Issue) model.Issue {
+	c := cloneIssue(issue)
+	c.Comments = nil
+	c.Links = nil
+	c.Attachments = nil
+	return c
+}
+
+// Present issues in the order of the key numbers
+func (s *MemoryStore) presentIssues() []*model.Issue {
+	var issues []*model.Issue
+	for _, stored := range s.issues {
+		if !stored.removed {
+			issues = append(issues, &stored.issue)
+		}
+	}
+	slices.SortFunc(issues, func(a, b *model.Issue) int {
+		return cmp.Or(strings.Compare(a.Project(), b.Project()), cmp.Compare(keyNum(a.Key), keyNum(b.Key)))
+	})
+	return issues
+}
+
+func compareCreatedDesc(a *model.Issue, b *model.Issue) int {
+	return -compareDates(a.CreatedDate, b.CreatedDate)
+}
+
+// Orders missing dates first, like '-infinity'
+func compareDates(a *time.Time, b *time.Time) int {
+	if a == nil || b == nil {
+		return cmp.Compare(boolRank(a != nil), boolRank(b != nil))
+	}
+	return a.Compare(*b)
+}
+
+func boolRank(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+var priorityRanks = map[string]int{"Low": 1, "Normal": 2, "Important": 3, "Very Important": 4}
+
+// Same orders as issueSorts, descending and ending with the key
+var memorySorts = map[string]func(a, b *model.Issue) int{
+	"Created": func(a, b *model.Issue) int {
+		return compareCreatedDesc(a, b)
+	},
+	"Updated": func(a, b *model.Issue) int {
+		return -compareDates(a.UpdatedDate, b.UpdatedDate)
+	},
+	"Resolved": func(a, b *model.Issue) int {
+		return -compareDates(a.ResolvedDate, b.ResolvedDate)
+	},
+	"Priority": func(a, b *model.Issue) int {
+		return cmp.Or(-cmp.Compare(priorityRanks[a.MojangPriority], priorityRanks[b.MojangPriority]), compareCreatedDesc(a, b))
+	},
+	"Votes": func(a, b *model.Issue) int {
+		return cmp.Or(-cmp.Compare(a.TotalVotes(), b.TotalVotes()), compareCreatedDesc(a, b))
+	},
+	"Comments": func(a, b *model.Issue) int {
+		return cmp.Or(-cmp.Compare(len(a.Comments), len(b.Comments)), compareCreatedDesc(a, b))
+	},
+	"Duplicates": func(a, b *model.Issue) int {
+		return cmp.Or(-cmp.Compare(duplicateCount(a), duplicateCount(b)), compareCreatedDesc(a, b))
+	},
+}
+
+// Whether every word of a search appears in the summary or description
+func matchesSearch(issue *model.Issue, text string) bool {
+	content := strings.ToLower(issue.Summary + "\n" + model.ExtractPlainTextFromADF(issue.Description))
+	for _, word := range strings.Fields(strings.ToLower(text)) {
+		if !strings.Contains(content, strings.Trim(word, `"`)) {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *MemoryStore) GetIssueByKey(key string) (*model.Issue, error) {
+	issue, removed, err := s.GetStoredIssue(key)
+	if removed {
+		return nil, model.ErrIssueRemoved
+	}
+	return issue, err
+}
+
+func (s *MemoryStore) GetStoredIssue(key string) (*model.Issue, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stored, ok := s.issues[key]
+	if !ok {
+		return nil, false, model.ErrIssueNotStored
+	}
+	issue := cloneIssue(&stored.issue)
+	if issue.Comments == nil {
+		issue.Comments = []model.Comment{}
+	}
+	if issue.Links == nil {
+		issue.Links = []model.IssueLink{}
+	}
+	if issue.Attachments == nil {
+		issue.Attachments = []model.Attachment{}
+	}
+	slices.SortStableFunc(issue.Comments, func(a, b model.Comment) int {
+		return compareDates(a.Date, b.Date)
+	})
+	for i := range issue.Comments {
+		issue.Comments[i].Issue = &issue
+	}
+	return &issue, stored.removed, nil
+}
+
+func (s *MemoryStore) GetIssuesByKeys(ctx context.Context, keys []string) ([]model.Issue, []string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	issues := []model.Issue{}
+	removed := []string{}
+	for _, key := range slices.Compact(slices.Sorted(slices.Values(keys))) {
+		stored, ok := s.issues[key]
+		if !ok {
+			continue
+		}
+		if stored.removed {
+			removed = append(removed, key)
+			continue
+		}
+		issues = append(issues, listedIssue(&stored.issue))
+	}
+	slices.SortStableFunc(issues, func(a, b model.Issue) int {
+		return cmp.Compare(keyNum(a.Key), keyNum(b.Key))
+	})
+	return issues, removed, nil
+}
+
+func (s *MemoryStore) GetIssueForSync(key string) (*model.Issue, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stored, ok := s.issues[key]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	return &model.Issue{Key: key, SyncedDate: stored.issue.SyncedDate}, nil
+}
+
+func (s *MemoryStore) GetIssueSyncedDate(key string) (*time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stored, ok := s.issues[key]
+	if !ok || stored.removed {
+		return nil, sql.ErrNoRows
+	}
+	return stored.issue.SyncedDate, nil
+}
+
+func (s *MemoryStore) UpdateIssue(ctx context.Context, issue *model.Issue, changes []model.FieldChange) error {
+	if issue.Partial {
+		return errors.New("tried to insert a partial issue")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stored := cloneIssue(issue)
+	for i := range stored.Comments {
+		stored.Comments[i].Issue = nil
+	}
+	s.issues[issue.Key] = &memoryIssue{issue: stored}
+	now := time.Now()
+	for _, change := range changes {
+		change.Date = &now
+		s.history[issue.Key] = append(s.history[issue.Key], change)
+	}
+	return nil
+}
+
+func (s *MemoryStore) MarkIssueRemoved(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if stored, ok := s.issues[key]; ok {
+		stored.removed = true
+	}
+	return nil
+}
+
+func (s *MemoryStore) GetIssueHistory(ctx context.Context, key string) ([]model.FieldChange, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	history := slices.Clone(s.history[key])
+	slices.Reverse(history)
+	if history == nil {
+		history = []model.FieldChange{}
+	}
+	return history, nil
+}
+
+func (s *MemoryStore) PeekFutureVersionIssues(ctx context.Context, limit int) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var keys []string
+	for key, stored := range s.issues {
+		if slices.ContainsFunc(stored.issue.FixVersions, func(v string) bool { return strings.HasPrefix(v, "Future") }) {
+			keys = append(keys, key)
+		}
+	}
+	slices.Sort(keys)
+	return keys[:min(limit, len(keys))], nil
+}
+
+// Counts are computed on every request, there is no view to refresh
+func (s *MemoryStore) RefreshCountView() error {
+	return nil
+}
+
+func (s *MemoryStore) SearchIssues(text string, limit int) ([]model.Issue, error) {
+	if strings.HasPrefix(strings.TrimSpace(text), "-") {
+		return []model.Issue{}, nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var issues []model.Issue
+	for _, issue := range s.presentIssues() {
+		if matchesSearch(issue, text) {
+			issues = append(issues, listedIssue(issue))
+		}
+	}
+	slices.SortStableFunc(issues, func(a, b model.Issue) int {
+		return compareCreatedDesc(&a, &b)
+	})
+	return issues[:min(limit, len(issues))], nil
+}
+
+// Pages through the issues with offsets, cursors with sort values from Postgres aren't accepted
+func (s *MemoryStore) FilterIssues(filter IssueFilter, cursor *IssueCursor, limit int) (*IssuePage, error) {
+	page := &IssuePage{Issues: []model.Issue{}}
+	if strings.HasPrefix(strings.TrimSpace(filter.Search), "-") {
+		return page, nil
+	}
+	if cursor == nil {
+		cursor = &IssueCursor{}
+	}
+	if len(cursor.Values) > 0 {
+		return nil, ErrInvalidCursor
+	}
+	var query *jql.Query
+	if filter.Query != "" {
+		q, err := jql.Parse(filter.Query)
+		if err != nil {
+			return nil, err
+		}
+		query = q
+	}
+	sortName := filter.Sort
+	compare, ok := memorySorts[sortName]
+	if !ok {
+		sortName = "Created"
+		compare = memorySorts[sortName]
+	}
+	if query != nil && len(query.OrderBy) > 0 {
+		compare = query.Compare
+	}
+
+	// Matches only looks at the summary, so the search is done separately
+	fields := filter
+	fields.Search = ""
+	fields.query = query
+
+	s.mu.Lock()
+	var matched []*model.Issue
+	for _, issue := range s.presentIssues() {
+		if sortName == "Updated" && issue.UpdatedDate == nil || sortName == "Resolved" && issue.ResolvedDate == nil {
+			continue
+		}
+		if fields.Matches(issue) && (filter.Search == "" || matchesSearch(issue, filter.Search)) {
+			matched = append(matched, issue)
+		}
+	}
+	slices.SortStableFunc(matched, func(a, b *model.Issue) int {
+		return cmp.Or(compare(a, b), -strings.Compare(a.Key, b.Key))
+	})
+	page.Count = len(matched)
+	for _, issue := range matched[min(cursor.Offset, len(matched)):min(cursor.Offset+limit, len(matched))] {
+		listed := listedIssue(issue)
+		if !filter.WithDescription {
+			listed.Description = ""
+		}
+		page.Issues = append(page.Issues, listed)
+	}
+	s.mu.Unlock()
+
+	if cursor.Offset+limit < page.Count {
+		page.Next = &IssueCursor{Offset: cursor.Offset + limit}
+	}
+	if cursor.Offset > 0 {
+		page.Prev = &IssueCursor{Offset: max(cursor.Offset-limit, 0)}
+	}
+	return page, nil
+}
+
+func (s *MemoryStore) issuesByUser(name func(*model.Issue) string, user string, limit int) []model.Issue {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var issues []model.Issue
+	for _, issue := range s.presentIssues() {
+		if strings.EqualFold(name(issue), user) {
+			issues = append(issues, listedIssue(issue))
+		}
+	}
+	slices.SortStableFunc(issues, func(a, b model.Issue) int {
+		return compareCreatedDesc(&a, &b)
+	})
+	return issues[:min(limit, len(issues))]
+}
+
+func (s *MemoryStore) GetIssueByReporter(reporter string, limit int) ([]model.Issue, error) {
+	return s.issuesByUser(func(i *model.Issue) string { return i.ReporterName }, reporter, limit), nil
+}
+
+func (s *MemoryStore) GetIssueByAssignee(assignee string, limit int) ([]model.Issue, error) {
+	return s.issuesByUser(func(i *model.Issue) string { return i.AssigneeName }, assignee, limit), nil
+}
+
+func (s *MemoryStore) GetCommentsByUser(name string, offset int, limit int) ([]model.Comment, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	comments := []model.Comment{}
+	for _, issue := range s.presentIssues() {
+		for _, c := range issue.Comments {
+			if strings.EqualFold(c.AuthorName, name) {
+				c.Issue = &model.Issue{Key: issue.Key}
+				comments = append(comments, c)
+			}
+		}
+	}
+	slices.SortStableFunc(comments, func(a, b model.Comment) int {
+		return -compareDates(a.Date, b.Date)
+	})
+	return comments[min(offset, len(comments)):min(offset+limit, len(comments))], nil
+}
+
+func (s *MemoryStore) InsertActivity(ctx context.Context, events []model.ActivityEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for _, e := range events {
+		s.nextActivityId += 1
+		e.Id = s.nextActivityId
+		e.Versions = slices.Clone(e.Versions)
+		if e.Date == nil {
+			e.Date = &now
+		}
+		s.activity = append(s.activity, e)
+	}
+	return nil
+}
+
+func (s *MemoryStore) GetActivity(ctx context.Context, project string, typ string, version string, before int, limit int) ([]model.ActivityEvent, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	events := []model.ActivityEvent{}
+	for i := len(s.activity) - 1; i >= 0 && len(events) < limit; i-- {
+		e := s.activity[i]
+		if (project == "" || e.Project() == project) && (typ == "" || e.Type == typ) && (version == "" || slices.Contains(e.Versions, version)) && (before == 0 || e.Id < before) {
+			events = append(events, e)
+		}
+	}
+	return events, nil
+}
+
+// Adds a key to the queue unless it is already queued, the caller must hold the lock
+func (s *MemoryStore) enqueue(key string, priority int, reason string) bool {
+	if _, ok := s.queue[key]; ok {
+		return false
+	}
+	now := time.Now()
+	s.queue[key] = &memoryQueueEntry{QueueRow: QueueRow{Key: key, QueuedDate: &now, Priority: priority, Reason: reason, RetryAfter: &now}}
+	return true
+}
+
+func (s *MemoryStore) QueueIssueKeys(keys []string, priority int, reason string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var result []string
+	for _, key := range keys {
+		if stored, ok := s.issues[key]; ok && stored.issue.SyncedDate != nil && time.Since(*stored.issue.SyncedDate) <= 15*time.Minute {
+			continue
+		}
+		if s.enqueue(key, priority, reason) {
+			result = append(result, key)
+		}
+	}
+	return result, nil
+}
+
+// Queue entries in the order they are claimed
+func (s *MemoryStore) claimOrder() []*memoryQueueEntry {
+	entries := make([]*memoryQueueEntry, 0, len(s.queue))
+	for _, entry := range s.queue {
+		entries = append(entries, entry)
+	}
+	slices.SortFunc(entries, func(a, b *memoryQueueEntry) int {
+		return cmp.Or(-cmp.Compare(a.Priority, b.Priority), cmp.Compare(a.FailedCount, b.FailedCount), a.QueuedDate.Compare(*b.QueuedDate), strings.Compare(a.Key, b.Key))
+	})
+	return entries
+}
+
+func (s *MemoryStore) ClaimQueuedIssues(ctx context.Context, worker string, limit int, lease time.Duration) ([]ClaimedIssue, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	leaseUntil := now.Add(lease)
+	var claimed []ClaimedIssue
+	for _, entry := range s.claimOrder() {
+		if len(claimed) >= limit {
+			break
+		}
+		if entry.RetryAfter.After(now) || (entry.ClaimedBy != "" && !entry.LeaseUntil.Before(now)) {
+			continue
+		}
+		claim := ClaimedIssue{Key: entry.Key, Reason: entry.Reason, AbandonedBy: entry.ClaimedBy}
+		if entry.ScanJobId != 0 {
+			scanJobId := entry.ScanJobId
+			claim.ScanJobId = &scanJobId
+		}
+		claimed = append(claimed, claim)
+		entry.ClaimedBy = worker
+		entry.LeaseUntil = &leaseUntil
+	}
+	return claimed, nil
+}
+
+func (s *MemoryStore) RetryQueuedIssue(ctx context.Context, worker string, key string, lastError string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry, ok := s.queue[key]
+	if !ok || entry.ClaimedBy != worker {
+		return ErrLeaseLost
+	}
+	now := time.Now()
+	entry.FailedCount += 1
+	if entry.FailedCount > 4 && entry.Priority < 10 {
+		if dead, ok := s.deadLetters[key]; ok {
+			dead.Priority = entry.Priority
+			dead.Reason = entry.Reason
+			dead.LastError = lastError
+			dead.FailedCount += entry.FailedCount
+			dead.LastFailedDate = &now
+		} else {
+			firstFailed := cmp.Or(entry.FirstFailedDate, &now)
+			s.deadLetters[key] = &DeadLetterRow{Key: key, Priority: entry.Priority, Reason: entry.Reason, LastError: lastError, FailedCount: entry.FailedCount, FirstFailedDate: firstFailed, LastFailedDate: &now}
+		}
+		delete(s.queue, key)
+		return nil
+	}
+	// Delay will be: 2m, 4m, 8m, 16m, 16m, 16m, ...
+	retryAfter := now.Add(time.Duration(math.Pow(2, float64(min(4, entry.FailedCount)))) * time.Minute)
+	entry.QueuedDate = &now
+	entry.RetryAfter = &retryAfter
+	entry.ClaimedBy = ""
+	entry.LeaseUntil = nil
+	entry.LastError = lastError
+	entry.FirstFailedDate = cmp.Or(entry.FirstFailedDate, &now)
+	return nil
+}
+
+func (s *MemoryStore) ExtendQueuedIssueLease(ctx context.Context, worker string, key string, lease time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry, ok := s.queue[key]
+	if !ok || entry.ClaimedBy != worker {
+		return ErrLeaseLost
+	}
+	leaseUntil := time.Now().Add(lease)
+	entry.LeaseUntil = &leaseUntil
+	return nil
+}
+
+func (s *MemoryStore) ReleaseQueuedIssue(ctx context.Context, worker string, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if entry, ok := s.queue[key]; ok && entry.ClaimedBy == worker {
+		entry.ClaimedBy = ""
+		entry.LeaseUntil = nil
+	}
+	return nil
+}
+
+func (s *MemoryStore) DeleteQueuedIssue(ctx context.Context, worker string, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry, ok := s.queue[key]
+	if !ok || entry.ClaimedBy != worker {
+		return ErrLeaseLost
+	}
+	delete(s.queue, key)
+	return nil
+}
+
+func (s *MemoryStore) GetQueueSize(ctx context.Context) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.queue), nil
+}
+
+func (s *MemoryStore) GetSyncOutage(ctx context.Context) (*SyncOutage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	totalCount, newCount := 0, 0
+	for key, entry := range s.queue {
+		if entry.Reason != "update-feed" {
+			continue
+		}
+		totalCount += 1
+		if _, ok := s.issues[key]; !ok {
+			newCount += 1
+		}
+	}
+	if newCount < 50 {
+		return nil, nil
+	}
+	return &SyncOutage{NewCount: newCount, UpdateCount: totalCount - newCount}, nil
+}
+
+func (s *MemoryStore) GetQueue(ctx context.Context) ([]QueueRow, int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entries := s.claimOrder()
+	slices.SortStableFunc(entries, func(a, b *memoryQueueEntry) int {
+		return cmp.Or(-cmp.Compare(a.Priority, b.Priority), a.QueuedDate.Compare(*b.QueuedDate))
+	})
+	var queue []QueueRow
+	for _, entry := range entries[:min(100, len(entries))] {
+		queue = append(queue, entry.QueueRow)
+	}
+	return queue, len(s.queue), nil
+}
+
+func (s *MemoryStore) GetDeadLetters(ctx context.Context, limit int) ([]DeadLetterRow, int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var deadLetters []DeadLetterRow
+	for _, dead := range s.deadLetters {
+		deadLetters = append(deadLetters, *dead)
+	}
+	slices.SortFunc(deadLetters, func(a, b DeadLetterRow) int {
+		return cmp.Or(-a.LastFailedDate.Compare(*b.LastFailedDate), strings.Compare(a.Key, b.Key))
+	})
+	return deadLetters[:min(limit, len(deadLetters))], len(s.deadLetters), nil
+}
+
+func (s *MemoryStore) GetDeadLetterSize(ctx context.Context) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.deadLetters), nil
+}
+
+func (s *MemoryStore) RequeueDeadLetters(ctx context.Context, keys []string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var requeued []string
+	for key, dead := range s.deadLetters {
+		if keys != nil && !slices.Contains(keys, key) {
+			continue
+		}
+		delete(s.deadLetters, key)
+		s.enqueue(key, dead.Priority, dead.Reason)
+		requeued = append(requeued, key)
+	}
+	slices.Sort(requeued)
+	return requeued, nil
+}
+
+// Key numbers of the issues of a project, including removed ones. The caller must hold the lock
+func (s *MemoryStore) keyNums(project string) []int {
+	var nums []int
+	for key := range s.issues {
+		if p, _, _ := strings.Cut(key, "-"); p == project {
+			nums = append(nums, keyNum(key))
+		}
+	}
+	slices.Sort(nums)
+	return nums
+}
+
+func (s *MemoryStore) GetHighestKeyNums(ctx context.Context, projects []string) (map[string]int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	result := make(map[string]int)
+	for _, project := range projects {
+		if nums := s.keyNums(project); len(nums) > 0 {
+			result[project] = nums[len(nums)-1]
+		}
+	}
+	return result, nil
+}
+
+func (s *MemoryStore) FindKeyGaps(ctx context.Context, project string, probedSince time.Time, limit int) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	nums := s.keyNums(project)
+	var keys []string
+	for i := len(nums) - 1; i > 0 && len(keys) < limit; i-- {
+		for n := nums[i] - 1; n > nums[i-1] && len(keys) < limit; n-- {
+			key := fmt.Sprintf("%s-%d", project, n)
+			if checked, ok := s.probes[key]; ok && !checked.Before(probedSince) {
+				continue
+			}
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+func (s *MemoryStore) RecordKeyProbe(ctx context.Context, key string, reason string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.probes[key] = time.Now()
+	return nil
+}
+
+func (s *MemoryStore) QueueProbeKeys(ctx context.Context, keys []string, priority int, reason string, probedSince time.Time) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var result []string
+	for _, key := range keys {
+		if _, ok := s.issues[key]; ok {
+			continue
+		}
+		if checked, ok := s.probes[key]; ok && !checked.Before(probedSince) {
+			continue
+		}
+		s.probes[key] = time.Now()
+		if s.enqueue(key, priority, reason) {
+			result = append(result, key)
+		}
+	}
+	return result, nil
+}
+
+func (s *MemoryStore) GetScanJobs(ctx context.Context) ([]ScanJob, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.scanJobs), nil
+}
+
+func (s *MemoryStore) CreateScanJob(ctx context.Context, job ScanJob) (int, error) {
+	if _, ok := scanPredicates[job.Predicate]; !ok {
+		return 0, fmt.Errorf("unknown scan predicate %q", job.Predicate)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	s.nextScanJobId += 1
+	job = ScanJob{Id: s.nextScanJobId, Project: job.Project, RangeStart: job.RangeStart, RangeEnd: job.RangeEnd, Predicate: job.Predicate, Priority: job.Priority, Reason: job.Reason, ChunkSize: job.ChunkSize, RepeatHours: job.RepeatHours, Enabled: true, NextNum: job.RangeStart, CreatedDate: &now}
+	s.scanJobs = append(s.scanJobs, job)
+	return job.Id, nil
+}
+
+func (s *MemoryStore) scanJob(id int) *ScanJob {
+	index := slices.IndexFunc(s.scanJobs, func(j ScanJob) bool { return j.Id == id })
+	if index < 0 {
+		return nil
+	}
+	return &s.scanJobs[index]
+}
+
+func (s *MemoryStore) SetScanJobEnabled(ctx context.Context, id int, enabled bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if job := s.scanJob(id); job != nil {
+		job.Enabled = enabled
+	}
+	return nil
+}
+
+func (s *MemoryStore) DeleteScanJob(ctx context.Context, id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.scanJobs = slices.DeleteFunc(s.scanJobs, func(j ScanJob) bool { return j.Id == id })
+	return nil
+}
+
+// Same predicates as scanPredicates, for a single key
+var memoryScanPredicates = map[string]func(stored *memoryIssue) bool{
+	"all":     func(stored *memoryIssue) bool { return true },
+	"missing": func(stored *memoryIssue) bool { return stored == nil },
+	"present": func(stored *memoryIssue) bool { return stored != nil && !stored.removed },
+	"resolved": func(stored *memoryIssue) bool {
+		return stored != nil && !stored.removed && stored.issue.Status != "Open" && stored.issue.Status != "Reopened"
+	},
+	"removed": func(stored *memoryIssue) bool { return stored != nil && stored.removed },
+}
+
+func (s *MemoryStore) AdvanceScanJob(ctx context.Context, id int) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job := s.scanJob(id)
+	if job == nil || !job.Enabled {
+		return 0, nil
+	}
+	predicate, ok := memoryScanPredicates[job.Predicate]
+	if !ok {
+		return 0, fmt.Errorf("unknown scan predicate %q", job.Predicate)
+	}
+
+	nextNum, startedDate := job.NextNum, job.StartedDate
+	if job.FinishedDate != nil {
+		if job.RepeatHours <= 0 || time.Since(*job.FinishedDate) < time.Duration(job.RepeatHours)*time.Hour {
+			return 0, nil
+		}
+		nextNum = job.RangeStart
+		startedDate = nil
+	}
+
+	backlog := 0
+	for _, entry := range s.queue {
+		if entry.ScanJobId == job.Id {
+			backlog += 1
+		}
+	}
+	if backlog >= job.ChunkSize {
+		return 0, nil
+	}
+
+	scanEnd := job.RangeStart
+	if job.RangeEnd != nil {
+		scanEnd = *job.RangeEnd
+	} else if nums := s.keyNums(job.Project); len(nums) > 0 {
+		scanEnd = nums[len(nums)-1]
+	}
+	to := min(nextNum+job.ChunkSize-1, scanEnd)
+
+	queued := 0
+	for n := nextNum; n <= to; n++ {
+		key := fmt.Sprintf("%s-%d", job.Project, n)
+		if predicate(s.issues[key]) && s.enqueue(key, job.Priority, job.Reason) {
+			s.queue[key].ScanJobId = job.Id
+			queued += 1
+		}
+	}
+
+	now := time.Now()
+	job.NextNum = max(nextNum, to+1)
+	job.ScanEnd = &scanEnd
+	if startedDate == nil {
+		job.QueuedCount = queued
+		job.StartedDate = &now
+	} else {
+		job.QueuedCount += queued
+	}
+	job.FinishedDate = nil
+	if job.NextNum > scanEnd {
+		job.FinishedDate = &now
+	}
+	return queued, nil
+}
+
+// Adds a webhook, which is otherwise only done with SQL
+func (s *MemoryStore) AddWebhook(webhook Webhook) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	s.nextWebhookId += 1
+	webhook.Id = s.nextWebhookId
+	webhook.CreatedDate = &now
+	webhook.parseQuery()
+	s.webhooks = append(s.webhooks, webhook)
+	return webhook.Id
+}
+
+func (s *MemoryStore) GetWebhooks(ctx context.Context) ([]Webhook, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.webhooks), nil
+}
+
+func (s *MemoryStore) webhook(id int) *Webhook {
+	index := slices.IndexFunc(s.webhooks, func(h Webhook) bool { return h.Id == id })
+	if index < 0 {
+		return nil
+	}
+	return &s.webhooks[index]
+}
+
+func (s *MemoryStore) delivery(id int) *WebhookDelivery {
+	index := slices.IndexFunc(s.deliveries, func(d WebhookDelivery) bool { return d.Id == id })
+	if index < 0 {
+		return nil
+	}
+	return &s.deliveries[index]
+}
+
+func (s *MemoryStore) QueueWebhookDeliveries(ctx context.Context, deliveries []WebhookDelivery) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for _, d := range deliveries {
+		if s.webhook(d.WebhookId) == nil {
+			return fmt.Errorf("failed to insert webhook_delivery: unknown webhook %d", d.WebhookId)
+		}
+		retryAfter := &now
+		if d.RetryAfter != nil {
+			retryAfter = d.RetryAfter
+		}
+		s.nextDeliveryId += 1
+		s.deliveries = append(s.deliveries, WebhookDelivery{Id: s.nextDeliveryId, WebhookId: d.WebhookId, IssueKey: d.IssueKey, Payload: d.Payload, Status: "pending", QueuedDate: &now, RetryAfter: retryAfter})
+	}
+	return nil
+}
+
+func (s *MemoryStore) ClaimWebhookDeliveries(ctx context.Context, worker string, limit int, lease time.Duration) ([]WebhookDelivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	var claimable []*WebhookDelivery
+	for i := range s.deliveries {
+		d := &s.deliveries[i]
+		h := s.webhook(d.WebhookId)
+		if d.Status != "pending" || d.RetryAfter.After(now) || h == nil || !h.Enabled {
+			continue
+		}
+		if d.LeaseUntil != nil && !d.LeaseUntil.Before(now) {
+			continue
+		}
+		claimable = append(claimable, d)
+	}
+	slices.SortStableFunc(claimable, func(a, b *WebhookDelivery) int {
+		return cmp.Or(a.RetryAfter.Compare(*b.RetryAfter), cmp.Compare(a.Id, b.Id))
+	})
+	var deliveries []WebhookDelivery
+	leaseUntil := now.Add(lease)
+	for _, d := range claimable[:min(limit, len(claimable))] {
+		d.ClaimedBy = worker
+		d.LeaseUntil = &leaseUntil
+		h := s.webhook(d.WebhookId)
+		claimed := *d
+		claimed.WebhookUrl = h.Url
+		claimed.Secret = h.Secret
+		claimed.Format = h.Format
+		deliveries = append(deliveries, claimed)
+	}
+	return deliveries, nil
+}
+
+func (s *MemoryStore) MarkWebhookDelivered(ctx context.Context, id int, responseCode int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if d := s.delivery(id); d != nil {
+		now := time.Now()
+		d.Status = "delivered"
+		d.ResponseCode = &responseCode
+		d.LastError = ""
+		d.DeliveredDate = &now
+		d.ClaimedBy = ""
+		d.LeaseUntil = nil
+	}
+	return nil
+}
+
+func (s *MemoryStore) RetryWebhookDelivery(ctx context.Context, id int, responseCode *int, lastError string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d := s.delivery(id)
+	if d == nil {
+		return sql.ErrNoRows
+	}
+	d.FailedCount += 1
+	d.ResponseCode = responseCode
+	d.LastError = lastError
+	d.ClaimedBy = ""
+	d.LeaseUntil = nil
+	if d.FailedCount >= 8 {
+		d.Status = "failed"
+		return nil
+	}
+	// Delay will be: 2m, 4m, 8m, 16m, 16m, 16m, ...
+	retryAfter := time.Now().Add(time.Duration(math.Pow(2, float64(min(4, d.FailedCount)))) * time.Minute)
+	d.RetryAfter = &retryAfter
+	return nil
+}
+
+func (s *MemoryStore) GetWebhookDeliveries(ctx context.Context, limit int) ([]WebhookDelivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var deliveries []WebhookDelivery
+	for i := len(s.deliveries) - 1; i >= 0 && len(deliveries) < limit; i-- {
+		d := s.deliveries[i]
+		if h := s.webhook(d.WebhookId); h != nil {
+			d.WebhookUrl = h.Url
+		}
+		deliveries = append(deliveries, d)
+	}
+	return deliveries, nil
+}
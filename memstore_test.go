@@ -0,0 +1,32 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func claimedKeys(claimed []ClaimedIssue) []string {
+	keys := make([]string, 0, len(claimed))
+	for _, claim := range claimed {
+		keys = append(keys, claim.Key)
+	}
+	return keys
+}
+
+func TestMemoryStore(t *testing.T) {
+	open := func(t *testing.T) Store {
+		return NewMemoryStore()
+	}
+	expire := func(t *testing.T, store Store, key string) {
+		s := store.(*MemoryStore)
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		past := time.Now().Add(-time.Second)
+		entry := s.queue[key]
+		entry.RetryAfter = &past
+		if entry.LeaseUntil != nil {
+			entry.LeaseUntil = &past
+		}
+	}
+	testStore(t, open, expire)
+}
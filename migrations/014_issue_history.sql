@@ -0,0 +1,10 @@
+-- Keep track of field changes detected between syncs
+CREATE TABLE IF NOT EXISTS issue_history (
+  id SERIAL PRIMARY KEY,
+  issue_key VARCHAR(32) NOT NULL REFERENCES issue(key) ON DELETE CASCADE,
+  field VARCHAR(32) NOT NULL,
+  old_value TEXT NOT NULL,
+  new_value TEXT NOT NULL,
+  changed_date TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS idx_issue_history_issue_key ON issue_history(issue_key, changed_date DESC);
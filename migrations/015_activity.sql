@@ -0,0 +1,18 @@
+-- Events detected while syncing issues, used for the activity feed
+CREATE TABLE IF NOT EXISTS activity (
+  id SERIAL PRIMARY KEY,
+  issue_key VARCHAR(32) NOT NULL REFERENCES issue(key) ON DELETE CASCADE,
+  project VARCHAR(16) GENERATED ALWAYS AS (substring(issue_key from '^(.*?)-')) STORED,
+  type VARCHAR(32) NOT NULL,
+  summary TEXT NOT NULL DEFAULT '',
+  old_value TEXT NOT NULL DEFAULT '',
+  new_value TEXT NOT NULL DEFAULT '',
+  author_name TEXT NOT NULL DEFAULT '',
+  anchor TEXT NOT NULL DEFAULT '',
+  versions TEXT[] NOT NULL DEFAULT '{}',
+  date TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS idx_activity_issue_key ON activity(issue_key);
+CREATE INDEX IF NOT EXISTS idx_activity_project_id ON activity(project, id DESC);
+CREATE INDEX IF NOT EXISTS idx_activity_type_id ON activity(type, id DESC);
+CREATE INDEX IF NOT EXISTS idx_activity_versions ON activity USING GIN (versions);
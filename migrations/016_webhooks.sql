@@ -0,0 +1,26 @@
+-- Outgoing webhooks, notified when a matching issue changes
+CREATE TABLE IF NOT EXISTS webhook (
+  id SERIAL PRIMARY KEY,
+  url TEXT NOT NULL,
+  secret TEXT NOT NULL,
+  filter JSONB NOT NULL DEFAULT '{}',
+  events TEXT[] NOT NULL DEFAULT '{}',
+  enabled BOOLEAN NOT NULL DEFAULT TRUE,
+  created_date TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS webhook_delivery (
+  id SERIAL PRIMARY KEY,
+  webhook_id INTEGER NOT NULL REFERENCES webhook(id) ON DELETE CASCADE,
+  issue_key VARCHAR(32) NOT NULL,
+  payload TEXT NOT NULL,
+  status VARCHAR(16) NOT NULL DEFAULT 'pending',
+  failed_count INTEGER NOT NULL DEFAULT 0,
+  response_code INTEGER,
+  last_error TEXT NOT NULL DEFAULT '',
+  queued_date TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  retry_after TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  delivered_date TIMESTAMPTZ
+);
+CREATE INDEX IF NOT EXISTS idx_webhook_delivery_pending ON webhook_delivery(retry_after) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS idx_webhook_delivery_webhook_id ON webhook_delivery(webhook_id);
@@ -0,0 +1,3 @@
+-- Allow webhooks to post chat messages, batched deliveries can cover several issues
+ALTER TABLE webhook ADD COLUMN format VARCHAR(16) NOT NULL DEFAULT 'json';
+ALTER TABLE webhook_delivery ALTER COLUMN issue_key TYPE TEXT;
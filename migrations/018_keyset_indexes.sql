@@ -0,0 +1,8 @@
+-- Support keyset pagination for every sort mode, the columns match issueSorts
+CREATE INDEX IF NOT EXISTS idx_issue_present_created_key ON issue ((COALESCE(created_date, '-infinity')) DESC, key DESC) WHERE state = 'present';
+CREATE INDEX IF NOT EXISTS idx_issue_present_updated_key ON issue (updated_date DESC, key DESC) WHERE state = 'present' AND updated_date IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_issue_present_resolved_key ON issue (resolved_date DESC, key DESC) WHERE state = 'present' AND resolved_date IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_issue_present_priority_key ON issue (mojang_priority_rank DESC, (COALESCE(created_date, '-infinity')) DESC, key DESC) WHERE state = 'present';
+CREATE INDEX IF NOT EXISTS idx_issue_present_votes_key ON issue (total_votes DESC, (COALESCE(created_date, '-infinity')) DESC, key DESC) WHERE state = 'present';
+CREATE INDEX IF NOT EXISTS idx_issue_present_comments_key ON issue (comment_count DESC, (COALESCE(created_date, '-infinity')) DESC, key DESC) WHERE state = 'present';
+CREATE INDEX IF NOT EXISTS idx_issue_present_duplicates_key ON issue (duplicate_count DESC, (COALESCE(created_date, '-infinity')) DESC, key DESC) WHERE state = 'present';
@@ -0,0 +1,6 @@
+-- Lets several sync workers claim queued keys without processing them twice
+ALTER TABLE sync_queue
+  ADD COLUMN IF NOT EXISTS claimed_by TEXT,
+  ADD COLUMN IF NOT EXISTS lease_until TIMESTAMPTZ;
+
+CREATE INDEX IF NOT EXISTS idx_sync_queue_claim_order ON sync_queue (priority DESC, failed_count ASC, queued_date ASC);
@@ -0,0 +1,15 @@
+-- Keeps the keys the sync queue gave up on, so they can be inspected and queued again
+ALTER TABLE sync_queue
+  ADD COLUMN IF NOT EXISTS last_error TEXT NOT NULL DEFAULT '',
+  ADD COLUMN IF NOT EXISTS first_failed_date TIMESTAMPTZ;
+
+CREATE TABLE IF NOT EXISTS sync_dead_letter (
+  issue_key VARCHAR(32) PRIMARY KEY,
+  priority INTEGER NOT NULL,
+  reason VARCHAR(32) NOT NULL,
+  last_error TEXT NOT NULL DEFAULT '',
+  failed_count INTEGER NOT NULL,
+  first_failed_date TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  last_failed_date TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS idx_sync_dead_letter_last_failed_date ON sync_dead_letter(last_failed_date);
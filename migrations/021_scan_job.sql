@@ -0,0 +1,20 @@
+-- Scans of issue key ranges, which add their keys to the sync queue a chunk at a time
+CREATE TABLE IF NOT EXISTS scan_job (
+  id SERIAL PRIMARY KEY,
+  project VARCHAR(16) NOT NULL,
+  range_start INTEGER NOT NULL DEFAULT 1,
+  range_end INTEGER,
+  predicate VARCHAR(16) NOT NULL DEFAULT 'all',
+  priority INTEGER NOT NULL DEFAULT 1,
+  reason VARCHAR(32) NOT NULL DEFAULT 'scan',
+  chunk_size INTEGER NOT NULL DEFAULT 500,
+  repeat_hours INTEGER NOT NULL DEFAULT 0,
+  enabled BOOLEAN NOT NULL DEFAULT TRUE,
+  next_num INTEGER NOT NULL,
+  scan_end INTEGER,
+  queued_count INTEGER NOT NULL DEFAULT 0,
+  created_date TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  started_date TIMESTAMPTZ,
+  finished_date TIMESTAMPTZ
+);
+CREATE INDEX IF NOT EXISTS idx_sync_queue_reason ON sync_queue(reason);
@@ -0,0 +1,6 @@
+-- Remembers which unmirrored keys were probed, so keys that don't exist aren't queued over and over
+CREATE TABLE IF NOT EXISTS key_probe (
+  issue_key VARCHAR(32) PRIMARY KEY,
+  reason VARCHAR(32) NOT NULL,
+  checked_date TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
@@ -0,0 +1,4 @@
+-- Lets several instances deliver webhooks without sending a delivery twice
+ALTER TABLE webhook_delivery
+  ADD COLUMN IF NOT EXISTS claimed_by TEXT,
+  ADD COLUMN IF NOT EXISTS lease_until TIMESTAMPTZ;
@@ -0,0 +1,3 @@
+-- Remembers which scan job queued a key, so each job only waits for its own keys
+ALTER TABLE sync_queue ADD COLUMN IF NOT EXISTS scan_job_id INTEGER REFERENCES scan_job(id) ON DELETE SET NULL;
+CREATE INDEX IF NOT EXISTS idx_sync_queue_scan_job_id ON sync_queue(scan_job_id);
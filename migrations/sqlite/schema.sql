@@ -0,0 +1,222 @@
+-- Schema of the SQLite store, applied whenever it is opened. It follows the Postgres migrations up to 024,
+-- with arrays stored as JSON, times as UTC text and a table instead of the issue_count materialized view
+CREATE TABLE IF NOT EXISTS issue (
+  id INTEGER PRIMARY KEY,
+  key TEXT NOT NULL UNIQUE,
+  project TEXT GENERATED ALWAYS AS (substr(key, 1, instr(key, '-') - 1)) STORED,
+  key_num INTEGER GENERATED ALWAYS AS (CAST(substr(key, instr(key, '-') + 1) AS INTEGER)) STORED,
+  summary TEXT,
+  creator_name TEXT NOT NULL DEFAULT '',
+  creator_avatar TEXT NOT NULL DEFAULT '',
+  reporter_name TEXT,
+  reporter_avatar TEXT,
+  assignee_name TEXT,
+  assignee_avatar TEXT,
+  description TEXT,
+  environment TEXT,
+  labels TEXT,
+  created_date TIMESTAMP,
+  updated_date TIMESTAMP,
+  resolved_date TIMESTAMP,
+  status TEXT,
+  confirmation_status TEXT,
+  resolution TEXT,
+  affected_versions TEXT,
+  fix_versions TEXT,
+  category TEXT,
+  mojang_priority TEXT,
+  mojang_priority_rank INTEGER GENERATED ALWAYS AS (
+    CASE mojang_priority
+      WHEN 'Very Important' THEN 4
+      WHEN 'Important' THEN 3
+      WHEN 'Normal' THEN 2
+      WHEN 'Low' THEN 1
+      ELSE 0
+    END
+  ) STORED,
+  area TEXT,
+  components TEXT,
+  ado TEXT,
+  platform TEXT,
+  os_version TEXT,
+  realms_platform TEXT,
+  votes INTEGER NOT NULL DEFAULT 0,
+  legacy_votes INTEGER NOT NULL DEFAULT 0,
+  total_votes INTEGER GENERATED ALWAYS AS (legacy_votes + votes) STORED,
+  comment_count INTEGER DEFAULT 0,
+  duplicate_count INTEGER DEFAULT 0,
+  text TEXT,
+  synced_date TIMESTAMP NOT NULL,
+  state TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_issue_project_key_num ON issue(project, key_num);
+CREATE INDEX IF NOT EXISTS idx_issue_state_created ON issue(state, created_date DESC);
+CREATE INDEX IF NOT EXISTS idx_issue_reporter_created ON issue(LOWER(reporter_name), created_date DESC);
+CREATE INDEX IF NOT EXISTS idx_issue_assignee_created ON issue(LOWER(assignee_name), created_date DESC);
+
+-- Full text search over the text column, kept up to date by the triggers
+CREATE VIRTUAL TABLE IF NOT EXISTS issue_fts USING fts5(text, content='issue', content_rowid='id', tokenize='porter unicode61');
+CREATE TRIGGER IF NOT EXISTS issue_fts_insert AFTER INSERT ON issue BEGIN
+  INSERT INTO issue_fts(rowid, text) VALUES (new.id, new.text);
+END;
+CREATE TRIGGER IF NOT EXISTS issue_fts_delete AFTER DELETE ON issue BEGIN
+  INSERT INTO issue_fts(issue_fts, rowid, text) VALUES ('delete', old.id, old.text);
+END;
+CREATE TRIGGER IF NOT EXISTS issue_fts_update AFTER UPDATE OF text ON issue BEGIN
+  INSERT INTO issue_fts(issue_fts, rowid, text) VALUES ('delete', old.id, old.text);
+  INSERT INTO issue_fts(rowid, text) VALUES (new.id, new.text);
+END;
+
+-- Refreshed by RefreshCountView
+CREATE TABLE IF NOT EXISTS issue_count (
+  project TEXT,
+  status TEXT,
+  confirmation_status TEXT,
+  resolution TEXT,
+  count INTEGER NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS comment (
+  id INTEGER PRIMARY KEY,
+  issue_key TEXT NOT NULL REFERENCES issue(key) ON DELETE CASCADE,
+  comment_id TEXT,
+  legacy_id TEXT NOT NULL DEFAULT '',
+  date TIMESTAMP,
+  author_name TEXT,
+  author_avatar TEXT,
+  adf_comment TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_comment_issue_key ON comment(issue_key);
+CREATE INDEX IF NOT EXISTS idx_comment_author_date ON comment(LOWER(author_name), date DESC);
+
+CREATE TABLE IF NOT EXISTS issue_link (
+  id INTEGER PRIMARY KEY,
+  issue_key TEXT NOT NULL REFERENCES issue(key) ON DELETE CASCADE,
+  type TEXT NOT NULL,
+  other_key TEXT NOT NULL,
+  other_summary TEXT,
+  other_status TEXT
+);
+CREATE INDEX IF NOT EXISTS idx_issue_link_issue_key ON issue_link(issue_key);
+
+CREATE TABLE IF NOT EXISTS attachment (
+  id INTEGER PRIMARY KEY,
+  issue_key TEXT NOT NULL REFERENCES issue(key) ON DELETE CASCADE,
+  attachment_id TEXT,
+  filename TEXT,
+  author_name TEXT,
+  author_avatar TEXT,
+  created_date TIMESTAMP,
+  size INTEGER,
+  mime_type TEXT
+);
+CREATE INDEX IF NOT EXISTS idx_attachment_issue_key ON attachment(issue_key);
+
+CREATE TABLE IF NOT EXISTS issue_history (
+  id INTEGER PRIMARY KEY,
+  issue_key TEXT NOT NULL REFERENCES issue(key) ON DELETE CASCADE,
+  field TEXT NOT NULL,
+  old_value TEXT NOT NULL,
+  new_value TEXT NOT NULL,
+  changed_date TIMESTAMP NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_issue_history_issue_key ON issue_history(issue_key, changed_date DESC);
+
+CREATE TABLE IF NOT EXISTS activity (
+  id INTEGER PRIMARY KEY,
+  issue_key TEXT NOT NULL REFERENCES issue(key) ON DELETE CASCADE,
+  project TEXT GENERATED ALWAYS AS (substr(issue_key, 1, instr(issue_key, '-') - 1)) STORED,
+  type TEXT NOT NULL,
+  summary TEXT NOT NULL DEFAULT '',
+  old_value TEXT NOT NULL DEFAULT '',
+  new_value TEXT NOT NULL DEFAULT '',
+  author_name TEXT NOT NULL DEFAULT '',
+  anchor TEXT NOT NULL DEFAULT '',
+  versions TEXT NOT NULL DEFAULT '[]',
+  date TIMESTAMP NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_activity_issue_key ON activity(issue_key);
+CREATE INDEX IF NOT EXISTS idx_activity_project_id ON activity(project, id DESC);
+CREATE INDEX IF NOT EXISTS idx_activity_type_id ON activity(type, id DESC);
+
+CREATE TABLE IF NOT EXISTS sync_queue (
+  issue_key TEXT PRIMARY KEY,
+  queued_date TIMESTAMP NOT NULL,
+  priority INTEGER NOT NULL DEFAULT 0,
+  reason TEXT NOT NULL,
+  failed_count INTEGER NOT NULL DEFAULT 0,
+  retry_after TIMESTAMP NOT NULL,
+  claimed_by TEXT,
+  lease_until TIMESTAMP,
+  last_error TEXT NOT NULL DEFAULT '',
+  first_failed_date TIMESTAMP,
+  scan_job_id INTEGER REFERENCES scan_job(id) ON DELETE SET NULL
+);
+CREATE INDEX IF NOT EXISTS idx_sync_queue_claim_order ON sync_queue(priority DESC, failed_count ASC, queued_date ASC);
+CREATE INDEX IF NOT EXISTS idx_sync_queue_reason ON sync_queue(reason);
+CREATE INDEX IF NOT EXISTS idx_sync_queue_scan_job_id ON sync_queue(scan_job_id);
+
+CREATE TABLE IF NOT EXISTS sync_dead_letter (
+  issue_key TEXT PRIMARY KEY,
+  priority INTEGER NOT NULL,
+  reason TEXT NOT NULL,
+  last_error TEXT NOT NULL DEFAULT '',
+  failed_count INTEGER NOT NULL,
+  first_failed_date TIMESTAMP NOT NULL,
+  last_failed_date TIMESTAMP NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_sync_dead_letter_last_failed_date ON sync_dead_letter(last_failed_date);
+
+CREATE TABLE IF NOT EXISTS scan_job (
+  id INTEGER PRIMARY KEY,
+  project TEXT NOT NULL,
+  range_start INTEGER NOT NULL DEFAULT 1,
+  range_end INTEGER,
+  predicate TEXT NOT NULL DEFAULT 'all',
+  priority INTEGER NOT NULL DEFAULT 1,
+  reason TEXT NOT NULL DEFAULT 'scan',
+  chunk_size INTEGER NOT NULL DEFAULT 500,
+  repeat_hours INTEGER NOT NULL DEFAULT 0,
+  enabled BOOLEAN NOT NULL DEFAULT TRUE,
+  next_num INTEGER NOT NULL,
+  scan_end INTEGER,
+  queued_count INTEGER NOT NULL DEFAULT 0,
+  created_date TIMESTAMP NOT NULL,
+  started_date TIMESTAMP,
+  finished_date TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS key_probe (
+  issue_key TEXT PRIMARY KEY,
+  reason TEXT NOT NULL,
+  checked_date TIMESTAMP NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS webhook (
+  id INTEGER PRIMARY KEY,
+  url TEXT NOT NULL,
+  secret TEXT NOT NULL,
+  format TEXT NOT NULL DEFAULT 'json',
+  filter TEXT NOT NULL DEFAULT '{}',
+  events TEXT NOT NULL DEFAULT '[]',
+  enabled BOOLEAN NOT NULL DEFAULT TRUE,
+  created_date TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS webhook_delivery (
+  id INTEGER PRIMARY KEY,
+  webhook_id INTEGER NOT NULL REFERENCES webhook(id) ON DELETE CASCADE,
+  issue_key TEXT NOT NULL,
+  payload TEXT NOT NULL,
+  status TEXT NOT NULL DEFAULT 'pending',
+  failed_count INTEGER NOT NULL DEFAULT 0,
+  response_code INTEGER,
+  last_error TEXT NOT NULL DEFAULT '',
+  queued_date TIMESTAMP NOT NULL,
+  retry_after TIMESTAMP NOT NULL,
+  claimed_by TEXT,
+  lease_until TIMESTAMP,
+  delivered_date TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_webhook_delivery_pending ON webhook_delivery(retry_after) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS idx_webhook_delivery_webhook_id ON webhook_delivery(webhook_id);
@@ -0,0 +1,133 @@
+package model
+
+import (
+	"slices"
+	"strings"
+	"time"
+)
+
+var ActivityTypes = []string{"created", "resolved", "confirmation", "comment", "duplicate", "fix_version"}
+
+var activityLabels = map[string]string{
+	"created":      "Created",
+	"resolved":     "Resolved",
+	"confirmation": "Confirmation",
+	"comment":      "Comment",
+	"duplicate":    "Duplicate",
+	"fix_version":  "Fix Versions",
+}
+
+type ActivityEvent struct {
+	Id         int
+	IssueKey   string
+	Summary    string
+	Type       string
+	OldValue   string
+	NewValue   string
+	AuthorName string
+	Anchor     string
+	Versions   []string
+	Date       *time.Time
+}
+
+func (e *ActivityEvent) Project() string {
+	return strings.Split(e.IssueKey, "-")[0]
+}
+
+func (e *ActivityEvent) Label() string {
+	if label, ok := activityLabels[e.Type]; ok {
+		return label
+	}
+	return e.Type
+}
+
+// Only issues created recently count as new, so that scans of older keys don't flood the feed
+const newIssueWindow = 7 * 24 * time.Hour
+
+func ActivityEvents(diff *IssueDiff) []ActivityEvent {
+	if diff == nil || diff.New == nil {
+		return nil
+	}
+	issue := diff.New
+	versions := issueVersions(issue)
+	event := func(typ string) ActivityEvent {
+		return ActivityEvent{IssueKey: issue.Key, Summary: issue.Summary, Type: typ, Versions: versions}
+	}
+
+	var events []ActivityEvent
+	if diff.Old == nil {
+		if issue.CreatedDate != nil && time.Since(*issue.CreatedDate) < newIssueWindow {
+			e := event("created")
+			e.AuthorName = issue.ReporterName
+			e.Date = issue.CreatedDate
+			events = append(events, e)
+		}
+		return events
+	}
+
+	if c := diff.Change("resolution"); c != nil && c.NewValue != "" {
+		e := event("resolved")
+		e.OldValue = c.OldValue
+		e.NewValue = c.NewValue
+		e.Date = issue.ResolvedDate
+		events = append(events, e)
+	}
+	if c := diff.Change("confirmation_status"); c != nil {
+		e := event("confirmation")
+		e.OldValue = c.OldValue
+		e.NewValue = c.NewValue
+		events = append(events, e)
+	}
+	if c := diff.Change("fix_versions"); c != nil {
+		e := event("fix_version")
+		e.OldValue = c.OldValue
+		e.NewValue = c.NewValue
+		for _, v := range diff.Old.FixVersions {
+			if v != "" && !slices.Contains(e.Versions, v) {
+				e.Versions = append(e.Versions, v)
+			}
+		}
+		events = append(events, e)
+	}
+
+	oldComments := make(map[string]bool)
+	for _, c := range diff.Old.Comments {
+		oldComments[c.Id] = true
+	}
+	for _, c := range issue.Comments {
+		if oldComments[c.Id] {
+			continue
+		}
+		e := event("comment")
+		e.AuthorName = c.AuthorName
+		e.Anchor = c.Anchor()
+		e.Date = c.Date
+		events = append(events, e)
+	}
+
+	oldDuplicates := make(map[string]bool)
+	for _, l := range diff.Old.Links {
+		if l.Type == "is duplicated by" {
+			oldDuplicates[l.OtherKey] = true
+		}
+	}
+	for _, l := range issue.Links {
+		if l.Type != "is duplicated by" || oldDuplicates[l.OtherKey] {
+			continue
+		}
+		e := event("duplicate")
+		e.NewValue = l.OtherKey
+		events = append(events, e)
+	}
+	return events
+}
+
+func issueVersions(issue *Issue) []string {
+	versions := []string{}
+	for _, v := range slices.Concat(issue.AffectedVersions, issue.FixVersions) {
+		if v != "" && !slices.Contains(versions, v) {
+			versions = append(versions, v)
+		}
+	}
+	return versions
+}
@@ -0,0 +1,108 @@
+package model
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func activityTypes(events []ActivityEvent) []string {
+	types := []string{}
+	for _, e := range events {
+		types = append(types, e.Type)
+	}
+	return types
+}
+
+func TestActivityEvents(t *testing.T) {
+	recent := time.Now().Add(-time.Hour)
+	old := time.Date(2012, 7, 28, 12, 0, 0, 0, time.UTC)
+	resolved := time.Now()
+	stored := Issue{
+		Key:                "MC-4",
+		Summary:            "Item drops appear at the wrong position",
+		CreatedDate:        &old,
+		ConfirmationStatus: "Unconfirmed",
+		AffectedVersions:   []string{"1.21"},
+		FixVersions:        []string{"1.21.1"},
+		Comments:           []Comment{{Id: "1", Date: &old}},
+		Links:              []IssueLink{{Type: "is duplicated by", OtherKey: "MC-5"}, {Type: "relates to", OtherKey: "MC-6"}},
+	}
+
+	t.Run("new issue", func(t *testing.T) {
+		issue := stored
+		issue.CreatedDate = &recent
+		issue.ReporterName = "Kumasasa"
+		events := ActivityEvents(NewIssueDiff(nil, &issue))
+		if len(events) != 1 || events[0].Type != "created" || events[0].AuthorName != "Kumasasa" || events[0].Date != &recent {
+			t.Errorf("expected a created event, got %+v", events)
+		}
+		if !slices.Equal(events[0].Versions, []string{"1.21", "1.21.1"}) {
+			t.Errorf("expected the affected and fix versions, got %v", events[0].Versions)
+		}
+	})
+
+	t.Run("old issue first seen", func(t *testing.T) {
+		if events := ActivityEvents(NewIssueDiff(nil, &stored)); len(events) != 0 {
+			t.Errorf("expected no events for an old issue, got %+v", events)
+		}
+	})
+
+	t.Run("unchanged", func(t *testing.T) {
+		issue := stored
+		if events := ActivityEvents(NewIssueDiff(&stored, &issue)); len(events) != 0 {
+			t.Errorf("expected no events, got %+v", events)
+		}
+	})
+
+	t.Run("changes", func(t *testing.T) {
+		issue := stored
+		issue.Resolution = "Fixed"
+		issue.ResolvedDate = &resolved
+		issue.ConfirmationStatus = "Confirmed"
+		issue.FixVersions = []string{"1.21.2"}
+		issue.Comments = []Comment{{Id: "1", Date: &old}, {Id: "2", LegacyId: "", AuthorName: "Moderator", Date: &recent}}
+		issue.Links = []IssueLink{{Type: "is duplicated by", OtherKey: "MC-5"}, {Type: "is duplicated by", OtherKey: "MC-7"}, {Type: "relates to", OtherKey: "MC-8"}}
+		events := ActivityEvents(NewIssueDiff(&stored, &issue))
+
+		want := []string{"resolved", "confirmation", "fix_version", "comment", "duplicate"}
+		if got := activityTypes(events); !slices.Equal(got, want) {
+			t.Fatalf("expected %v, got %v", want, got)
+		}
+		if e := events[0]; e.NewValue != "Fixed" || e.Date != &resolved {
+			t.Errorf("unexpected resolved event %+v", e)
+		}
+		if e := events[1]; e.OldValue != "Unconfirmed" || e.NewValue != "Confirmed" {
+			t.Errorf("unexpected confirmation event %+v", e)
+		}
+		// The removed fix version still counts, so filtering by it shows the change
+		if e := events[2]; e.OldValue != "1.21.1" || e.NewValue != "1.21.2" || !slices.Equal(e.Versions, []string{"1.21", "1.21.2", "1.21.1"}) {
+			t.Errorf("unexpected fix version event %+v", e)
+		}
+		if e := events[3]; e.AuthorName != "Moderator" || e.Anchor != "comment-id-2" {
+			t.Errorf("unexpected comment event %+v", e)
+		}
+		if e := events[4]; e.NewValue != "MC-7" {
+			t.Errorf("unexpected duplicate event %+v", e)
+		}
+	})
+
+	t.Run("unresolved", func(t *testing.T) {
+		old := stored
+		old.Resolution = "Fixed"
+		issue := stored
+		if got := activityTypes(ActivityEvents(NewIssueDiff(&old, &issue))); len(got) != 0 {
+			t.Errorf("expected reopening not to count as resolved, got %v", got)
+		}
+	})
+
+	t.Run("restored", func(t *testing.T) {
+		issue := stored
+		issue.CreatedDate = &recent
+		diff := NewIssueDiff(&stored, &issue)
+		diff.Changes = append(diff.Changes, FieldChange{Field: "state", OldValue: "removed", NewValue: "present"})
+		if got := activityTypes(ActivityEvents(diff)); len(got) != 0 {
+			t.Errorf("expected a restored issue not to count as created, got %v", got)
+		}
+	})
+}
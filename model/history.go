@@ -0,0 +1,95 @@
+package model
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type FieldChange struct {
+	Field    string
+	OldValue string
+	NewValue string
+	Date     *time.Time
+}
+
+var historyFieldLabels = map[string]string{
+	"status":              "Status",
+	"resolution":          "Resolution",
+	"confirmation_status": "Confirmation Status",
+	"fix_versions":        "Fix Versions",
+	"affected_versions":   "Affects Versions",
+	"labels":              "Labels",
+	"priority":            "Mojang Priority",
+	"assignee":            "Assignee",
+	"votes":               "Votes",
+	"state":               "State",
+}
+
+func (c *FieldChange) Label() string {
+	if label, ok := historyFieldLabels[c.Field]; ok {
+		return label
+	}
+	return c.Field
+}
+
+type IssueDiff struct {
+	Old     *Issue // nil if the issue wasn't stored before
+	New     *Issue
+	Changes []FieldChange
+}
+
+func NewIssueDiff(old *Issue, new *Issue) *IssueDiff {
+	return &IssueDiff{Old: old, New: new, Changes: DiffIssues(old, new)}
+}
+
+func (d *IssueDiff) Change(field string) *FieldChange {
+	for i := range d.Changes {
+		if d.Changes[i].Field == field {
+			return &d.Changes[i]
+		}
+	}
+	return nil
+}
+
+// Compares the tracked fields of a stored issue with a freshly fetched one
+func DiffIssues(old *Issue, new *Issue) []FieldChange {
+	if old == nil || new == nil {
+		return nil
+	}
+	var changes []FieldChange
+	diff := func(field string, oldValue string, newValue string) {
+		if oldValue != newValue {
+			changes = append(changes, FieldChange{Field: field, OldValue: oldValue, NewValue: newValue})
+		}
+	}
+	diffList := func(field string, oldValues []string, newValues []string) {
+		oldValues = NonEmpty(oldValues)
+		newValues = NonEmpty(newValues)
+		if !slices.Equal(oldValues, newValues) {
+			changes = append(changes, FieldChange{Field: field, OldValue: strings.Join(oldValues, ", "), NewValue: strings.Join(newValues, ", ")})
+		}
+	}
+	diff("status", old.Status, new.Status)
+	diff("resolution", old.Resolution, new.Resolution)
+	diff("confirmation_status", old.ConfirmationStatus, new.ConfirmationStatus)
+	diffList("fix_versions", old.FixVersions, new.FixVersions)
+	diffList("affected_versions", old.AffectedVersions, new.AffectedVersions)
+	diffList("labels", old.Labels, new.Labels)
+	diff("priority", old.MojangPriority, new.MojangPriority)
+	diff("assignee", old.AssigneeName, new.AssigneeName)
+	diff("votes", strconv.Itoa(old.TotalVotes()), strconv.Itoa(new.TotalVotes()))
+	return changes
+}
+
+// Returns the values that aren't empty strings
+func NonEmpty(values []string) []string {
+	var result []string
+	for _, v := range values {
+		if v != "" {
+			result = append(result, v)
+		}
+	}
+	return result
+}
@@ -0,0 +1,70 @@
+package model
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestDiffIssues(t *testing.T) {
+	base := Issue{
+		Key:                "MC-4",
+		Status:             "Open",
+		ConfirmationStatus: "Unconfirmed",
+		AffectedVersions:   []string{"1.21"},
+		Labels:             []string{"item-entity"},
+		AssigneeName:       "",
+		Votes:              3,
+		LegacyVotes:        2,
+	}
+	cases := []struct {
+		name   string
+		change func(issue *Issue)
+		want   []FieldChange
+	}{
+		{"unchanged", func(issue *Issue) {}, nil},
+		{"status", func(issue *Issue) {
+			issue.Status = "Resolved"
+			issue.Resolution = "Fixed"
+		}, []FieldChange{{Field: "status", OldValue: "Open", NewValue: "Resolved"}, {Field: "resolution", OldValue: "", NewValue: "Fixed"}}},
+		{"confirmation", func(issue *Issue) { issue.ConfirmationStatus = "Confirmed" }, []FieldChange{{Field: "confirmation_status", OldValue: "Unconfirmed", NewValue: "Confirmed"}}},
+		{"lists", func(issue *Issue) {
+			issue.FixVersions = []string{"1.21.2"}
+			issue.AffectedVersions = []string{"1.21", "1.21.1"}
+		}, []FieldChange{{Field: "fix_versions", OldValue: "", NewValue: "1.21.2"}, {Field: "affected_versions", OldValue: "1.21", NewValue: "1.21, 1.21.1"}}},
+		{"empty list values", func(issue *Issue) {
+			issue.AffectedVersions = []string{"", "1.21"}
+			issue.FixVersions = []string{""}
+		}, nil},
+		{"label order", func(issue *Issue) { issue.Labels = []string{"entity", "item-entity"} }, []FieldChange{{Field: "labels", OldValue: "item-entity", NewValue: "entity, item-entity"}}},
+		{"priority and assignee", func(issue *Issue) {
+			issue.MojangPriority = "Important"
+			issue.AssigneeName = "Moderator"
+		}, []FieldChange{{Field: "priority", OldValue: "", NewValue: "Important"}, {Field: "assignee", OldValue: "", NewValue: "Moderator"}}},
+		{"votes include legacy votes", func(issue *Issue) { issue.Votes = 4 }, []FieldChange{{Field: "votes", OldValue: "5", NewValue: "6"}}},
+		{"moved votes", func(issue *Issue) {
+			issue.Votes = 5
+			issue.LegacyVotes = 0
+		}, nil},
+		{"untracked fields", func(issue *Issue) {
+			issue.Summary = "Other summary"
+			issue.Description = "{}"
+		}, nil},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			old := base
+			new := base
+			new.AffectedVersions = slices.Clone(base.AffectedVersions)
+			new.Labels = slices.Clone(base.Labels)
+			c.change(&new)
+			got := DiffIssues(&old, &new)
+			if !slices.Equal(got, c.want) {
+				t.Errorf("expected %+v, got %+v", c.want, got)
+			}
+		})
+	}
+
+	if changes := DiffIssues(nil, &base); changes != nil {
+		t.Errorf("expected no changes for a new issue, got %+v", changes)
+	}
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"mojira/model"
+	"strings"
+	"time"
+)
+
+// Chat messages are limited in size, so only this many issues get their own embed
+var maxNotificationIssues = 10
+
+// Chat notifications are queued as webhook deliveries that are due at the end of this window, so a burst of updates ends up in a single message
+var notificationWindow = 30 * time.Second
+
+// A change of an issue as it is queued for chat webhooks, with only the fields that messages show
+type queuedNotification struct {
+	Issue   *model.Issue        `json:"issue"`
+	New     bool                `json:"new"`
+	Changes []model.FieldChange `json:"changes"`
+}
+
+func notificationPayload(diff *model.IssueDiff) ([]byte, error) {
+	issue := diff.New
+	return json.Marshal(queuedNotification{
+		Issue: &model.Issue{
+			Key:                issue.Key,
+			Summary:            issue.Summary,
+			Status:             issue.Status,
+			ConfirmationStatus: issue.ConfirmationStatus,
+			Resolution:         issue.Resolution,
+			AffectedVersions:   issue.AffectedVersions,
+			FixVersions:        issue.FixVersions,
+			UpdatedDate:        issue.UpdatedDate,
+		},
+		New:     diff.Old == nil,
+		Changes: diff.Changes,
+	})
+}
+
+func notificationDue() *time.Time {
+	due := time.Now().Truncate(notificationWindow).Add(notificationWindow)
+	return &due
+}
+
+// Builds one message for the queued notifications of a webhook, merging the changes of each issue
+func notificationMessage(format string, deliveries []WebhookDelivery) ([]byte, error) {
+	var diffs []*model.IssueDiff
+	for _, d := range deliveries {
+		var n queuedNotification
+		if err := json.Unmarshal([]byte(d.Payload), &n); err != nil || n.Issue == nil {
+			return nil, fmt.Errorf("invalid notification in delivery %d", d.Id)
+		}
+		diff := &model.IssueDiff{New: n.Issue, Changes: n.Changes}
+		if !n.New {
+			// Only whether there is an old issue matters for the message
+			diff.Old = &model.Issue{Key: n.Issue.Key}
+		}
+		merged := false
+		for i, existing := range diffs {
+			if existing.New.Key == diff.New.Key {
+				diffs[i] = mergeDiffs(existing, diff)
+				merged = true
+				break
+			}
+		}
+		if !merged {
+			diffs = append(diffs, diff)
+		}
+	}
+	switch format {
+	case "discord":
+		return json.Marshal(discordMessage(diffs))
+	case "slack":
+		return json.Marshal(slackMessage(diffs))
+	}
+	return nil, fmt.Errorf("unknown format %s", format)
+}
+
+// Combines two consecutive diffs of the same issue, keeping the oldest value of each field
+func mergeDiffs(a *model.IssueDiff, b *model.IssueDiff) *model.IssueDiff {
+	merged := &model.IssueDiff{Old: a.Old, New: b.New}
+	for _, c := range a.Changes {
+		if later := b.Change(c.Field); later != nil {
+			c.NewValue = later.NewValue
+		}
+		if c.OldValue != c.NewValue {
+			merged.Changes = append(merged.Changes, c)
+		}
+	}
+	for _, c := range b.Changes {
+		if a.Change(c.Field) == nil {
+			merged.Changes = append(merged.Changes, c)
+		}
+	}
+	return merged
+}
+
+func statusColor(issue *model.Issue) int {
+	if issue.IsResolved() {
+		return 0x00875a
+	}
+	if issue.Status == "Open" || issue.Status == "Reopened" {
+		return 0x0c66e4
+	}
+	return 0x6a7282
+}
+
+func notificationTitle(issue *model.Issue) string {
+	title := fmt.Sprintf("[%s] %s", issue.Key, issue.Summary)
+	// Discord allows 256 characters, not bytes
+	if runes := []rune(title); len(runes) > 250 {
+		title = string(runes[:250]) + "..."
+	}
+	return title
+}
+
+func notificationChanges(diff *model.IssueDiff) string {
+	if diff.Old == nil {
+		return "New issue"
+	}
+	var lines []string
+	for _, c := range diff.Changes {
+		oldValue := c.OldValue
+		if oldValue == "" {
+			oldValue = "(None)"
+		}
+		newValue := c.NewValue
+		if newValue == "" {
+			newValue = "(None)"
+		}
+		lines = append(lines, fmt.Sprintf("%s: %s → %s", c.Label(), oldValue, newValue))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func notificationStatus(issue *model.Issue) string {
+	if issue.Resolution != "" {
+		return fmt.Sprintf("%s (%s)", issue.Status, issue.Resolution)
+	}
+	return issue.Status
+}
+
+func notificationSummary(diffs []*model.IssueDiff) string {
+	if len(diffs) == 1 {
+		return fmt.Sprintf("%s was updated", diffs[0].New.Key)
+	}
+	summary := fmt.Sprintf("%d issues were updated", len(diffs))
+	if len(diffs) > maxNotificationIssues {
+		summary += fmt.Sprintf(", showing the first %d", maxNotificationIssues)
+	}
+	return summary
+}
+
+type discordEmbed struct {
+	Title       string              `json:"title"`
+	Url         string              `json:"url"`
+	Description string              `json:"description,omitempty"`
+	Color       int                 `json:"color"`
+	Fields      []discordEmbedField `json:"fields,omitempty"`
+	Timestamp   string              `json:"timestamp,omitempty"`
+}
+
+type discordEmbedField struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Inline bool   `json:"inline"`
+}
+
+func discordMessage(diffs []*model.IssueDiff) map[string]any {
+	var embeds []discordEmbed
+	for _, diff := range diffs[:min(len(diffs), maxNotificationIssues)] {
+		issue := diff.New
+		fields := []discordEmbedField{
+			{Name: "Status", Value: notificationStatus(issue), Inline: true},
+		}
+		if issue.ConfirmationStatus != "" {
+			fields = append(fields, discordEmbedField{Name: "Confirmation", Value: issue.ConfirmationStatus, Inline: true})
+		}
+		if versions := model.NonEmpty(issue.FixVersions); len(versions) > 0 {
+			fields = append(fields, discordEmbedField{Name: "Fix Versions", Value: strings.Join(versions, ", "), Inline: true})
+		}
+		if versions := model.NonEmpty(issue.AffectedVersions); len(versions) > 0 {
+			fields = append(fields, discordEmbedField{Name: "Affects Versions", Value: shortVersionList(versions), Inline: true})
+		}
+		embed := discordEmbed{
+			Title:       notificationTitle(issue),
+			Url:         fmt.Sprintf("%s/%s", siteUrl, issue.Key),
+			Description: notificationChanges(diff),
+			Color:       statusColor(issue),
+			Fields:      fields,
+		}
+		if issue.UpdatedDate != nil {
+			embed.Timestamp = issue.UpdatedDate.UTC().Format(time.RFC3339)
+		}
+		embeds = append(embeds, embed)
+	}
+	return map[string]any{
+		"username": "mojira.dev",
+		"content":  notificationSummary(diffs),
+		"embeds":   embeds,
+	}
+}
+
+type slackAttachment struct {
+	Color     string       `json:"color"`
+	Title     string       `json:"title"`
+	TitleLink string       `json:"title_link"`
+	Text      string       `json:"text,omitempty"`
+	Fields    []slackField `json:"fields,omitempty"`
+	Ts        int64        `json:"ts,omitempty"`
+}
+
+type slackField struct {
+	Title string `json:"title"`
+	Value string `json:"value"`
+	Short bool   `json:"short"`
+}
+
+func slackMessage(diffs []*model.IssueDiff) map[string]any {
+	var attachments []slackAttachment
+	for _, diff := range diffs[:min(len(diffs), maxNotificationIssues)] {
+		issue := diff.New
+		fields := []slackField{
+			{Title: "Status", Value: notificationStatus(issue), Short: true},
+		}
+		if issue.ConfirmationStatus != "" {
+			fields = append(fields, slackField{Title: "Confirmation", Value: issue.ConfirmationStatus, Short: true})
+		}
+		if versions := model.NonEmpty(issue.FixVersions); len(versions) > 0 {
+			fields = append(fields, slackField{Title: "Fix Versions", Value: strings.Join(versions, ", "), Short: true})
+		}
+		if versions := model.NonEmpty(issue.AffectedVersions); len(versions) > 0 {
+			fields = append(fields, slackField{Title: "Affects Versions", Value: shortVersionList(versions), Short: true})
+		}
+		attachment := slackAttachment{
+			Color:     fmt.Sprintf("#%06x", statusColor(issue)),
+			Title:     notificationTitle(issue),
+			TitleLink: fmt.Sprintf("%s/%s", siteUrl, issue.Key),
+			Text:      notificationChanges(diff),
+			Fields:    fields,
+		}
+		if issue.UpdatedDate != nil {
+			attachment.Ts = issue.UpdatedDate.Unix()
+		}
+		attachments = append(attachments, attachment)
+	}
+	return map[string]any{
+		"text":        notificationSummary(diffs),
+		"attachments": attachments,
+	}
+}
+
+func shortVersionList(versions []string) string {
+	if len(versions) <= 5 {
+		return strings.Join(versions, ", ")
+	}
+	return fmt.Sprintf("%s, ... %s (%d total)", strings.Join(versions[:2], ", "), strings.Join(versions[len(versions)-2:], ", "), len(versions))
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"mojira/model"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func notificationDiff(issue *model.Issue, changes ...model.FieldChange) *model.IssueDiff {
+	return &model.IssueDiff{Old: &model.Issue{Key: issue.Key}, New: issue, Changes: changes}
+}
+
+func TestDiscordMessage(t *testing.T) {
+	updated := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	resolved := &model.Issue{
+		Key: "MC-1", Summary: "Lava is hot", Status: "Resolved", Resolution: "Fixed", ConfirmationStatus: "Confirmed",
+		FixVersions: []string{"1.21", ""}, AffectedVersions: []string{"1.16", "1.17", "1.18", "1.19", "1.20", "1.20.1"}, UpdatedDate: &updated,
+	}
+	created := &model.Issue{Key: "MC-2", Summary: strings.Repeat("ä", 300), Status: "Open"}
+	message := discordMessage([]*model.IssueDiff{
+		notificationDiff(resolved, model.FieldChange{Field: "status", OldValue: "Open", NewValue: "Resolved"}, model.FieldChange{Field: "resolution", NewValue: "Fixed"}),
+		{New: created},
+	})
+
+	if message["content"] != "2 issues were updated" {
+		t.Errorf("unexpected content %q", message["content"])
+	}
+	embeds := message["embeds"].([]discordEmbed)
+	if len(embeds) != 2 {
+		t.Fatalf("expected 2 embeds, got %d", len(embeds))
+	}
+	embed := embeds[0]
+	if embed.Title != "[MC-1] Lava is hot" || embed.Url != "https://mojira.dev/MC-1" || embed.Timestamp != "2024-03-01T12:00:00Z" {
+		t.Errorf("unexpected embed %+v", embed)
+	}
+	if embed.Description != "Status: Open → Resolved\nResolution: (None) → Fixed" {
+		t.Errorf("unexpected changes %q", embed.Description)
+	}
+	if embed.Color != 0x00875a || embeds[1].Color != 0x0c66e4 {
+		t.Errorf("unexpected colours %06x and %06x", embed.Color, embeds[1].Color)
+	}
+	want := []discordEmbedField{
+		{Name: "Status", Value: "Resolved (Fixed)", Inline: true},
+		{Name: "Confirmation", Value: "Confirmed", Inline: true},
+		{Name: "Fix Versions", Value: "1.21", Inline: true},
+		{Name: "Affects Versions", Value: "1.16, 1.17, ... 1.20, 1.20.1 (6 total)", Inline: true},
+	}
+	if fmt.Sprint(embed.Fields) != fmt.Sprint(want) {
+		t.Errorf("unexpected fields %+v", embed.Fields)
+	}
+
+	if embeds[1].Description != "New issue" || embeds[1].Timestamp != "" || len(embeds[1].Fields) != 1 {
+		t.Errorf("unexpected embed for a new issue %+v", embeds[1])
+	}
+	if title := []rune(embeds[1].Title); len(title) != 253 || !strings.HasSuffix(embeds[1].Title, "ää...") {
+		t.Errorf("expected the title to be cut to 250 characters, got %d", len(title))
+	}
+}
+
+func TestDiscordMessageLimit(t *testing.T) {
+	var diffs []*model.IssueDiff
+	for i := range maxNotificationIssues + 2 {
+		diffs = append(diffs, notificationDiff(&model.Issue{Key: fmt.Sprintf("MC-%d", i), Status: "Open"}))
+	}
+	message := discordMessage(diffs)
+	if len(message["embeds"].([]discordEmbed)) != maxNotificationIssues {
+		t.Errorf("expected %d embeds", maxNotificationIssues)
+	}
+	if message["content"] != "12 issues were updated, showing the first 10" {
+		t.Errorf("unexpected content %q", message["content"])
+	}
+	if discordMessage(diffs[:1])["content"] != "MC-0 was updated" {
+		t.Errorf("unexpected content for one issue %q", discordMessage(diffs[:1])["content"])
+	}
+}
+
+func TestSlackMessage(t *testing.T) {
+	updated := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	issue := &model.Issue{Key: "MC-1", Summary: "Lava is hot", Status: "In Progress", AffectedVersions: []string{"1.20"}, UpdatedDate: &updated}
+	message := slackMessage([]*model.IssueDiff{notificationDiff(issue, model.FieldChange{Field: "status", OldValue: "Open", NewValue: "In Progress"})})
+
+	if message["text"] != "MC-1 was updated" {
+		t.Errorf("unexpected text %q", message["text"])
+	}
+	attachments := message["attachments"].([]slackAttachment)
+	if len(attachments) != 1 {
+		t.Fatalf("expected 1 attachment, got %d", len(attachments))
+	}
+	a := attachments[0]
+	if a.Color != "#6a7282" || a.Title != "[MC-1] Lava is hot" || a.TitleLink != "https://mojira.dev/MC-1" || a.Ts != updated.Unix() {
+		t.Errorf("unexpected attachment %+v", a)
+	}
+	if a.Text != "Status: Open → In Progress" {
+		t.Errorf("unexpected changes %q", a.Text)
+	}
+	want := []slackField{{Title: "Status", Value: "In Progress", Short: true}, {Title: "Affects Versions", Value: "1.20", Short: true}}
+	if fmt.Sprint(a.Fields) != fmt.Sprint(want) {
+		t.Errorf("unexpected fields %+v", a.Fields)
+	}
+}
+
+func TestMergeDiffs(t *testing.T) {
+	issue := &model.Issue{Key: "MC-1"}
+	first := notificationDiff(issue,
+		model.FieldChange{Field: "status", OldValue: "Open", NewValue: "Resolved"},
+		model.FieldChange{Field: "labels", OldValue: "", NewValue: "crash"},
+	)
+	second := notificationDiff(issue,
+		model.FieldChange{Field: "status", OldValue: "Resolved", NewValue: "Reopened"},
+		model.FieldChange{Field: "labels", OldValue: "crash", NewValue: ""},
+		model.FieldChange{Field: "resolution", OldValue: "", NewValue: "Fixed"},
+	)
+	merged := mergeDiffs(first, second)
+	if got := notificationChanges(merged); got != "Status: Open → Reopened\nResolution: (None) → Fixed" {
+		t.Errorf("expected the oldest and newest values with reverted changes dropped, got %q", got)
+	}
+	if merged.Old != first.Old || merged.New != second.New {
+		t.Error("expected the merged diff to span both diffs")
+	}
+	if created := mergeDiffs(&model.IssueDiff{New: issue}, second); notificationChanges(created) != "New issue" {
+		t.Error("expected an issue created in the batch to stay new")
+	}
+}
+
+func TestChatNotificationDelivery(t *testing.T) {
+	receiver := &webhookReceiver{status: http.StatusNoContent}
+	server := httptest.NewServer(receiver)
+	t.Cleanup(server.Close)
+	store := NewMemoryStore()
+	id := store.AddWebhook(Webhook{Url: server.URL, Format: "discord", Enabled: true})
+	service := &IssueService{db: store}
+	ctx := context.Background()
+
+	// Two updates of MC-1 and a new issue in the same window
+	first := &model.Issue{Key: "MC-1", Summary: "Lava is hot", Status: "Open", Comments: []model.Comment{{Id: "1"}}}
+	first.Comments[0].Issue = first
+	second := &model.Issue{Key: "MC-1", Summary: "Lava is hot", Status: "Resolved", Resolution: "Fixed"}
+	queueWebhooks(service, ctx, notificationDiff(first, model.FieldChange{Field: "status", OldValue: "Reopened", NewValue: "Open"}), []string{"status"})
+	queueWebhooks(service, ctx, notificationDiff(second, model.FieldChange{Field: "status", OldValue: "Open", NewValue: "Resolved"}), []string{"status"})
+	queueWebhooks(service, ctx, &model.IssueDiff{New: &model.Issue{Key: "MC-2", Summary: "Water is wet", Status: "Open"}}, []string{"created"})
+
+	deliveries, _ := store.GetWebhookDeliveries(ctx, 10)
+	if len(deliveries) != 3 {
+		t.Fatalf("expected each change to be queued, got %d deliveries", len(deliveries))
+	}
+	webhookDeliverer(service)
+	if receiver.requests() != 0 {
+		t.Fatal("expected notifications to wait for the end of the window")
+	}
+
+	past := time.Now().Add(-time.Second)
+	for _, d := range deliveries {
+		store.delivery(d.Id).RetryAfter = &past
+	}
+	webhookDeliverer(service)
+	if receiver.requests() != 1 {
+		t.Fatalf("expected a single message, got %d", receiver.requests())
+	}
+	var message struct {
+		Content string         `json:"content"`
+		Embeds  []discordEmbed `json:"embeds"`
+	}
+	if err := json.Unmarshal([]byte(receiver.bodies[0]), &message); err != nil {
+		t.Fatal(err)
+	}
+	if message.Content != "2 issues were updated" || len(message.Embeds) != 2 {
+		t.Fatalf("unexpected message %s", receiver.bodies[0])
+	}
+	if embed := message.Embeds[0]; embed.Description != "Status: Reopened → Resolved" || embed.Color != 0x00875a {
+		t.Errorf("expected the changes of MC-1 to be merged, got %+v", embed)
+	}
+	if message.Embeds[1].Description != "New issue" {
+		t.Errorf("expected MC-2 to be new, got %+v", message.Embeds[1])
+	}
+
+	deliveries, _ = store.GetWebhookDeliveries(ctx, 10)
+	for _, d := range deliveries {
+		if d.WebhookId != id || d.Status != "delivered" {
+			t.Errorf("expected every notification to be delivered: %+v", d)
+		}
+	}
+}
+
+func TestChatNotificationRetry(t *testing.T) {
+	receiver := &webhookReceiver{status: http.StatusTooManyRequests}
+	server := httptest.NewServer(receiver)
+	t.Cleanup(server.Close)
+	store := NewMemoryStore()
+	store.AddWebhook(Webhook{Url: server.URL, Format: "slack", Enabled: true})
+	service := &IssueService{db: store}
+	ctx := context.Background()
+
+	for _, key := range []string{"MC-1", "MC-2"} {
+		queueWebhooks(service, ctx, &model.IssueDiff{New: &model.Issue{Key: key, Status: "Open"}}, []string{"created"})
+	}
+	deliveries, _ := store.GetWebhookDeliveries(ctx, 10)
+	past := time.Now().Add(-time.Second)
+	for _, d := range deliveries {
+		store.delivery(d.Id).RetryAfter = &past
+	}
+	webhookDeliverer(service)
+	if receiver.requests() != 1 {
+		t.Fatalf("expected a single message, got %d", receiver.requests())
+	}
+	deliveries, _ = store.GetWebhookDeliveries(ctx, 10)
+	for _, d := range deliveries {
+		if d.Status != "pending" || d.FailedCount != 1 || d.ResponseCode == nil || *d.ResponseCode != http.StatusTooManyRequests {
+			t.Errorf("expected every notification of the message to be retried: %+v", d)
+		}
+	}
+}
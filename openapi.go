@@ -0,0 +1,248 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"mojira/model"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The v1 response types are anonymous structs, so the schema names are listed here
+var openAPIComponents = []struct {
+	name string
+	typ  reflect.Type
+}{
+	{"Issue", reflect.TypeOf(V1Issue{})},
+	{"Link", reflect.TypeOf(V1Link{})},
+	{"Attachment", reflect.TypeOf(V1Attachment{})},
+	{"Comment", reflect.TypeOf(V1Comment{})},
+	{"FieldChange", reflect.TypeOf(V1FieldChange{})},
+	{"ActivityEvent", reflect.TypeOf(V1ActivityEvent{})},
+	{"SearchResult", reflect.TypeOf(V1SearchResult{})},
+	{"BatchRequest", reflect.TypeOf(V1BatchRequest{})},
+	{"BatchResult", reflect.TypeOf(V1BatchResult{})},
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+func openAPIRef(name string) map[string]any {
+	return map[string]any{"$ref": "#/components/schemas/" + name}
+}
+
+// Describes a Go type as an OpenAPI schema, pointers and slices can be null in the JSON output
+func openAPISchema(t reflect.Type, component bool) map[string]any {
+	nullable := false
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+		nullable = true
+	}
+	if !component {
+		for _, c := range openAPIComponents {
+			if c.typ == t {
+				if nullable {
+					return map[string]any{"allOf": []any{openAPIRef(c.name)}, "nullable": true}
+				}
+				return openAPIRef(c.name)
+			}
+		}
+	}
+	var schema map[string]any
+	switch {
+	case t == timeType:
+		schema = map[string]any{"type": "string", "format": "date-time"}
+	case t.Kind() == reflect.String:
+		schema = map[string]any{"type": "string"}
+	case t.Kind() == reflect.Bool:
+		schema = map[string]any{"type": "boolean"}
+	case t.Kind() >= reflect.Int && t.Kind() <= reflect.Int64:
+		schema = map[string]any{"type": "integer"}
+	case t.Kind() == reflect.Slice:
+		schema = map[string]any{"type": "array", "items": openAPISchema(t.Elem(), false)}
+		nullable = true
+	case t.Kind() == reflect.Struct:
+		properties := map[string]any{}
+		required := []string{}
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+			if name == "" || name == "-" {
+				continue
+			}
+			properties[name] = openAPISchema(f.Type, false)
+			required = append(required, name)
+		}
+		schema = map[string]any{"type": "object", "properties": properties, "required": required, "additionalProperties": false}
+	default:
+		panic(fmt.Sprintf("openapi: unsupported type %s", t))
+	}
+	if nullable {
+		schema["nullable"] = true
+	}
+	return schema
+}
+
+func openAPIParam(name string, in string, description string, schema map[string]any) map[string]any {
+	return map[string]any{
+		"name":        name,
+		"in":          in,
+		"required":    in == "path",
+		"description": description,
+		"schema":      schema,
+	}
+}
+
+func openAPIResponse(description string, schema map[string]any) map[string]any {
+	return map[string]any{
+		"description": description,
+		"content": map[string]any{
+			"application/json": map[string]any{"schema": schema},
+		},
+	}
+}
+
+func openAPIArray(schema map[string]any) map[string]any {
+	return map[string]any{"type": "array", "items": schema}
+}
+
+func openAPIEnum(values []string) map[string]any {
+	return map[string]any{"type": "string", "enum": values}
+}
+
+var stringSchema = map[string]any{"type": "string"}
+var integerSchema = map[string]any{"type": "integer"}
+var textError = map[string]any{"description": "Error message", "content": map[string]any{"text/plain": map[string]any{"schema": stringSchema}}}
+
+func buildOpenAPI() map[string]any {
+	schemas := map[string]any{}
+	for _, c := range openAPIComponents {
+		schemas[c.name] = openAPISchema(c.typ, true)
+	}
+	keyParam := openAPIParam("key", "path", "Issue key, like MC-4", stringSchema)
+
+	searchParams := []any{
+		openAPIParam("search", "query", "Full text search", stringSchema),
+		openAPIParam("query", "query", "Query in the JQL-like syntax, like `project = MC AND votes > 50`", stringSchema),
+	}
+	for _, name := range []string{"project", "status", "confirmation", "resolution", "priority", "reporter", "assignee", "affected_version", "fix_version", "category", "label", "component", "platform", "area"} {
+		searchParams = append(searchParams, openAPIParam(name, "query", "", stringSchema))
+	}
+	searchParams = append(searchParams,
+		openAPIParam("sort", "query", "Sort order, ignored when the query has an ORDER BY", openAPIEnum(searchSortOptions)),
+		openAPIParam("cursor", "query", "Cursor from a previous response", stringSchema),
+		openAPIParam("limit", "query", fmt.Sprintf("Number of issues, at most %d", maxSearchPageSize), integerSchema),
+	)
+
+	return map[string]any{
+		"openapi": "3.0.3",
+		"info": map[string]any{
+			"title":   "mojira.dev API",
+			"version": "1",
+		},
+		"servers": []any{map[string]any{"url": siteUrl}},
+		"paths": map[string]any{
+			"/api/v1/search": map[string]any{
+				"get": map[string]any{
+					"operationId": "searchIssues",
+					"summary":     "Search the mirrored issues",
+					"parameters":  searchParams,
+					"responses": map[string]any{
+						"200": openAPIResponse("Matching issues", openAPIRef("SearchResult")),
+						"400": textError,
+					},
+				},
+			},
+			"/api/v1/issues/batch": map[string]any{
+				"post": map[string]any{
+					"operationId": "getIssuesBatch",
+					"summary":     "Get several issues from the mirror at once",
+					"requestBody": map[string]any{
+						"required": true,
+						"content": map[string]any{
+							"application/json": map[string]any{"schema": openAPIRef("BatchRequest")},
+						},
+					},
+					"responses": map[string]any{
+						"200": openAPIResponse("Stored issues and the keys that could not be returned", openAPIRef("BatchResult")),
+						"400": textError,
+					},
+				},
+			},
+			"/api/v1/issues/{key}": map[string]any{
+				"get": map[string]any{
+					"operationId": "getIssue",
+					"summary":     "Get a single issue",
+					"parameters":  []any{keyParam},
+					"responses": map[string]any{
+						"200": openAPIResponse("The issue", openAPIRef("Issue")),
+						"404": textError,
+					},
+				},
+			},
+			"/api/v1/issues/{key}/comments": map[string]any{
+				"get": map[string]any{
+					"operationId": "getIssueComments",
+					"summary":     "Get the comments of an issue",
+					"parameters": []any{
+						keyParam,
+						openAPIParam("format", "query", "Format of the comment bodies", openAPIEnum(commentFormats)),
+					},
+					"responses": map[string]any{
+						"200": openAPIResponse("Comments, oldest first", openAPIArray(openAPIRef("Comment"))),
+						"400": textError,
+						"404": textError,
+					},
+				},
+			},
+			"/api/v1/issues/{key}/history": map[string]any{
+				"get": map[string]any{
+					"operationId": "getIssueHistory",
+					"summary":     "Get the field changes detected between syncs",
+					"parameters":  []any{keyParam},
+					"responses": map[string]any{
+						"200": openAPIResponse("Field changes", openAPIArray(openAPIRef("FieldChange"))),
+						"404": textError,
+					},
+				},
+			},
+			"/api/v1/activity": map[string]any{
+				"get": map[string]any{
+					"operationId": "getActivity",
+					"summary":     "Get recent activity, newest first",
+					"parameters": []any{
+						openAPIParam("project", "query", "", stringSchema),
+						openAPIParam("type", "query", "", openAPIEnum(model.ActivityTypes)),
+						openAPIParam("version", "query", "", stringSchema),
+						openAPIParam("before", "query", "Only return events with a lower id", integerSchema),
+						openAPIParam("limit", "query", "Number of events, at most 200", integerSchema),
+					},
+					"responses": map[string]any{
+						"200": openAPIResponse("Activity events", openAPIArray(openAPIRef("ActivityEvent"))),
+						"400": textError,
+					},
+				},
+			},
+		},
+		"components": map[string]any{
+			"schemas": schemas,
+		},
+	}
+}
+
+var openAPIDocument = sync.OnceValue(func() []byte {
+	data, err := json.MarshalIndent(buildOpenAPI(), "", "  ")
+	if err != nil {
+		log.Fatalf("[ERROR] Encoding OpenAPI document: %s", err)
+	}
+	return data
+})
+
+func apiV1OpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Write(openAPIDocument())
+}
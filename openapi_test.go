@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"math"
+	"mojira/model"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+var updateGolden = flag.Bool("update", false, "Update the golden files with the current output")
+
+const openAPIGolden = "testdata/openapi.json"
+
+// Checks a decoded JSON value against a schema of the committed document
+func validateOpenAPI(doc map[string]any, schema map[string]any, value any, path string) error {
+	if ref, ok := schema["$ref"].(string); ok {
+		name := strings.TrimPrefix(ref, "#/components/schemas/")
+		schema = doc["components"].(map[string]any)["schemas"].(map[string]any)[name].(map[string]any)
+	}
+	if value == nil {
+		if schema["nullable"] == true {
+			return nil
+		}
+		return fmt.Errorf("%s: is null, but not nullable", path)
+	}
+	if allOf, ok := schema["allOf"].([]any); ok {
+		for _, s := range allOf {
+			if err := validateOpenAPI(doc, s.(map[string]any), value, path); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	switch schema["type"] {
+	case "string":
+		s, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("%s: expected a string, got %T", path, value)
+		}
+		if schema["format"] == "date-time" {
+			if _, err := time.Parse(time.RFC3339, s); err != nil {
+				return fmt.Errorf("%s: expected a date-time, got %q", path, s)
+			}
+		}
+		if enum, ok := schema["enum"].([]any); ok && !slices.Contains(enum, any(s)) {
+			return fmt.Errorf("%s: %q is not one of %v", path, s, enum)
+		}
+	case "integer":
+		n, ok := value.(float64)
+		if !ok || n != math.Trunc(n) {
+			return fmt.Errorf("%s: expected an integer, got %v", path, value)
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return fmt.Errorf("%s: expected a boolean, got %T", path, value)
+		}
+	case "array":
+		items, ok := value.([]any)
+		if !ok {
+			return fmt.Errorf("%s: expected an array, got %T", path, value)
+		}
+		for i, item := range items {
+			if err := validateOpenAPI(doc, schema["items"].(map[string]any), item, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+	case "object":
+		obj, ok := value.(map[string]any)
+		if !ok {
+			return fmt.Errorf("%s: expected an object, got %T", path, value)
+		}
+		properties := schema["properties"].(map[string]any)
+		required, _ := schema["required"].([]any)
+		for _, name := range required {
+			if _, ok := obj[name.(string)]; !ok {
+				return fmt.Errorf("%s: missing property %q", path, name)
+			}
+		}
+		names := make([]string, 0, len(obj))
+		for name := range obj {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			prop, ok := properties[name]
+			if !ok {
+				return fmt.Errorf("%s: property %q is not in the schema", path, name)
+			}
+			if err := validateOpenAPI(doc, prop.(map[string]any), obj[name], path+"."+name); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("%s: schema without a type", path)
+	}
+	return nil
+}
+
+func validateOpenAPIValue(doc map[string]any, component string, value any) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	var decoded any
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return err
+	}
+	return validateOpenAPI(doc, map[string]any{"$ref": "#/components/schemas/" + component}, decoded, component)
+}
+
+// The served document has to match testdata/openapi.json, so every change to the API shows up in review.
+// Run with -update after an intended change
+func TestOpenAPIContract(t *testing.T) {
+	served := openAPIDocument()
+	if *updateGolden {
+		if err := os.WriteFile(openAPIGolden, served, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	golden, err := os.ReadFile(openAPIGolden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(served, golden) {
+		t.Errorf("the served OpenAPI document differs from %s, run go test -run TestOpenAPIContract -update and review the diff", openAPIGolden)
+	}
+
+	var doc map[string]any
+	if err := json.Unmarshal(golden, &doc); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now().UTC().Truncate(time.Second)
+	full := &model.Issue{
+		Key:                "MC-4",
+		Summary:            "Item drops appear at the wrong position",
+		CreatorName:        "creator",
+		ReporterName:       "reporter",
+		ReporterAvatar:     "https://example.com/avatar.png",
+		AssigneeName:       "assignee",
+		AssigneeAvatar:     "https://example.com/avatar.png",
+		Description:        `{"type":"doc","version":1,"content":[]}`,
+		Environment:        `{"type":"doc","version":1,"content":[]}`,
+		Labels:             []string{"item"},
+		CreatedDate:        &now,
+		UpdatedDate:        &now,
+		ResolvedDate:       &now,
+		Status:             "Resolved",
+		ConfirmationStatus: "Confirmed",
+		Resolution:         "Fixed",
+		AffectedVersions:   []string{"1.21"},
+		FixVersions:        []string{"1.21.1"},
+		Category:           []string{"Items"},
+		MojangPriority:     "Normal",
+		Area:               "Gameplay",
+		Components:         []string{"Entities"},
+		Platform:           "Java",
+		OSVersion:          "Windows 11",
+		RealmsPlatform:     "Java",
+		ADO:                "1234",
+		Votes:              10,
+		LegacyVotes:        5,
+		Links:              []model.IssueLink{{Type: "is duplicated by", OtherKey: "MC-5", OtherSummary: "Duplicate", OtherStatus: "Resolved"}},
+		Attachments:        []model.Attachment{{Id: "1", Filename: "screenshot.png", AuthorName: "reporter", CreatedDate: &now, Size: 1024, MimeType: "image/png"}},
+		SyncedDate:         &now,
+	}
+	full.Comments = []model.Comment{{Issue: full, Id: "1", LegacyId: "100", Date: &now, AuthorName: "commenter", AdfComment: `{"type":"doc","version":1,"content":[]}`}}
+	partial := &model.Issue{Key: "MC-5", Summary: "Partially loaded"}
+	comment := &model.ActivityEvent{Id: 7, IssueKey: "MC-4", Summary: full.Summary, Type: "comment", AuthorName: "commenter", Anchor: "comment-1", Date: &now}
+	fixed := &model.ActivityEvent{Id: 8, IssueKey: "MC-4", Summary: full.Summary, Type: "fix_version", NewValue: "1.21.1", Versions: []string{"1.21.1"}, Date: &now}
+
+	checks := []struct {
+		name      string
+		component string
+		value     any
+	}{
+		{"full issue", "Issue", newV1Issue(full)},
+		{"partial issue", "Issue", newV1Issue(partial)},
+		{"search result", "SearchResult", V1SearchResult{Total: 1, Issues: []V1Issue{newV1Issue(partial)}, NextCursor: apiField("cursor")}},
+		{"batch result", "BatchResult", V1BatchResult{Issues: []V1Issue{newV1Issue(full)}, Missing: []string{}, Removed: []string{}, Invalid: []string{}, Queued: []string{}}},
+		{"html comment", "Comment", newV1Comment(&full.Comments[0], "html")},
+		{"adf comment", "Comment", newV1Comment(&full.Comments[0], "adf")},
+		{"field change", "FieldChange", V1FieldChange{Field: "status", OldValue: apiField("Open"), ChangedDate: &now}},
+		{"comment activity", "ActivityEvent", newV1ActivityEvent(comment)},
+		{"fix version activity", "ActivityEvent", newV1ActivityEvent(fixed)},
+	}
+	for _, check := range checks {
+		t.Run(check.name, func(t *testing.T) {
+			if err := validateOpenAPIValue(doc, check.component, check.value); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"cmp"
 	"context"
 	"errors"
 	"log"
@@ -9,25 +10,32 @@ import (
 	"os"
 	"strings"
 	"time"
+
+	"golang.org/x/sync/singleflight"
 )
 
+// Fetches shared between requests aren't cancelled with the request that started them
+const sharedFetchTimeout = 30 * time.Second
+
 type IssueService struct {
-	db           *DBClient
-	legacy       *api.LegacyClient
-	public       *api.PublicClient
-	serviceDesk  *api.ServiceDeskClient
+	db           Store
+	legacy       api.LegacySource
+	public       api.PublicSource
+	serviceDesk  api.ServiceDeskSource
 	redactedKeys map[string]struct{}
+	cache        *issueCache
+	fetches      singleflight.Group
 }
 
 func NewIssueService() *IssueService {
-	dbClient, err := NewDBClient()
+	store, err := NewStore()
 	if err != nil {
 		log.Fatalf("Failed to connect to database: %v", err)
 	}
 
-	legacy := api.NewLegacyClient()
-	public := api.NewPublicClient()
-	serviceDesk := api.NewServiceDeskClient()
+	legacy := api.NewLegacyClient(cmp.Or(os.Getenv("LEGACY_API_URL"), api.DefaultLegacyURL))
+	public := api.NewPublicClient(cmp.Or(os.Getenv("PUBLIC_API_URL"), api.DefaultPublicURL))
+	serviceDesk := api.NewServiceDeskClient(cmp.Or(os.Getenv("SERVICEDESK_URL"), api.DefaultServiceDeskURL))
 	err = serviceDesk.Authenticate()
 	if err != nil {
 		log.Printf("Failed to authenticate to service desk: %v", err)
@@ -46,60 +54,125 @@ func NewIssueService() *IssueService {
 	}
 	log.Printf("Using %v redacted keys", len(redactedKeys))
 
-	return &IssueService{db: dbClient, legacy: legacy, public: public, serviceDesk: serviceDesk, redactedKeys: redactedKeys}
+	cacheSize := issueCacheSize()
+	log.Printf("Caching up to %v issues", cacheSize)
+
+	return &IssueService{db: store, legacy: legacy, public: public, serviceDesk: serviceDesk, redactedKeys: redactedKeys, cache: newIssueCache(cacheSize)}
+}
+
+func (s *IssueService) upstreams() []*api.Upstream {
+	return []*api.Upstream{s.legacy.Upstream(), s.public.Upstream(), s.serviceDesk.Upstream()}
+}
+
+// Sources every issue is synced from, the legacy API is only needed for issues created before the migration
+func (s *IssueService) requiredUpstreams() []*api.Upstream {
+	return []*api.Upstream{s.public.Upstream(), s.serviceDesk.Upstream()}
 }
 
 func (s *IssueService) GetIssue(ctx context.Context, key string) (*model.Issue, error) {
+	if issue := s.cache.Get(key); issue != nil {
+		// Another instance may have refreshed or removed the issue since it was cached
+		syncedDate, err := s.db.GetIssueSyncedDate(key)
+		if err == nil && syncedDate != nil && issue.SyncedDate != nil && syncedDate.Equal(*issue.SyncedDate) {
+			return issue, nil
+		}
+		s.cache.Remove(key)
+	}
 	issue, err := s.db.GetIssueByKey(key)
 	if errors.Is(err, model.ErrIssueRemoved) {
 		return nil, err
 	}
 	if issue != nil {
+		s.cache.Add(issue)
 		return issue, nil
 	}
 	if !errors.Is(err, model.ErrIssueNotStored) {
 		log.Printf("[ERROR] GetIssueByKey %s: %s", key, err)
 	}
 
-	issue, err = s.fetchIssue(ctx, key)
+	result, err, _ := s.fetches.Do("get:"+key, func() (any, error) {
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), sharedFetchTimeout)
+		defer cancel()
+		issue, err := s.fetchIssue(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+
+		if !issue.Partial {
+			err = s.db.UpdateIssue(ctx, issue, nil)
+			s.cache.Remove(key)
+			if err != nil {
+				log.Printf("Error inserting issue %s: %v", key, err)
+			} else {
+				processChanges(s, ctx, model.NewIssueDiff(nil, issue))
+			}
+		}
+		return issue, nil
+	})
 	if err != nil {
 		return nil, err
 	}
+	return result.(*model.Issue), nil
+}
 
-	if !issue.Partial {
-		err = s.db.UpdateIssue(ctx, issue)
-		if err != nil {
-			log.Printf("Error inserting issue %s: %v", key, err)
-		}
-	}
+type refreshResult struct {
+	issue *model.Issue
+	diff  *model.IssueDiff
+}
 
-	return issue, nil
+// Concurrent refreshes of the same issue share one fetch. Only the caller that did the refresh gets the diff, so the changes are handled once
+func (s *IssueService) RefreshIssue(ctx context.Context, key string) (*model.Issue, *model.IssueDiff, error) {
+	refreshed := false
+	result, err, _ := s.fetches.Do("refresh:"+key, func() (any, error) {
+		refreshed = true
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), sharedFetchTimeout)
+		defer cancel()
+		issue, diff, err := s.refreshIssue(ctx, key)
+		return refreshResult{issue: issue, diff: diff}, err
+	})
+	refresh := result.(refreshResult)
+	if !refreshed {
+		return refresh.issue, nil, err
+	}
+	return refresh.issue, refresh.diff, err
 }
 
-func (s *IssueService) RefreshIssue(ctx context.Context, key string) (*model.Issue, error) {
+func (s *IssueService) refreshIssue(ctx context.Context, key string) (*model.Issue, *model.IssueDiff, error) {
 	oldIssue, _ := s.db.GetIssueForSync(key)
 	if oldIssue != nil && oldIssue.IsUpToDate() {
-		return nil, nil
+		return nil, nil, nil
 	}
 
 	issue, err := s.fetchIssue(ctx, key)
 	if err != nil {
 		if oldIssue != nil && errors.Is(err, model.ErrIssueNotFound) {
 			s.db.MarkIssueRemoved(key)
-			return nil, model.ErrIssueRemoved
+			s.cache.Remove(key)
+			return nil, nil, model.ErrIssueRemoved
 		}
-		return oldIssue, err
+		return oldIssue, nil, err
 	}
 
 	if issue.Partial {
-		return oldIssue, errors.New("cannot refresh issue")
+		return oldIssue, nil, errors.New("cannot refresh issue")
 	}
 
-	err = s.db.UpdateIssue(ctx, issue)
+	var storedIssue *model.Issue
+	removed := false
+	if oldIssue != nil {
+		storedIssue, removed, _ = s.db.GetStoredIssue(key)
+	}
+	diff := model.NewIssueDiff(storedIssue, issue)
+	if storedIssue != nil && removed {
+		// The issue is back, which is a change of its own and not a new issue
+		diff.Changes = append(diff.Changes, model.FieldChange{Field: "state", OldValue: "removed", NewValue: "present"})
+	}
+	err = s.db.UpdateIssue(ctx, issue, diff.Changes)
+	s.cache.Remove(key)
 	if err != nil {
-		return issue, err
+		return issue, nil, err
 	}
-	return issue, nil
+	return issue, diff, nil
 }
 
 func (s *IssueService) fetchIssue(ctx context.Context, key string) (*model.Issue, error) {
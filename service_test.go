@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"mojira/api"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Counts the legacy issue requests to the fake tracker and holds the first one until released
+type heldTracker struct {
+	handler http.Handler
+	fetches atomic.Int32
+	held    chan struct{}
+	release chan struct{}
+}
+
+func holdLegacyFetches(t *testing.T, service *IssueService, handler http.Handler) *heldTracker {
+	held := &heldTracker{handler: handler, held: make(chan struct{}), release: make(chan struct{})}
+	server := httptest.NewServer(held)
+	t.Cleanup(server.Close)
+	service.legacy = api.NewLegacyClient(server.URL)
+	return held
+}
+
+func (h *heldTracker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if strings.HasPrefix(r.URL.Path, "/rest/api/2/issue/") && h.fetches.Add(1) == 1 {
+		close(h.held)
+		<-h.release
+	}
+	h.handler.ServeHTTP(w, r)
+}
+
+// Waits until the first fetch is held and the other callers had time to join it, then lets it finish
+func (h *heldTracker) releaseAfterJoin() {
+	<-h.held
+	time.Sleep(50 * time.Millisecond)
+	close(h.release)
+}
+
+func TestGetIssueSharesFetch(t *testing.T) {
+	ctx := context.Background()
+	service, tracker := newFakeTrackerService(t, NewMemoryStore())
+	held := holdLegacyFetches(t, service, tracker)
+
+	issues := make([]any, 5)
+	var wg sync.WaitGroup
+	for i := range issues {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			issue, err := service.GetIssue(ctx, "MC-4")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			issues[i] = issue
+		}()
+	}
+	held.releaseAfterJoin()
+	wg.Wait()
+
+	if n := held.fetches.Load(); n != 1 {
+		t.Errorf("expected concurrent gets to share 1 fetch, got %d", n)
+	}
+	for _, issue := range issues {
+		if issue != issues[0] {
+			t.Error("expected every caller to get the fetched issue")
+		}
+	}
+}
+
+func TestRefreshIssueSharesFetch(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	service, tracker := newFakeTrackerService(t, store)
+	if _, _, err := service.RefreshIssue(ctx, "MC-4"); err != nil {
+		t.Fatal(err)
+	}
+	synced := time.Now().Add(-time.Hour)
+	store.issues["MC-4"].issue.SyncedDate = &synced
+	held := holdLegacyFetches(t, service, tracker)
+
+	var diffs atomic.Int32
+	var wg sync.WaitGroup
+	for range 5 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			issue, diff, err := service.RefreshIssue(ctx, "MC-4")
+			if err != nil || issue == nil {
+				t.Errorf("expected MC-4 to be refreshed, got %v", err)
+			}
+			if diff != nil {
+				diffs.Add(1)
+			}
+		}()
+	}
+	held.releaseAfterJoin()
+	wg.Wait()
+
+	if n := held.fetches.Load(); n != 1 {
+		t.Errorf("expected concurrent refreshes to share 1 fetch, got %d", n)
+	}
+	if n := diffs.Load(); n != 1 {
+		t.Errorf("expected only the refreshing caller to get the diff, got %d diffs", n)
+	}
+}
+
+func TestGetIssueDoesNotJoinRefresh(t *testing.T) {
+	ctx := context.Background()
+	service, tracker := newFakeTrackerService(t, NewMemoryStore())
+	held := holdLegacyFetches(t, service, tracker)
+
+	refreshed := make(chan error)
+	go func() {
+		_, _, err := service.RefreshIssue(ctx, "MC-4")
+		refreshed <- err
+	}()
+	<-held.held
+	// The refresh is held, a get of the same issue fetches it on its own
+	if _, err := service.GetIssue(ctx, "MC-4"); err != nil {
+		t.Fatal(err)
+	}
+	close(held.release)
+	if err := <-refreshed; err != nil {
+		t.Fatal(err)
+	}
+	if n := held.fetches.Load(); n != 2 {
+		t.Errorf("expected a get and a refresh not to share a fetch, got %d fetches", n)
+	}
+}
+
+func TestRefreshRestoredIssue(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	service, _ := newFakeTrackerService(t, store)
+	if _, _, err := service.RefreshIssue(ctx, "MC-4"); err != nil {
+		t.Fatal(err)
+	}
+	synced := time.Now().Add(-time.Hour)
+	store.issues["MC-4"].issue.SyncedDate = &synced
+	store.issues["MC-4"].issue.ConfirmationStatus = "Unconfirmed"
+	store.MarkIssueRemoved("MC-4")
+
+	issue, diff, err := service.RefreshIssue(ctx, "MC-4")
+	if err != nil || issue == nil || diff == nil {
+		t.Fatalf("expected MC-4 to be refreshed, got %v", err)
+	}
+	if diff.Old == nil {
+		t.Fatal("expected the removed issue to be compared with the fetched one")
+	}
+	if c := diff.Change("state"); c == nil || c.OldValue != "removed" || c.NewValue != "present" {
+		t.Errorf("expected a state change, got %+v", diff.Changes)
+	}
+	if diff.Change("confirmation_status") == nil {
+		t.Errorf("expected the fields to be compared with the removed issue, got %+v", diff.Changes)
+	}
+	if title := notificationChanges(diff); title == "New issue" {
+		t.Error("expected a restored issue not to be announced as new")
+	}
+
+	if _, err := store.GetIssueByKey("MC-4"); err != nil {
+		t.Errorf("expected MC-4 to be present again, got %v", err)
+	}
+	history, _ := store.GetIssueHistory(ctx, "MC-4")
+	if len(history) != len(diff.Changes) {
+		t.Errorf("expected the changes to be recorded, got %+v", history)
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"log"
+	"mojira/api"
+	"os"
+	"path/filepath"
+)
+
+// Issues served by the fake tracker. Next to the API responses, an issue can have an expected.json with the issue the mirror should make of them, which TestFetchIssueFixtures checks
+const fakeTrackerFixtures = "faketracker/fixtures"
+
+// Records the responses of each API for an issue to a cassette, which can then be added to the replayed cases
+func recordCassettes(key string) error {
+	legacy := api.NewLegacyClient(cmp.Or(os.Getenv("LEGACY_API_URL"), api.DefaultLegacyURL))
+	public := api.NewPublicClient(cmp.Or(os.Getenv("PUBLIC_API_URL"), api.DefaultPublicURL))
+	serviceDesk := api.NewServiceDeskClient(cmp.Or(os.Getenv("SERVICEDESK_URL"), api.DefaultServiceDeskURL))
+	recorders := map[string]*api.Recorder{"legacy": api.NewRecorder(nil), "public": api.NewRecorder(nil), "servicedesk": api.NewRecorder(nil)}
+	legacy.SetTransport(recorders["legacy"])
+	public.SetTransport(recorders["public"])
+	serviceDesk.SetTransport(recorders["servicedesk"])
+
+	ctx := context.Background()
+	var errs []error
+	if _, err := legacy.GetIssue(ctx, key); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := public.GetIssue(ctx, key); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := serviceDesk.GetIssue(ctx, key); err != nil {
+		errs = append(errs, err)
+	}
+	for source, recorder := range recorders {
+		path := filepath.Join(api.CassetteDir, source+"-"+key+".json")
+		if err := recorder.Cassette().Save(path); err != nil {
+			return errors.New("failed to save cassette: " + err.Error())
+		}
+		log.Printf("Recorded %s", path)
+	}
+	// Failed requests are recorded too, they are worth replaying
+	for _, err := range errs {
+		log.Printf("Recorded an error: %v", err)
+	}
+	return nil
+}
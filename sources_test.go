@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"mojira/api"
+	"mojira/faketracker"
+	"mojira/model"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type sourceCheckResult struct {
+	Partial  bool        `json:"partial"`
+	Issue    V1Issue     `json:"issue"`
+	Comments []V1Comment `json:"comments"`
+}
+
+// The sync date and comment urls depend on when and where the test runs, so they are left out
+func newSourceCheckResult(issue *model.Issue) sourceCheckResult {
+	result := sourceCheckResult{Partial: issue.Partial, Issue: newV1Issue(issue), Comments: []V1Comment{}}
+	result.Issue.SyncedDate = nil
+	for i := range issue.Comments {
+		comment := newV1Comment(&issue.Comments[i], "adf")
+		comment.Url = ""
+		result.Comments = append(result.Comments, comment)
+	}
+	return result
+}
+
+// Builds a service that syncs from the fake tracker fixtures into the given store
+func newFakeTrackerService(t *testing.T, store Store) (*IssueService, *faketracker.Server) {
+	t.Helper()
+	tracker := faketracker.NewServer(os.DirFS(fakeTrackerFixtures))
+	server := httptest.NewServer(tracker)
+	t.Cleanup(server.Close)
+
+	service := &IssueService{
+		db:           store,
+		legacy:       api.NewLegacyClient(server.URL),
+		public:       api.NewPublicClient(server.URL),
+		serviceDesk:  api.NewServiceDeskClient(server.URL),
+		redactedKeys: make(map[string]struct{}),
+		cache:        newIssueCache(0),
+	}
+	return service, tracker
+}
+
+func TestFetchIssueFixtures(t *testing.T) {
+	service, tracker := newFakeTrackerService(t, nil)
+	ctx := context.Background()
+
+	keys, err := service.serviceDesk.GetUpdatedIssues(ctx)
+	if err != nil {
+		t.Fatalf("failed to list fixture issues: %v", err)
+	}
+	if len(keys) == 0 {
+		t.Fatalf("no fixture issues in %s", fakeTrackerFixtures)
+	}
+	for i, key := range keys {
+		t.Run(key, func(t *testing.T) {
+			// Every other issue is fetched after the session expired, which has to log in again
+			if i%2 == 1 {
+				tracker.ExpireSessions()
+			}
+			raw, err := os.ReadFile(filepath.Join(fakeTrackerFixtures, key, "expected.json"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var expected sourceCheckResult
+			if err := json.Unmarshal(raw, &expected); err != nil {
+				t.Fatalf("invalid expected.json: %v", err)
+			}
+			issue, err := service.fetchIssue(ctx, key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want, _ := json.MarshalIndent(expected, "", "  ")
+			got, _ := json.MarshalIndent(newSourceCheckResult(issue), "", "  ")
+			if !bytes.Equal(want, got) {
+				t.Errorf("merged issue doesn't match expected.json, got:\n%s", got)
+			}
+		})
+	}
+
+	t.Run("unknown", func(t *testing.T) {
+		if _, err := service.fetchIssue(ctx, "MC-1"); !errors.Is(err, model.ErrIssueNotFound) {
+			t.Errorf("expected an unknown issue to be not found, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,1258 @@
+//go:build sqlite_fts5
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"mojira/jql"
+	"mojira/model"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// Applied every time the database is opened, every statement in it can run again
+const sqliteSchema = "migrations/sqlite/schema.sql"
+
+// Store for small mirrors that keeps everything in a single SQLite file, see migrations/sqlite/schema.sql for how it differs from Postgres
+type SQLiteStore struct {
+	db *sql.DB
+}
+
+var _ Store = (*SQLiteStore)(nil)
+
+func NewSQLiteStore(path string) (Store, error) {
+	log.Printf("Opening SQLite database %s...", path)
+	// Transactions take the write lock right away, so two of them never wait on each other to upgrade
+	db, err := sql.Open("sqlite3", "file:"+path+"?_foreign_keys=on&_busy_timeout=10000&_journal_mode=WAL&_txlock=immediate")
+	if err != nil {
+		return nil, err
+	}
+	schema, err := os.ReadFile(sqliteSchema)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.Exec(string(schema)); err != nil {
+		return nil, errors.New("failed to apply SQLite schema: " + err.Error())
+	}
+	return &SQLiteStore{db: db}, nil
+}
+
+// Times are stored as text, which only sorts in order when every time is in UTC
+func sqliteTime(t *time.Time) any {
+	if t == nil {
+		return nil
+	}
+	return t.UTC()
+}
+
+// Arrays are stored as JSON, a nil array as NULL
+func sqliteArray(values []string) any {
+	if values == nil {
+		return nil
+	}
+	data, _ := json.Marshal(values)
+	return string(data)
+}
+
+type sqliteArrayScanner struct {
+	dest *[]string
+}
+
+func (s sqliteArrayScanner) Scan(src any) error {
+	switch v := src.(type) {
+	case nil:
+		*s.dest = nil
+		return nil
+	case string:
+		return json.Unmarshal([]byte(v), s.dest)
+	case []byte:
+		return json.Unmarshal(v, s.dest)
+	}
+	return fmt.Errorf("cannot scan %T into an array", src)
+}
+
+func sqliteNow() time.Time {
+	return time.Now().UTC()
+}
+
+func (s *SQLiteStore) SearchIssues(text string, limit int) ([]model.Issue, error) {
+	// Disallow queries starting with "-" for performance reasons
+	if strings.HasPrefix(strings.TrimSpace(text), "-") {
+		return []model.Issue{}, nil
+	}
+	query := jql.FTSQuery(text)
+	if query == "" {
+		return []model.Issue{}, nil
+	}
+	// The text column starts with the summary, so searching it covers both
+	rows, err := s.db.Query(`SELECT key, summary, created_date FROM issue
+		WHERE state = 'present' AND id IN (SELECT rowid FROM issue_fts WHERE issue_fts MATCH ?1)
+		ORDER BY created_date DESC
+		LIMIT ?2`, query, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var issues []model.Issue
+	for rows.Next() {
+		var issue model.Issue
+		if err := rows.Scan(&issue.Key, &issue.Summary, &issue.CreatedDate); err != nil {
+			return nil, err
+		}
+		issues = append(issues, issue)
+	}
+	return issues, nil
+}
+
+// Same as issueFilterWhere, ?1 is the search converted with jql.FTSQuery
+const sqliteIssueFilterWhere = `state = 'present' AND (?2 = '' OR project = ?2) AND (?3 = '' OR status = ?3) AND (?4 = '' OR confirmation_status = ?4) AND (?5 = '' OR resolution = ?5 OR (resolution = '' AND ?5 = 'Unresolved')) AND (?6 = '' OR mojang_priority = ?6) AND (?7 = '' OR LOWER(reporter_name) = LOWER(?7)) AND (?8 = '' OR LOWER(assignee_name) = LOWER(?8)) AND (?9 = '' OR EXISTS (SELECT 1 FROM json_each(affected_versions) WHERE value = ?9)) AND (?10 = '' OR EXISTS (SELECT 1 FROM json_each(fix_versions) WHERE value = ?10)) AND (?11 = '' OR EXISTS (SELECT 1 FROM json_each(category) WHERE value = ?11)) AND (?12 = '' OR EXISTS (SELECT 1 FROM json_each(labels) WHERE value = ?12)) AND (?13 = '' OR EXISTS (SELECT 1 FROM json_each(components) WHERE value = ?13)) AND (?14 = '' OR platform = ?14) AND (?15 = '' OR area = ?15) AND (?1 = '' OR id IN (SELECT rowid FROM issue_fts WHERE issue_fts MATCH ?1))`
+
+// Missing created dates sort first, like '-infinity' does in Postgres
+var sqliteCreatedSortColumn = sortColumn{`COALESCE(created_date, '')`, "text"}
+
+// Same sorts as issueSorts
+var sqliteIssueSorts = map[string]issueSort{
+	"Created":    {columns: []sortColumn{sqliteCreatedSortColumn, keySortColumn}},
+	"Updated":    {columns: []sortColumn{{`updated_date`, "text"}, keySortColumn}, filter: ` AND (updated_date IS NOT NULL)`},
+	"Resolved":   {columns: []sortColumn{{`resolved_date`, "text"}, keySortColumn}, filter: ` AND (resolved_date IS NOT NULL)`},
+	"Priority":   {columns: []sortColumn{{`mojang_priority_rank`, "int"}, sqliteCreatedSortColumn, keySortColumn}},
+	"Votes":      {columns: []sortColumn{{`total_votes`, "int"}, sqliteCreatedSortColumn, keySortColumn}},
+	"Comments":   {columns: []sortColumn{{`comment_count`, "int"}, sqliteCreatedSortColumn, keySortColumn}},
+	"Duplicates": {columns: []sortColumn{{`duplicate_count`, "int"}, sqliteCreatedSortColumn, keySortColumn}},
+}
+
+func (s *SQLiteStore) FilterIssues(filter IssueFilter, cursor *IssueCursor, limit int) (*IssuePage, error) {
+	page := &IssuePage{Issues: []model.Issue{}}
+	// Disallow queries starting with "-" for performance reasons
+	if strings.HasPrefix(strings.TrimSpace(filter.Search), "-") {
+		return page, nil
+	}
+	filterArgs := filter.args()
+	if filter.Search != "" {
+		filterArgs[0] = jql.FTSQuery(filter.Search)
+		if filterArgs[0] == "" {
+			return page, nil
+		}
+	}
+	sortName := filter.Sort
+	sort, ok := sqliteIssueSorts[sortName]
+	if !ok {
+		sortName = "Created"
+		sort = sqliteIssueSorts[sortName]
+	}
+	if cursor == nil {
+		cursor = &IssueCursor{}
+	}
+	filterStr := sort.filter
+	sortStr := ``
+	var queryArgs []any
+	if filter.Query != "" {
+		q, err := jql.Parse(filter.Query)
+		if err != nil {
+			return nil, err
+		}
+		var where string
+		where, queryArgs = q.DialectSQL(jql.SQLite, 16)
+		filterStr += ` AND ` + where
+		sortStr = q.OrderSQL()
+	}
+	keyset := sortStr == ``
+
+	args := append(filterArgs, queryArgs...)
+	pageStr := ``
+	offset := cursor.Offset
+	if len(cursor.Values) > 0 {
+		if !keyset || cursor.Sort != sortName || len(cursor.Values) != len(sort.columns) {
+			return nil, ErrInvalidCursor
+		}
+		var exprs, params []string
+		for i, col := range sort.columns {
+			args = append(args, cursor.Values[i])
+			exprs = append(exprs, col.expr)
+			if col.typ == "int" {
+				params = append(params, fmt.Sprintf("CAST(?%d AS INTEGER)", len(args)))
+			} else {
+				params = append(params, fmt.Sprintf("?%d", len(args)))
+			}
+		}
+		op := "<"
+		if cursor.Before {
+			op = ">"
+		}
+		pageStr = fmt.Sprintf(` AND (%s) %s (%s)`, strings.Join(exprs, ", "), op, strings.Join(params, ", "))
+		offset = 0
+	}
+	valuesStr := ``
+	if keyset {
+		direction := " DESC"
+		if cursor.Before {
+			direction = " ASC"
+		}
+		var orders []string
+		for _, col := range sort.columns {
+			orders = append(orders, col.expr+direction)
+			valuesStr += `, CAST(` + col.expr + ` AS TEXT)`
+		}
+		sortStr = strings.Join(orders, ", ")
+	}
+	// Fetch one extra row to know whether there is another page
+	args = append(args, offset, limit+1)
+	pagination := fmt.Sprintf(` LIMIT ?%d OFFSET ?%d`, len(args), len(args)-1)
+	rows, err := s.db.Query(`SELECT key, summary, `+filter.descriptionColumn()+`, status, resolution, confirmation_status, reporter_avatar, reporter_name, assignee_avatar, assignee_name, created_date, updated_date, resolved_date, labels, affected_versions, fix_versions, category, components, mojang_priority, area, platform, votes, legacy_votes`+valuesStr+` FROM issue WHERE `+sqliteIssueFilterWhere+filterStr+pageStr+` ORDER BY `+sortStr+pagination, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sortValues [][]string
+	for rows.Next() {
+		var issue model.Issue
+		values := make([]string, len(sort.columns))
+		dest := []any{&issue.Key, &issue.Summary, &issue.Description, &issue.Status, &issue.Resolution, &issue.ConfirmationStatus, &issue.ReporterAvatar, &issue.ReporterName, &issue.AssigneeAvatar, &issue.AssigneeName, &issue.CreatedDate, &issue.UpdatedDate, &issue.ResolvedDate, sqliteArrayScanner{&issue.Labels}, sqliteArrayScanner{&issue.AffectedVersions}, sqliteArrayScanner{&issue.FixVersions}, sqliteArrayScanner{&issue.Category}, sqliteArrayScanner{&issue.Components}, &issue.MojangPriority, &issue.Area, &issue.Platform, &issue.Votes, &issue.LegacyVotes}
+		if keyset {
+			for i := range values {
+				dest = append(dest, &values[i])
+			}
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		page.Issues = append(page.Issues, issue)
+		sortValues = append(sortValues, values)
+	}
+	hasMore := len(page.Issues) > limit
+	if hasMore {
+		page.Issues = page.Issues[:limit]
+		sortValues = sortValues[:limit]
+	}
+
+	if keyset && len(page.Issues) > 0 {
+		if cursor.Before {
+			slices.Reverse(page.Issues)
+			slices.Reverse(sortValues)
+		}
+		first := &IssueCursor{Sort: sortName, Values: sortValues[0], Before: true}
+		last := &IssueCursor{Sort: sortName, Values: sortValues[len(sortValues)-1]}
+		if cursor.Before {
+			page.Next = last
+			if hasMore {
+				page.Prev = first
+			}
+		} else {
+			if hasMore {
+				page.Next = last
+			}
+			if len(cursor.Values) > 0 || cursor.Offset > 0 {
+				page.Prev = first
+			}
+		}
+	} else if !keyset {
+		if hasMore {
+			page.Next = &IssueCursor{Offset: cursor.Offset + limit}
+		}
+		if cursor.Offset > 0 {
+			page.Prev = &IssueCursor{Offset: max(cursor.Offset-limit, 0)}
+		}
+	}
+
+	if filter.Search == "" && filter.Query == "" && filter.Priority == "" && filter.Reporter == "" && filter.Assignee == "" && filter.AffectedVersion == "" && filter.FixVersion == "" && filter.Category == "" && filter.Label == "" && filter.Component == "" && filter.Platform == "" && filter.Area == "" {
+		countRow := s.db.QueryRow(`SELECT COALESCE(SUM(count), 0) FROM issue_count WHERE (?1 = '' OR project = ?1) AND (?2 = '' OR status = ?2) AND (?3 = '' OR confirmation_status = ?3) AND (?4 = '' OR resolution = ?4 OR (resolution = '' AND ?4 = 'Unresolved'))`, filter.Project, filter.Status, filter.Confirmation, filter.Resolution)
+		err = countRow.Scan(&page.Count)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		countRow := s.db.QueryRow(`SELECT COUNT(*) FROM issue WHERE `+sqliteIssueFilterWhere+filterStr, append(filterArgs, queryArgs...)...)
+		err = countRow.Scan(&page.Count)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return page, nil
+}
+
+func (s *SQLiteStore) GetIssueByReporter(reporter string, limit int) ([]model.Issue, error) {
+	rows, err := s.db.Query(`SELECT key, summary, status, resolution, confirmation_status, reporter_avatar, reporter_name, created_date FROM issue WHERE state = 'present' AND LOWER(reporter_name) = LOWER(?1) ORDER BY created_date DESC LIMIT ?2`, reporter, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var issues []model.Issue
+	for rows.Next() {
+		var issue model.Issue
+		if err := rows.Scan(&issue.Key, &issue.Summary, &issue.Status, &issue.Resolution, &issue.ConfirmationStatus, &issue.ReporterAvatar, &issue.ReporterName, &issue.CreatedDate); err != nil {
+			return nil, err
+		}
+		issues = append(issues, issue)
+	}
+	return issues, nil
+}
+
+func (s *SQLiteStore) GetIssueByAssignee(assignee string, limit int) ([]model.Issue, error) {
+	rows, err := s.db.Query(`SELECT key, summary, status, resolution, confirmation_status, assignee_avatar, assignee_name, created_date FROM issue WHERE state = 'present' AND LOWER(assignee_name) = LOWER(?1) ORDER BY created_date DESC LIMIT ?2`, assignee, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var issues []model.Issue
+	for rows.Next() {
+		var issue model.Issue
+		if err := rows.Scan(&issue.Key, &issue.Summary, &issue.Status, &issue.Resolution, &issue.ConfirmationStatus, &issue.AssigneeAvatar, &issue.AssigneeName, &issue.CreatedDate); err != nil {
+			return nil, err
+		}
+		issues = append(issues, issue)
+	}
+	return issues, nil
+}
+
+func (s *SQLiteStore) GetIssueForSync(key string) (*model.Issue, error) {
+	row := s.db.QueryRow("SELECT synced_date FROM issue WHERE key = ?1", key)
+	var issue model.Issue
+	issue.Key = key
+	err := row.Scan(&issue.SyncedDate)
+	if err != nil {
+		return nil, err
+	}
+	return &issue, nil
+}
+
+func (s *SQLiteStore) GetIssueSyncedDate(key string) (*time.Time, error) {
+	row := s.db.QueryRow("SELECT synced_date FROM issue WHERE key = ?1 AND state = 'present'", key)
+	var syncedDate *time.Time
+	err := row.Scan(&syncedDate)
+	if err != nil {
+		return nil, err
+	}
+	return syncedDate, nil
+}
+
+func (s *SQLiteStore) GetIssueByKey(key string) (*model.Issue, error) {
+	issue, removed, err := s.GetStoredIssue(key)
+	if removed {
+		return nil, model.ErrIssueRemoved
+	}
+	return issue, err
+}
+
+// Loads an issue whether or not it was removed, for comparing it with the fetched issue
+func (s *SQLiteStore) GetStoredIssue(key string) (*model.Issue, bool, error) {
+	row := s.db.QueryRow("SELECT summary, creator_name, creator_avatar, reporter_name, reporter_avatar, assignee_name, assignee_avatar, description, environment, labels, created_date, updated_date, resolved_date, status, confirmation_status, resolution, affected_versions, fix_versions, category, mojang_priority, area, components, ado, platform, os_version, realms_platform, votes, legacy_votes, synced_date, state FROM issue WHERE key = ?1", key)
+	var state string
+	var issue model.Issue
+	issue.Key = key
+	err := row.Scan(&issue.Summary, &issue.CreatorName, &issue.CreatorAvatar, &issue.ReporterName, &issue.ReporterAvatar, &issue.AssigneeName, &issue.AssigneeAvatar, &issue.Description, &issue.Environment, sqliteArrayScanner{&issue.Labels}, &issue.CreatedDate, &issue.UpdatedDate, &issue.ResolvedDate, &issue.Status, &issue.ConfirmationStatus, &issue.Resolution, sqliteArrayScanner{&issue.AffectedVersions}, sqliteArrayScanner{&issue.FixVersions}, sqliteArrayScanner{&issue.Category}, &issue.MojangPriority, &issue.Area, sqliteArrayScanner{&issue.Components}, &issue.ADO, &issue.Platform, &issue.OSVersion, &issue.RealmsPlatform, &issue.Votes, &issue.LegacyVotes, &issue.SyncedDate, &state)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, false, model.ErrIssueNotStored
+		}
+		return nil, false, err
+	}
+	comments := []model.Comment{}
+	rows, err := s.db.Query(`SELECT comment_id, legacy_id, date, author_name, author_avatar, adf_comment FROM comment WHERE issue_key = ?1 ORDER BY date ASC`, key)
+	if err == nil {
+		defer rows.Close()
+		for rows.Next() {
+			var cmt model.Comment
+			err := rows.Scan(&cmt.Id, &cmt.LegacyId, &cmt.Date, &cmt.AuthorName, &cmt.AuthorAvatar, &cmt.AdfComment)
+			if err != nil {
+				return nil, false, err
+			}
+			cmt.Issue = &issue
+			comments = append(comments, cmt)
+		}
+	}
+	issue.Comments = comments
+	links := []model.IssueLink{}
+	rows, err = s.db.Query(`SELECT type, other_key, other_summary, other_status FROM issue_link WHERE issue_key = ?1`, key)
+	if err == nil {
+		defer rows.Close()
+		for rows.Next() {
+			var l model.IssueLink
+			err := rows.Scan(&l.Type, &l.OtherKey, &l.OtherSummary, &l.OtherStatus)
+			if err != nil {
+				return nil, false, err
+			}
+			links = append(links, l)
+		}
+	}
+	issue.Links = links
+	attachments := []model.Attachment{}
+	rows, err = s.db.Query(`SELECT attachment_id, filename, author_name, author_avatar, created_date, size, mime_type FROM attachment WHERE issue_key = ?1`, key)
+	if err == nil {
+		defer rows.Close()
+		for rows.Next() {
+			var a model.Attachment
+			err := rows.Scan(&a.Id, &a.Filename, &a.AuthorName, &a.AuthorAvatar, &a.CreatedDate, &a.Size, &a.MimeType)
+			if err != nil {
+				return nil, false, err
+			}
+			attachments = append(attachments, a)
+		}
+	}
+	issue.Attachments = attachments
+	return &issue, state == "removed", nil
+}
+
+func (s *SQLiteStore) GetIssuesByKeys(ctx context.Context, keys []string) ([]model.Issue, []string, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT key, summary, creator_name, creator_avatar, reporter_name, reporter_avatar, assignee_name, assignee_avatar, description, environment, labels, created_date, updated_date, resolved_date, status, confirmation_status, resolution, affected_versions, fix_versions, category, mojang_priority, area, components, ado, platform, os_version, realms_platform, votes, legacy_votes, synced_date, state FROM issue WHERE key IN (SELECT value FROM json_each(?1)) ORDER BY key_num", sqliteArray(keys))
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+	issues := []model.Issue{}
+	removed := []string{}
+	for rows.Next() {
+		var state string
+		var issue model.Issue
+		err := rows.Scan(&issue.Key, &issue.Summary, &issue.CreatorName, &issue.CreatorAvatar, &issue.ReporterName, &issue.ReporterAvatar, &issue.AssigneeName, &issue.AssigneeAvatar, &issue.Description, &issue.Environment, sqliteArrayScanner{&issue.Labels}, &issue.CreatedDate, &issue.UpdatedDate, &issue.ResolvedDate, &issue.Status, &issue.ConfirmationStatus, &issue.Resolution, sqliteArrayScanner{&issue.AffectedVersions}, sqliteArrayScanner{&issue.FixVersions}, sqliteArrayScanner{&issue.Category}, &issue.MojangPriority, &issue.Area, sqliteArrayScanner{&issue.Components}, &issue.ADO, &issue.Platform, &issue.OSVersion, &issue.RealmsPlatform, &issue.Votes, &issue.LegacyVotes, &issue.SyncedDate, &state)
+		if err != nil {
+			return nil, nil, err
+		}
+		if state == "removed" {
+			removed = append(removed, issue.Key)
+			continue
+		}
+		issues = append(issues, issue)
+	}
+	return issues, removed, rows.Err()
+}
+
+func (s *SQLiteStore) GetCommentsByUser(name string, offset int, limit int) ([]model.Comment, error) {
+	comments := []model.Comment{}
+	query := `SELECT c.issue_key, c.comment_id, c.legacy_id, c.date, c.author_name, c.author_avatar, c.adf_comment
+		FROM comment c
+		JOIN issue i ON c.issue_key = i.key
+		WHERE LOWER(c.author_name) = LOWER(?1) AND i.state = 'present'
+		ORDER BY c.date DESC
+		LIMIT ?3 OFFSET ?2;`
+	rows, err := s.db.Query(query, name, offset, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var cmt model.Comment
+		var issueKey string
+		err := rows.Scan(&issueKey, &cmt.Id, &cmt.LegacyId, &cmt.Date, &cmt.AuthorName, &cmt.AuthorAvatar, &cmt.AdfComment)
+		if err != nil {
+			return nil, err
+		}
+		cmt.Issue = &model.Issue{Key: issueKey}
+		comments = append(comments, cmt)
+	}
+	return comments, nil
+}
+
+func (s *SQLiteStore) UpdateIssue(ctx context.Context, issue *model.Issue, changes []model.FieldChange) error {
+	if issue.Partial {
+		return errors.New("tried to insert a partial issue")
+	}
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := s.updateIssueImpl(tx, issue); err != nil {
+		tx.Rollback()
+		return err
+	}
+	now := sqliteNow()
+	for _, change := range changes {
+		_, err = tx.Exec(`INSERT INTO issue_history (issue_key, field, old_value, new_value, changed_date) VALUES (?1, ?2, ?3, ?4, ?5)`, issue.Key, change.Field, change.OldValue, change.NewValue, now)
+		if err != nil {
+			tx.Rollback()
+			return errors.New("failed to insert issue_history: " + err.Error())
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *SQLiteStore) updateIssueImpl(tx *sql.Tx, issue *model.Issue) error {
+	_, err := tx.Exec(`INSERT INTO issue (key, creator_name, creator_avatar, synced_date, state) VALUES (?1, '', '', ?2, 'present') ON CONFLICT DO NOTHING`, issue.Key, sqliteNow())
+	if err != nil {
+		return err
+	}
+	query := `UPDATE issue SET summary = ?2, creator_name = ?3, creator_avatar = ?4, reporter_name = ?5, reporter_avatar = ?6, assignee_name = ?7, assignee_avatar = ?8, description = ?9, environment = ?10, labels = ?11, created_date = ?12, updated_date = ?13, resolved_date = ?14, status = ?15, confirmation_status = ?16, resolution = ?17, affected_versions = ?18, fix_versions = ?19, category = ?20, mojang_priority = ?21, area = ?22, components = ?23, ado = ?24, platform = ?25, os_version = ?26, realms_platform = ?27, votes = ?28, legacy_votes = ?29, text = ?30, comment_count = ?31, duplicate_count = ?32, synced_date = ?33, state = 'present' WHERE key = ?1`
+	_, err = tx.Exec(query, issue.Key, issue.Summary, issue.CreatorName, issue.CreatorAvatar, issue.ReporterName, issue.ReporterAvatar, issue.AssigneeName, issue.AssigneeAvatar, issue.Description, issue.Environment, sqliteArray(issue.Labels), sqliteTime(issue.CreatedDate), sqliteTime(issue.UpdatedDate), sqliteTime(issue.ResolvedDate), issue.Status, issue.ConfirmationStatus, issue.Resolution, sqliteArray(issue.AffectedVersions), sqliteArray(issue.FixVersions), sqliteArray(issue.Category), issue.MojangPriority, issue.Area, sqliteArray(issue.Components), issue.ADO, issue.Platform, issue.OSVersion, issue.RealmsPlatform, issue.Votes, issue.LegacyVotes, searchText(issue), len(issue.Comments), duplicateCount(issue), sqliteTime(issue.SyncedDate))
+	if err != nil {
+		return errors.New("failed to update issue: " + err.Error())
+	}
+
+	_, err = tx.Exec(`DELETE FROM comment WHERE issue_key = ?1`, issue.Key)
+	if err != nil {
+		return errors.New("failed to delete comments: " + err.Error())
+	}
+	for _, cmt := range issue.Comments {
+		_, err = tx.Exec(`INSERT INTO comment (issue_key, comment_id, legacy_id, date, author_name, author_avatar, adf_comment) VALUES (?1, ?2, ?3, ?4, ?5, ?6, ?7)`, issue.Key, cmt.Id, cmt.LegacyId, sqliteTime(cmt.Date), cmt.AuthorName, cmt.AuthorAvatar, cmt.AdfComment)
+		if err != nil {
+			return errors.New("failed to insert comment: " + err.Error())
+		}
+	}
+	_, err = tx.Exec(`DELETE FROM issue_link WHERE issue_key = ?1`, issue.Key)
+	if err != nil {
+		return errors.New("failed to delete issue links: " + err.Error())
+	}
+	for _, l := range issue.Links {
+		_, err = tx.Exec(`INSERT INTO issue_link (issue_key, type, other_key, other_summary, other_status) VALUES (?1, ?2, ?3, ?4, ?5)`, issue.Key, l.Type, l.OtherKey, l.OtherSummary, l.OtherStatus)
+		if err != nil {
+			return errors.New("failed to insert issue_link: " + err.Error())
+		}
+	}
+	_, err = tx.Exec(`DELETE FROM attachment WHERE issue_key = ?1`, issue.Key)
+	if err != nil {
+		return errors.New("failed to delete attachments: " + err.Error())
+	}
+	for _, a := range issue.Attachments {
+		_, err = tx.Exec(`INSERT INTO attachment (issue_key, attachment_id, filename, author_name, author_avatar, created_date, size, mime_type) VALUES (?1, ?2, ?3, ?4, ?5, ?6, ?7, ?8)`, issue.Key, a.Id, a.Filename, a.AuthorName, a.AuthorAvatar, sqliteTime(a.CreatedDate), a.Size, a.MimeType)
+		if err != nil {
+			return errors.New("failed to insert attachment: " + err.Error())
+		}
+	}
+	return nil
+}
+
+func (s *SQLiteStore) GetIssueHistory(ctx context.Context, key string) ([]model.FieldChange, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT field, old_value, new_value, changed_date FROM issue_history WHERE issue_key = ?1 ORDER BY changed_date DESC, id DESC`, key)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	history := []model.FieldChange{}
+	for rows.Next() {
+		var change model.FieldChange
+		if err := rows.Scan(&change.Field, &change.OldValue, &change.NewValue, &change.Date); err != nil {
+			return nil, err
+		}
+		history = append(history, change)
+	}
+	return history, nil
+}
+
+func (s *SQLiteStore) InsertActivity(ctx context.Context, events []model.ActivityEvent) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	now := sqliteNow()
+	for _, e := range events {
+		_, err = tx.ExecContext(ctx, `INSERT INTO activity (issue_key, type, summary, old_value, new_value, author_name, anchor, versions, date) VALUES (?1, ?2, ?3, ?4, ?5, ?6, ?7, COALESCE(?8, '[]'), COALESCE(?9, ?10))`, e.IssueKey, e.Type, e.Summary, e.OldValue, e.NewValue, e.AuthorName, e.Anchor, sqliteArray(e.Versions), sqliteTime(e.Date), now)
+		if err != nil {
+			return errors.New("failed to insert activity: " + err.Error())
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *SQLiteStore) GetActivity(ctx context.Context, project string, typ string, version string, before int, limit int) ([]model.ActivityEvent, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT id, issue_key, type, summary, old_value, new_value, author_name, anchor, versions, date FROM activity WHERE (?1 = '' OR project = ?1) AND (?2 = '' OR type = ?2) AND (?3 = '' OR EXISTS (SELECT 1 FROM json_each(versions) WHERE value = ?3)) AND (?4 = 0 OR id < ?4) ORDER BY id DESC LIMIT ?5`, project, typ, version, before, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	events := []model.ActivityEvent{}
+	for rows.Next() {
+		var e model.ActivityEvent
+		if err := rows.Scan(&e.Id, &e.IssueKey, &e.Type, &e.Summary, &e.OldValue, &e.NewValue, &e.AuthorName, &e.Anchor, sqliteArrayScanner{&e.Versions}, &e.Date); err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+	return events, nil
+}
+
+func (s *SQLiteStore) MarkIssueRemoved(key string) error {
+	query := `UPDATE issue SET state = 'removed' WHERE key = ?1`
+	_, err := s.db.Exec(query, key)
+	if err != nil {
+		return errors.New("failed to mark issue as removed: " + err.Error())
+	}
+	return nil
+}
+
+// Reads the keys returned by a statement
+func scanKeys(rows *sql.Rows, err error) ([]string, error) {
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var keys []string
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+func (s *SQLiteStore) QueueIssueKeys(keys []string, priority int, reason string) ([]string, error) {
+	if len(keys) == 0 {
+		return nil, nil
+	}
+	now := sqliteNow()
+	query := `
+		INSERT INTO sync_queue (issue_key, priority, reason, queued_date, retry_after)
+		SELECT k.value, ?2, ?3, ?4, ?4
+		FROM json_each(?1) AS k
+		WHERE
+			NOT EXISTS (SELECT 1 FROM sync_queue q WHERE q.issue_key = k.value)
+			AND NOT EXISTS (SELECT 1 FROM issue i WHERE i.key = k.value AND i.synced_date >= ?5)
+		RETURNING issue_key
+	`
+	return scanKeys(s.db.Query(query, sqliteArray(keys), priority, reason, now, now.Add(-15*time.Minute)))
+}
+
+func (s *SQLiteStore) ClaimQueuedIssues(ctx context.Context, worker string, limit int, lease time.Duration) ([]ClaimedIssue, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	now := sqliteNow()
+	// The transaction holds the write lock, so no other worker can claim the same rows in between
+	rows, err := tx.QueryContext(ctx, `SELECT issue_key, reason, COALESCE(claimed_by, ''), scan_job_id
+		FROM sync_queue
+		WHERE retry_after <= ?1 AND (claimed_by IS NULL OR lease_until < ?1)
+		ORDER BY priority DESC, failed_count ASC, queued_date ASC
+		LIMIT ?2`, now, limit)
+	if err != nil {
+		return nil, err
+	}
+	var claimed []ClaimedIssue
+	for rows.Next() {
+		var issue ClaimedIssue
+		if err := rows.Scan(&issue.Key, &issue.Reason, &issue.AbandonedBy, &issue.ScanJobId); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		claimed = append(claimed, issue)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, issue := range claimed {
+		_, err = tx.ExecContext(ctx, `UPDATE sync_queue SET claimed_by = ?2, lease_until = ?3 WHERE issue_key = ?1`, issue.Key, worker, now.Add(lease))
+		if err != nil {
+			return nil, err
+		}
+	}
+	return claimed, tx.Commit()
+}
+
+func (s *SQLiteStore) GetHighestKeyNums(ctx context.Context, projects []string) (map[string]int, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT p.value, (SELECT MAX(key_num) FROM issue WHERE project = p.value) FROM json_each(?1) AS p`, sqliteArray(projects))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	result := make(map[string]int)
+	for rows.Next() {
+		var project string
+		var keyNum *int
+		if err := rows.Scan(&project, &keyNum); err != nil {
+			return nil, err
+		}
+		if keyNum != nil {
+			result[project] = *keyNum
+		}
+	}
+	return result, rows.Err()
+}
+
+func (s *SQLiteStore) FindKeyGaps(ctx context.Context, project string, probedSince time.Time, limit int) ([]string, error) {
+	// SQLite has no generate_series, so the numbers in each gap are counted up recursively
+	query := `WITH RECURSIVE gap(n, next_num) AS (
+			SELECT key_num + 1, next_num
+			FROM (
+				SELECT key_num, LEAD(key_num) OVER (ORDER BY key_num) AS next_num
+				FROM issue
+				WHERE project = ?1
+			)
+			WHERE next_num > key_num + 1
+			UNION ALL
+			SELECT n + 1, next_num FROM gap WHERE n + 1 < next_num
+		)
+		SELECT ?1 || '-' || n
+		FROM gap
+		WHERE NOT EXISTS (SELECT 1 FROM key_probe p WHERE p.issue_key = ?1 || '-' || n AND p.checked_date >= ?2)
+		ORDER BY n DESC
+		LIMIT ?3`
+	return scanKeys(s.db.QueryContext(ctx, query, project, probedSince.UTC(), limit))
+}
+
+func (s *SQLiteStore) RecordKeyProbe(ctx context.Context, key string, reason string) error {
+	_, err := s.db.ExecContext(ctx, `INSERT INTO key_probe (issue_key, reason, checked_date) VALUES (?1, ?2, ?3)
+		ON CONFLICT (issue_key) DO UPDATE SET reason = excluded.reason, checked_date = excluded.checked_date`, key, reason, sqliteNow())
+	return err
+}
+
+func (s *SQLiteStore) QueueProbeKeys(ctx context.Context, keys []string, priority int, reason string, probedSince time.Time) ([]string, error) {
+	if len(keys) == 0 {
+		return nil, nil
+	}
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	now := sqliteNow()
+	probed, err := scanKeys(tx.QueryContext(ctx, `
+		INSERT INTO key_probe (issue_key, reason, checked_date)
+		SELECT k.value, ?2, ?3 FROM json_each(?1) AS k
+		WHERE NOT EXISTS (SELECT 1 FROM issue i WHERE i.key = k.value)
+		ON CONFLICT (issue_key) DO UPDATE SET reason = excluded.reason, checked_date = excluded.checked_date
+		WHERE key_probe.checked_date < ?4
+		RETURNING issue_key
+	`, sqliteArray(keys), reason, now, probedSince.UTC()))
+	if err != nil {
+		return nil, err
+	}
+	var result []string
+	for _, key := range probed {
+		queued, err := tx.ExecContext(ctx, `INSERT INTO sync_queue (issue_key, priority, reason, queued_date, retry_after) VALUES (?1, ?2, ?3, ?4, ?4) ON CONFLICT DO NOTHING`, key, priority, reason, now)
+		if err != nil {
+			return nil, err
+		}
+		if n, _ := queued.RowsAffected(); n > 0 {
+			result = append(result, key)
+		}
+	}
+	return result, tx.Commit()
+}
+
+func (s *SQLiteStore) PeekFutureVersionIssues(ctx context.Context, limit int) ([]string, error) {
+	// GLOB is case sensitive, like LIKE in Postgres
+	query := `SELECT key
+		FROM issue
+		WHERE EXISTS (
+			SELECT 1 FROM json_each(fix_versions)
+			WHERE value GLOB 'Future*'
+		)
+		LIMIT ?1`
+	return scanKeys(s.db.QueryContext(ctx, query, limit))
+}
+
+func (s *SQLiteStore) RetryQueuedIssue(ctx context.Context, worker string, key string, lastError string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var failedCount int
+	var priority int
+	query := `SELECT failed_count, priority FROM sync_queue WHERE issue_key = ?1 AND claimed_by = ?2`
+	err = tx.QueryRowContext(ctx, query, key, worker).Scan(&failedCount, &priority)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrLeaseLost
+	}
+	if err != nil {
+		return err
+	}
+
+	now := sqliteNow()
+	failedCount += 1
+	if failedCount > 4 && priority < 10 {
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO sync_dead_letter (issue_key, priority, reason, last_error, failed_count, first_failed_date, last_failed_date)
+			SELECT issue_key, priority, reason, ?2, ?3, COALESCE(first_failed_date, ?4), ?4
+			FROM sync_queue
+			WHERE issue_key = ?1
+			ON CONFLICT (issue_key) DO UPDATE SET
+				priority = excluded.priority,
+				reason = excluded.reason,
+				last_error = excluded.last_error,
+				failed_count = sync_dead_letter.failed_count + excluded.failed_count,
+				last_failed_date = excluded.last_failed_date
+		`, key, lastError, failedCount, now)
+		if err != nil {
+			return errors.New("failed to insert sync_dead_letter: " + err.Error())
+		}
+		_, err = tx.ExecContext(ctx, `DELETE FROM sync_queue WHERE issue_key = ?1`, key)
+	} else {
+		// Delay will be: 2m, 4m, 8m, 16m, 16m, 16m, ...
+		delay := time.Duration(math.Pow(2, float64(min(4, failedCount)))) * time.Minute
+		retryAfter := now.Add(delay)
+		_, err = tx.ExecContext(ctx, `
+			UPDATE sync_queue
+			SET
+				failed_count = ?2,
+				queued_date = ?5,
+				retry_after = ?3,
+				claimed_by = NULL,
+				lease_until = NULL,
+				last_error = ?4,
+				first_failed_date = COALESCE(first_failed_date, ?5)
+			WHERE issue_key = ?1
+		`, key, failedCount, retryAfter, lastError, now)
+	}
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *SQLiteStore) ExtendQueuedIssueLease(ctx context.Context, worker string, key string, lease time.Duration) error {
+	query := `UPDATE sync_queue SET lease_until = ?3 WHERE issue_key = ?1 AND claimed_by = ?2`
+	result, err := s.db.ExecContext(ctx, query, key, worker, sqliteNow().Add(lease))
+	if err != nil {
+		return err
+	}
+	extended, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if extended == 0 {
+		return ErrLeaseLost
+	}
+	return nil
+}
+
+func (s *SQLiteStore) ReleaseQueuedIssue(ctx context.Context, worker string, key string) error {
+	query := `UPDATE sync_queue SET claimed_by = NULL, lease_until = NULL WHERE issue_key = ?1 AND claimed_by = ?2`
+	_, err := s.db.ExecContext(ctx, query, key, worker)
+	return err
+}
+
+func (s *SQLiteStore) DeleteQueuedIssue(ctx context.Context, worker string, key string) error {
+	query := `DELETE FROM sync_queue WHERE issue_key = ?1 AND claimed_by = ?2`
+	result, err := s.db.ExecContext(ctx, query, key, worker)
+	if err != nil {
+		return err
+	}
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return ErrLeaseLost
+	}
+	return nil
+}
+
+func (s *SQLiteStore) GetQueueSize(ctx context.Context) (int, error) {
+	row := s.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM sync_queue`)
+	var count int
+	err := row.Scan(&count)
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+func (s *SQLiteStore) GetSyncOutage(ctx context.Context) (*SyncOutage, error) {
+	row := s.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM sync_queue WHERE reason = 'update-feed'`)
+	var totalCount int
+	err := row.Scan(&totalCount)
+	if err != nil {
+		return nil, err
+	}
+
+	row = s.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM sync_queue WHERE reason = 'update-feed'
+		AND NOT EXISTS (SELECT 1 FROM issue WHERE key = sync_queue.issue_key)`)
+	var newCount int
+	err = row.Scan(&newCount)
+	if err != nil {
+		return nil, err
+	}
+
+	if newCount < 50 {
+		return nil, nil
+	}
+	return &SyncOutage{NewCount: newCount, UpdateCount: totalCount - newCount}, nil
+}
+
+func (s *SQLiteStore) GetQueue(ctx context.Context) ([]QueueRow, int, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT issue_key, queued_date, priority, reason, failed_count, retry_after, COALESCE(claimed_by, ''), lease_until, last_error FROM sync_queue ORDER BY priority DESC, queued_date ASC LIMIT 100`)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+	var queue []QueueRow
+	for rows.Next() {
+		var q QueueRow
+		if err := rows.Scan(&q.Key, &q.QueuedDate, &q.Priority, &q.Reason, &q.FailedCount, &q.RetryAfter, &q.ClaimedBy, &q.LeaseUntil, &q.LastError); err != nil {
+			return nil, 0, err
+		}
+		queue = append(queue, q)
+	}
+	count, err := s.GetQueueSize(ctx)
+	if err != nil {
+		return nil, 0, err
+	}
+	return queue, count, nil
+}
+
+func (s *SQLiteStore) GetDeadLetters(ctx context.Context, limit int) ([]DeadLetterRow, int, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT issue_key, priority, reason, last_error, failed_count, first_failed_date, last_failed_date FROM sync_dead_letter ORDER BY last_failed_date DESC LIMIT ?1`, limit)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+	var deadLetters []DeadLetterRow
+	for rows.Next() {
+		var d DeadLetterRow
+		if err := rows.Scan(&d.Key, &d.Priority, &d.Reason, &d.LastError, &d.FailedCount, &d.FirstFailedDate, &d.LastFailedDate); err != nil {
+			return nil, 0, err
+		}
+		deadLetters = append(deadLetters, d)
+	}
+	count, err := s.GetDeadLetterSize(ctx)
+	if err != nil {
+		return nil, 0, err
+	}
+	return deadLetters, count, nil
+}
+
+func (s *SQLiteStore) GetDeadLetterSize(ctx context.Context) (int, error) {
+	row := s.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM sync_dead_letter`)
+	var count int
+	err := row.Scan(&count)
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+func (s *SQLiteStore) RequeueDeadLetters(ctx context.Context, keys []string) ([]string, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, `DELETE FROM sync_dead_letter
+		WHERE ?1 IS NULL OR issue_key IN (SELECT value FROM json_each(?1))
+		RETURNING issue_key, priority, reason`, sqliteArray(keys))
+	if err != nil {
+		return nil, err
+	}
+	var requeued []string
+	var priorities []int
+	var reasons []string
+	for rows.Next() {
+		var key, reason string
+		var priority int
+		if err := rows.Scan(&key, &priority, &reason); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		requeued = append(requeued, key)
+		priorities = append(priorities, priority)
+		reasons = append(reasons, reason)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	now := sqliteNow()
+	for i, key := range requeued {
+		_, err = tx.ExecContext(ctx, `INSERT INTO sync_queue (issue_key, priority, reason, queued_date, retry_after) VALUES (?1, ?2, ?3, ?4, ?4) ON CONFLICT DO NOTHING`, key, priorities[i], reasons[i], now)
+		if err != nil {
+			return nil, errors.New("failed to insert sync_queue: " + err.Error())
+		}
+	}
+	return requeued, tx.Commit()
+}
+
+// Same as scanPredicates, with the key numbers counted up recursively instead of generate_series. Selects the keys as k
+var sqliteScanPredicates = map[string]string{
+	"all":      `WITH RECURSIVE series(n) AS (SELECT ?2 UNION ALL SELECT n + 1 FROM series WHERE n < ?3) SELECT ?1 || '-' || n AS k FROM series`,
+	"missing":  `WITH RECURSIVE series(n) AS (SELECT ?2 UNION ALL SELECT n + 1 FROM series WHERE n < ?3) SELECT ?1 || '-' || n AS k FROM series WHERE NOT EXISTS (SELECT 1 FROM issue WHERE key = ?1 || '-' || n)`,
+	"present":  `SELECT key AS k FROM issue WHERE project = ?1 AND key_num BETWEEN ?2 AND ?3 AND state = 'present'`,
+	"resolved": `SELECT key AS k FROM issue WHERE project = ?1 AND key_num BETWEEN ?2 AND ?3 AND state = 'present' AND status NOT IN ('Open', 'Reopened')`,
+	"removed":  `SELECT key AS k FROM issue WHERE project = ?1 AND key_num BETWEEN ?2 AND ?3 AND state = 'removed'`,
+}
+
+func (s *SQLiteStore) GetScanJobs(ctx context.Context) ([]ScanJob, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT `+scanJobColumns+` FROM scan_job ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var jobs []ScanJob
+	for rows.Next() {
+		j, err := scanScanJob(rows)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, j)
+	}
+	return jobs, rows.Err()
+}
+
+func (s *SQLiteStore) CreateScanJob(ctx context.Context, job ScanJob) (int, error) {
+	if _, ok := sqliteScanPredicates[job.Predicate]; !ok {
+		return 0, fmt.Errorf("unknown scan predicate %q", job.Predicate)
+	}
+	var id int
+	err := s.db.QueryRowContext(ctx, `INSERT INTO scan_job (project, range_start, range_end, predicate, priority, reason, chunk_size, repeat_hours, next_num, created_date)
+		VALUES (?1, ?2, ?3, ?4, ?5, ?6, ?7, ?8, ?2, ?9)
+		RETURNING id`, job.Project, job.RangeStart, job.RangeEnd, job.Predicate, job.Priority, job.Reason, job.ChunkSize, job.RepeatHours, sqliteNow()).Scan(&id)
+	return id, err
+}
+
+func (s *SQLiteStore) SetScanJobEnabled(ctx context.Context, id int, enabled bool) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE scan_job SET enabled = ?2 WHERE id = ?1`, id, enabled)
+	return err
+}
+
+func (s *SQLiteStore) DeleteScanJob(ctx context.Context, id int) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM scan_job WHERE id = ?1`, id)
+	return err
+}
+
+func (s *SQLiteStore) AdvanceScanJob(ctx context.Context, id int) (int, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	row := tx.QueryRowContext(ctx, `SELECT `+scanJobColumns+` FROM scan_job WHERE id = ?1 AND enabled`, id)
+	job, err := scanScanJob(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	predicate, ok := sqliteScanPredicates[job.Predicate]
+	if !ok {
+		return 0, fmt.Errorf("unknown scan predicate %q", job.Predicate)
+	}
+
+	if job.FinishedDate != nil {
+		if job.RepeatHours <= 0 || time.Since(*job.FinishedDate) < time.Duration(job.RepeatHours)*time.Hour {
+			return 0, nil
+		}
+		job.NextNum = job.RangeStart
+		job.FinishedDate = nil
+		job.StartedDate = nil
+	}
+
+	var backlog int
+	err = tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM sync_queue WHERE scan_job_id = ?1`, job.Id).Scan(&backlog)
+	if err != nil {
+		return 0, err
+	}
+	if backlog >= job.ChunkSize {
+		return 0, nil
+	}
+
+	scanEnd := job.RangeEnd
+	if scanEnd == nil {
+		err = tx.QueryRowContext(ctx, `SELECT MAX(key_num) FROM issue WHERE project = ?1`, job.Project).Scan(&scanEnd)
+		if err != nil {
+			return 0, err
+		}
+		if scanEnd == nil {
+			scanEnd = &job.RangeStart
+		}
+	}
+	to := min(job.NextNum+job.ChunkSize-1, *scanEnd)
+
+	now := sqliteNow()
+	var queued int64
+	if job.NextNum <= to {
+		// The WHERE clause keeps ON CONFLICT from being parsed as a join constraint
+		result, err := tx.ExecContext(ctx, `INSERT INTO sync_queue (issue_key, priority, reason, queued_date, retry_after, scan_job_id)
+			SELECT k, ?4, ?5, ?6, ?6, ?7 FROM (`+predicate+`) WHERE true
+			ON CONFLICT DO NOTHING`, job.Project, job.NextNum, to, job.Priority, job.Reason, now, job.Id)
+		if err != nil {
+			return 0, errors.New("failed to insert sync_queue: " + err.Error())
+		}
+		queued, err = result.RowsAffected()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE scan_job SET
+			next_num = ?2,
+			scan_end = ?3,
+			queued_count = CASE WHEN ?5 THEN ?4 ELSE queued_count + ?4 END,
+			started_date = CASE WHEN ?5 THEN ?6 ELSE started_date END,
+			finished_date = CASE WHEN ?2 > ?3 THEN ?6 END
+		WHERE id = ?1`, job.Id, max(job.NextNum, to+1), *scanEnd, queued, job.StartedDate == nil, now)
+	if err != nil {
+		return 0, err
+	}
+	return int(queued), tx.Commit()
+}
+
+// Rebuilds the issue_count table, which stands in for the materialized view
+func (s *SQLiteStore) RefreshCountView() error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`DELETE FROM issue_count`); err != nil {
+		return err
+	}
+	_, err = tx.Exec(`INSERT INTO issue_count (project, status, confirmation_status, resolution, count)
+		SELECT project, status, confirmation_status, resolution, COUNT(*)
+		FROM issue
+		WHERE state = 'present'
+		GROUP BY project, status, confirmation_status, resolution`)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *SQLiteStore) GetWebhooks(ctx context.Context) ([]Webhook, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT id, url, secret, format, filter, events, enabled, created_date FROM webhook ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var webhooks []Webhook
+	for rows.Next() {
+		var h Webhook
+		var filter []byte
+		if err := rows.Scan(&h.Id, &h.Url, &h.Secret, &h.Format, &filter, sqliteArrayScanner{&h.Events}, &h.Enabled, &h.CreatedDate); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(filter, &h.Filter); err != nil {
+			return nil, fmt.Errorf("invalid filter for webhook %d: %w", h.Id, err)
+		}
+		h.parseQuery()
+		webhooks = append(webhooks, h)
+	}
+	return webhooks, nil
+}
+
+func (s *SQLiteStore) QueueWebhookDeliveries(ctx context.Context, deliveries []WebhookDelivery) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	now := sqliteNow()
+	for _, d := range deliveries {
+		retryAfter := now
+		if d.RetryAfter != nil {
+			retryAfter = d.RetryAfter.UTC()
+		}
+		_, err = tx.ExecContext(ctx, `INSERT INTO webhook_delivery (webhook_id, issue_key, payload, queued_date, retry_after) VALUES (?1, ?2, ?3, ?4, ?5)`, d.WebhookId, d.IssueKey, d.Payload, now, retryAfter)
+		if err != nil {
+			return errors.New("failed to insert webhook_delivery: " + err.Error())
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *SQLiteStore) ClaimWebhookDeliveries(ctx context.Context, worker string, limit int, lease time.Duration) ([]WebhookDelivery, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	now := sqliteNow()
+	rows, err := tx.QueryContext(ctx, `SELECT d.id, d.webhook_id, h.url, h.secret, h.format, d.issue_key, d.payload, d.failed_count
+		FROM webhook_delivery d
+		JOIN webhook h ON h.id = d.webhook_id
+		WHERE d.status = 'pending' AND d.retry_after <= ?1 AND h.enabled AND (d.claimed_by IS NULL OR d.lease_until < ?1)
+		ORDER BY d.retry_after ASC, d.id ASC
+		LIMIT ?2`, now, limit)
+	if err != nil {
+		return nil, err
+	}
+	var deliveries []WebhookDelivery
+	for rows.Next() {
+		var d WebhookDelivery
+		if err := rows.Scan(&d.Id, &d.WebhookId, &d.WebhookUrl, &d.Secret, &d.Format, &d.IssueKey, &d.Payload, &d.FailedCount); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		deliveries = append(deliveries, d)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, d := range deliveries {
+		_, err = tx.ExecContext(ctx, `UPDATE webhook_delivery SET claimed_by = ?2, lease_until = ?3 WHERE id = ?1`, d.Id, worker, now.Add(lease))
+		if err != nil {
+			return nil, err
+		}
+	}
+	return deliveries, tx.Commit()
+}
+
+func (s *SQLiteStore) MarkWebhookDelivered(ctx context.Context, id int, responseCode int) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE webhook_delivery SET status = 'delivered', response_code = ?2, last_error = '', delivered_date = ?3, claimed_by = NULL, lease_until = NULL WHERE id = ?1`, id, responseCode, sqliteNow())
+	return err
+}
+
+func (s *SQLiteStore) RetryWebhookDelivery(ctx context.Context, id int, responseCode *int, lastError string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var failedCount int
+	err = tx.QueryRowContext(ctx, `SELECT failed_count FROM webhook_delivery WHERE id = ?1`, id).Scan(&failedCount)
+	if err != nil {
+		return err
+	}
+
+	failedCount += 1
+	if failedCount >= 8 {
+		_, err = tx.ExecContext(ctx, `UPDATE webhook_delivery SET status = 'failed', failed_count = ?2, response_code = ?3, last_error = ?4, claimed_by = NULL, lease_until = NULL WHERE id = ?1`, id, failedCount, responseCode, lastError)
+	} else {
+		// Delay will be: 2m, 4m, 8m, 16m, 16m, 16m, ...
+		delay := time.Duration(math.Pow(2, float64(min(4, failedCount)))) * time.Minute
+		retryAfter := sqliteNow().Add(delay)
+		_, err = tx.ExecContext(ctx, `UPDATE webhook_delivery SET failed_count = ?2, response_code = ?3, last_error = ?4, retry_after = ?5, claimed_by = NULL, lease_until = NULL WHERE id = ?1`, id, failedCount, responseCode, lastError, retryAfter)
+	}
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *SQLiteStore) GetWebhookDeliveries(ctx context.Context, limit int) ([]WebhookDelivery, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT d.id, d.webhook_id, h.url, d.issue_key, d.status, d.failed_count, d.response_code, d.last_error, d.queued_date, d.retry_after, d.delivered_date
+		FROM webhook_delivery d
+		JOIN webhook h ON h.id = d.webhook_id
+		ORDER BY d.id DESC
+		LIMIT ?1`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var deliveries []WebhookDelivery
+	for rows.Next() {
+		var d WebhookDelivery
+		if err := rows.Scan(&d.Id, &d.WebhookId, &d.WebhookUrl, &d.IssueKey, &d.Status, &d.FailedCount, &d.ResponseCode, &d.LastError, &d.QueuedDate, &d.RetryAfter, &d.DeliveredDate); err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, d)
+	}
+	return deliveries, nil
+}
@@ -0,0 +1,9 @@
+//go:build !sqlite_fts5
+
+package main
+
+import "errors"
+
+func NewSQLiteStore(path string) (Store, error) {
+	return nil, errors.New("built without SQLite support, build with -tags sqlite_fts5")
+}
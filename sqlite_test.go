@@ -0,0 +1,149 @@
+//go:build sqlite_fts5
+
+package main
+
+import (
+	"context"
+	"mojira/jql"
+	"mojira/model"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestSQLiteStore(t *testing.T) {
+	open := func(t *testing.T) Store {
+		store, err := NewSQLiteStore(filepath.Join(t.TempDir(), "mojira.db"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return store
+	}
+	expire := func(t *testing.T, store Store, key string) {
+		// min() keeps a missing lease NULL
+		_, err := store.(*SQLiteStore).db.Exec(`UPDATE sync_queue SET retry_after = ?2, lease_until = min(lease_until, ?2) WHERE issue_key = ?1`, key, sqliteNow().Add(-time.Second))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	testStore(t, open, expire)
+}
+
+func TestSQLiteSearchExclusions(t *testing.T) {
+	store, err := NewSQLiteStore(filepath.Join(t.TempDir(), "mojira.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	summaries := map[string]string{"MC-1": "Game crash in lava", "MC-2": "Bucket of lava disappears", "MC-3": "Crash on startup"}
+	for key, summary := range summaries {
+		created := time.Date(2024, 1, keyNum(key), 0, 0, 0, 0, time.UTC)
+		if err := store.UpdateIssue(context.Background(), &model.Issue{Key: key, Summary: summary, CreatedDate: &created, SyncedDate: &created, Status: "Open"}, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for query, want := range map[string][]string{
+		`text ~ "lava -crash"`:  {"MC-2"},
+		`text ~ "-crash lava"`:  {"MC-2"},
+		`text ~ "-lava crash"`:  {"MC-3"},
+		`text ~ "-crash -lava"`: {},
+	} {
+		page, err := store.FilterIssues(IssueFilter{Query: query}, nil, 10)
+		if err != nil {
+			t.Fatalf("%s: %v", query, err)
+		}
+		keys := []string{}
+		for _, issue := range page.Issues {
+			keys = append(keys, issue.Key)
+		}
+		if !slices.Equal(keys, want) {
+			t.Errorf("%s: expected %v, got %v", query, want, keys)
+		}
+	}
+}
+
+// The SQL of a query selects the same issues as matching it in memory
+func TestSQLiteQueryMatches(t *testing.T) {
+	store, err := NewSQLiteStore(filepath.Join(t.TempDir(), "mojira.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	at := func(value string) *time.Time {
+		date, _ := time.Parse(time.RFC3339, value)
+		return &date
+	}
+	issues := []*model.Issue{
+		{
+			Key: "MC-1", Summary: "Game crashes in lava", Status: "Open", MojangPriority: "Important",
+			Labels: []string{"crash", "lava"}, CreatedDate: at("2024-01-15T10:00:00Z"), Votes: 3, LegacyVotes: 4,
+		},
+		{
+			Key: "MC-2", Summary: "Bucket disappears", Status: "Resolved", Resolution: "Fixed",
+			MojangPriority: "Low", ReporterName: "Alice", CreatedDate: at("2024-01-16T00:00:00Z"), ResolvedDate: at("2024-02-01T12:00:00Z"),
+			Votes: 1, Comments: []model.Comment{{Id: "1", Date: at("2024-01-17T00:00:00Z")}, {Id: "2", Date: at("2024-01-18T00:00:00Z")}},
+		},
+		{
+			Key: "MCPE-3", Summary: "Water renders black", Status: "Open", MojangPriority: "Very Important",
+			AssigneeName: "bob", Components: []string{"Rendering"}, CreatedDate: at("2024-01-14T23:59:59Z"),
+			Links: []model.IssueLink{{Type: "is duplicated by", OtherKey: "MCPE-4"}, {Type: "relates to", OtherKey: "MC-1"}},
+		},
+	}
+	for _, issue := range issues {
+		issue.SyncedDate = issue.CreatedDate
+		if err := store.UpdateIssue(context.Background(), issue, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, query := range []string{
+		`project = MC AND status = Open OR priority = Low`,
+		`NOT project = MC OR resolution = Fixed`,
+		`status not in (Open)`,
+		`resolution = Unresolved`,
+		`summary ~ CRASH`,
+		`summary ~ "bucket*"`,
+		`summary !~ "*lava"`,
+		`text ~ lava`,
+		`labels ~ "lav*"`,
+		`labels is empty`,
+		`components in (Rendering, Sound)`,
+		`reporter = alice`,
+		`assignee is not empty`,
+		`created = 2024-01-15`,
+		`created != 2024-01-15`,
+		`created > 2024-01-15`,
+		`created <= 2024-01-15`,
+		`created < "2024-01-15T11:00:00+02:00"`,
+		`resolved is empty`,
+		`resolved < 2024-02-02`,
+		`votes >= 1 AND votes < 7`,
+		`comments >= 2`,
+		`duplicates = 1`,
+		`priority > Important`,
+		`priority < Normal`,
+	} {
+		q, err := jql.Parse(query)
+		if err != nil {
+			t.Fatalf("%s: %v", query, err)
+		}
+		want := []string{}
+		for _, issue := range issues {
+			if q.Matches(issue) {
+				want = append(want, issue.Key)
+			}
+		}
+		page, err := store.FilterIssues(IssueFilter{Query: query}, nil, 10)
+		if err != nil {
+			t.Fatalf("%s: %v", query, err)
+		}
+		keys := []string{}
+		for _, issue := range page.Issues {
+			keys = append(keys, issue.Key)
+		}
+		slices.Sort(keys)
+		if !slices.Equal(keys, want) {
+			t.Errorf("%s: SQL selected %v, Matches %v", query, keys, want)
+		}
+	}
+}
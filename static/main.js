@@ -40,6 +40,18 @@ function afterSwap() {
     }
   })
 
+  document.querySelectorAll('[data-tab]').forEach((el) => {
+    el.onclick = () => {
+      document.querySelectorAll('[data-tab]').forEach((tab) => {
+        tab.classList.toggle('active', tab === el)
+        const panel = document.getElementById(tab.getAttribute('data-tab'))
+        if (panel) {
+          panel.style.display = tab === el ? '' : 'none'
+        }
+      })
+    }
+  })
+
   expandCommentsIfNeeded()
 }
 
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"log"
+	"mojira/model"
+	"os"
+	"time"
+)
+
+// Everything the server stores. DBClient keeps it in Postgres, SQLiteStore in a single file and MemoryStore in memory for tests and local development
+type Store interface {
+	// Issues
+	GetIssueByKey(key string) (*model.Issue, error)
+	// Also loads a removed issue, the bool reports whether it is removed
+	GetStoredIssue(key string) (*model.Issue, bool, error)
+	GetIssuesByKeys(ctx context.Context, keys []string) ([]model.Issue, []string, error)
+	GetIssueForSync(key string) (*model.Issue, error)
+	GetIssueSyncedDate(key string) (*time.Time, error)
+	UpdateIssue(ctx context.Context, issue *model.Issue, changes []model.FieldChange) error
+	MarkIssueRemoved(key string) error
+	GetIssueHistory(ctx context.Context, key string) ([]model.FieldChange, error)
+	PeekFutureVersionIssues(ctx context.Context, limit int) ([]string, error)
+	RefreshCountView() error
+
+	// Search and filter
+	SearchIssues(text string, limit int) ([]model.Issue, error)
+	FilterIssues(filter IssueFilter, cursor *IssueCursor, limit int) (*IssuePage, error)
+
+	// Users
+	GetIssueByReporter(reporter string, limit int) ([]model.Issue, error)
+	GetIssueByAssignee(assignee string, limit int) ([]model.Issue, error)
+	GetCommentsByUser(name string, offset int, limit int) ([]model.Comment, error)
+
+	// Activity
+	InsertActivity(ctx context.Context, events []model.ActivityEvent) error
+	GetActivity(ctx context.Context, project string, typ string, version string, before int, limit int) ([]model.ActivityEvent, error)
+
+	// Sync queue
+	QueueIssueKeys(keys []string, priority int, reason string) ([]string, error)
+	ClaimQueuedIssues(ctx context.Context, worker string, limit int, lease time.Duration) ([]ClaimedIssue, error)
+	RetryQueuedIssue(ctx context.Context, worker string, key string, lastError string) error
+	ExtendQueuedIssueLease(ctx context.Context, worker string, key string, lease time.Duration) error
+	ReleaseQueuedIssue(ctx context.Context, worker string, key string) error
+	DeleteQueuedIssue(ctx context.Context, worker string, key string) error
+	GetQueueSize(ctx context.Context) (int, error)
+	GetSyncOutage(ctx context.Context) (*SyncOutage, error)
+	GetQueue(ctx context.Context) ([]QueueRow, int, error)
+	GetDeadLetters(ctx context.Context, limit int) ([]DeadLetterRow, int, error)
+	GetDeadLetterSize(ctx context.Context) (int, error)
+	RequeueDeadLetters(ctx context.Context, keys []string) ([]string, error)
+
+	// Key probes and scan jobs
+	GetHighestKeyNums(ctx context.Context, projects []string) (map[string]int, error)
+	FindKeyGaps(ctx context.Context, project string, probedSince time.Time, limit int) ([]string, error)
+	QueueProbeKeys(ctx context.Context, keys []string, priority int, reason string, probedSince time.Time) ([]string, error)
+	RecordKeyProbe(ctx context.Context, key string, reason string) error
+	GetScanJobs(ctx context.Context) ([]ScanJob, error)
+	CreateScanJob(ctx context.Context, job ScanJob) (int, error)
+	SetScanJobEnabled(ctx context.Context, id int, enabled bool) error
+	DeleteScanJob(ctx context.Context, id int) error
+	AdvanceScanJob(ctx context.Context, id int) (int, error)
+
+	// Webhooks
+	GetWebhooks(ctx context.Context) ([]Webhook, error)
+	// Deliveries are due at their RetryAfter if it is set, otherwise right away
+	QueueWebhookDeliveries(ctx context.Context, deliveries []WebhookDelivery) error
+	ClaimWebhookDeliveries(ctx context.Context, worker string, limit int, lease time.Duration) ([]WebhookDelivery, error)
+	MarkWebhookDelivered(ctx context.Context, id int, responseCode int) error
+	RetryWebhookDelivery(ctx context.Context, id int, responseCode *int, lastError string) error
+	GetWebhookDeliveries(ctx context.Context, limit int) ([]WebhookDelivery, error)
+}
+
+var _ Store = (*DBClient)(nil)
+var _ Store = (*MemoryStore)(nil)
+
+// Opens the store selected by STORE, which is postgres unless it is set to memory or sqlite
+func NewStore() (Store, error) {
+	switch os.Getenv("STORE") {
+	case "memory":
+		log.Println("Keeping issues in memory, they are lost on restart")
+		return NewMemoryStore(), nil
+	case "sqlite":
+		return NewSQLiteStore(cmp.Or(os.Getenv("SQLITE_PATH"), "mojira.db"))
+	default:
+		return NewDBClient()
+	}
+}
@@ -0,0 +1,381 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"mojira/model"
+	"slices"
+	"testing"
+	"time"
+)
+
+// Opens an empty store for a single test
+type storeOpener func(t *testing.T) Store
+
+// Moves the retry and an existing lease of a queued key into the past, as each store keeps them differently
+type queueExpirer func(t *testing.T, store Store, key string)
+
+func putIssue(t *testing.T, store Store, issue *model.Issue) {
+	t.Helper()
+	if issue.SyncedDate == nil {
+		synced := time.Now().Add(-time.Hour)
+		issue.SyncedDate = &synced
+	}
+	if err := store.UpdateIssue(context.Background(), issue, nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func issueKeys(issues []model.Issue) []string {
+	keys := make([]string, 0, len(issues))
+	for _, issue := range issues {
+		keys = append(keys, issue.Key)
+	}
+	return keys
+}
+
+func claim(t *testing.T, store Store, worker string, limit int, lease time.Duration) []ClaimedIssue {
+	t.Helper()
+	claimed, err := store.ClaimQueuedIssues(context.Background(), worker, limit, lease)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return claimed
+}
+
+// Runs the behaviour every Store shares, the memory store stands in for the others in most tests
+func testStore(t *testing.T, open storeOpener, expire queueExpirer) {
+	ctx := context.Background()
+
+	t.Run("queue", func(t *testing.T) {
+		store := open(t)
+		recent := time.Now()
+		putIssue(t, store, &model.Issue{Key: "MC-1", CreatedDate: &recent, SyncedDate: &recent})
+		putIssue(t, store, &model.Issue{Key: "MC-2", CreatedDate: &recent})
+		queued, err := store.QueueIssueKeys([]string{"MC-1", "MC-2", "MC-3"}, 5, "update-feed")
+		if err != nil {
+			t.Fatal(err)
+		}
+		slices.Sort(queued)
+		if !slices.Equal(queued, []string{"MC-2", "MC-3"}) {
+			t.Errorf("expected the recently synced MC-1 to be skipped, queued %v", queued)
+		}
+		if queued, _ = store.QueueIssueKeys([]string{"MC-3"}, 10, "update-feed"); len(queued) != 0 {
+			t.Errorf("expected a queued key not to be queued twice, queued %v", queued)
+		}
+		if size, _ := store.GetQueueSize(ctx); size != 2 {
+			t.Errorf("expected 2 queued keys, got %d", size)
+		}
+	})
+
+	t.Run("claim", func(t *testing.T) {
+		store := open(t)
+		store.QueueIssueKeys([]string{"MC-1"}, 5, "update-feed")
+		store.QueueIssueKeys([]string{"MC-2"}, 5, "update-feed")
+		store.QueueIssueKeys([]string{"MC-3"}, 10, "update-feed")
+
+		if got := claimedKeys(claim(t, store, "a", 2, time.Minute)); !slices.Equal(got, []string{"MC-3", "MC-1"}) {
+			t.Fatalf("expected the highest priority and oldest keys first, got %v", got)
+		}
+		if got := claimedKeys(claim(t, store, "b", 10, time.Minute)); !slices.Equal(got, []string{"MC-2"}) {
+			t.Fatalf("expected only the unclaimed key, got %v", got)
+		}
+		if err := store.DeleteQueuedIssue(ctx, "b", "MC-1"); err != ErrLeaseLost {
+			t.Errorf("expected a key claimed by another worker not to be deleted, got %v", err)
+		}
+		if err := store.ExtendQueuedIssueLease(ctx, "a", "MC-1", time.Hour); err != nil {
+			t.Fatal(err)
+		}
+
+		// An expired lease can be claimed by another worker, after which the first worker lost it
+		expire(t, store, "MC-3")
+		claimed := claim(t, store, "b", 10, time.Minute)
+		if len(claimed) != 1 || claimed[0].Key != "MC-3" || claimed[0].AbandonedBy != "a" {
+			t.Fatalf("expected to recover MC-3 from a, got %v", claimed)
+		}
+		if err := store.RetryQueuedIssue(ctx, "a", "MC-3", "failed"); err != ErrLeaseLost {
+			t.Errorf("expected the lease of a to be lost, got %v", err)
+		}
+		if err := store.ExtendQueuedIssueLease(ctx, "a", "MC-3", time.Hour); err != ErrLeaseLost {
+			t.Errorf("expected the lease of a to be lost, got %v", err)
+		}
+		if err := store.DeleteQueuedIssue(ctx, "b", "MC-3"); err != nil {
+			t.Fatal(err)
+		}
+
+		// A released key can be claimed right away, without counting as abandoned
+		if err := store.ReleaseQueuedIssue(ctx, "b", "MC-2"); err != nil {
+			t.Fatal(err)
+		}
+		claimed = claim(t, store, "c", 10, time.Minute)
+		if len(claimed) != 1 || claimed[0].Key != "MC-2" || claimed[0].AbandonedBy != "" {
+			t.Errorf("expected to claim the released MC-2, got %v", claimed)
+		}
+	})
+
+	t.Run("lease", func(t *testing.T) {
+		store := open(t)
+		store.QueueIssueKeys([]string{"MC-1"}, 5, "update-feed")
+		claim(t, store, "a", 1, 10*time.Millisecond)
+		if claimed := claim(t, store, "b", 1, time.Minute); len(claimed) != 0 {
+			t.Fatalf("expected the lease to hold, got %v", claimed)
+		}
+		time.Sleep(20 * time.Millisecond)
+		claimed := claim(t, store, "b", 1, time.Minute)
+		if len(claimed) != 1 || claimed[0].AbandonedBy != "a" {
+			t.Errorf("expected the lease to run out, got %v", claimed)
+		}
+	})
+
+	t.Run("retry", func(t *testing.T) {
+		store := open(t)
+		store.QueueIssueKeys([]string{"MC-1"}, 5, "update-feed")
+		claim(t, store, "a", 1, time.Minute)
+		if err := store.RetryQueuedIssue(ctx, "a", "MC-1", "timeout"); err != nil {
+			t.Fatal(err)
+		}
+		if claimed := claim(t, store, "a", 1, time.Minute); len(claimed) != 0 {
+			t.Fatalf("expected the key to wait for its retry, got %v", claimed)
+		}
+		queue, size, err := store.GetQueue(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if size != 1 || queue[0].FailedCount != 1 || queue[0].LastError != "timeout" || queue[0].ClaimedBy != "" || !queue[0].RetryAfter.After(time.Now()) {
+			t.Fatalf("expected the failure to be recorded, got %+v", queue)
+		}
+		expire(t, store, "MC-1")
+		if claimed := claim(t, store, "a", 1, time.Minute); len(claimed) != 1 {
+			t.Errorf("expected the key to be claimed after its retry, got %v", claimed)
+		}
+	})
+
+	t.Run("dead letter", func(t *testing.T) {
+		store := open(t)
+		store.QueueIssueKeys([]string{"MC-1"}, 5, "update-feed")
+		store.QueueIssueKeys([]string{"MC-2"}, 10, "update-feed")
+		for attempt := 1; attempt <= 5; attempt++ {
+			for _, key := range []string{"MC-1", "MC-2"} {
+				if attempt > 1 {
+					expire(t, store, key)
+				}
+				claim(t, store, "a", 2, time.Minute)
+				if err := store.RetryQueuedIssue(ctx, "a", key, fmt.Sprintf("attempt %d", attempt)); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+
+		dead, size, err := store.GetDeadLetters(ctx, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if size != 1 || dead[0].Key != "MC-1" || dead[0].FailedCount != 5 || dead[0].LastError != "attempt 5" || dead[0].Reason != "update-feed" {
+			t.Fatalf("expected MC-1 in the dead letters, got %+v", dead)
+		}
+		if queue, _, _ := store.GetQueue(ctx); len(queue) != 1 || queue[0].Key != "MC-2" {
+			t.Errorf("expected only the high priority MC-2 to stay queued, got %+v", queue)
+		}
+
+		requeued, err := store.RequeueDeadLetters(ctx, nil)
+		if err != nil || !slices.Equal(requeued, []string{"MC-1"}) {
+			t.Fatalf("expected MC-1 to be requeued, got %v (%v)", requeued, err)
+		}
+		if size, _ := store.GetDeadLetterSize(ctx); size != 0 {
+			t.Errorf("expected no dead letters after requeuing, got %d", size)
+		}
+		expire(t, store, "MC-2")
+		if got := claimedKeys(claim(t, store, "a", 10, time.Minute)); !slices.Equal(got, []string{"MC-2", "MC-1"}) {
+			t.Errorf("expected the requeued key to be claimable right away, got %v", got)
+		}
+	})
+
+	t.Run("scan job", func(t *testing.T) {
+		store := open(t)
+		for i := 1; i <= 3; i++ {
+			putIssue(t, store, &model.Issue{Key: fmt.Sprintf("MC-%d", i)})
+		}
+		rangeEnd := 8
+		id, err := store.CreateScanJob(ctx, ScanJob{Project: "MC", RangeStart: 1, RangeEnd: &rangeEnd, Predicate: "missing", Priority: 3, Reason: "scan", ChunkSize: 3})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if queued, _ := store.AdvanceScanJob(ctx, id); queued != 0 {
+			t.Errorf("expected the stored MC-1 to MC-3 to be skipped, queued %d", queued)
+		}
+		if queued, _ := store.AdvanceScanJob(ctx, id); queued != 3 {
+			t.Errorf("expected MC-4 to MC-6 to be queued, queued %d", queued)
+		}
+		// The job waits until its own keys left the queue, keys queued for another reason don't count
+		store.QueueIssueKeys([]string{"MC-100"}, 5, "update-feed")
+		if queued, _ := store.AdvanceScanJob(ctx, id); queued != 0 {
+			t.Errorf("expected the job to wait for its backlog, queued %d", queued)
+		}
+		for _, c := range claim(t, store, "a", 10, time.Minute) {
+			if (c.Key == "MC-100") != (c.ScanJobId == nil) || c.ScanJobId != nil && *c.ScanJobId != id {
+				t.Errorf("expected only the scanned keys to belong to job %d, got %s with %v", id, c.Key, c.ScanJobId)
+			}
+			if c.Key == "MC-4" || c.Key == "MC-5" {
+				store.DeleteQueuedIssue(ctx, "a", c.Key)
+			} else {
+				store.ReleaseQueuedIssue(ctx, "a", c.Key)
+			}
+		}
+		if queued, _ := store.AdvanceScanJob(ctx, id); queued != 2 {
+			t.Errorf("expected MC-7 and MC-8 to be queued, queued %d", queued)
+		}
+
+		jobs, _ := store.GetScanJobs(ctx)
+		if len(jobs) != 1 || jobs[0].NextNum != 9 || jobs[0].FinishedDate == nil || jobs[0].QueuedCount != 5 {
+			t.Errorf("expected the job to be finished after queuing 5 keys, got %+v", jobs)
+		}
+		if queued, _ := store.AdvanceScanJob(ctx, id); queued != 0 {
+			t.Errorf("expected a finished job without repeat to stay finished, queued %d", queued)
+		}
+
+		if err := store.SetScanJobEnabled(ctx, id, false); err != nil {
+			t.Fatal(err)
+		}
+		if jobs, _ = store.GetScanJobs(ctx); jobs[0].Enabled {
+			t.Error("expected the job to be disabled")
+		}
+		if err := store.DeleteScanJob(ctx, id); err != nil {
+			t.Fatal(err)
+		}
+		if jobs, _ = store.GetScanJobs(ctx); len(jobs) != 0 {
+			t.Errorf("expected the job to be deleted, got %+v", jobs)
+		}
+	})
+
+	t.Run("count", func(t *testing.T) {
+		store := open(t)
+		for i := 1; i <= 5; i++ {
+			issue := &model.Issue{Key: fmt.Sprintf("MC-%d", i), Status: "Open"}
+			if i == 3 {
+				issue.Status, issue.Resolution = "Resolved", "Fixed"
+			}
+			putIssue(t, store, issue)
+		}
+		putIssue(t, store, &model.Issue{Key: "MCPE-1", Status: "Open"})
+		store.MarkIssueRemoved("MC-2")
+		if err := store.RefreshCountView(); err != nil {
+			t.Fatal(err)
+		}
+
+		for filter, want := range map[IssueFilter]int{
+			{}:                          5,
+			{Project: "MC"}:             4,
+			{Resolution: "Unresolved"}:  4,
+			{Resolution: "Fixed"}:       1,
+			{Project: "MCPE"}:           1,
+			{Query: "status = Open"}:    4,
+			{Query: "resolution = Won"}: 0,
+		} {
+			page, err := store.FilterIssues(filter, nil, 2)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if page.Count != want {
+				t.Errorf("%+v: expected a count of %d, got %d", filter, want, page.Count)
+			}
+		}
+	})
+
+	t.Run("description", func(t *testing.T) {
+		store := open(t)
+		putIssue(t, store, &model.Issue{Key: "MC-1", Status: "Open", Description: "Steps to reproduce"})
+		for _, withDescription := range []bool{false, true} {
+			page, err := store.FilterIssues(IssueFilter{WithDescription: withDescription}, nil, 10)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(page.Issues) != 1 || (page.Issues[0].Description != "") != withDescription {
+				t.Errorf("expected the description only when it is asked for, got %+v", page.Issues)
+			}
+		}
+	})
+
+	t.Run("paging", func(t *testing.T) {
+		store := open(t)
+		at := func(value string) *time.Time {
+			date, _ := time.Parse(time.RFC3339Nano, value)
+			return &date
+		}
+		comments := func(n int) []model.Comment {
+			var comments []model.Comment
+			for i := range n {
+				comments = append(comments, model.Comment{Id: fmt.Sprint(i), AuthorName: "Someone", Date: at("2024-02-01T00:00:00Z")})
+			}
+			return comments
+		}
+		// Times with fractions of a second and ties on every sort column but the key
+		for _, issue := range []*model.Issue{
+			{Key: "MC-1", CreatedDate: at("2024-01-01T00:00:00Z"), MojangPriority: "Low", Votes: 2, UpdatedDate: at("2024-03-01T00:00:00Z")},
+			{Key: "MC-2", CreatedDate: at("2024-01-02T00:00:00.5Z"), MojangPriority: "Important", Votes: 1, LegacyVotes: 1, UpdatedDate: at("2024-03-01T00:00:00.5Z"), ResolvedDate: at("2024-02-01T00:00:00.25Z")},
+			{Key: "MC-3", CreatedDate: at("2024-01-02T00:00:00Z"), MojangPriority: "Important", ResolvedDate: at("2024-02-01T00:00:00Z"), Comments: comments(1)},
+			{Key: "MC-10", CreatedDate: at("2024-01-02T00:00:00Z"), Votes: 5, Comments: comments(2), Links: []model.IssueLink{{Type: "is duplicated by", OtherKey: "MC-11"}}},
+			{Key: "MC-11", MojangPriority: "Low", UpdatedDate: at("2024-03-01T00:00:00Z")},
+			{Key: "MC-12", CreatedDate: at("2024-01-03T00:00:00Z"), Votes: 10},
+		} {
+			putIssue(t, store, issue)
+		}
+		store.MarkIssueRemoved("MC-12")
+
+		for sort, want := range map[string][]string{
+			"Created":    {"MC-2", "MC-3", "MC-10", "MC-1", "MC-11"},
+			"Updated":    {"MC-2", "MC-11", "MC-1"},
+			"Resolved":   {"MC-2", "MC-3"},
+			"Priority":   {"MC-2", "MC-3", "MC-1", "MC-11", "MC-10"},
+			"Votes":      {"MC-10", "MC-2", "MC-1", "MC-3", "MC-11"},
+			"Comments":   {"MC-10", "MC-3", "MC-2", "MC-1", "MC-11"},
+			"Duplicates": {"MC-10", "MC-2", "MC-3", "MC-1", "MC-11"},
+		} {
+			// Cursors are passed through their string form like in a URL
+			roundTrip := func(cursor *IssueCursor) *IssueCursor {
+				parsed, err := ParseIssueCursor(cursor.String())
+				if err != nil {
+					t.Fatalf("%s: %v", sort, err)
+				}
+				return parsed
+			}
+			var pages []*IssuePage
+			var cursor *IssueCursor
+			for len(pages) <= len(want) {
+				page, err := store.FilterIssues(IssueFilter{Sort: sort}, cursor, 2)
+				if err != nil {
+					t.Fatalf("%s: %v", sort, err)
+				}
+				pages = append(pages, page)
+				if page.Next == nil {
+					break
+				}
+				cursor = roundTrip(page.Next)
+			}
+			var forward []string
+			for _, page := range pages {
+				forward = append(forward, issueKeys(page.Issues)...)
+			}
+			if !slices.Equal(forward, want) {
+				t.Errorf("%s: paging forward listed %v, want %v", sort, forward, want)
+				continue
+			}
+			if pages[0].Prev != nil {
+				t.Errorf("%s: expected no previous page on the first page", sort)
+			}
+
+			backward := issueKeys(pages[len(pages)-1].Issues)
+			for page := pages[len(pages)-1]; page.Prev != nil && len(backward) <= len(want); {
+				var err error
+				page, err = store.FilterIssues(IssueFilter{Sort: sort}, roundTrip(page.Prev), 2)
+				if err != nil {
+					t.Fatalf("%s: %v", sort, err)
+				}
+				backward = append(issueKeys(page.Issues), backward...)
+			}
+			if !slices.Equal(backward, want) {
+				t.Errorf("%s: paging backward listed %v, want %v", sort, backward, want)
+			}
+		}
+	})
+}
@@ -2,12 +2,12 @@ package main
 
 import (
 	"context"
-	"errors"
+	"fmt"
 	"log"
 	"mojira/model"
+	"os"
 	"slices"
 	"strings"
-	"sync"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
@@ -23,7 +23,43 @@ var syncQueueCount = promauto.NewGauge(
 	},
 )
 
-func StartSync(service *IssueService, noSync bool) {
+var frontierKeyNum = promauto.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "mojira_frontier_key_num",
+		Help: "Highest mirrored key number per project",
+	},
+	[]string{"project"},
+)
+
+var syncDeadLetterCount = promauto.NewGauge(
+	prometheus.GaugeOpts{
+		Name: "mojira_sync_dead_letter_size",
+		Help: "Number of rows in sync_dead_letter table",
+	},
+)
+
+// Queued keys are claimed for this long, after which another worker may take them over
+const queueLeaseDuration = 2 * time.Minute
+
+// Leases of keys that are still being processed are extended this often, a slow fetch can take longer than a lease
+const queueLeaseRenewInterval = queueLeaseDuration / 4
+
+// Identifies the leases of this instance in the sync_queue, set SYNC_WORKER_ID to keep it stable across restarts
+var syncWorkerId string
+
+func newSyncWorkerId() string {
+	if id := os.Getenv("SYNC_WORKER_ID"); id != "" {
+		return id
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+	return fmt.Sprintf("%s-%d", hostname, os.Getpid())
+}
+
+func StartSync(service *IssueService, noSync bool) *SyncPool {
+	syncWorkerId = newSyncWorkerId()
 	updateMetric(service, context.Background())
 
 	if !noSync {
@@ -42,24 +78,51 @@ func StartSync(service *IssueService, noSync bool) {
 				futureVersionChecker(service)
 			}
 		}()
+		go func() {
+			ticker := time.NewTicker(30 * time.Second)
+			for {
+				<-ticker.C
+				scanScheduler(service)
+			}
+		}()
+		go func() {
+			ticker := time.NewTicker(frontierProbeInterval)
+			for {
+				<-ticker.C
+				frontierProbe(service)
+			}
+		}()
+		go func() {
+			ticker := time.NewTicker(time.Hour)
+			for {
+				<-ticker.C
+				gapDetector(service)
+			}
+		}()
 	}
 
-	log.Println("Starting queue processor...")
+	pool := NewSyncPool(service, defaultSyncPoolConfig())
+	config := pool.Config()
+	log.Printf("Starting queue processor as %s with %d workers...", syncWorkerId, config.Workers)
+	pool.Start()
+
 	go func() {
-		ticker := time.NewTicker(3 * time.Second)
+		ticker := time.NewTicker(5 * time.Minute)
 		for {
 			<-ticker.C
-			queueProcessor(service)
+			refreshCountView(service)
 		}
 	}()
 
 	go func() {
-		ticker := time.NewTicker(5 * time.Minute)
+		ticker := time.NewTicker(5 * time.Second)
 		for {
 			<-ticker.C
-			refreshCountView(service)
+			webhookDeliverer(service)
 		}
 	}()
+
+	return pool
 }
 
 func updateFeedListener(service *IssueService) {
@@ -106,41 +169,104 @@ func futureVersionChecker(service *IssueService) {
 	}
 }
 
-func queueProcessor(service *IssueService) {
+// Advances the scan jobs, each queueing its next chunk once the previous one was mostly processed
+func scanScheduler(service *IssueService) {
 	ctx := context.Background()
-	keys, err := service.db.PeekQueuedIssues(ctx, 10)
+	jobs, err := service.db.GetScanJobs(ctx)
 	if err != nil {
-		log.Printf("[ERROR] [queue] Error getting queued keys: %v", err)
+		log.Printf("[ERROR] [scan] Error getting scan jobs: %v", err)
 		return
 	}
-	var wg sync.WaitGroup
-	for _, key := range keys {
-		wg.Add(1)
-		go func(key string) {
-			defer wg.Done()
-			_, err := service.RefreshIssue(ctx, key)
-			if err != nil {
-				if errors.Is(err, model.ErrIssueRemoved) {
-					log.Printf("[queue] Detected removed issue %s", key)
-					service.db.MarkIssueRemoved(key)
-				} else {
-					err = service.db.RetryQueuedIssue(ctx, key)
-					if err != nil {
-						log.Printf("[ERROR] [queue] Error retrying queued issue %s: %v", key, err)
-					}
-					return
-				}
-			} else {
-				log.Printf("[queue] Refreshed issue %s", key)
-			}
-			err = service.db.DeleteQueuedIssue(ctx, key)
-			if err != nil {
-				log.Printf("[ERROR] [queue] Error deleting queued issue %s: %v", key, err)
-			}
-		}(key)
+	for _, job := range jobs {
+		if !job.Enabled {
+			continue
+		}
+		queued, err := service.db.AdvanceScanJob(ctx, job.Id)
+		if err != nil {
+			log.Printf("[ERROR] [scan] Error advancing scan job %d: %v", job.Id, err)
+			continue
+		}
+		if queued > 0 {
+			log.Printf("[scan] Queued %d issues for scan job %d (%s %s)", queued, job.Id, job.Predicate, job.Project)
+		}
+	}
+}
+
+const frontierProbeInterval = 5 * time.Minute
+
+// Number of keys above the highest mirrored key that are probed, so a few inaccessible keys don't hide newer issues
+const frontierProbeWindow = 5
+
+// Probed keys that didn't exist are probed again after this long
+const frontierProbeRecheck = 30 * time.Minute
+const gapProbeRecheck = 7 * 24 * time.Hour
+
+// Keys that are probed because they might exist. When they don't, they are removed from the queue instead of retried
+var probeReasons = []string{"frontier-probe", "gap", "api-batch"}
+
+// Queues the keys just above the highest mirrored key of each project, to find new issues missed by the update feed
+func frontierProbe(service *IssueService) {
+	ctx := context.Background()
+	highest, err := service.db.GetHighestKeyNums(ctx, projects)
+	if err != nil {
+		log.Printf("[ERROR] [frontier] Error getting highest keys: %v", err)
+		return
+	}
+	var keys []string
+	for _, project := range projects {
+		keyNum, ok := highest[project]
+		if !ok {
+			continue
+		}
+		frontierKeyNum.WithLabelValues(project).Set(float64(keyNum))
+		for i := 1; i <= frontierProbeWindow; i++ {
+			keys = append(keys, fmt.Sprintf("%s-%d", project, keyNum+i))
+		}
+	}
+	queuedKeys, err := service.db.QueueProbeKeys(ctx, keys, 9, "frontier-probe", time.Now().Add(-frontierProbeRecheck))
+	if err != nil {
+		log.Printf("[ERROR] [frontier] Error queueing issues: %v", err)
+		return
+	}
+	if len(queuedKeys) > 0 {
+		log.Printf("[frontier] Queued %d issues: %s", len(queuedKeys), strings.Join(queuedKeys, ", "))
+	}
+}
+
+// Queues keys missing between mirrored issues, which weren't probed recently
+func gapDetector(service *IssueService) {
+	ctx := context.Background()
+	probedSince := time.Now().Add(-gapProbeRecheck)
+	for _, project := range projects {
+		keys, err := service.db.FindKeyGaps(ctx, project, probedSince, 100)
+		if err != nil {
+			log.Printf("[ERROR] [gaps] Error finding gaps in %s: %v", project, err)
+			continue
+		}
+		queuedKeys, err := service.db.QueueProbeKeys(ctx, keys, 2, "gap", probedSince)
+		if err != nil {
+			log.Printf("[ERROR] [gaps] Error queueing issues: %v", err)
+			continue
+		}
+		if len(queuedKeys) > 0 {
+			log.Printf("[gaps] Queued %d missing %s issues: %s", len(queuedKeys), project, strings.Join(queuedKeys, ", "))
+		}
 	}
-	wg.Wait()
-	updateMetric(service, ctx)
+}
+
+// Handles the differences detected by a refresh, used by the queue and by on-demand refreshes
+func processChanges(service *IssueService, ctx context.Context, diff *model.IssueDiff) {
+	if diff == nil {
+		return
+	}
+	activity := model.ActivityEvents(diff)
+	if len(activity) > 0 {
+		err := service.db.InsertActivity(ctx, activity)
+		if err != nil {
+			log.Printf("[ERROR] [activity] Error inserting %d events for %s: %v", len(activity), diff.New.Key, err)
+		}
+	}
+	queueWebhooks(service, ctx, diff, diffEvents(diff, activity))
 }
 
 func refreshCountView(service *IssueService) {
@@ -156,4 +282,10 @@ func updateMetric(service *IssueService, ctx context.Context) {
 		log.Printf("[ERROR] [queue] Error getting queue size: %v", err)
 	}
 	syncQueueCount.Set(float64(count))
+
+	count, err = service.db.GetDeadLetterSize(ctx)
+	if err != nil {
+		log.Printf("[ERROR] [queue] Error getting dead letter size: %v", err)
+	}
+	syncDeadLetterCount.Set(float64(count))
 }
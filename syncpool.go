@@ -0,0 +1,385 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"math"
+	"mojira/api"
+	"mojira/model"
+	"os"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var syncJobsInFlight = promauto.NewGauge(
+	prometheus.GaugeOpts{
+		Name: "mojira_sync_jobs_in_flight",
+		Help: "Number of claimed queue keys that are waiting for or being processed by a worker",
+	},
+)
+
+var syncJobDuration = promauto.NewHistogramVec(
+	prometheus.HistogramOpts{
+		Name:    "mojira_sync_job_duration_seconds",
+		Help:    "Time to process a queued key",
+		Buckets: prometheus.DefBuckets,
+	},
+	[]string{"reason", "result"},
+)
+
+var syncJobs = promauto.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "mojira_sync_jobs_total",
+		Help: "Number of processed queue keys",
+	},
+	[]string{"reason", "result"},
+)
+
+// How long the pool waits before looking at an empty queue again
+const queuePollInterval = 3 * time.Second
+
+var syncSources = []string{"legacy", "public", "servicedesk"}
+
+type SyncPoolConfig struct {
+	// Number of keys processed at the same time
+	Workers int
+	// Maximum number of keys claimed at once
+	BatchSize int
+	// Requests per second to each source, 0 is unlimited
+	Rates map[string]float64
+}
+
+func defaultSyncPoolConfig() SyncPoolConfig {
+	return SyncPoolConfig{
+		Workers:   envInt("SYNC_WORKERS", 10),
+		BatchSize: envInt("SYNC_BATCH_SIZE", 10),
+		Rates: map[string]float64{
+			"legacy":      envFloat("SYNC_RATE_LEGACY", 0),
+			"public":      envFloat("SYNC_RATE_PUBLIC", 0),
+			"servicedesk": envFloat("SYNC_RATE_SERVICEDESK", 0),
+		},
+	}
+}
+
+func envInt(name string, fallback int) int {
+	value, err := strconv.Atoi(os.Getenv(name))
+	if err != nil || value < 1 {
+		return fallback
+	}
+	return value
+}
+
+func envFloat(name string, fallback float64) float64 {
+	value, err := parseRate(os.Getenv(name))
+	if err != nil {
+		return fallback
+	}
+	return value
+}
+
+// Parses a rate in requests per second. NaN and infinity are rejected, the limiter can't compute a delay from them
+func parseRate(text string) (float64, error) {
+	rate, err := strconv.ParseFloat(text, 64)
+	if err != nil {
+		return 0, err
+	}
+	if rate < 0 || math.IsNaN(rate) || math.IsInf(rate, 0) {
+		return 0, fmt.Errorf("invalid rate %q", text)
+	}
+	return rate, nil
+}
+
+// Keeps a number of workers busy with keys from the sync queue. Keys are claimed as soon as a worker is free, so a slow key doesn't hold up the others
+type SyncPool struct {
+	service *IssueService
+	jobs    chan ClaimedIssue
+	// Signals the feeder that a worker became free or the config changed
+	wake chan struct{}
+
+	mu      sync.Mutex
+	config  SyncPoolConfig
+	running int
+	pending int
+	resized chan struct{}
+}
+
+func NewSyncPool(service *IssueService, config SyncPoolConfig) *SyncPool {
+	p := &SyncPool{
+		service: service,
+		jobs:    make(chan ClaimedIssue),
+		wake:    make(chan struct{}, 1),
+		resized: make(chan struct{}),
+	}
+	p.SetConfig(config)
+	return p
+}
+
+func (p *SyncPool) Config() SyncPoolConfig {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	config := p.config
+	config.Rates = maps.Clone(p.config.Rates)
+	return config
+}
+
+// Number of claimed keys that haven't been processed yet
+func (p *SyncPool) InFlight() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.pending
+}
+
+// Applies a new config while the pool is running. Extra workers stop after finishing their current key
+func (p *SyncPool) SetConfig(config SyncPoolConfig) {
+	config.Workers = max(1, config.Workers)
+	config.BatchSize = max(1, config.BatchSize)
+	for _, upstream := range p.service.upstreams() {
+		upstream.Limiter().SetRate(config.Rates[upstream.Source()])
+	}
+
+	p.mu.Lock()
+	p.config = config
+	for p.running < config.Workers {
+		p.running += 1
+		go p.worker()
+	}
+	close(p.resized)
+	p.resized = make(chan struct{})
+	p.mu.Unlock()
+	p.notify()
+}
+
+func (p *SyncPool) Start() {
+	go p.feed()
+}
+
+func (p *SyncPool) notify() {
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (p *SyncPool) sleep(d time.Duration) {
+	select {
+	case <-p.wake:
+	case <-time.After(d):
+	}
+}
+
+// Limits the keys in flight while the circuit breaker of a required source isn't closed. Nothing is claimed while such a circuit is open,
+// and once its cooldown is over a single key is processed to find out whether the source recovered
+func (p *SyncPool) circuitLimit() (int, time.Time) {
+	limit := -1
+	var until time.Time
+	for _, upstream := range p.service.requiredUpstreams() {
+		openUntil := upstream.Breaker().OpenUntil()
+		if openUntil.IsZero() {
+			continue
+		}
+		if time.Now().Before(openUntil) {
+			limit = 0
+			if openUntil.After(until) {
+				until = openUntil
+			}
+		} else if limit != 0 {
+			limit = 1
+		}
+	}
+	return limit, until
+}
+
+// Claims keys for the free workers
+func (p *SyncPool) feed() {
+	ctx := context.Background()
+	var metricDate time.Time
+	paused := false
+	for {
+		if time.Since(metricDate) > queuePollInterval {
+			updateMetric(p.service, ctx)
+			metricDate = time.Now()
+		}
+		limit, until := p.circuitLimit()
+		if limit == 0 {
+			if !paused {
+				log.Printf("[queue] Paused until %s, an API circuit is open", until.UTC().Format(time.RFC3339))
+				paused = true
+			}
+			p.sleep(min(time.Until(until), queuePollInterval))
+			continue
+		}
+		if paused {
+			log.Printf("[queue] Resumed")
+			paused = false
+		}
+		p.mu.Lock()
+		free := min(p.config.Workers-p.pending, p.config.BatchSize)
+		if limit > 0 {
+			free = min(free, limit-p.pending)
+		}
+		p.mu.Unlock()
+		if free <= 0 {
+			p.sleep(queuePollInterval)
+			continue
+		}
+
+		claimed, err := p.service.db.ClaimQueuedIssues(ctx, syncWorkerId, free, queueLeaseDuration)
+		if err != nil {
+			log.Printf("[ERROR] [queue] Error claiming queued keys: %v", err)
+			p.sleep(queuePollInterval)
+			continue
+		}
+		if len(claimed) == 0 {
+			p.sleep(queuePollInterval)
+			continue
+		}
+
+		p.mu.Lock()
+		p.pending += len(claimed)
+		syncJobsInFlight.Set(float64(p.pending))
+		p.mu.Unlock()
+		for _, claim := range claimed {
+			if claim.AbandonedBy != "" && claim.AbandonedBy != syncWorkerId {
+				log.Printf("[queue] Recovered abandoned lease of %s from %s", claim.Key, claim.AbandonedBy)
+			}
+			p.jobs <- claim
+		}
+	}
+}
+
+func (p *SyncPool) worker() {
+	for {
+		p.mu.Lock()
+		resized := p.resized
+		if p.running > p.config.Workers {
+			p.running -= 1
+			p.mu.Unlock()
+			return
+		}
+		p.mu.Unlock()
+
+		select {
+		case claim := <-p.jobs:
+			p.process(claim)
+			p.mu.Lock()
+			p.pending -= 1
+			syncJobsInFlight.Set(float64(p.pending))
+			p.mu.Unlock()
+			p.notify()
+		case <-resized:
+		}
+	}
+}
+
+// Extends the lease of a key until the returned function is called
+func (p *SyncPool) renewLease(key string) func() {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(queueLeaseRenewInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			err := p.service.db.ExtendQueuedIssueLease(ctx, syncWorkerId, key, queueLeaseDuration)
+			if errors.Is(err, ErrLeaseLost) {
+				log.Printf("[queue] Lease of %s was lost while it was processed", key)
+				return
+			} else if err != nil && ctx.Err() == nil {
+				log.Printf("[ERROR] [queue] Error extending lease of %s: %v", key, err)
+			}
+		}
+	}()
+	return func() {
+		cancel()
+		<-done
+	}
+}
+
+// Gives back a key that couldn't be synced because a source is unavailable. The queue keeps running while only the legacy
+// circuit is open, so the key stays claimed until that circuit may close instead of being claimed again right away
+func (p *SyncPool) release(ctx context.Context, key string) {
+	limit, _ := p.circuitLimit()
+	legacyOpenUntil := p.service.legacy.Upstream().Breaker().OpenUntil()
+	if limit != 0 && time.Now().Before(legacyOpenUntil) {
+		err := p.service.db.ExtendQueuedIssueLease(ctx, syncWorkerId, key, time.Until(legacyOpenUntil))
+		if err != nil && !errors.Is(err, ErrLeaseLost) {
+			log.Printf("[ERROR] [queue] Error holding queued issue %s: %v", key, err)
+		}
+		return
+	}
+	err := p.service.db.ReleaseQueuedIssue(ctx, syncWorkerId, key)
+	if err != nil {
+		log.Printf("[ERROR] [queue] Error releasing queued issue %s: %v", key, err)
+	}
+}
+
+func (p *SyncPool) process(claim ClaimedIssue) {
+	ctx := context.Background()
+	service := p.service
+	key := claim.Key
+	t0 := time.Now()
+	result := "refreshed"
+	defer func() {
+		syncJobDuration.WithLabelValues(claim.Reason, result).Observe(time.Since(t0).Seconds())
+		syncJobs.WithLabelValues(claim.Reason, result).Inc()
+	}()
+
+	stopRenewing := p.renewLease(key)
+	issue, diff, err := service.RefreshIssue(ctx, key)
+	stopRenewing()
+	if issue == nil && err == nil {
+		result = "up-to-date"
+	}
+	if err != nil {
+		if errors.Is(err, model.ErrIssueRemoved) {
+			result = "removed"
+			log.Printf("[queue] Detected removed issue %s", key)
+			service.db.MarkIssueRemoved(key)
+		} else if errors.Is(err, model.ErrIssueNotFound) && slices.Contains(probeReasons, claim.Reason) {
+			result = "not-found"
+		} else if errors.Is(err, model.ErrIssueNotFound) && claim.ScanJobId != nil {
+			// Scans walk over key ranges, so like a probe they often ask for keys that don't exist
+			result = "not-found"
+			err = service.db.RecordKeyProbe(ctx, key, claim.Reason)
+			if err != nil {
+				log.Printf("[ERROR] [queue] Error recording probe of %s: %v", key, err)
+			}
+		} else if errors.Is(err, api.ErrCircuitOpen) || errors.Is(err, api.ErrRateLimited) {
+			// The source is unavailable, which says nothing about this key
+			result = "paused"
+			p.release(ctx, key)
+			return
+		} else {
+			result = "failed"
+			err = service.db.RetryQueuedIssue(ctx, syncWorkerId, key, err.Error())
+			if errors.Is(err, ErrLeaseLost) {
+				log.Printf("[queue] Lease of %s expired before it was retried", key)
+			} else if err != nil {
+				log.Printf("[ERROR] [queue] Error retrying queued issue %s: %v", key, err)
+			}
+			return
+		}
+	} else {
+		log.Printf("[queue] Refreshed issue %s", key)
+		processChanges(service, ctx, diff)
+	}
+	err = service.db.DeleteQueuedIssue(ctx, syncWorkerId, key)
+	if errors.Is(err, ErrLeaseLost) {
+		log.Printf("[queue] Lease of %s expired before it was processed", key)
+	} else if err != nil {
+		log.Printf("[ERROR] [queue] Error deleting queued issue %s: %v", key, err)
+	}
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"mojira/api"
+	"mojira/model"
+	"testing"
+	"time"
+)
+
+func newSyncPoolTest(t *testing.T) (*MemoryStore, *SyncPool) {
+	workerId := syncWorkerId
+	syncWorkerId = "test-worker"
+	t.Cleanup(func() { syncWorkerId = workerId })
+	store := NewMemoryStore()
+	service, _ := newFakeTrackerService(t, store)
+	// Without SetConfig no workers are started, the test processes the claimed keys itself
+	return store, &SyncPool{service: service}
+}
+
+// Claims the queued key and processes it like a worker would
+func processQueued(t *testing.T, store *MemoryStore, pool *SyncPool, key string) {
+	t.Helper()
+	claimed, err := store.ClaimQueuedIssues(context.Background(), syncWorkerId, 1, queueLeaseDuration)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(claimed) != 1 || claimed[0].Key != key {
+		t.Fatalf("expected to claim %s, got %v", key, claimed)
+	}
+	pool.process(claimed[0])
+}
+
+func queueEntry(store *MemoryStore, key string) *memoryQueueEntry {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	return store.queue[key]
+}
+
+func TestSyncPoolProcess(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("refreshed", func(t *testing.T) {
+		store, pool := newSyncPoolTest(t)
+		store.QueueIssueKeys([]string{"MC-4"}, 5, "update-feed")
+		processQueued(t, store, pool, "MC-4")
+
+		issue, err := store.GetIssueByKey("MC-4")
+		if err != nil || issue.Summary != "Item drops appear at the wrong position" || issue.SyncedDate == nil {
+			t.Fatalf("expected MC-4 to be stored, got %v", err)
+		}
+		if queueEntry(store, "MC-4") != nil {
+			t.Error("expected MC-4 to be removed from the queue")
+		}
+	})
+
+	t.Run("removed", func(t *testing.T) {
+		store, pool := newSyncPoolTest(t)
+		synced := time.Now().Add(-time.Hour)
+		store.UpdateIssue(ctx, &model.Issue{Key: "MC-5", Summary: "Gone", CreatedDate: &synced, SyncedDate: &synced}, nil)
+		store.QueueIssueKeys([]string{"MC-5"}, 5, "update-feed")
+		processQueued(t, store, pool, "MC-5")
+
+		if _, err := store.GetIssueByKey("MC-5"); err != model.ErrIssueRemoved {
+			t.Errorf("expected MC-5 to be marked as removed, got %v", err)
+		}
+		if queueEntry(store, "MC-5") != nil {
+			t.Error("expected MC-5 to be removed from the queue")
+		}
+	})
+
+	t.Run("dead letter", func(t *testing.T) {
+		store, pool := newSyncPoolTest(t)
+		// Only the servicedesk knows MC-280000, a partial issue can't be stored
+		store.QueueIssueKeys([]string{"MC-280000"}, 5, "update-feed")
+		for attempt := 1; attempt <= 5; attempt++ {
+			processQueued(t, store, pool, "MC-280000")
+			entry := queueEntry(store, "MC-280000")
+			if attempt < 5 {
+				if entry == nil || entry.FailedCount != attempt || entry.ClaimedBy != "" || !entry.RetryAfter.After(time.Now()) {
+					t.Fatalf("attempt %d: expected the key to be retried later, got %+v", attempt, entry)
+				}
+				store.mu.Lock()
+				past := time.Now().Add(-time.Second)
+				entry.RetryAfter = &past
+				store.mu.Unlock()
+			} else if entry != nil {
+				t.Fatalf("expected the key to leave the queue after %d failures", attempt)
+			}
+		}
+		dead, _, _ := store.GetDeadLetters(ctx, 10)
+		if len(dead) != 1 || dead[0].Key != "MC-280000" || dead[0].FailedCount != 5 || dead[0].LastError == "" {
+			t.Errorf("expected MC-280000 in the dead letters, got %+v", dead)
+		}
+	})
+
+	t.Run("not found probe", func(t *testing.T) {
+		store, pool := newSyncPoolTest(t)
+		store.QueueProbeKeys(ctx, []string{"MC-6"}, 2, "gap", time.Now().Add(-gapProbeRecheck))
+		processQueued(t, store, pool, "MC-6")
+
+		if queueEntry(store, "MC-6") != nil {
+			t.Error("expected the probed key to be dropped from the queue")
+		}
+		if dead, _, _ := store.GetDeadLetters(ctx, 10); len(dead) != 0 {
+			t.Errorf("expected no dead letters, got %+v", dead)
+		}
+		if _, err := store.GetIssueByKey("MC-6"); err != model.ErrIssueNotStored {
+			t.Errorf("expected MC-6 not to be stored, got %v", err)
+		}
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		store, pool := newSyncPoolTest(t)
+		// A key that isn't a probe is expected to exist, so it is retried
+		store.QueueIssueKeys([]string{"MC-6"}, 10, "update-feed")
+		processQueued(t, store, pool, "MC-6")
+
+		if entry := queueEntry(store, "MC-6"); entry == nil || entry.FailedCount != 1 {
+			t.Errorf("expected MC-6 to be retried, got %+v", entry)
+		}
+	})
+
+	t.Run("not found scan", func(t *testing.T) {
+		store, pool := newSyncPoolTest(t)
+		rangeEnd := 6
+		id, _ := store.CreateScanJob(ctx, ScanJob{Project: "MC", RangeStart: 6, RangeEnd: &rangeEnd, Predicate: "missing", Priority: 3, Reason: "scan", ChunkSize: 10})
+		if queued, _ := store.AdvanceScanJob(ctx, id); queued != 1 {
+			t.Fatalf("expected the scan to queue MC-6, queued %d", queued)
+		}
+		processQueued(t, store, pool, "MC-6")
+
+		if queueEntry(store, "MC-6") != nil {
+			t.Error("expected the scanned key to be dropped from the queue")
+		}
+		if dead, _, _ := store.GetDeadLetters(ctx, 10); len(dead) != 0 {
+			t.Errorf("expected no dead letters, got %+v", dead)
+		}
+		if queued, _ := store.QueueProbeKeys(ctx, []string{"MC-6"}, 2, "gap", time.Now().Add(-gapProbeRecheck)); len(queued) != 0 {
+			t.Errorf("expected the scanned key to count as probed, queued %v", queued)
+		}
+	})
+
+	t.Run("legacy circuit open", func(t *testing.T) {
+		store, pool := newSyncPoolTest(t)
+		legacy := pool.service.legacy.Upstream().Breaker()
+		legacy.Record(api.ErrRateLimited)
+		if limit, _ := pool.circuitLimit(); limit != -1 {
+			t.Errorf("expected the legacy circuit not to limit the queue, got %d", limit)
+		}
+
+		// MC-4 was created before the migration, so it is held until the legacy circuit may close
+		store.QueueIssueKeys([]string{"MC-4"}, 5, "update-feed")
+		store.QueueIssueKeys([]string{"MC-280000"}, 4, "update-feed")
+		processQueued(t, store, pool, "MC-4")
+		entry := queueEntry(store, "MC-4")
+		if entry == nil || entry.FailedCount != 0 || entry.ClaimedBy != syncWorkerId || entry.LeaseUntil.Before(legacy.OpenUntil()) {
+			t.Fatalf("expected MC-4 to stay claimed until the circuit may close, got %+v", entry)
+		}
+		claimed, _ := store.ClaimQueuedIssues(ctx, syncWorkerId, 10, queueLeaseDuration)
+		if len(claimed) != 1 || claimed[0].Key != "MC-280000" {
+			t.Errorf("expected only MC-280000 to be claimable, got %v", claimed)
+		}
+	})
+
+	t.Run("servicedesk circuit open", func(t *testing.T) {
+		store, pool := newSyncPoolTest(t)
+		pool.service.serviceDesk.Upstream().Breaker().Record(api.ErrRateLimited)
+		if limit, _ := pool.circuitLimit(); limit != 0 {
+			t.Errorf("expected the servicedesk circuit to pause the queue, got %d", limit)
+		}
+
+		store.QueueIssueKeys([]string{"MC-4"}, 5, "update-feed")
+		processQueued(t, store, pool, "MC-4")
+		if entry := queueEntry(store, "MC-4"); entry == nil || entry.FailedCount != 0 || entry.ClaimedBy != "" {
+			t.Errorf("expected MC-4 to be released without counting a failure, got %+v", entry)
+		}
+	})
+}
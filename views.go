@@ -1,12 +1,15 @@
 package main
 
 import (
+	"crypto/subtle"
+	"database/sql"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"html/template"
 	"log"
 	"maps"
+	"mojira/jql"
 	"mojira/model"
 	"net/http"
 	"net/url"
@@ -45,6 +48,13 @@ func render(w http.ResponseWriter, name string, data any) {
 			return a + b
 		},
 		"urlPathEscape": url.PathEscape,
+		"urlHost": func(s string) string {
+			u, err := url.Parse(s)
+			if err != nil {
+				return ""
+			}
+			return u.Host
+		},
 	}).ParseFiles("templates/base.html", "templates/common.html", fmt.Sprintf("templates/%s", name))
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -98,38 +108,44 @@ func issueRedirectHandler(w http.ResponseWriter, r *http.Request) {
 func indexHandler(service *IssueService) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		query := r.URL.Query()
-		search := query.Get("search")
-		project := query.Get("project")
-		status := query.Get("status")
-		confirmation := query.Get("confirmation")
-		resolution := query.Get("resolution")
-		priority := query.Get("priority")
-		reporter := query.Get("reporter")
-		assignee := query.Get("assignee")
-		affected_version := query.Get("affected_version")
-		fix_version := query.Get("fix_version")
-		category := query.Get("category")
-		label := query.Get("label")
-		component := query.Get("component")
-		platform := query.Get("platform")
-		area := query.Get("area")
-		sort := query.Get("sort")
+		filter := parseIssueFilter(query)
 		page, err := strconv.Atoi(query.Get("page"))
 		if err != nil {
 			page = 1
 		}
 		page = max(page, 1)
-		offset := (page - 1) * issuePageSize
+		cursor, err := ParseIssueCursor(query.Get("cursor"))
+		if err != nil || page == 1 {
+			cursor = nil
+		}
+		if cursor == nil && page > 1 {
+			// Links from before cursors were introduced only have a page number
+			cursor = &IssueCursor{Offset: (page - 1) * issuePageSize}
+		}
 
 		t0 := time.Now()
-		issues, count, err := service.db.FilterIssues(search, project, status, confirmation, resolution, priority, reporter, assignee, affected_version, fix_version, category, label, component, platform, area, sort, offset, issuePageSize)
+		result, err := service.db.FilterIssues(filter, cursor, issuePageSize)
+		if errors.Is(err, ErrInvalidCursor) {
+			page = 1
+			result, err = service.db.FilterIssues(filter, nil, issuePageSize)
+		}
 		t1 := time.Now()
 		if t1.Sub(t0) > time.Duration(4)*time.Second {
-			log.Printf("[WARNING] Slow filter! %s: project=%s status=%s confirmation=%s resolution=%s priority=%s sort=%s search=%s", t1.Sub(t0), project, status, confirmation, resolution, priority, sort, search)
+			log.Printf("[WARNING] Slow filter! %s: project=%s status=%s confirmation=%s resolution=%s priority=%s sort=%s search=%s query=%s", t1.Sub(t0), filter.Project, filter.Status, filter.Confirmation, filter.Resolution, filter.Priority, filter.Sort, filter.Search, filter.Query)
 		}
-		if err != nil {
+		var queryErr *jql.Error
+		if errors.As(err, &queryErr) {
+			result = &IssuePage{Issues: []model.Issue{}}
+		} else if err != nil {
 			log.Printf("[ERROR] FilterIssues: %s", err)
-			issues = []model.Issue{}
+			result = &IssuePage{Issues: []model.Issue{}}
+		}
+		var nextCursor, prevCursor string
+		if result.Next != nil {
+			nextCursor = result.Next.String()
+		}
+		if result.Prev != nil {
+			prevCursor = result.Prev.String()
 		}
 
 		outage, err := service.db.GetSyncOutage(r.Context())
@@ -148,6 +164,7 @@ func indexHandler(service *IssueService) http.HandlerFunc {
 				filtered["page"] = []string{strconv.Itoa(page)}
 			} else {
 				filtered["page"] = nil
+				filtered["cursor"] = nil
 			}
 			u := *r.URL
 			u.RawQuery = filtered.Encode()
@@ -160,15 +177,41 @@ func indexHandler(service *IssueService) http.HandlerFunc {
 			}
 		}
 		render(w, "pages/index", map[string]any{
-			"Issues": issues,
-			"Count":  count,
-			"Query":  queryMap,
-			"Page":   page,
-			"Outage": outage,
+			"Issues":     result.Issues,
+			"Count":      result.Count,
+			"Query":      queryMap,
+			"Page":       page,
+			"NextCursor": nextCursor,
+			"PrevCursor": prevCursor,
+			"Outage":     outage,
+			"QueryError": queryErr,
+			"FeedUrl":    siteQueryUrl("/feed", query, "page", "cursor"),
 		})
 	}
 }
 
+func parseIssueFilter(query url.Values) IssueFilter {
+	return IssueFilter{
+		Search:          query.Get("search"),
+		Project:         query.Get("project"),
+		Status:          query.Get("status"),
+		Confirmation:    query.Get("confirmation"),
+		Resolution:      query.Get("resolution"),
+		Priority:        query.Get("priority"),
+		Reporter:        query.Get("reporter"),
+		Assignee:        query.Get("assignee"),
+		AffectedVersion: query.Get("affected_version"),
+		FixVersion:      query.Get("fix_version"),
+		Category:        query.Get("category"),
+		Label:           query.Get("label"),
+		Component:       query.Get("component"),
+		Platform:        query.Get("platform"),
+		Area:            query.Get("area"),
+		Query:           query.Get("query"),
+		Sort:            query.Get("sort"),
+	}
+}
+
 func issueHandler(service *IssueService) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		key := r.PathValue("key")
@@ -185,6 +228,9 @@ func issueHandler(service *IssueService) http.HandlerFunc {
 			})
 			return
 		}
+		if issueNotModified(w, r, service.cache, "html", issue) {
+			return
+		}
 		render(w, "pages/issue", map[string]any{
 			"Issue": issue,
 		})
@@ -269,6 +315,57 @@ func apiUserCommentsHandler(service *IssueService) http.HandlerFunc {
 	}
 }
 
+var activityPageSize = 50
+
+func activityHandler(service *IssueService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		project := query.Get("project")
+		typ := query.Get("type")
+		version := query.Get("version")
+		before, err := strconv.Atoi(query.Get("before"))
+		if err != nil {
+			before = 0
+		}
+		events, err := service.db.GetActivity(r.Context(), project, typ, version, before, activityPageSize+1)
+		if err != nil {
+			log.Printf("[ERROR] GetActivity: %s", err)
+			events = []model.ActivityEvent{}
+		}
+		nextBefore := 0
+		if len(events) > activityPageSize {
+			events = events[:activityPageSize]
+			nextBefore = events[len(events)-1].Id
+		}
+		queryMap := make(map[string]string)
+		for k, v := range query {
+			if len(v) > 0 {
+				queryMap[k] = v[0]
+			}
+		}
+		name := "pages/activity"
+		if before > 0 {
+			name = "partials/activity_events"
+		} else if r.Header.Get("Hx-Request") != "" {
+			filtered := url.Values{}
+			for k, v := range query {
+				if len(v) > 0 && v[0] != "" {
+					filtered[k] = v
+				}
+			}
+			u := *r.URL
+			u.RawQuery = filtered.Encode()
+			w.Header().Add("Hx-Replace-Url", u.String())
+		}
+		render(w, name, map[string]any{
+			"Events":     events,
+			"Types":      model.ActivityTypes,
+			"Query":      queryMap,
+			"NextBefore": nextBefore,
+		})
+	}
+}
+
 func queueOverviewHandler(service *IssueService) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
@@ -277,9 +374,238 @@ func queueOverviewHandler(service *IssueService) http.HandlerFunc {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
+		deadLetterCount, err := service.db.GetDeadLetterSize(ctx)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		scanJobs, err := service.db.GetScanJobs(ctx)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
 		render(w, "pages/queue", map[string]any{
-			"Queue": queue,
-			"Count": count,
+			"Queue":           queue,
+			"Count":           count,
+			"DeadLetterCount": deadLetterCount,
+			"ScanJobs":        scanJobs,
+			"ServiceDesk":     service.serviceDesk.Health(),
+			"Upstreams":       service.upstreams(),
+		})
+	}
+}
+
+func deadLetterHandler(service *IssueService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		deadLetters, count, err := service.db.GetDeadLetters(r.Context(), 100)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		render(w, "pages/dead_letter", map[string]any{
+			"DeadLetters": deadLetters,
+			"Count":       count,
+		})
+	}
+}
+
+func deadLetterRequeueHandler(service *IssueService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		err := r.ParseForm()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var keys []string
+		if r.PostForm.Get("all") == "" {
+			keys = r.PostForm["key"]
+			if len(keys) == 0 {
+				http.Error(w, "Missing key", http.StatusBadRequest)
+				return
+			}
+		}
+		requeued, err := service.db.RequeueDeadLetters(r.Context(), keys)
+		if err != nil {
+			log.Printf("[ERROR] [queue] Error re-queueing dead letters: %v", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		log.Printf("[queue] Re-queued %d dead letters: %s", len(requeued), strings.Join(requeued, ", "))
+		updateMetric(service, r.Context())
+		http.Redirect(w, r, "/admin/dead-letter", http.StatusSeeOther)
+	}
+}
+
+func scanJobsHandler(service *IssueService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		jobs, err := service.db.GetScanJobs(r.Context())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		predicates := slices.Sorted(maps.Keys(scanPredicates))
+		render(w, "pages/scan_jobs", map[string]any{
+			"ScanJobs":   jobs,
+			"Projects":   projects,
+			"Predicates": predicates,
+		})
+	}
+}
+
+func scanJobCreateHandler(service *IssueService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		err := r.ParseForm()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		form := r.PostForm
+		job := ScanJob{
+			Project:   form.Get("project"),
+			Predicate: form.Get("predicate"),
+			Reason:    form.Get("reason"),
+		}
+		if !slices.Contains(projects, job.Project) {
+			http.Error(w, "Unknown project", http.StatusBadRequest)
+			return
+		}
+		if _, ok := scanPredicates[job.Predicate]; !ok {
+			http.Error(w, "Unknown predicate", http.StatusBadRequest)
+			return
+		}
+		if job.Reason == "" || len(job.Reason) > 32 {
+			http.Error(w, "Invalid reason", http.StatusBadRequest)
+			return
+		}
+		job.RangeStart, err = strconv.Atoi(form.Get("range_start"))
+		if err != nil || job.RangeStart < 1 {
+			http.Error(w, "Invalid range start", http.StatusBadRequest)
+			return
+		}
+		if value := form.Get("range_end"); value != "" {
+			rangeEnd, err := strconv.Atoi(value)
+			if err != nil || rangeEnd < job.RangeStart {
+				http.Error(w, "Invalid range end", http.StatusBadRequest)
+				return
+			}
+			job.RangeEnd = &rangeEnd
+		}
+		job.Priority, err = strconv.Atoi(form.Get("priority"))
+		if err != nil {
+			http.Error(w, "Invalid priority", http.StatusBadRequest)
+			return
+		}
+		job.ChunkSize, err = strconv.Atoi(form.Get("chunk_size"))
+		if err != nil || job.ChunkSize < 1 {
+			http.Error(w, "Invalid chunk size", http.StatusBadRequest)
+			return
+		}
+		job.RepeatHours, err = strconv.Atoi(form.Get("repeat_hours"))
+		if err != nil || job.RepeatHours < 0 {
+			http.Error(w, "Invalid repeat hours", http.StatusBadRequest)
+			return
+		}
+		id, err := service.db.CreateScanJob(r.Context(), job)
+		if err != nil {
+			log.Printf("[ERROR] [scan] Error creating scan job: %v", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		log.Printf("[scan] Created scan job %d (%s %s)", id, job.Predicate, job.Project)
+		http.Redirect(w, r, "/admin/scan-jobs", http.StatusSeeOther)
+	}
+}
+
+func scanJobUpdateHandler(service *IssueService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(r.PathValue("id"))
+		if err != nil {
+			http.Error(w, "Invalid id", http.StatusBadRequest)
+			return
+		}
+		err = r.ParseForm()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		switch r.PostForm.Get("action") {
+		case "enable":
+			err = service.db.SetScanJobEnabled(r.Context(), id, true)
+		case "disable":
+			err = service.db.SetScanJobEnabled(r.Context(), id, false)
+		case "delete":
+			err = service.db.DeleteScanJob(r.Context(), id)
+		default:
+			http.Error(w, "Unknown action", http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		http.Redirect(w, r, "/admin/scan-jobs", http.StatusSeeOther)
+	}
+}
+
+func syncPoolHandler(pool *SyncPool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		render(w, "pages/sync", map[string]any{
+			"WorkerId": syncWorkerId,
+			"Config":   pool.Config(),
+			"Sources":  syncSources,
+			"InFlight": pool.InFlight(),
+		})
+	}
+}
+
+func syncPoolUpdateHandler(pool *SyncPool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		err := r.ParseForm()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		config := pool.Config()
+		config.Workers, err = strconv.Atoi(r.PostForm.Get("workers"))
+		if err != nil || config.Workers < 1 {
+			http.Error(w, "Invalid workers", http.StatusBadRequest)
+			return
+		}
+		config.BatchSize, err = strconv.Atoi(r.PostForm.Get("batch_size"))
+		if err != nil || config.BatchSize < 1 {
+			http.Error(w, "Invalid batch size", http.StatusBadRequest)
+			return
+		}
+		for _, source := range syncSources {
+			rate, err := parseRate(r.PostForm.Get("rate_" + source))
+			if err != nil {
+				http.Error(w, "Invalid rate for "+source, http.StatusBadRequest)
+				return
+			}
+			config.Rates[source] = rate
+		}
+		pool.SetConfig(config)
+		log.Printf("[queue] Updated sync pool: %d workers, batch size %d, rates %v", config.Workers, config.BatchSize, config.Rates)
+		http.Redirect(w, r, "/admin/sync", http.StatusSeeOther)
+	}
+}
+
+func webhooksHandler(service *IssueService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		webhooks, err := service.db.GetWebhooks(ctx)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		deliveries, err := service.db.GetWebhookDeliveries(ctx, 100)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		render(w, "pages/webhooks", map[string]any{
+			"Webhooks":   webhooks,
+			"Deliveries": deliveries,
 		})
 	}
 }
@@ -312,7 +638,7 @@ func apiSearchHandler(service *IssueService) http.HandlerFunc {
 func apiRefreshHandler(service *IssueService) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		key := r.PathValue("key")
-		issue, err := service.RefreshIssue(r.Context(), key)
+		issue, diff, err := service.RefreshIssue(r.Context(), key)
 		if err != nil {
 			if errors.Is(err, model.ErrIssueRemoved) {
 				render(w, "pages/issue_removed", map[string]any{
@@ -329,6 +655,7 @@ func apiRefreshHandler(service *IssueService) http.HandlerFunc {
 		if issue == nil {
 			return
 		}
+		processChanges(service, r.Context(), diff)
 		render(w, "pages/issue", map[string]any{
 			"Issue":     issue,
 			"IsRefresh": true,
@@ -336,33 +663,71 @@ func apiRefreshHandler(service *IssueService) http.HandlerFunc {
 	}
 }
 
+func apiHistoryHandler(service *IssueService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.PathValue("key")
+		history, err := service.db.GetIssueHistory(r.Context(), key)
+		if err != nil {
+			log.Printf("[ERROR] GetIssueHistory %s: %s", key, err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		render(w, "partials/issue_history", map[string]any{
+			"History": history,
+		})
+	}
+}
+
 type V1Issue = struct {
-	Key                string     `json:"key"`
-	Summary            string     `json:"summary"`
-	ReporterName       *string    `json:"reporter_name"`
-	ReporterAvatar     *string    `json:"reporter_avatar"`
-	AssigneeName       *string    `json:"assignee_name"`
-	AssigneeAvatar     *string    `json:"assignee_avatar"`
-	Description        *string    `json:"description"`
-	Environment        *string    `json:"environment"`
-	Labels             []string   `json:"labels"`
-	CreatedDate        *time.Time `json:"created_date"`
-	UpdatedDate        *time.Time `json:"updated_date"`
-	ResolvedDate       *time.Time `json:"resolved_date"`
-	Status             *string    `json:"status"`
-	ConfirmationStatus string     `json:"confirmation_status"`
-	Resolution         string     `json:"resolution"`
-	AffectedVersions   []string   `json:"affected_versions"`
-	FixVersions        []string   `json:"fix_versions"`
-	Category           []string   `json:"category"`
-	MojangPriority     *string    `json:"mojang_priority"`
-	Area               *string    `json:"area"`
-	Components         []string   `json:"components"`
-	Platform           *string    `json:"platform"`
-	OSVersion          *string    `json:"os_version"`
-	RealmsPlatform     *string    `json:"realms_platform"`
-	ADO                *string    `json:"ado"`
-	Votes              int        `json:"votes"`
+	Key                string         `json:"key"`
+	Summary            string         `json:"summary"`
+	ReporterName       *string        `json:"reporter_name"`
+	ReporterAvatar     *string        `json:"reporter_avatar"`
+	AssigneeName       *string        `json:"assignee_name"`
+	AssigneeAvatar     *string        `json:"assignee_avatar"`
+	Description        *string        `json:"description"`
+	Environment        *string        `json:"environment"`
+	Labels             []string       `json:"labels"`
+	CreatedDate        *time.Time     `json:"created_date"`
+	UpdatedDate        *time.Time     `json:"updated_date"`
+	ResolvedDate       *time.Time     `json:"resolved_date"`
+	Status             *string        `json:"status"`
+	ConfirmationStatus string         `json:"confirmation_status"`
+	Resolution         string         `json:"resolution"`
+	AffectedVersions   []string       `json:"affected_versions"`
+	FixVersions        []string       `json:"fix_versions"`
+	Category           []string       `json:"category"`
+	MojangPriority     *string        `json:"mojang_priority"`
+	Area               *string        `json:"area"`
+	Components         []string       `json:"components"`
+	Platform           *string        `json:"platform"`
+	OSVersion          *string        `json:"os_version"`
+	RealmsPlatform     *string        `json:"realms_platform"`
+	ADO                *string        `json:"ado"`
+	Votes              int            `json:"votes"`
+	LegacyVotes        int            `json:"legacy_votes"`
+	CreatorName        *string        `json:"creator_name"`
+	SyncedDate         *time.Time     `json:"synced_date"`
+	CommentCount       *int           `json:"comment_count"`
+	Links              []V1Link       `json:"links"`
+	Attachments        []V1Attachment `json:"attachments"`
+}
+
+type V1Link = struct {
+	Type    string  `json:"type"`
+	Key     string  `json:"key"`
+	Summary string  `json:"summary"`
+	Status  *string `json:"status"`
+}
+
+type V1Attachment = struct {
+	Id          string     `json:"id"`
+	Filename    string     `json:"filename"`
+	AuthorName  *string    `json:"author_name"`
+	CreatedDate *time.Time `json:"created_date"`
+	Size        int64      `json:"size"`
+	MimeType    string     `json:"mime_type"`
+	Url         string     `json:"url"`
 }
 
 func apiField(value string) *string {
@@ -391,7 +756,7 @@ func newV1Issue(issue *model.Issue) V1Issue {
 			components = append(components, v)
 		}
 	}
-	return V1Issue{
+	result := V1Issue{
 		Key:                issue.Key,
 		Summary:            issue.Summary,
 		ReporterName:       apiField(issue.ReporterName),
@@ -418,7 +783,41 @@ func newV1Issue(issue *model.Issue) V1Issue {
 		RealmsPlatform:     apiField(issue.RealmsPlatform),
 		ADO:                apiField(issue.ADO),
 		Votes:              issue.LegacyVotes + issue.Votes,
+		LegacyVotes:        issue.LegacyVotes,
+		CreatorName:        apiField(issue.CreatorName),
+		SyncedDate:         issue.SyncedDate,
 	}
+	// Comments, links and attachments are only loaded for single issues, they stay null in lists
+	if issue.Comments != nil {
+		count := len(issue.Comments)
+		result.CommentCount = &count
+	}
+	if issue.Links != nil {
+		result.Links = make([]V1Link, 0, len(issue.Links))
+		for _, l := range issue.Links {
+			result.Links = append(result.Links, V1Link{
+				Type:    l.Type,
+				Key:     l.OtherKey,
+				Summary: l.OtherSummary,
+				Status:  apiField(l.OtherStatus),
+			})
+		}
+	}
+	if issue.Attachments != nil {
+		result.Attachments = make([]V1Attachment, 0, len(issue.Attachments))
+		for _, a := range issue.Attachments {
+			result.Attachments = append(result.Attachments, V1Attachment{
+				Id:          a.Id,
+				Filename:    a.Filename,
+				AuthorName:  apiField(a.AuthorName),
+				CreatedDate: a.CreatedDate,
+				Size:        a.Size,
+				MimeType:    a.MimeType,
+				Url:         a.GetUrl(),
+			})
+		}
+	}
+	return result
 }
 
 func apiV1Issue(service *IssueService) http.HandlerFunc {
@@ -432,6 +831,10 @@ func apiV1Issue(service *IssueService) http.HandlerFunc {
 			}
 			log.Printf("[ERROR] API /v1/issues/%s: %s", key, err)
 			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		if issueNotModified(w, r, service.cache, "json", issue) {
+			return
 		}
 		w.Header().Set("Content-Type", "application/json")
 		result := newV1Issue(issue)
@@ -443,6 +846,341 @@ func apiV1Issue(service *IssueService) http.HandlerFunc {
 	}
 }
 
+var searchSortOptions = []string{"Created", "Updated", "Resolved", "Priority", "Votes", "Comments", "Duplicates"}
+var maxSearchPageSize = 100
+
+type V1SearchResult = struct {
+	Total      int       `json:"total"`
+	Issues     []V1Issue `json:"issues"`
+	NextCursor *string   `json:"next_cursor"`
+	PrevCursor *string   `json:"prev_cursor"`
+}
+
+func apiV1Search(service *IssueService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		filter := parseIssueFilter(query)
+		if filter.Sort != "" && !slices.Contains(searchSortOptions, filter.Sort) {
+			http.Error(w, "Unknown sort, expected one of "+strings.Join(searchSortOptions, ", "), http.StatusBadRequest)
+			return
+		}
+		cursor, err := ParseIssueCursor(query.Get("cursor"))
+		if err != nil {
+			http.Error(w, "Invalid cursor", http.StatusBadRequest)
+			return
+		}
+		limit, err := strconv.Atoi(query.Get("limit"))
+		if err != nil {
+			limit = issuePageSize
+		}
+		limit = min(max(limit, 1), maxSearchPageSize)
+		page, err := service.db.FilterIssues(filter, cursor, limit)
+		var queryErr *jql.Error
+		if errors.As(err, &queryErr) {
+			http.Error(w, "Invalid query: "+queryErr.Error(), http.StatusBadRequest)
+			return
+		}
+		if errors.Is(err, ErrInvalidCursor) {
+			http.Error(w, "Cursor does not match the sort or query", http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			log.Printf("[ERROR] API /v1/search: %s", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		result := V1SearchResult{
+			Total:  page.Count,
+			Issues: make([]V1Issue, 0, len(page.Issues)),
+		}
+		for i := range page.Issues {
+			result.Issues = append(result.Issues, newV1Issue(&page.Issues[i]))
+		}
+		if page.Next != nil {
+			result.NextCursor = apiField(page.Next.String())
+		}
+		if page.Prev != nil {
+			result.PrevCursor = apiField(page.Prev.String())
+		}
+		w.Header().Set("Content-Type", "application/json")
+		err = json.NewEncoder(w).Encode(result)
+		if err != nil {
+			log.Printf("[ERROR] API /v1/search: %s", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		}
+	}
+}
+
+var commentFormats = []string{"adf", "html", "text"}
+
+type V1Comment = struct {
+	Id           string     `json:"id"`
+	LegacyId     *string    `json:"legacy_id"`
+	AuthorName   *string    `json:"author_name"`
+	AuthorAvatar *string    `json:"author_avatar"`
+	Date         *time.Time `json:"date"`
+	Format       string     `json:"format"`
+	Body         string     `json:"body"`
+	Url          string     `json:"url"`
+}
+
+func newV1Comment(c *model.Comment, format string) V1Comment {
+	var body string
+	switch format {
+	case "html":
+		body = string(c.Render())
+	case "text":
+		body = model.ExtractPlainTextFromADF(c.AdfComment)
+	default:
+		body = c.AdfComment
+	}
+	return V1Comment{
+		Id:           c.Id,
+		LegacyId:     apiField(c.LegacyId),
+		AuthorName:   apiField(c.AuthorName),
+		AuthorAvatar: apiField(c.AuthorAvatar),
+		Date:         c.Date,
+		Format:       format,
+		Body:         body,
+		Url:          fmt.Sprintf("%s/%s#%s", siteUrl, c.Issue.Key, c.Anchor()),
+	}
+}
+
+func apiV1IssueComments(service *IssueService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.PathValue("key")
+		format := r.URL.Query().Get("format")
+		if format == "" {
+			format = "adf"
+		}
+		if !slices.Contains(commentFormats, format) {
+			http.Error(w, "Unknown format, expected one of "+strings.Join(commentFormats, ", "), http.StatusBadRequest)
+			return
+		}
+		issue, err := service.GetIssue(r.Context(), key)
+		if err != nil {
+			if errors.Is(err, model.ErrIssueRemoved) || errors.Is(err, model.ErrIssueNotFound) {
+				http.Error(w, "Issue not found", http.StatusNotFound)
+				return
+			}
+			log.Printf("[ERROR] API /v1/issues/%s/comments: %s", key, err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		if issueNotModified(w, r, service.cache, "comments-"+format, issue) {
+			return
+		}
+		result := make([]V1Comment, 0, len(issue.Comments))
+		for i := range issue.Comments {
+			result = append(result, newV1Comment(&issue.Comments[i], format))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		err = json.NewEncoder(w).Encode(result)
+		if err != nil {
+			log.Printf("[ERROR] API /v1/issues/%s/comments: %s", key, err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		}
+	}
+}
+
+var maxBatchKeys = 500
+
+type V1BatchRequest = struct {
+	Keys         []string `json:"keys"`
+	QueueMissing bool     `json:"queue_missing"`
+}
+
+type V1BatchResult = struct {
+	Issues  []V1Issue `json:"issues"`
+	Missing []string  `json:"missing"`
+	Removed []string  `json:"removed"`
+	Invalid []string  `json:"invalid"`
+	Queued  []string  `json:"queued"`
+}
+
+func isIssueKey(key string) bool {
+	project, num, ok := strings.Cut(key, "-")
+	if !ok || model.PortalIds[project] == 0 {
+		return false
+	}
+	n, err := strconv.Atoi(num)
+	return err == nil && n > 0
+}
+
+func apiV1IssuesBatch(service *IssueService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req V1BatchRequest
+		err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64*1024)).Decode(&req)
+		if err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if len(req.Keys) > maxBatchKeys {
+			http.Error(w, fmt.Sprintf("Too many keys, the limit is %d", maxBatchKeys), http.StatusBadRequest)
+			return
+		}
+		result := V1BatchResult{
+			Missing: []string{},
+			Invalid: []string{},
+			Queued:  []string{},
+		}
+		var keys []string
+		for _, key := range req.Keys {
+			key = strings.ToUpper(strings.TrimSpace(key))
+			if !isIssueKey(key) {
+				result.Invalid = append(result.Invalid, key)
+			} else if !slices.Contains(keys, key) {
+				keys = append(keys, key)
+			}
+		}
+		issues, removed, err := service.db.GetIssuesByKeys(r.Context(), keys)
+		if err != nil {
+			log.Printf("[ERROR] API /v1/issues/batch: %s", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		result.Issues = make([]V1Issue, 0, len(issues))
+		for i := range issues {
+			result.Issues = append(result.Issues, newV1Issue(&issues[i]))
+		}
+		result.Removed = removed
+		for _, key := range keys {
+			found := slices.ContainsFunc(issues, func(issue model.Issue) bool { return issue.Key == key })
+			if !found && !slices.Contains(removed, key) {
+				result.Missing = append(result.Missing, key)
+			}
+		}
+		if req.QueueMissing && len(result.Missing) > 0 {
+			// Anyone can ask for any key, so the keys are probed like gaps: once a week, and dropped when they don't exist
+			queued, err := service.db.QueueProbeKeys(r.Context(), result.Missing, 2, "api-batch", time.Now().Add(-gapProbeRecheck))
+			if err != nil {
+				log.Printf("[ERROR] API /v1/issues/batch queue: %s", err)
+			}
+			result.Queued = append(result.Queued, queued...)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		err = json.NewEncoder(w).Encode(result)
+		if err != nil {
+			log.Printf("[ERROR] API /v1/issues/batch: %s", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		}
+	}
+}
+
+type V1FieldChange = struct {
+	Field       string     `json:"field"`
+	OldValue    *string    `json:"old_value"`
+	NewValue    *string    `json:"new_value"`
+	ChangedDate *time.Time `json:"changed_date"`
+}
+
+func apiV1IssueHistory(service *IssueService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.PathValue("key")
+		_, err := service.db.GetIssueForSync(key)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				http.Error(w, "Issue not found", http.StatusNotFound)
+				return
+			}
+			log.Printf("[ERROR] API /v1/issues/%s/history: %s", key, err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		history, err := service.db.GetIssueHistory(r.Context(), key)
+		if err != nil {
+			log.Printf("[ERROR] API /v1/issues/%s/history: %s", key, err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		result := make([]V1FieldChange, 0, len(history))
+		for _, change := range history {
+			result = append(result, V1FieldChange{
+				Field:       change.Field,
+				OldValue:    apiField(change.OldValue),
+				NewValue:    apiField(change.NewValue),
+				ChangedDate: change.Date,
+			})
+		}
+		w.Header().Set("Content-Type", "application/json")
+		err = json.NewEncoder(w).Encode(result)
+		if err != nil {
+			log.Printf("[ERROR] API /v1/issues/%s/history: %s", key, err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		}
+	}
+}
+
+type V1ActivityEvent = struct {
+	Id          int        `json:"id"`
+	IssueKey    string     `json:"issue_key"`
+	Project     string     `json:"project"`
+	Summary     string     `json:"summary"`
+	Type        string     `json:"type"`
+	OldValue    *string    `json:"old_value"`
+	NewValue    *string    `json:"new_value"`
+	AuthorName  *string    `json:"author_name"`
+	CommentLink *string    `json:"comment_link"`
+	Versions    []string   `json:"versions"`
+	Date        *time.Time `json:"date"`
+}
+
+func newV1ActivityEvent(e *model.ActivityEvent) V1ActivityEvent {
+	var commentLink *string
+	if e.Anchor != "" {
+		commentLink = apiField(fmt.Sprintf("%s/%s#%s", siteUrl, e.IssueKey, e.Anchor))
+	}
+	return V1ActivityEvent{
+		Id:          e.Id,
+		IssueKey:    e.IssueKey,
+		Project:     e.Project(),
+		Summary:     e.Summary,
+		Type:        e.Type,
+		OldValue:    apiField(e.OldValue),
+		NewValue:    apiField(e.NewValue),
+		AuthorName:  apiField(e.AuthorName),
+		CommentLink: commentLink,
+		Versions:    e.Versions,
+		Date:        e.Date,
+	}
+}
+
+func apiV1Activity(service *IssueService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		typ := query.Get("type")
+		if typ != "" && !slices.Contains(model.ActivityTypes, typ) {
+			http.Error(w, "Unknown event type", http.StatusBadRequest)
+			return
+		}
+		before, err := strconv.Atoi(query.Get("before"))
+		if err != nil {
+			before = 0
+		}
+		limit, err := strconv.Atoi(query.Get("limit"))
+		if err != nil {
+			limit = activityPageSize
+		}
+		limit = min(max(limit, 1), 200)
+		events, err := service.db.GetActivity(r.Context(), query.Get("project"), typ, query.Get("version"), before, limit)
+		if err != nil {
+			log.Printf("[ERROR] API /v1/activity: %s", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		result := make([]V1ActivityEvent, 0, len(events))
+		for i := range events {
+			result = append(result, newV1ActivityEvent(&events[i]))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		err = json.NewEncoder(w).Encode(result)
+		if err != nil {
+			log.Printf("[ERROR] API /v1/activity: %s", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		}
+	}
+}
+
 func metricsHandler(w http.ResponseWriter, r *http.Request) {
 	auth := r.Header.Get("Authorization")
 	metricsToken := os.Getenv("METRICS_TOKEN")
@@ -453,6 +1191,41 @@ func metricsHandler(w http.ResponseWriter, r *http.Request) {
 	promhttp.Handler().ServeHTTP(w, r)
 }
 
+// Whether a browser sent the request from another site. Browsers send basic auth along with any form posted to the admin pages,
+// so changes are only accepted from the admin pages themselves. Requests without these headers don't come from a browser
+func isCrossOrigin(r *http.Request) bool {
+	switch r.Header.Get("Sec-Fetch-Site") {
+	case "same-origin", "none":
+		return false
+	case "":
+	default:
+		return true
+	}
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return false
+	}
+	u, err := url.Parse(origin)
+	return err != nil || u.Host != r.Host
+}
+
+func requireAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		adminToken := os.Getenv("ADMIN_TOKEN")
+		_, password, ok := r.BasicAuth()
+		if adminToken == "" || !ok || subtle.ConstantTimeCompare([]byte(password), []byte(adminToken)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Basic realm="mojira.dev admin"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if r.Method != http.MethodGet && r.Method != http.MethodHead && isCrossOrigin(r) {
+			http.Error(w, "Cross-origin request rejected", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
 func formatTime(t any) string {
 	switch v := t.(type) {
 	case nil:
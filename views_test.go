@@ -0,0 +1,307 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"mojira/model"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Stores issues MC-1 to MC-n, a newer issue for every key number, with every third one resolved
+func storeTestIssues(t *testing.T, store *MemoryStore, n int) {
+	t.Helper()
+	for i := 1; i <= n; i++ {
+		created := time.Date(2024, 1, i, 0, 0, 0, 0, time.UTC)
+		issue := &model.Issue{Key: fmt.Sprintf("MC-%d", i), Summary: fmt.Sprintf("Issue number %d", i), CreatedDate: &created, Status: "Open"}
+		if i%3 == 0 {
+			issue.Status = "Resolved"
+			issue.Resolution = "Fixed"
+		}
+		if err := store.UpdateIssue(context.Background(), issue, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func serveIssue(service *IssueService, key string, header http.Header) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("GET", "/"+key, nil)
+	r.SetPathValue("key", key)
+	for name, values := range header {
+		r.Header[name] = values
+	}
+	w := httptest.NewRecorder()
+	issueHandler(service)(w, r)
+	return w
+}
+
+func TestIssueHandler(t *testing.T) {
+	store := NewMemoryStore()
+	service, _ := newFakeTrackerService(t, store)
+	if _, _, err := service.RefreshIssue(context.Background(), "MC-4"); err != nil {
+		t.Fatal(err)
+	}
+	storeTestIssues(t, store, 2)
+	store.MarkIssueRemoved("MC-2")
+
+	t.Run("stored", func(t *testing.T) {
+		w := serveIssue(service, "MC-4", nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d", w.Code)
+		}
+		if !strings.Contains(w.Body.String(), "Item drops appear at the wrong position") {
+			t.Error("expected the page to show the summary")
+		}
+		etag := w.Header().Get("ETag")
+		if etag == "" {
+			t.Fatal("expected an ETag")
+		}
+
+		w = serveIssue(service, "MC-4", http.Header{"If-None-Match": {etag}})
+		if w.Code != http.StatusNotModified || w.Body.Len() > 0 {
+			t.Errorf("expected an empty 304, got %d with %d bytes", w.Code, w.Body.Len())
+		}
+	})
+
+	t.Run("changed", func(t *testing.T) {
+		etag := serveIssue(service, "MC-1", nil).Header().Get("ETag")
+		issue, _ := store.GetIssueByKey("MC-1")
+		issue.Summary = "Changed summary"
+		if err := store.UpdateIssue(context.Background(), issue, nil); err != nil {
+			t.Fatal(err)
+		}
+		w := serveIssue(service, "MC-1", http.Header{"If-None-Match": {etag}})
+		if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "Changed summary") {
+			t.Errorf("expected the changed issue, got %d", w.Code)
+		}
+	})
+
+	t.Run("removed", func(t *testing.T) {
+		w := serveIssue(service, "MC-2", nil)
+		if !strings.Contains(w.Body.String(), "This issue is no longer available") || w.Header().Get("ETag") != "" {
+			t.Error("expected the removed page instead of the issue")
+		}
+	})
+
+	t.Run("fetched", func(t *testing.T) {
+		// Only the servicedesk knows MC-280000, so it is shown but not stored
+		w := serveIssue(service, "MC-280000", nil)
+		if !strings.Contains(w.Body.String(), "Bundles lose their contents when dropped in lava") {
+			t.Error("expected the page to show the fetched summary")
+		}
+		if _, err := store.GetIssueByKey("MC-280000"); err != model.ErrIssueNotStored {
+			t.Errorf("expected a partial issue not to be stored, got %v", err)
+		}
+	})
+
+	t.Run("unknown", func(t *testing.T) {
+		w := serveIssue(service, "MC-6", nil)
+		if !strings.Contains(w.Body.String(), "This issue cannot be found") || w.Header().Get("ETag") != "" {
+			t.Error("expected the not found page")
+		}
+	})
+}
+
+func searchV1(t *testing.T, service *IssueService, query url.Values) (int, V1SearchResult) {
+	t.Helper()
+	r := httptest.NewRequest("GET", "/api/v1/search?"+query.Encode(), nil)
+	w := httptest.NewRecorder()
+	apiV1Search(service)(w, r)
+	var result V1SearchResult
+	if w.Code == http.StatusOK {
+		if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return w.Code, result
+}
+
+func resultKeys(issues []V1Issue) []string {
+	keys := make([]string, 0, len(issues))
+	for _, issue := range issues {
+		keys = append(keys, issue.Key)
+	}
+	return keys
+}
+
+func TestApiV1Search(t *testing.T) {
+	store := NewMemoryStore()
+	service := &IssueService{db: store}
+	storeTestIssues(t, store, 7)
+
+	t.Run("pages", func(t *testing.T) {
+		var keys []string
+		var pages []V1SearchResult
+		query := url.Values{"limit": {"3"}}
+		for {
+			code, result := searchV1(t, service, query)
+			if code != http.StatusOK {
+				t.Fatalf("expected 200, got %d", code)
+			}
+			if result.Total != 7 {
+				t.Errorf("expected a total of 7, got %d", result.Total)
+			}
+			keys = append(keys, resultKeys(result.Issues)...)
+			pages = append(pages, result)
+			if result.NextCursor == nil {
+				break
+			}
+			query.Set("cursor", *result.NextCursor)
+		}
+		want := []string{"MC-7", "MC-6", "MC-5", "MC-4", "MC-3", "MC-2", "MC-1"}
+		if !slices.Equal(keys, want) {
+			t.Errorf("expected %v, got %v", want, keys)
+		}
+		if len(pages) != 3 || pages[0].PrevCursor != nil {
+			t.Fatalf("expected 3 pages without a cursor before the first, got %d", len(pages))
+		}
+
+		query.Set("cursor", *pages[1].PrevCursor)
+		_, result := searchV1(t, service, query)
+		if got := resultKeys(result.Issues); !slices.Equal(got, want[:3]) {
+			t.Errorf("expected the previous cursor to return %v, got %v", want[:3], got)
+		}
+	})
+
+	t.Run("query", func(t *testing.T) {
+		code, result := searchV1(t, service, url.Values{"query": {"status = Resolved ORDER BY key ASC"}})
+		if code != http.StatusOK {
+			t.Fatalf("expected 200, got %d", code)
+		}
+		if got := resultKeys(result.Issues); !slices.Equal(got, []string{"MC-3", "MC-6"}) || result.Total != 2 {
+			t.Errorf("expected MC-3 and MC-6, got %v (total %d)", got, result.Total)
+		}
+	})
+
+	t.Run("search", func(t *testing.T) {
+		_, result := searchV1(t, service, url.Values{"search": {"number 4"}})
+		if got := resultKeys(result.Issues); !slices.Equal(got, []string{"MC-4"}) {
+			t.Errorf("expected MC-4, got %v", got)
+		}
+	})
+
+	for name, query := range map[string]url.Values{
+		"invalid cursor":    {"cursor": {"not a cursor"}},
+		"postgres cursor":   {"cursor": {(&IssueCursor{Values: []string{"2024-01-01"}}).String()}},
+		"invalid query":     {"query": {"status = "}},
+		"unknown sort":      {"sort": {"Summary"}},
+		"unknown jql field": {"query": {"colour = red"}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			if code, _ := searchV1(t, service, query); code != http.StatusBadRequest {
+				t.Errorf("expected 400, got %d", code)
+			}
+		})
+	}
+}
+
+func batchV1(t *testing.T, service *IssueService, body string) (int, V1BatchResult) {
+	t.Helper()
+	r := httptest.NewRequest("POST", "/api/v1/issues/batch", strings.NewReader(body))
+	w := httptest.NewRecorder()
+	apiV1IssuesBatch(service)(w, r)
+	var result V1BatchResult
+	if w.Code == http.StatusOK {
+		if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return w.Code, result
+}
+
+func TestApiV1IssuesBatch(t *testing.T) {
+	store := NewMemoryStore()
+	service := &IssueService{db: store}
+	storeTestIssues(t, store, 3)
+	store.MarkIssueRemoved("MC-3")
+
+	code, result := batchV1(t, service, `{"keys": ["MC-1", " mc-2 ", "MC-1", "MC-3", "MC-10", "nope", "XYZ-1"], "queue_missing": true}`)
+	if code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", code)
+	}
+	if got := resultKeys(result.Issues); !slices.Equal(got, []string{"MC-1", "MC-2"}) {
+		t.Errorf("expected MC-1 and MC-2, got %v", got)
+	}
+	if !slices.Equal(result.Removed, []string{"MC-3"}) {
+		t.Errorf("expected MC-3 to be removed, got %v", result.Removed)
+	}
+	if !slices.Equal(result.Missing, []string{"MC-10"}) || !slices.Equal(result.Queued, []string{"MC-10"}) {
+		t.Errorf("expected MC-10 to be missing and queued, got %v and %v", result.Missing, result.Queued)
+	}
+	if !slices.Equal(result.Invalid, []string{"NOPE", "XYZ-1"}) {
+		t.Errorf("expected NOPE and XYZ-1 to be invalid, got %v", result.Invalid)
+	}
+	queue, _, _ := store.GetQueue(context.Background())
+	if len(queue) != 1 || queue[0].Reason != "api-batch" {
+		t.Errorf("expected MC-10 to be queued as a probe, got %v", queue)
+	}
+
+	// A probed key isn't queued again within the week, even after it was dropped from the queue
+	store.queue = make(map[string]*memoryQueueEntry)
+	_, result = batchV1(t, service, `{"keys": ["MC-10"], "queue_missing": true}`)
+	if !slices.Equal(result.Missing, []string{"MC-10"}) || len(result.Queued) != 0 {
+		t.Errorf("expected MC-10 to be missing but not queued again, got %v and %v", result.Missing, result.Queued)
+	}
+
+	t.Run("too many keys", func(t *testing.T) {
+		keys, _ := json.Marshal(slices.Repeat([]string{"MC-1"}, maxBatchKeys+1))
+		if code, _ := batchV1(t, service, `{"keys": `+string(keys)+`}`); code != http.StatusBadRequest {
+			t.Errorf("expected 400, got %d", code)
+		}
+	})
+
+	t.Run("invalid body", func(t *testing.T) {
+		if code, _ := batchV1(t, service, `{"keys": "MC-1"}`); code != http.StatusBadRequest {
+			t.Errorf("expected 400, got %d", code)
+		}
+	})
+}
+
+func TestRequireAdmin(t *testing.T) {
+	t.Setenv("ADMIN_TOKEN", "s3cret")
+	handler := requireAdmin(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	cases := []struct {
+		name     string
+		method   string
+		password string
+		header   http.Header
+		want     int
+	}{
+		{"no password", "GET", "", nil, http.StatusUnauthorized},
+		{"wrong password", "POST", "wrong", nil, http.StatusUnauthorized},
+		{"get", "GET", "s3cret", http.Header{"Sec-Fetch-Site": {"cross-site"}}, http.StatusNoContent},
+		{"same origin", "POST", "s3cret", http.Header{"Sec-Fetch-Site": {"same-origin"}, "Origin": {"https://mojira.test"}}, http.StatusNoContent},
+		{"typed url", "POST", "s3cret", http.Header{"Sec-Fetch-Site": {"none"}}, http.StatusNoContent},
+		{"not a browser", "POST", "s3cret", nil, http.StatusNoContent},
+		{"cross site", "POST", "s3cret", http.Header{"Sec-Fetch-Site": {"cross-site"}}, http.StatusForbidden},
+		{"same site", "POST", "s3cret", http.Header{"Sec-Fetch-Site": {"same-site"}}, http.StatusForbidden},
+		{"same origin header", "POST", "s3cret", http.Header{"Origin": {"https://mojira.test"}}, http.StatusNoContent},
+		{"other origin", "POST", "s3cret", http.Header{"Origin": {"https://evil.test"}}, http.StatusForbidden},
+		{"null origin", "POST", "s3cret", http.Header{"Origin": {"null"}}, http.StatusForbidden},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			r := httptest.NewRequest(c.method, "https://mojira.test/admin/sync", nil)
+			if c.password != "" {
+				r.SetBasicAuth("admin", c.password)
+			}
+			for name, values := range c.header {
+				r.Header[name] = values
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			if w.Code != c.want {
+				t.Errorf("expected %d, got %d", c.want, w.Code)
+			}
+		})
+	}
+}
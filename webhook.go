@@ -0,0 +1,235 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"mojira/jql"
+	"mojira/model"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const webhookTimeout = 10 * time.Second
+
+var webhookClient = &http.Client{Timeout: webhookTimeout}
+
+const webhookBatchSize = 20
+
+// Long enough to send a whole batch when every request times out, so no other instance sends them again meanwhile
+const webhookLeaseDuration = webhookBatchSize*webhookTimeout + time.Minute
+
+type webhookPayload struct {
+	Key       string          `json:"key"`
+	Url       string          `json:"url"`
+	Events    []string        `json:"events"`
+	Changes   []V1FieldChange `json:"changes"`
+	Issue     V1Issue         `json:"issue"`
+	Timestamp time.Time       `json:"timestamp"`
+}
+
+// Lists the activity types and changed fields of a diff, which webhooks can subscribe to
+func diffEvents(diff *model.IssueDiff, activity []model.ActivityEvent) []string {
+	var events []string
+	for _, e := range activity {
+		if !slices.Contains(events, e.Type) {
+			events = append(events, e.Type)
+		}
+	}
+	for _, c := range diff.Changes {
+		if !slices.Contains(events, c.Field) {
+			events = append(events, c.Field)
+		}
+	}
+	return events
+}
+
+type webhookQuery struct {
+	query *jql.Query
+	err   error
+}
+
+// Webhooks are loaded for every diff, so each query is only parsed and reported once
+var webhookQueries = struct {
+	sync.Mutex
+	parsed map[string]webhookQuery
+}{parsed: map[string]webhookQuery{}}
+
+// Parses the filter query of a loaded webhook, an invalid query makes it match no issue
+func (h *Webhook) parseQuery() {
+	if h.Filter.Query == "" {
+		return
+	}
+	webhookQueries.Lock()
+	defer webhookQueries.Unlock()
+	parsed, ok := webhookQueries.parsed[h.Filter.Query]
+	if !ok {
+		parsed.query, parsed.err = jql.Parse(h.Filter.Query)
+		if parsed.err != nil {
+			log.Printf("[ERROR] [webhook] Invalid query of webhook %d, it won't match any issue: %v", h.Id, parsed.err)
+		}
+		webhookQueries.parsed[h.Filter.Query] = parsed
+	}
+	h.Filter.query = parsed.query
+	h.QueryError = parsed.err
+}
+
+func (h *Webhook) Matches(issue *model.Issue, events []string) bool {
+	if !h.Enabled || len(events) == 0 {
+		return false
+	}
+	if h.Filter.Key != "" && h.Filter.Key != issue.Key {
+		return false
+	}
+	if len(h.Events) > 0 && !slices.ContainsFunc(h.Events, func(e string) bool { return slices.Contains(events, e) }) {
+		return false
+	}
+	return h.Filter.Matches(issue)
+}
+
+func queueWebhooks(service *IssueService, ctx context.Context, diff *model.IssueDiff, events []string) {
+	if len(events) == 0 {
+		return
+	}
+	webhooks, err := service.db.GetWebhooks(ctx)
+	if err != nil {
+		log.Printf("[ERROR] [webhook] Error getting webhooks: %v", err)
+		return
+	}
+	var deliveries []WebhookDelivery
+	var payload, notification []byte
+	for _, h := range webhooks {
+		if !h.Matches(diff.New, events) {
+			continue
+		}
+		if h.Format != "json" {
+			if notification == nil {
+				notification, err = notificationPayload(diff)
+				if err != nil {
+					log.Printf("[ERROR] [webhook] Error encoding notification for %s: %v", diff.New.Key, err)
+					return
+				}
+			}
+			deliveries = append(deliveries, WebhookDelivery{WebhookId: h.Id, IssueKey: diff.New.Key, Payload: string(notification), RetryAfter: notificationDue()})
+			continue
+		}
+		if payload == nil {
+			changes := make([]V1FieldChange, 0, len(diff.Changes))
+			for _, c := range diff.Changes {
+				changes = append(changes, V1FieldChange{Field: c.Field, OldValue: apiField(c.OldValue), NewValue: apiField(c.NewValue), ChangedDate: diff.New.SyncedDate})
+			}
+			payload, err = json.Marshal(webhookPayload{
+				Key:       diff.New.Key,
+				Url:       fmt.Sprintf("%s/%s", siteUrl, diff.New.Key),
+				Events:    events,
+				Changes:   changes,
+				Issue:     newV1Issue(diff.New),
+				Timestamp: time.Now().UTC(),
+			})
+			if err != nil {
+				log.Printf("[ERROR] [webhook] Error encoding payload for %s: %v", diff.New.Key, err)
+				return
+			}
+		}
+		deliveries = append(deliveries, WebhookDelivery{WebhookId: h.Id, IssueKey: diff.New.Key, Payload: string(payload)})
+	}
+	if len(deliveries) == 0 {
+		return
+	}
+	err = service.db.QueueWebhookDeliveries(ctx, deliveries)
+	if err != nil {
+		log.Printf("[ERROR] [webhook] Error queueing deliveries for %s: %v", diff.New.Key, err)
+	}
+}
+
+func signWebhookPayload(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func webhookDeliverer(service *IssueService) {
+	ctx := context.Background()
+	deliveries, err := service.db.ClaimWebhookDeliveries(ctx, syncWorkerId, webhookBatchSize, webhookLeaseDuration)
+	if err != nil {
+		log.Printf("[ERROR] [webhook] Error claiming pending deliveries: %v", err)
+		return
+	}
+	// Chat notifications are sent as one message for each webhook
+	var webhookIds []int
+	notifications := make(map[int][]WebhookDelivery)
+	for _, d := range deliveries {
+		if d.Format == "json" {
+			code, err := sendWebhook(ctx, &d)
+			finishWebhookDelivery(service, ctx, d, code, err)
+			continue
+		}
+		if _, ok := notifications[d.WebhookId]; !ok {
+			webhookIds = append(webhookIds, d.WebhookId)
+		}
+		notifications[d.WebhookId] = append(notifications[d.WebhookId], d)
+	}
+	for _, id := range webhookIds {
+		batch := notifications[id]
+		message := batch[0]
+		payload, err := notificationMessage(message.Format, batch)
+		code := 0
+		if err == nil {
+			message.Payload = string(payload)
+			code, err = sendWebhook(ctx, &message)
+		}
+		for _, d := range batch {
+			finishWebhookDelivery(service, ctx, d, code, err)
+		}
+	}
+}
+
+func finishWebhookDelivery(service *IssueService, ctx context.Context, d WebhookDelivery, code int, err error) {
+	if err != nil {
+		log.Printf("[webhook] Delivery %d of %s to webhook %d failed: %v", d.Id, d.IssueKey, d.WebhookId, err)
+		var responseCode *int
+		if code != 0 {
+			responseCode = &code
+		}
+		err = service.db.RetryWebhookDelivery(ctx, d.Id, responseCode, err.Error())
+		if err != nil {
+			log.Printf("[ERROR] [webhook] Error retrying delivery %d: %v", d.Id, err)
+		}
+		return
+	}
+	err = service.db.MarkWebhookDelivered(ctx, d.Id, code)
+	if err != nil {
+		log.Printf("[ERROR] [webhook] Error marking delivery %d: %v", d.Id, err)
+	}
+}
+
+func sendWebhook(ctx context.Context, d *WebhookDelivery) (int, error) {
+	body := []byte(d.Payload)
+	req, err := http.NewRequestWithContext(ctx, "POST", d.WebhookUrl, bytes.NewBuffer(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "mojira.dev webhooks")
+	req.Header.Set("X-Mojira-Delivery", strconv.Itoa(d.Id))
+	req.Header.Set("X-Mojira-Signature", signWebhookPayload(d.Secret, body))
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
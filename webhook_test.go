@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"mojira/model"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+type webhookReceiver struct {
+	mu         sync.Mutex
+	status     int
+	bodies     []string
+	signatures []string
+}
+
+func (h *webhookReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.bodies = append(h.bodies, string(body))
+	h.signatures = append(h.signatures, r.Header.Get("X-Mojira-Signature"))
+	w.WriteHeader(h.status)
+}
+
+func (h *webhookReceiver) requests() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.bodies)
+}
+
+func newWebhookTest(t *testing.T, status int) (*MemoryStore, *IssueService, *webhookReceiver) {
+	receiver := &webhookReceiver{status: status}
+	server := httptest.NewServer(receiver)
+	t.Cleanup(server.Close)
+	store := NewMemoryStore()
+	id := store.AddWebhook(Webhook{Url: server.URL, Secret: "s3cret", Format: "json", Enabled: true})
+	err := store.QueueWebhookDeliveries(context.Background(), []WebhookDelivery{{WebhookId: id, IssueKey: "MC-4", Payload: `{"key":"MC-4"}`}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store, &IssueService{db: store}, receiver
+}
+
+func getDelivery(t *testing.T, store *MemoryStore) WebhookDelivery {
+	deliveries, err := store.GetWebhookDeliveries(context.Background(), 10)
+	if err != nil || len(deliveries) != 1 {
+		t.Fatalf("expected one delivery, got %v (%v)", deliveries, err)
+	}
+	return deliveries[0]
+}
+
+func TestWebhookSignature(t *testing.T) {
+	store, service, receiver := newWebhookTest(t, http.StatusNoContent)
+	webhookDeliverer(service)
+
+	if receiver.requests() != 1 {
+		t.Fatalf("expected 1 request, got %d", receiver.requests())
+	}
+	mac := hmac.New(sha256.New, []byte("s3cret"))
+	mac.Write([]byte(receiver.bodies[0]))
+	expected := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	if receiver.bodies[0] != `{"key":"MC-4"}` {
+		t.Errorf("unexpected body %s", receiver.bodies[0])
+	}
+	if !hmac.Equal([]byte(receiver.signatures[0]), []byte(expected)) {
+		t.Errorf("signature %q, expected %q", receiver.signatures[0], expected)
+	}
+
+	d := getDelivery(t, store)
+	if d.Status != "delivered" || d.ResponseCode == nil || *d.ResponseCode != http.StatusNoContent || d.DeliveredDate == nil {
+		t.Errorf("delivery not marked as delivered: %+v", d)
+	}
+	webhookDeliverer(service)
+	if receiver.requests() != 1 {
+		t.Errorf("delivered delivery was sent again")
+	}
+}
+
+func TestWebhookRetry(t *testing.T) {
+	store, service, receiver := newWebhookTest(t, http.StatusInternalServerError)
+	delays := []time.Duration{2, 4, 8, 16, 16, 16, 16}
+	for i, delay := range delays {
+		start := time.Now()
+		webhookDeliverer(service)
+		if receiver.requests() != i+1 {
+			t.Fatalf("attempt %d: expected %d requests, got %d", i+1, i+1, receiver.requests())
+		}
+		d := getDelivery(t, store)
+		if d.Status != "pending" || d.FailedCount != i+1 || d.ResponseCode == nil || *d.ResponseCode != http.StatusInternalServerError {
+			t.Fatalf("attempt %d: unexpected delivery %+v", i+1, d)
+		}
+		if d.LastError == "" || d.ClaimedBy != "" || d.LeaseUntil != nil {
+			t.Fatalf("attempt %d: failure not recorded or claim kept: %+v", i+1, d)
+		}
+		wait := d.RetryAfter.Sub(start)
+		if wait < delay*time.Minute || wait > delay*time.Minute+time.Second {
+			t.Fatalf("attempt %d: retried after %v, expected %v", i+1, wait, delay*time.Minute)
+		}
+
+		// Not sent again before the backoff passed
+		webhookDeliverer(service)
+		if receiver.requests() != i+1 {
+			t.Fatalf("attempt %d: sent again before retry_after", i+1)
+		}
+		past := time.Now().Add(-time.Second)
+		store.delivery(d.Id).RetryAfter = &past
+	}
+
+	webhookDeliverer(service)
+	d := getDelivery(t, store)
+	if d.Status != "failed" || d.FailedCount != 8 {
+		t.Fatalf("expected delivery to fail after 8 attempts: %+v", d)
+	}
+	webhookDeliverer(service)
+	if receiver.requests() != 8 {
+		t.Errorf("failed delivery was sent again")
+	}
+}
+
+func TestWebhookDeliveryLease(t *testing.T) {
+	store, _, _ := newWebhookTest(t, http.StatusOK)
+	ctx := context.Background()
+	claimed, err := store.ClaimWebhookDeliveries(ctx, "worker-1", 10, time.Minute)
+	if err != nil || len(claimed) != 1 {
+		t.Fatalf("expected to claim the delivery, got %v (%v)", claimed, err)
+	}
+	again, err := store.ClaimWebhookDeliveries(ctx, "worker-2", 10, time.Minute)
+	if err != nil || len(again) != 0 {
+		t.Fatalf("claimed delivery was claimed again: %v (%v)", again, err)
+	}
+	expired := time.Now().Add(-time.Second)
+	store.delivery(claimed[0].Id).LeaseUntil = &expired
+	again, err = store.ClaimWebhookDeliveries(ctx, "worker-2", 10, time.Minute)
+	if err != nil || len(again) != 1 {
+		t.Fatalf("expired lease was not claimed again: %v (%v)", again, err)
+	}
+}
+
+func TestWebhookQuery(t *testing.T) {
+	store := NewMemoryStore()
+	store.AddWebhook(Webhook{Format: "json", Enabled: true, Filter: WebhookFilter{IssueFilter: IssueFilter{Query: "project = MC AND resolution = Fixed"}}})
+	store.AddWebhook(Webhook{Format: "json", Enabled: true, Filter: WebhookFilter{IssueFilter: IssueFilter{Query: "project = "}}})
+	webhooks, err := store.GetWebhooks(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fixed := &model.Issue{Key: "MC-1", Resolution: "Fixed"}
+	unresolved := &model.Issue{Key: "MC-2"}
+	events := []string{"resolved"}
+	if webhooks[0].QueryError != nil || !webhooks[0].Matches(fixed, events) || webhooks[0].Matches(unresolved, events) {
+		t.Errorf("expected the query to only match the fixed issue, got error %v", webhooks[0].QueryError)
+	}
+	if webhooks[1].QueryError == nil {
+		t.Error("expected the invalid query to be reported")
+	}
+	if webhooks[1].Matches(fixed, events) || webhooks[1].Matches(unresolved, events) {
+		t.Error("expected the invalid query not to match any issue")
+	}
+}